@@ -19,6 +19,7 @@ type Activity = {
   entityId: number; 
   action: string; 
   timeStampUnix: number; 
+  version?: number;
 };
 
 /**
@@ -46,7 +47,7 @@ export async function getActivitys() {
  * @param activity - Activity object (should include `logId` to identify the record)
  * @returns Promise resolving with the API response
  */
-export async function updateActivity(activity: Activity) {
+export async function updateActivity(activity: Activity & { version: number }) {
   // PUT /activities/update with updated activity payload
   return apiRequest("/activities/update", "PUT", activity);
 }
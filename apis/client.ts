@@ -7,6 +7,7 @@
  */ 
 
 import { Alert } from "react-native";
+import * as SecureStore from "expo-secure-store";
 
 // Base URL for API requests. This is currently set to a local LAN IP used in
 // development. Replace with an environment variable or production URL when
@@ -15,8 +16,8 @@ const BASE_URL = "http://10.0.0.67:8080"; // LAN IP
 
 /**
  * Low-level helper for making HTTP requests to the backend.
- * It builds a fetch() call with JSON headers, serializes the body when
- * provided, and attempts to parse responses as JSON while falling back to
+ * It builds a fetch() call with JSON headers, attaches the stored auth token
+ * as a bearer token, serializes the body when provided, and attempts to parse responses as JSON while falling back to
  * plain text when parsing fails.
  *
  * @param endpoint - Path appended to the BASE_URL (e.g. `/properties`)
@@ -28,14 +29,23 @@ const BASE_URL = "http://10.0.0.67:8080"; // LAN IP
 async function apiRequest(
   endpoint: string,
   method: string = "GET",
-  body?: object
-) {
+  body?: object,
+  retried: boolean = false
+): Promise<any> {
   const url = `${BASE_URL}${endpoint}`; // append endpoint to base URL
   console.log(url);
 
+  const headers: Record<string, string> = { "Content-Type": "application/json" };
+
+  // Attach the token saved by AuthContext; the backend rejects requests without it
+  const token = await SecureStore.getItemAsync("authToken");
+  if (token) {
+    headers.Authorization = `Bearer ${token}`;
+  }
+
   const options: RequestInit = {
     method,
-    headers: { "Content-Type": "application/json" },
+    headers,
   };
 
   // Attach JSON body when provided
@@ -46,6 +56,11 @@ async function apiRequest(
   try {
     const res = await fetch(url, options);
 
+    // Access tokens are short-lived: on a 401 try the refresh token once and replay
+    if (res.status === 401 && !retried && endpoint !== "/token/refresh" && (await refreshTokens())) {
+      return apiRequest(endpoint, method, body, true);
+    }
+
     // Read response as text first so we can safely attempt JSON.parse
     const text = await res.text();
     if (!res.ok) {
@@ -67,6 +82,32 @@ async function apiRequest(
   }
 }
 
+/**
+ * Exchanges the stored refresh token for a new access/refresh token pair.
+ * Refresh tokens are single use, so both are replaced on success.
+ *
+ * @returns true when new tokens were stored
+ */
+async function refreshTokens(): Promise<boolean> {
+  const refreshToken = await SecureStore.getItemAsync("refreshToken");
+  if (!refreshToken) return false;
+
+  const res = await fetch(`${BASE_URL}/token/refresh`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ refreshToken }),
+  });
+  if (!res.ok) {
+    await SecureStore.deleteItemAsync("refreshToken");
+    return false;
+  }
+
+  const pair = await res.json();
+  await SecureStore.setItemAsync("authToken", pair.token);
+  await SecureStore.setItemAsync("refreshToken", pair.refreshToken);
+  return true;
+}
+
 export default apiRequest;
 
 /**
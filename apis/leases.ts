@@ -19,6 +19,7 @@ type Lease = {
   leaseSecurityDeposit: number;
   leaseDocumentLink: string;
   leaseStatus: string;
+  version?: number;
 };
 
 /**
@@ -43,7 +44,7 @@ export async function getLeases() {
  * to identify which record to update.
  * @param lease - Updated lease object
  */
-export async function updateLease(lease: Lease) {
+export async function updateLease(lease: Lease & { version: number }) {
   return apiRequest("/leases/update", "PUT", lease);
 }
 
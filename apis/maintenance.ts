@@ -20,6 +20,7 @@ type MaintenanceRequest = {
   maintenanceRequestCreatedUnix: number;
   maintenanceRequestCompletedUnix: number;
   maintenanceAssignedTo: string;
+  version?: number;
 };
 
 /**
@@ -42,7 +43,7 @@ export async function getMaintenanceRequests() {
  * Update an existing maintenance request. The payload should contain the
  * `maintenanceRequestId` to identify the record to modify.
  */
-export async function updateMaintenanceRequest(maintenanceRequest: MaintenanceRequest) {
+export async function updateMaintenanceRequest(maintenanceRequest: MaintenanceRequest & { version: number }) {
   return apiRequest("/maintenanceRequests/update", "PUT", maintenanceRequest);
 }
 
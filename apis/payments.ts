@@ -16,6 +16,7 @@ export type Payment = {
   paymentDateUnix: number; 
   paymentNotes: string;
   paymentConfirmation: Uint8Array; 
+  version?: number;
 };
 
 /**
@@ -38,7 +39,7 @@ export async function getPayments() {
  * Update an existing payment record.
  * @param payment - Payment payload including `paymentId`
  */
-export async function updatePayment(payment: Payment) {
+export async function updatePayment(payment: Payment & { version: number }) {
   return apiRequest("/payments/update", "PUT", payment);
 }
 
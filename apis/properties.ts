@@ -23,6 +23,7 @@ export type Property = {
   propertyType: string;
   propertyYearBuilt: string;
   propertyNotes: string;
+  version?: number;
 };
 
 /**
@@ -43,10 +44,10 @@ export async function getProperties() {
 }
 
 /**
- * Update a property. Provide the propertyId in the partial to identify the
- * record to modify.
+ * Update a property. Provide the propertyId and version in the partial to
+ * identify the record to modify.
  */
-export async function updateProperty(property: Partial<Property>) {
+export async function updateProperty(property: Partial<Property> & { propertyId: number; version: number }) {
   return apiRequest("/properties/update", "PUT", property);
 }
 
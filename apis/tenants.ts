@@ -15,6 +15,7 @@ type Tenant = {
   tenantLastName: string;
   tenantEmailAddress: string;
   tenantPhoneNumber: string;
+  version?: number;
 };
 
 /**
@@ -37,7 +38,7 @@ export async function getTenants() {
  * Update a tenant record. Include `tenantId` in the payload to identify the
  * record to update.
  */
-export async function updateTenant(tenant: Tenant) {
+export async function updateTenant(tenant: Tenant & { version: number }) {
   return apiRequest("/tenants/update", "PUT", tenant);
 }
 
@@ -18,6 +18,7 @@ type Unit = {
   propertySqFt: number;
   propertyRentDefault: number;
   propertyUnitNotes: string;
+  version?: number;
 };
 
 /**
@@ -39,7 +40,7 @@ export async function getUnits(propertyId: number) {
 /**
  * Update a unit record. Include `propertyUnitId` in the unit payload.
  */
-export async function updateUnit(unit: Unit) {
+export async function updateUnit(unit: Unit & { version: number }) {
   return apiRequest("/units/update", "PUT", unit);
 }
 
@@ -17,8 +17,12 @@ type User = {
   userPhoneNumber: string;
   userPassword: string;
   userRole: string;
+  version?: number;
 };
 
+// The signed-in user as returned by GET /users/me.
+export type CurrentUser = Omit<User, "userPassword"> & { userId: number; version: number };
+
 /**
  * Create a new user in the system.
  * @param user - User payload including password and role
@@ -41,16 +45,16 @@ export async function getUsers() {
  * Fetch the currently authenticated user.
  * Useful for profile screens and determining current user's permissions.
  */
-export async function getCurrentUser() {
+export async function getCurrentUser(): Promise<CurrentUser> {
   return apiRequest("/users/me", "GET");
 }
 
 /**
  * Update an existing user. Accepts a partial `User` so callers can update a
- * subset of fields. Include an identifier field (e.g., email or an id) per
- * backend contract to select which user to update.
+ * subset of fields; `userId` selects the user and `version` is the one it
+ * was read at.
  */
-export async function updateUser(user: Partial<User>) {
+export async function updateUser(user: Partial<User> & { userId: number; version: number }) {
   return apiRequest("/users/update", "PUT", user);
 }
 
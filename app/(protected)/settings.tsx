@@ -39,6 +39,7 @@ export default function SettingsScreen() {
   const navigation =
     useNavigation<NativeStackNavigationProp<RootStackParamList>>();
 
+  const [me, setMe] = useState<u.CurrentUser | null>(null);
   const [userEmail, setEmail] = useState("");
   const [userPassword, setPassword] = useState("");
   // Use the exported Property type from the properties API for stronger typing
@@ -48,8 +49,11 @@ export default function SettingsScreen() {
   // Load user + properties on mount. `safeCall` shows alerts and returns the
   // API result or undefined on error, keeping the UI simple.
   const fetchSettingsData = useCallback(async () => {
-    const user = await util.safeCall(() => u.getUsers());
-    if (user?.email) setEmail(user.email);
+    const user = await util.safeCall(() => u.getCurrentUser());
+    if (user) {
+      setMe(user);
+      setEmail(user.userEmail);
+    }
 
     const props = await util.safeCall(() => p.getProperties());
     if (props) setProperties(props);
@@ -66,8 +70,12 @@ export default function SettingsScreen() {
   );
 
 
+  // Updates send back the version they were loaded at; the server refuses a
+  // stale one, and the new version is kept for the next edit
   const handleUpdateUser = () =>
-    util.safeCall(() => u.updateUser({ userEmail: userEmail, userPassword: userPassword }), "Account updated");
+    me &&
+    util.safeCall(() => u.updateUser({ ...me, userEmail: userEmail, userPassword: userPassword }), "Account updated")
+      .then((res) => res && setMe({ ...me, userEmail, version: res.version }));
 
   const handleAddProperty = () =>
     newPropertyName.trim()
@@ -76,15 +84,19 @@ export default function SettingsScreen() {
         .then(() => setNewPropertyName(""))
       : Alert.alert("Validation", "Property name is required");
 
-  const handleEditProperty = (propertyId: number, propertyName: string) =>
-    util.safeCall(() => p.updateProperty({ propertyId, propertyName }), "Property updated")
-      .then(() =>
+  const handleEditProperty = (propertyId: number, propertyName: string) => {
+    const current = properties.find((prop) => prop.propertyId === propertyId);
+    if (!current?.version) return;
+    return util.safeCall(() => p.updateProperty({ ...current, propertyName, version: current.version! }), "Property updated")
+      .then((res) =>
+        res &&
         setProperties((prev) =>
           prev.map((prop) =>
-            prop.propertyId === propertyId ? { ...prop, propertyName } : prop
+            prop.propertyId === propertyId ? { ...prop, propertyName, version: res.version } : prop
           )
         )
       );
+  };
 
   const handleDeleteProperty = (propertyId: number) =>
     util.safeCall(() => p.deleteProperty(propertyId), "Property deleted")
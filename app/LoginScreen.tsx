@@ -26,11 +26,15 @@ import { useAuth } from "../contexts/AuthContext";
  * Login screen — authenticates a user and stores the returned token in
  * AuthContext. The screen uses `apiRequest` to POST credentials to `/login`.
  * On success it calls `login(token)` from context and navigates into the
- * protected tab layout.
+ * protected tab layout. Accounts with two-factor authentication receive a
+ * challenge instead of a token; the screen then asks for an authenticator (or
+ * recovery) code and completes the login at `/login/verify`.
  */
 export default function LoginScreen() {
   const [email, setEmail] = useState("");
   const [password, setPassword] = useState("");
+  const [challengeToken, setChallengeToken] = useState<string | null>(null);
+  const [code, setCode] = useState("");
   const router = useRouter();
   const { login } = useAuth();
 
@@ -40,8 +44,12 @@ export default function LoginScreen() {
     try {
       const res = await apiRequest("/login", "POST", { email, password });
 
+      if (res.twoFactorRequired) {
+        setChallengeToken(res.challengeToken);
+        return;
+      }
       if (res.token) {
-        await login(res.token);
+        await login(res.token, res.refreshToken);
         // Replace the stack so users can't go back to the login screen
         router.replace("/(protected)/dashboard");
       } else {
@@ -53,6 +61,67 @@ export default function LoginScreen() {
     }
   }
 
+  // Completes a two-factor login. Six-digit input is sent as a TOTP code;
+  // anything else is treated as a recovery code.
+  async function handleVerify() {
+    try {
+      const trimmed = code.trim();
+      const body = /^\d{6}$/.test(trimmed)
+        ? { challengeToken, code: trimmed }
+        : { challengeToken, recoveryCode: trimmed };
+      const res = await apiRequest("/login/verify", "POST", body);
+      await login(res.token, res.refreshToken);
+      router.replace("/(protected)/dashboard");
+    } catch (err: any) {
+      console.error("Verify error:", err);
+      Alert.alert("Error", err.message || "Verification failed");
+    }
+  }
+
+  if (challengeToken) {
+    return (
+      <LinearGradient
+        colors={["#432c83B3", "#2575fcB3"]}
+        style={styles.gradient}
+        start={{ x: 0, y: 0 }}
+        end={{ x: 1, y: 1 }}
+      >
+        <KeyboardAvoidingView
+          style={styles.container}
+          behavior={Platform.OS === "ios" ? "padding" : "height"}
+        >
+          <View style={styles.inner}>
+            <Text style={styles.title}>RentTracker</Text>
+            <Text style={styles.subtitle}>Enter your authenticator code</Text>
+
+            <TextInput
+              placeholder="123456 or recovery code"
+              value={code}
+              onChangeText={setCode}
+              style={styles.input}
+              autoCapitalize="none"
+              placeholderTextColor="#999"
+            />
+
+            <TouchableOpacity style={styles.button} onPress={handleVerify}>
+              <Text style={styles.buttonText}>Verify</Text>
+            </TouchableOpacity>
+
+            <Text
+              style={[styles.footerText, styles.linkText]}
+              onPress={() => {
+                setChallengeToken(null);
+                setCode("");
+              }}
+            >
+              Back to sign in
+            </Text>
+          </View>
+        </KeyboardAvoidingView>
+      </LinearGradient>
+    );
+  }
+
   return (
     <LinearGradient
       colors={["#432c83B3", "#2575fcB3"]}
@@ -0,0 +1,293 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Package-level summary:
+// This file implements per-owner data isolation. A user can see a property when
+// they own it (properties.ownerUserId) or have been granted access through the
+// propertyAccess table; every other entity is scoped through the property it
+// belongs to. The SQL fragments and canAccess* helpers here are used by the
+// entity files to filter queries. Handlers: CreatePropertyAccessHandler,
+// GetPropertyAccessHandler, DeletePropertyAccessHandler. DB helpers:
+// GrantPropertyAccess, GetPropertyAccessByProperty, RevokePropertyAccess.
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// PROPERTY ACCESS
+type PropertyAccess struct {
+	PropertyID      int   `db:"propertyId" json:"propertyId"`
+	UserID          int   `db:"userId" json:"userId"`
+	GrantedByUserID int   `db:"grantedByUserId" json:"grantedByUserId"`
+	GrantedUnix     int64 `db:"grantedUnix" json:"grantedUnix"`
+}
+
+// == Scope helpers ================================================================
+
+// accessiblePropertiesSQL selects the propertyIds visible to a user: properties
+// they own plus properties granted to them. Bind the user ID twice.
+const accessiblePropertiesSQL = `SELECT propertyId FROM properties WHERE ownerUserId = ?
+	UNION SELECT propertyId FROM propertyAccess WHERE userId = ?`
+
+// accessibleUnitsSQL selects the propertyUnitIds on accessible properties.
+// Bind the user ID twice.
+const accessibleUnitsSQL = `SELECT propertyUnitId FROM propertyUnits WHERE propertyId IN (` + accessiblePropertiesSQL + `)`
+
+// accessibleLeasesSQL selects the leaseIds on accessible units, leaving out
+// leases in the trash: the payments archived with a trashed lease are hidden
+// with it and come back when it is restored. Bind the user ID twice.
+const accessibleLeasesSQL = `SELECT leaseId FROM leases WHERE deletedUnix IS NULL AND propertyUnitId IN (` + accessibleUnitsSQL + `)`
+
+// accessibleTenantsSQL selects tenants created by the user or by the owner of a
+// property they were granted, plus any tenant leasing an accessible unit.
+// Bind the user ID four times.
+const accessibleTenantsSQL = `SELECT tenantId FROM tenants WHERE ownerUserId = ?
+	OR ownerUserId IN (SELECT p.ownerUserId FROM properties p JOIN propertyAccess pa ON pa.propertyId = p.propertyId WHERE pa.userId = ?)
+	UNION SELECT tenantId FROM leases WHERE propertyUnitId IN (` + accessibleUnitsSQL + `)`
+
+// accessibleUsersSQL selects the caller, staff granted on the caller's
+// properties, and owners of properties the caller was granted.
+// Bind the user ID three times.
+const accessibleUsersSQL = `SELECT CAST(? AS INTEGER) AS userId
+	UNION SELECT pa.userId FROM propertyAccess pa JOIN properties p ON pa.propertyId = p.propertyId WHERE p.ownerUserId = ?
+	UNION SELECT p.ownerUserId FROM properties p JOIN propertyAccess pa ON pa.propertyId = p.propertyId WHERE pa.userId = ?`
+
+// managedUsersSQL selects the users the caller may update or delete: themself
+// and the staff accounts they created. Granting a property does not make a
+// user managed, and no owner manages another owner. Bind the user ID twice.
+const managedUsersSQL = `SELECT CAST(? AS INTEGER) AS userId
+	UNION SELECT userId FROM users WHERE createdByUserId = ? AND COALESCE(userRole, 'owner') <> 'owner'`
+
+// scopeArgs returns the user ID repeated n times for binding a scope fragment.
+func scopeArgs(userID, n int) []interface{} {
+	args := make([]interface{}, n)
+	for i := range args {
+		args[i] = userID
+	}
+	return args
+}
+
+// currentUserID returns the authenticated user ID placed in the context by
+// AuthMiddleware. Routes behind the middleware always have one.
+func currentUserID(r *http.Request) int {
+	id, _ := userIDFromContext(r.Context())
+	return id
+}
+
+// canAccess runs an "id IN (scope)" existence check.
+func canAccess(db *sql.DB, scopeSQL string, n, userID, id int) (bool, error) {
+	var ok bool
+	args := append([]interface{}{id}, scopeArgs(userID, n)...)
+	err := db.QueryRow(`SELECT ? IN (`+scopeSQL+`)`, args...).Scan(&ok)
+	return ok, err
+}
+
+// canAccessLive is canAccess for the tables with soft delete (see trash.go):
+// the row must also exist and be out of the trash.
+func canAccessLive(db *sql.DB, table, idColumn, scopeSQL string, n, userID, id int) (bool, error) {
+	var ok bool
+	args := append([]interface{}{id}, scopeArgs(userID, n)...)
+	err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM `+table+` WHERE `+idColumn+` = ? AND deletedUnix IS NULL
+		AND `+idColumn+` IN (`+scopeSQL+`))`, args...).Scan(&ok)
+	return ok, err
+}
+
+// canAccessProperty reports whether the user owns or was granted the property
+// and it is not in the trash.
+func canAccessProperty(db *sql.DB, userID, propertyID int) (bool, error) {
+	return canAccessLive(db, "properties", "propertyId", accessiblePropertiesSQL, 2, userID, propertyID)
+}
+
+// canAccessUnit reports whether the unit belongs to an accessible property and
+// is not in the trash.
+func canAccessUnit(db *sql.DB, userID, unitID int) (bool, error) {
+	return canAccessLive(db, "propertyUnits", "propertyUnitId", accessibleUnitsSQL, 2, userID, unitID)
+}
+
+// canAccessLease reports whether the lease is on an accessible unit and is not
+// in the trash.
+func canAccessLease(db *sql.DB, userID, leaseID int) (bool, error) {
+	return canAccessLive(db, "leases", "leaseId", accessibleLeasesSQL, 2, userID, leaseID)
+}
+
+// canAccessTenant reports whether the tenant is visible to the user and is not
+// in the trash.
+func canAccessTenant(db *sql.DB, userID, tenantID int) (bool, error) {
+	return canAccessLive(db, "tenants", "tenantId", accessibleTenantsSQL, 4, userID, tenantID)
+}
+
+// canAccessUser reports whether the user record is visible to the caller.
+func canAccessUser(db *sql.DB, userID, targetID int) (bool, error) {
+	return canAccess(db, accessibleUsersSQL, 3, userID, targetID)
+}
+
+// canManageUser reports whether the caller may modify the user record.
+func canManageUser(db *sql.DB, userID, targetID int) (bool, error) {
+	return canAccess(db, managedUsersSQL, 2, userID, targetID)
+}
+
+// == Handlers =====================================================================
+// POST
+// CreatePropertyAccessHandler returns an HTTP handler for granting a user access to a property.
+// Only the owner of the property may grant access.
+func CreatePropertyAccessHandler(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var a PropertyAccess
+		if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		if a.PropertyID == 0 || a.UserID == 0 {
+			respondError(w, http.StatusBadRequest, "propertyId and userId required")
+			return
+		}
+		prop, err := s.Properties.GetByID(currentUserID(r), a.PropertyID)
+		if err != nil || prop.OwnerUserID != currentUserID(r) {
+			respondError(w, http.StatusNotFound, "property not found")
+			return
+		}
+		if _, err := s.Users.GetRole(a.UserID); err != nil {
+			respondError(w, http.StatusNotFound, "user not found")
+			return
+		}
+		a.GrantedByUserID = currentUserID(r)
+		a.GrantedUnix = time.Now().Unix()
+		if err := s.PropertyAccess.Grant(&a); err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		respondJSON(w, http.StatusCreated, a)
+	}
+}
+
+// GET
+// GetPropertyAccessHandler returns an HTTP handler for listing the users granted
+// access to a property, identified by /v1/propertyAccess/{propertyId}.
+func GetPropertyAccessHandler(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("propertyId"))
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "invalid propertyId")
+			return
+		}
+		if ok, err := s.Access.CanAccessProperty(currentUserID(r), id); err != nil || !ok {
+			respondError(w, http.StatusNotFound, "property not found")
+			return
+		}
+		list, err := s.PropertyAccess.GetByProperty(id)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		respondJSON(w, http.StatusOK, list)
+	}
+}
+
+// DELETE
+// DeletePropertyAccessHandler returns an HTTP handler for revoking a user's access
+// to a property, identified by /v1/propertyAccess/{propertyId}/{userId}.
+func DeletePropertyAccessHandler(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		propertyID, err1 := strconv.Atoi(r.PathValue("propertyId"))
+		userID, err2 := strconv.Atoi(r.PathValue("userId"))
+		if err1 != nil || err2 != nil {
+			respondError(w, http.StatusBadRequest, "invalid id")
+			return
+		}
+		prop, err := s.Properties.GetByID(currentUserID(r), propertyID)
+		if err != nil || prop.OwnerUserID != currentUserID(r) {
+			respondError(w, http.StatusNotFound, "property not found")
+			return
+		}
+		if err := s.PropertyAccess.Revoke(propertyID, userID); err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+	}
+}
+
+// == SQL Queries =====================================================================
+
+// GrantPropertyAccess inserts or refreshes an access grant.
+// Returns error if insertion fails.
+func GrantPropertyAccess(db *sql.DB, a *PropertyAccess) error {
+	_, err := db.Exec(`INSERT INTO propertyAccess (propertyId, userId, grantedByUserId, grantedUnix) VALUES (?, ?, ?, ?)
+	ON CONFLICT (propertyId, userId) DO UPDATE SET grantedByUserId=excluded.grantedByUserId, grantedUnix=excluded.grantedUnix`,
+		a.PropertyID, a.UserID, a.GrantedByUserID, a.GrantedUnix)
+	return err
+}
+
+// GetPropertyAccessByProperty retrieves all access grants for a property.
+// Returns a slice of PropertyAccess and error if query fails.
+func GetPropertyAccessByProperty(db *sql.DB, propertyID int) ([]PropertyAccess, error) {
+	rows, err := db.Query(`SELECT propertyId, userId, COALESCE(grantedByUserId, 0), grantedUnix FROM propertyAccess WHERE propertyId=?`, propertyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []PropertyAccess
+	for rows.Next() {
+		var a PropertyAccess
+		if err := rows.Scan(&a.PropertyID, &a.UserID, &a.GrantedByUserID, &a.GrantedUnix); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, nil
+}
+
+// RevokePropertyAccess removes an access grant.
+// Returns error if deletion fails.
+func RevokePropertyAccess(db *sql.DB, propertyID, userID int) error {
+	_, err := db.Exec(`DELETE FROM propertyAccess WHERE propertyId=? AND userId=?`, propertyID, userID)
+	return err
+}
+
+// checkAffected converts an UPDATE or DELETE that matched no rows into
+// sql.ErrNoRows so handlers can answer 404 for records outside the caller's scope.
+func checkAffected(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// canActForOwner reports whether the user may create records owned by ownerID:
+// either it is the user themself or an owner who granted them a property.
+func canActForOwner(db *sql.DB, userID, ownerID int) (bool, error) {
+	if userID == ownerID {
+		return true, nil
+	}
+	var ok bool
+	err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM properties p JOIN propertyAccess pa ON pa.propertyId = p.propertyId
+		WHERE pa.userId = ? AND p.ownerUserId = ?)`, userID, ownerID).Scan(&ok)
+	return ok, err
+}
@@ -12,16 +12,17 @@ package main
 // This file implements the activity log HTTP handlers and database helpers
 // used to create, read, update, and delete activity log records. Handlers
 // exposed: CreateActivityLogHandler, GetActivityLogHandler,
-// UpdateActivityLogHandler, DeleteActivityLogHandler. DB helpers include
-// CreateActivityLog, GetAllActivityLogs, GetActivityLogByID, UpdateActivityLog,
+// UpdateActivityLogHandler, PatchActivityLogHandler, DeleteActivityLogHandler. DB helpers include
+// CreateActivityLog, ListActivityLogs, GetActivityLogByID, UpdateActivityLog,
 // and DeleteActivityLog. The handlers validate input and use JSON request/response.
 
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
-	"strings"
+	"time"
 )
 
 // ACTIVITY LOGS
@@ -32,13 +33,15 @@ type ActivityLog struct {
 	EntityID      int    `db:"entityId" json:"entityId"`
 	Action        string `db:"action" json:"action"`
 	TimestampUnix int64  `db:"timestampUnix" json:"timestampUnix"`
+	Version       int    `db:"version" json:"version,omitempty"`
+	UpdatedUnix   *int64 `db:"updatedUnix" json:"updatedUnix,omitempty"`
 }
 
 // == Handlers ========================================================================
 // POST
 // CreateActivityLogHandler returns an HTTP handler for creating a new activity log entry.
 // Accepts a JSON body, validates required fields, inserts into DB, and responds with the created log.
-func CreateActivityLogHandler(db *sql.DB) http.HandlerFunc {
+func CreateActivityLogHandler(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -53,12 +56,16 @@ func CreateActivityLogHandler(db *sql.DB) http.HandlerFunc {
 			respondError(w, http.StatusBadRequest, "timestampUnix required")
 			return
 		}
-		id, err := CreateActivityLog(db, &a)
+		// Entries are always attributed to the caller
+		a.UserID = currentUserID(r)
+		id, err := s.Activity.Create(&a)
 		if err != nil {
 			respondError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
 		a.LogID = id
+		a.Version = 1
+		setETag(w, a.Version)
 		respondJSON(w, http.StatusCreated, a)
 	}
 }
@@ -66,28 +73,34 @@ func CreateActivityLogHandler(db *sql.DB) http.HandlerFunc {
 // GET
 // GetActivityLogHandler returns an HTTP handler for retrieving activity logs.
 // If no ID is provided, returns all logs; otherwise, returns the log with the given ID.
-func GetActivityLogHandler(db *sql.DB) http.HandlerFunc {
+func GetActivityLogHandler(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		idStr := strings.TrimPrefix(r.URL.Path, "/activity/")
-		if idStr == "" || idStr == "/" {
-			list, err := GetAllActivityLogs(db)
+		idStr := r.PathValue("id")
+		if idStr == "" {
+			p, err := ParseListParams(r, activityListSpec)
+			if err != nil {
+				respondError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			page, err := s.Activity.List(currentUserID(r), p)
 			if err != nil {
 				respondError(w, http.StatusInternalServerError, err.Error())
 				return
 			}
-			respondJSON(w, http.StatusOK, list)
+			respondList(w, r, page)
 			return
 		}
-		id, err := strconv.Atoi(strings.Trim(idStr, "/"))
+		id, err := strconv.Atoi(idStr)
 		if err != nil {
 			respondError(w, http.StatusBadRequest, "invalid id")
 			return
 		}
-		a, err := GetActivityLogByID(db, id)
+		a, err := s.Activity.GetByID(currentUserID(r), id)
 		if err != nil {
 			respondError(w, http.StatusNotFound, "not found")
 			return
 		}
+		setETag(w, a.Version)
 		respondJSON(w, http.StatusOK, a)
 	}
 }
@@ -95,7 +108,7 @@ func GetActivityLogHandler(db *sql.DB) http.HandlerFunc {
 // PUT
 // UpdateActivityLogHandler returns an HTTP handler for updating an activity log entry.
 // Accepts a JSON body, validates logId, updates the DB, and responds with status.
-func UpdateActivityLogHandler(db *sql.DB) http.HandlerFunc {
+func UpdateActivityLogHandler(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPut {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -106,35 +119,77 @@ func UpdateActivityLogHandler(db *sql.DB) http.HandlerFunc {
 			respondError(w, http.StatusBadRequest, "invalid json")
 			return
 		}
+		if !bindPathID(w, r, &a.LogID, "logId") {
+			return
+		}
 		if a.LogID == 0 {
 			respondError(w, http.StatusBadRequest, "logId required")
 			return
 		}
-		if err := UpdateActivityLog(db, &a); err != nil {
-			respondError(w, http.StatusInternalServerError, err.Error())
+		if a.TimestampUnix == 0 {
+			respondError(w, http.StatusBadRequest, "timestampUnix required")
+			return
+		}
+		if ok, err := s.Access.CanAccessUser(currentUserID(r), a.UserID); err != nil || !ok {
+			respondError(w, http.StatusNotFound, "user not found")
+			return
+		}
+		version, ifMatch, ok := requireVersion(w, r, a.Version)
+		if !ok {
+			return
+		}
+		a.Version = version
+		if err := s.Activity.Update(currentUserID(r), &a); err != nil {
+			if errors.Is(err, errStaleVersion) {
+				if current, err := s.Activity.GetByID(currentUserID(r), a.LogID); err == nil {
+					respondStale(w, ifMatch, current, current.Version)
+					return
+				}
+				err = sql.ErrNoRows
+			}
+			if errors.Is(err, sql.ErrNoRows) {
+				respondError(w, http.StatusNotFound, "not found")
+			} else {
+				respondError(w, http.StatusInternalServerError, err.Error())
+			}
 			return
 		}
-		respondJSON(w, http.StatusOK, map[string]string{"status": "updated"})
+		setETag(w, a.Version)
+		respondJSON(w, http.StatusOK, map[string]interface{}{"status": "updated", "version": a.Version})
 	}
 }
 
+// PATCH
+// PatchActivityLogHandler returns an HTTP handler for partially updating a activity log.
+// Accepts a JSON merge patch, applies it to the current activity log, and passes the
+// result to UpdateActivityLogHandler (see patch.go).
+func PatchActivityLogHandler(s *Store) http.HandlerFunc {
+	return PatchHandler(func(r *http.Request, id int) (*ActivityLog, error) {
+		return s.Activity.GetByID(currentUserID(r), id)
+	}, UpdateActivityLogHandler(s))
+}
+
 // DELETE
 // DeleteActivityLogHandler returns an HTTP handler for deleting an activity log entry by ID.
 // Accepts a DELETE request, removes the log from DB, and responds with status.
-func DeleteActivityLogHandler(db *sql.DB) http.HandlerFunc {
+func DeleteActivityLogHandler(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodDelete {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
-		idStr := strings.TrimPrefix(r.URL.Path, "/activity/")
-		id, err := strconv.Atoi(strings.Trim(idStr, "/"))
+		idStr := r.PathValue("id")
+		id, err := strconv.Atoi(idStr)
 		if err != nil {
 			respondError(w, http.StatusBadRequest, "invalid id")
 			return
 		}
-		if err := DeleteActivityLog(db, id); err != nil {
-			respondError(w, http.StatusInternalServerError, err.Error())
+		if err := s.Activity.Delete(currentUserID(r), id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				respondError(w, http.StatusNotFound, "not found")
+			} else {
+				respondError(w, http.StatusInternalServerError, err.Error())
+			}
 			return
 		}
 		respondJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
@@ -145,56 +200,73 @@ func DeleteActivityLogHandler(db *sql.DB) http.HandlerFunc {
 // CreateActivityLog inserts a new activity log into the database.
 // Returns the new log ID and error if insertion fails.
 func CreateActivityLog(db *sql.DB, a *ActivityLog) (int, error) {
-	res, err := db.Exec(`INSERT INTO activityLogs (userId, entityType, entityId, action, timestampUnix) VALUES (?, ?, ?, ?, ?)`,
-		a.UserID, a.EntityType, a.EntityID, a.Action, a.TimestampUnix)
-	if err != nil {
-		return 0, err
-	}
-	id, _ := res.LastInsertId()
-	return int(id), nil
+	var id int
+	err := db.QueryRow(`INSERT INTO activityLogs (userId, entityType, entityId, action, timestampUnix) VALUES (?, ?, ?, ?, ?) RETURNING logId`,
+		a.UserID, a.EntityType, a.EntityID, a.Action, a.TimestampUnix).Scan(&id)
+	return id, err
 }
 
-// UpdateActivityLog updates an existing activity log in the database.
-// Returns error if update fails.
-func UpdateActivityLog(db *sql.DB, a *ActivityLog) error {
-	_, err := db.Exec(`UPDATE activityLogs SET userId=?, entityType=?, entityId=?, action=?, timestampUnix=? WHERE logId=?`,
-		a.UserID, a.EntityType, a.EntityID, a.Action, a.TimestampUnix, a.LogID)
+// UpdateActivityLog updates an existing activity log visible to userID in the database if
+// it is still at a.Version (0 skips the check), then advances a.Version.
+// Returns sql.ErrNoRows if the log is not visible to the user, or
+// errStaleVersion if it was changed in the meantime.
+func UpdateActivityLog(db *sql.DB, userID int, a *ActivityLog) error {
+	now := time.Now().Unix()
+	args := append([]interface{}{a.UserID, a.EntityType, a.EntityID, a.Action, a.TimestampUnix, now, a.LogID, a.Version}, scopeArgs(userID, 3)...)
+	err := db.QueryRow(`UPDATE activityLogs SET userId=?, entityType=?, entityId=?, action=?, timestampUnix=?, version=version+1, updatedUnix=?
+		WHERE logId=? AND ? IN (0, version) AND userId IN (`+accessibleUsersSQL+`) RETURNING version`, args...).Scan(&a.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return staleOrMissing(GetActivityLogByID(db, userID, a.LogID))
+	}
+	if err == nil {
+		a.UpdatedUnix = &now
+	}
 	return err
 }
 
-// DeleteActivityLog removes an activity log from the database by logId.
-// Returns error if deletion fails.
-func DeleteActivityLog(db *sql.DB, id int) error {
-	_, err := db.Exec(`DELETE FROM activityLogs WHERE logId=?`, id)
-	return err
+// DeleteActivityLog removes an activity log visible to userID from the database by logId.
+// Returns sql.ErrNoRows if the log is not visible to the user.
+func DeleteActivityLog(db *sql.DB, userID, id int) error {
+	args := append([]interface{}{id}, scopeArgs(userID, 3)...)
+	return checkAffected(db.Exec(`DELETE FROM activityLogs WHERE logId=? AND userId IN (`+accessibleUsersSQL+`)`, args...))
 }
 
-// GetAllActivityLogs retrieves all activity logs from the database.
-// Returns a slice of ActivityLog and error if query fails.
-func GetAllActivityLogs(db *sql.DB) ([]ActivityLog, error) {
-	rows, err := db.Query(`SELECT logId, userId, entityType, entityId, action, timestampUnix FROM activityLogs`)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
+// activityListSpec describes how activity log entries are listed, sorted and filtered (see list.go).
+var activityListSpec = listSpec{
+	columns:     `logId, userId, entityType, entityId, action, timestampUnix, version, updatedUnix`,
+	table:       `activityLogs`,
+	idColumn:    `logId`,
+	defaultSort: "-timestampUnix",
+	sorts: map[string]string{
+		"logId":         "logId",
+		"timestampUnix": "timestampUnix",
+	},
+	filters: map[string]listFilter{
+		"userId":     {expr: "userId", op: "=", isInt: true},
+		"entityType": {expr: "entityType", op: "="},
+		"entityId":   {expr: "entityId", op: "=", isInt: true},
+		"action":     {expr: "action", op: "="},
+		"from":       {expr: "timestampUnix", op: ">=", isInt: true},
+		"to":         {expr: "timestampUnix", op: "<=", isInt: true},
+	},
+}
 
-	var out []ActivityLog
-	for rows.Next() {
-		var a ActivityLog
-		if err := rows.Scan(&a.LogID, &a.UserID, &a.EntityType, &a.EntityID, &a.Action, &a.TimestampUnix); err != nil {
-			return nil, err
-		}
-		out = append(out, a)
-	}
-	return out, nil
+// ListActivityLogs returns one page of the activity log entries visible to userID,
+// filtered and sorted as requested in p.
+func ListActivityLogs(db *sql.DB, userID int, p ListParams) (*Page[ActivityLog], error) {
+	return queryPage(db, activityListSpec, p, `userId IN (`+accessibleUsersSQL+`)`, scopeArgs(userID, 3), func(a *ActivityLog) []interface{} {
+		return []interface{}{&a.LogID, &a.UserID, &a.EntityType, &a.EntityID, &a.Action, &a.TimestampUnix, &a.Version, &a.UpdatedUnix}
+	})
 }
 
-// GetActivityLogByID retrieves an activity log by logId from the database.
+// GetActivityLogByID retrieves an activity log visible to userID by logId from the database.
 // Returns pointer to ActivityLog and error if not found or query fails.
-func GetActivityLogByID(db *sql.DB, id int) (*ActivityLog, error) {
+func GetActivityLogByID(db *sql.DB, userID, id int) (*ActivityLog, error) {
 	var a ActivityLog
-	err := db.QueryRow(`SELECT logId, userId, entityType, entityId, action, timestampUnix FROM activityLogs WHERE logId=?`, id).
-		Scan(&a.LogID, &a.UserID, &a.EntityType, &a.EntityID, &a.Action, &a.TimestampUnix)
+	args := append([]interface{}{id}, scopeArgs(userID, 3)...)
+	err := db.QueryRow(`SELECT logId, userId, entityType, entityId, action, timestampUnix, version, updatedUnix FROM activityLogs
+		WHERE logId=? AND userId IN (`+accessibleUsersSQL+`)`, args...).
+		Scan(&a.LogID, &a.UserID, &a.EntityType, &a.EntityID, &a.Action, &a.TimestampUnix, &a.Version, &a.UpdatedUnix)
 	if err != nil {
 		return nil, err
 	}
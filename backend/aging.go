@@ -0,0 +1,260 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Package-level summary:
+// This file implements the aged receivables report. The unpaid part of each
+// charge that has come due (see the ledger in ledger.go) is put in a bucket by
+// how many days it is past due: current (0-30), 31-60, 61-90 and over 90.
+// "Current" is the usual label of the first 30-day bucket: it holds charges up
+// to 30 days late, while charges not yet due are not in the report. The
+// report has one row per lease, property or owner, and is returned as JSON or
+// CSV. Handlers include GetAgingReportHandler.
+// DB helpers: GetAgingReport.
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// agingGroups are the values of the groupBy parameter, with the ID columns
+// of a CSV row for each.
+var agingGroups = map[string][]string{
+	"lease":    {"leaseId", "propertyId", "ownerUserId"},
+	"property": {"propertyId", "ownerUserId"},
+	"owner":    {"ownerUserId"},
+}
+
+// AgingBuckets holds amounts owed by how many days past due they are.
+type AgingBuckets struct {
+	Current int `json:"current"` // 0 to 30 days past due, not "not yet due"
+	Days30  int `json:"days30"`  // 31 to 60 days
+	Days60  int `json:"days60"`  // 61 to 90 days
+	Days90  int `json:"days90"`  // over 90 days
+	Total   int `json:"total"`   // Sum of the buckets
+	Credit  int `json:"credit"`  // Payments and credits not yet applied to a charge
+}
+
+// addDue puts amount, days past due, in its bucket.
+func (b *AgingBuckets) addDue(days, amount int) {
+	switch {
+	case days <= 30:
+		b.Current += amount
+	case days <= 60:
+		b.Days30 += amount
+	case days <= 90:
+		b.Days60 += amount
+	default:
+		b.Days90 += amount
+	}
+	b.Total += amount
+}
+
+// add adds the amounts of o to b.
+func (b *AgingBuckets) add(o AgingBuckets) {
+	b.Current += o.Current
+	b.Days30 += o.Days30
+	b.Days60 += o.Days60
+	b.Days90 += o.Days90
+	b.Total += o.Total
+	b.Credit += o.Credit
+}
+
+// AgingRow is one lease, property or owner in the report. The IDs that do not
+// apply to the grouping are left out.
+type AgingRow struct {
+	LeaseID     int    `json:"leaseId,omitempty"`
+	PropertyID  int    `json:"propertyId,omitempty"`
+	OwnerUserID int    `json:"ownerUserId,omitempty"`
+	Name        string `json:"name"` // Tenant and unit, property address, or owner name
+	AgingBuckets
+}
+
+// AgingReport is the aged receivables of the leases visible to a user.
+type AgingReport struct {
+	AsOfUnix int64        `json:"asOfUnix"`
+	GroupBy  string       `json:"groupBy"`
+	Rows     []AgingRow   `json:"rows"`
+	Totals   AgingBuckets `json:"totals"`
+}
+
+// == Handlers =============================================================================
+// GET
+// GetAgingReportHandler returns an HTTP handler for GET /v1/reports/aging.
+// The groupBy parameter is lease (the default), property or owner. The report
+// is CSV when format=csv is given or the Accept header asks for text/csv.
+func GetAgingReportHandler(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		q := r.URL.Query()
+		groupBy := q.Get("groupBy")
+		if groupBy == "" {
+			groupBy = "lease"
+		}
+		if _, ok := agingGroups[groupBy]; !ok {
+			respondError(w, http.StatusBadRequest, "groupBy must be lease, property or owner")
+			return
+		}
+		format := q.Get("format")
+		if format == "" && strings.Contains(r.Header.Get("Accept"), "text/csv") {
+			format = "csv"
+		}
+		if format != "" && format != "csv" && format != "json" {
+			respondError(w, http.StatusBadRequest, "format must be json or csv")
+			return
+		}
+
+		report, err := s.Ledger.AgingReport(currentUserID(r), groupBy, time.Now())
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if format == "csv" {
+			writeAgingCSV(w, report)
+			return
+		}
+		respondJSON(w, http.StatusOK, report)
+	}
+}
+
+// writeAgingCSV writes the report as a CSV download, one line per row and a
+// last line with the totals.
+func writeAgingCSV(w http.ResponseWriter, report *AgingReport) {
+	ids := agingGroups[report.GroupBy]
+	date := time.Unix(report.AsOfUnix, 0).Format("2006-01-02")
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="aging-`+report.GroupBy+"-"+date+`.csv"`)
+	w.WriteHeader(http.StatusOK)
+
+	cw := csv.NewWriter(w)
+	cw.Write(append(append([]string{}, ids...), "name", "current", "days30", "days60", "days90", "total", "credit"))
+	line := func(idValues map[string]int, name string, b AgingBuckets) {
+		var rec []string
+		for _, id := range ids {
+			if v := idValues[id]; v != 0 {
+				rec = append(rec, strconv.Itoa(v))
+			} else {
+				rec = append(rec, "")
+			}
+		}
+		rec = append(rec, name)
+		for _, v := range []int{b.Current, b.Days30, b.Days60, b.Days90, b.Total, b.Credit} {
+			rec = append(rec, strconv.Itoa(v))
+		}
+		cw.Write(rec)
+	}
+	for _, row := range report.Rows {
+		line(map[string]int{"leaseId": row.LeaseID, "propertyId": row.PropertyID, "ownerUserId": row.OwnerUserID}, row.Name, row.AgingBuckets)
+	}
+	line(nil, "Total", report.Totals)
+	cw.Flush()
+}
+
+// == SQL Queries ======================================================================
+// GetAgingReport builds the aged receivables of the live leases visible to
+// userID as of now, grouped by "lease", "property" or "owner". What a charge
+// still owes comes from the lease ledger, so it matches the payment
+// allocations; charges not yet due are left out. Rows with nothing owed and
+// no credit are left out too.
+func GetAgingReport(db *sql.DB, userID int, groupBy string, now time.Time) (*AgingReport, error) {
+	rows, err := db.Query(`SELECT l.leaseId, t.tenantFirstName, t.tenantLastName, p.propertyId, COALESCE(p.propertyStreetAddress, ''),
+			COALESCE(u.propertyUnitNumber, ''), COALESCE(p.ownerUserId, 0), COALESCE(o.userFirstName, ''), COALESCE(o.userLastName, '')
+		FROM leases l
+		JOIN tenants t ON t.tenantId = l.tenantId
+		JOIN propertyUnits u ON u.propertyUnitId = l.propertyUnitId
+		JOIN properties p ON p.propertyId = u.propertyId
+		LEFT JOIN users o ON o.userId = p.ownerUserId
+		WHERE l.deletedUnix IS NULL AND l.leaseId IN (`+accessibleLeasesSQL+`)
+		ORDER BY l.leaseId`, scopeArgs(userID, 2)...)
+	if err != nil {
+		return nil, err
+	}
+	type leaseInfo struct {
+		row                AgingRow
+		address, ownerName string
+	}
+	var leases []leaseInfo
+	for rows.Next() {
+		var li leaseInfo
+		var first, last, unit, ownerFirst, ownerLast string
+		if err := rows.Scan(&li.row.LeaseID, &first, &last, &li.row.PropertyID, &li.address, &unit, &li.row.OwnerUserID, &ownerFirst, &ownerLast); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		li.row.Name = first + " " + last
+		if li.address != "" {
+			li.row.Name += ", " + li.address
+		}
+		if unit != "" {
+			li.row.Name += " Unit " + unit
+		}
+		li.ownerName = strings.TrimSpace(ownerFirst + " " + ownerLast)
+		if li.ownerName == "" {
+			li.ownerName = "No owner"
+		}
+		leases = append(leases, li)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	report := &AgingReport{AsOfUnix: now.Unix(), GroupBy: groupBy, Rows: []AgingRow{}}
+	today := startOfDay(now)
+	groups := map[int]*AgingRow{}
+	var keys []int
+	for _, li := range leases {
+		ledger, err := loadLedger(db, li.row.LeaseID, now)
+		if err != nil {
+			return nil, err
+		}
+		row := li.row
+		for _, e := range ledger.Entries {
+			if e.Unpaid == nil || *e.Unpaid == 0 || e.DateUnix > now.Unix() {
+				continue
+			}
+			days := int(today.Sub(startOfDay(time.Unix(e.DateUnix, 0))).Hours()+12) / 24
+			row.addDue(days, *e.Unpaid)
+		}
+		row.Credit = ledger.UnappliedCredit
+		if row.Total == 0 && row.Credit == 0 {
+			continue
+		}
+		report.Totals.add(row.AgingBuckets)
+
+		key := row.LeaseID
+		switch groupBy {
+		case "property":
+			key = row.PropertyID
+			row.LeaseID, row.Name = 0, li.address
+		case "owner":
+			key = row.OwnerUserID
+			row.LeaseID, row.PropertyID, row.Name = 0, 0, li.ownerName
+		}
+		if g, ok := groups[key]; ok {
+			g.add(row.AgingBuckets)
+			continue
+		}
+		groups[key] = &row
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+	for _, key := range keys {
+		report.Rows = append(report.Rows, *groups[key])
+	}
+	return report, nil
+}
@@ -0,0 +1,170 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Tests for aging.go: the bucket edges, the report grouped by lease, property
+// and owner, and its CSV form.
+
+import (
+	"encoding/csv"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAgingBuckets(t *testing.T) {
+	tests := []struct {
+		days int
+		want AgingBuckets
+	}{
+		{0, AgingBuckets{Current: 1, Total: 1}},
+		{30, AgingBuckets{Current: 1, Total: 1}},
+		{31, AgingBuckets{Days30: 1, Total: 1}},
+		{60, AgingBuckets{Days30: 1, Total: 1}},
+		{61, AgingBuckets{Days60: 1, Total: 1}},
+		{90, AgingBuckets{Days60: 1, Total: 1}},
+		{91, AgingBuckets{Days90: 1, Total: 1}},
+		{400, AgingBuckets{Days90: 1, Total: 1}},
+	}
+	for _, tc := range tests {
+		var b AgingBuckets
+		b.addDue(tc.days, 1)
+		if b != tc.want {
+			t.Errorf("%d days past due: %+v, want %+v", tc.days, b, tc.want)
+		}
+	}
+}
+
+func TestAgingReport(t *testing.T) {
+	s := newTestStore(t)
+	ownerID := createTestUser(t, s, "owner@example.com", "owner")
+	now := time.Date(2025, time.June, 15, 12, 0, 0, 0, time.Local)
+	ago := func(days int) int64 {
+		d := now.AddDate(0, 0, -days)
+		return time.Date(d.Year(), d.Month(), d.Day(), 9, 0, 0, 0, time.Local).Unix()
+	}
+	tenantID, err := s.Tenants.Create(&Tenant{OwnerUserID: ownerID, TenantFirstName: "Tomasz", TenantLastName: "Renter", TenantEmail: "tomasz@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newProperty := func(street string) int {
+		id, err := s.Properties.Create(&Property{OwnerUserID: ownerID, PropertyName: street, PropertyStreet: street, PropertyCity: "Morgantown"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	newLease := func(propertyID int, unit string) int {
+		unitID, err := s.Units.Create(&PropertyUnit{PropertyID: propertyID, PropertyUnitNumber: unit, PropertyUnitRentDefault: 1000})
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := s.Leases.Create(&Lease{TenantID: tenantID, PropertyUnitID: unitID, LeaseStartUnix: ago(200), LeaseRentAmount: 1000, LeaseRentDueDay: 1, LeaseStatus: "active"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	charge := func(leaseID, amount int, due int64) {
+		if _, err := s.Ledger.CreateCharge(&Charge{LeaseID: leaseID, ChargeType: ChargeFee, ChargeAmount: amount, ChargeDueUnix: due, ChargeDescription: "Fee"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	maple, oak := newProperty("1 Maple St"), newProperty("2 Oak St")
+	// One charge on each side of every bucket edge, and one not yet due
+	edges := newLease(maple, "1")
+	for i, days := range []int{0, 30, 31, 60, 61, 90, 91} {
+		charge(edges, 1<<i, ago(days))
+	}
+	charge(edges, 1000, ago(-5))
+	// Paid with 50 left over
+	credit := newLease(maple, "2")
+	charge(credit, 100, ago(10))
+	if _, err := s.Payments.Create(&Payment{LeaseID: credit, PaymentAmount: 150, PaymentDateUnix: ago(5), PaymentMethod: "check"}); err != nil {
+		t.Fatal(err)
+	}
+	late := newLease(oak, "1")
+	charge(late, 200, ago(45))
+	// Nothing owed, so left out
+	settled := newLease(oak, "2")
+	charge(settled, 300, ago(20))
+	if _, err := s.Payments.Create(&Payment{LeaseID: settled, PaymentAmount: 300, PaymentDateUnix: ago(20), PaymentMethod: "check"}); err != nil {
+		t.Fatal(err)
+	}
+
+	edgeBuckets := AgingBuckets{Current: 1 + 2, Days30: 4 + 8, Days60: 16 + 32, Days90: 64, Total: 127}
+	mapleBuckets := edgeBuckets
+	mapleBuckets.Credit = 50
+	oakBuckets := AgingBuckets{Days30: 200, Total: 200}
+	totals := mapleBuckets
+	totals.add(oakBuckets)
+	tests := []struct {
+		groupBy string
+		want    []AgingRow
+	}{
+		{"lease", []AgingRow{
+			{LeaseID: edges, PropertyID: maple, OwnerUserID: ownerID, Name: "Tomasz Renter, 1 Maple St Unit 1", AgingBuckets: edgeBuckets},
+			{LeaseID: credit, PropertyID: maple, OwnerUserID: ownerID, Name: "Tomasz Renter, 1 Maple St Unit 2", AgingBuckets: AgingBuckets{Credit: 50}},
+			{LeaseID: late, PropertyID: oak, OwnerUserID: ownerID, Name: "Tomasz Renter, 2 Oak St Unit 1", AgingBuckets: oakBuckets},
+		}},
+		{"property", []AgingRow{
+			{PropertyID: maple, OwnerUserID: ownerID, Name: "1 Maple St", AgingBuckets: mapleBuckets},
+			{PropertyID: oak, OwnerUserID: ownerID, Name: "2 Oak St", AgingBuckets: oakBuckets},
+		}},
+		{"owner", []AgingRow{
+			{OwnerUserID: ownerID, Name: "Test owner", AgingBuckets: totals},
+		}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.groupBy, func(t *testing.T) {
+			report, err := s.Ledger.AgingReport(ownerID, tc.groupBy, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(report.Rows, tc.want) {
+				t.Errorf("rows = %+v\nwant %+v", report.Rows, tc.want)
+			}
+			if report.Totals != totals {
+				t.Errorf("totals = %+v, want %+v", report.Totals, totals)
+			}
+		})
+	}
+
+	t.Run("csv", func(t *testing.T) {
+		report, err := s.Ledger.AgingReport(ownerID, "property", now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		writeAgingCSV(w, report)
+		if ct := w.Header().Get("Content-Type"); ct != "text/csv" {
+			t.Errorf("Content-Type = %q, want text/csv", ct)
+		}
+		if cd, want := w.Header().Get("Content-Disposition"), `attachment; filename="aging-property-2025-06-15.csv"`; cd != want {
+			t.Errorf("Content-Disposition = %q, want %q", cd, want)
+		}
+		records, err := csv.NewReader(w.Body).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		owner := strconv.Itoa(ownerID)
+		want := [][]string{
+			{"propertyId", "ownerUserId", "name", "current", "days30", "days60", "days90", "total", "credit"},
+			{strconv.Itoa(maple), owner, "1 Maple St", "3", "12", "48", "64", "127", "50"},
+			{strconv.Itoa(oak), owner, "2 Oak St", "0", "200", "0", "0", "200", "0"},
+			{"", "", "Total", "3", "212", "48", "64", "327", "50"},
+		}
+		if !reflect.DeepEqual(records, want) {
+			t.Errorf("csv = %q\nwant %q", records, want)
+		}
+	})
+}
@@ -0,0 +1,299 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Package-level summary:
+// This file allocates payments to the charges they pay for (migration 0008).
+// A payment can name the charges it is for; the rest of it, and every other
+// payment, pays the oldest open charges first. Credit adjustments are
+// allocated the same way. Whatever is left over is credit, which later charges
+// take as they are posted. Allocations are recomputed for the whole lease by
+// reallocateLease whenever its payments or charges change, keeping the
+// explicit ones. The ledger (see ledger.go) reads them to know what each charge
+// still owes. DB helpers: reallocateLease, reallocate, setExplicitAllocations,
+// GetPaymentAllocations.
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// errInvalidAllocation is wrapped by the errors for explicit allocations a
+// payment cannot have. Its message is meant for a 400 response.
+var errInvalidAllocation = errors.New("invalid allocation")
+
+// liveAllocationSQL limits paymentAllocations rows (as a) to those whose
+// payment is not in the trash; rows from credit adjustments always count.
+const liveAllocationSQL = `(a.paymentId IS NULL OR a.paymentId IN (SELECT paymentId FROM payments WHERE deletedUnix IS NULL))`
+
+// PaymentAllocation is the part of a payment applied to one charge. On input
+// only chargeId and amount are read.
+type PaymentAllocation struct {
+	ChargeID          int    `json:"chargeId"`
+	Amount            int    `json:"amount"`
+	Explicit          bool   `json:"explicit"`
+	ChargeType        string `json:"chargeType,omitempty"`
+	ChargeDescription string `json:"chargeDescription,omitempty"`
+	ChargeDueUnix     int64  `json:"chargeDueUnix,omitempty"`
+}
+
+// == SQL Queries ==================================================================
+
+// reallocate runs reallocateLease for leaseID in its own transaction.
+func reallocate(db *sql.DB, leaseID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := reallocateLease(tx, leaseID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// reallocateLease recomputes the allocations of one lease. Explicit
+// allocations of live payments are kept, reduced if the charge or payment no
+// longer has room for them. Then payments and credit adjustments, oldest
+// first, pay the oldest charges that are still open. Explicit allocations of
+// payments in the trash are left for when they are restored.
+func reallocateLease(q sqlQuerier, leaseID int) error {
+	// Open amount of each charge that can be paid: positive and not waived
+	open := map[int]int{}
+	var order []int
+	rows, err := q.Query(`SELECT chargeId, chargeAmount FROM charges
+		WHERE leaseId=? AND chargeAmount > 0 AND waivedUnix IS NULL ORDER BY chargeDueUnix, chargeId`, leaseID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var id, amount int
+		if err := rows.Scan(&id, &amount); err != nil {
+			rows.Close()
+			return err
+		}
+		open[id] = amount
+		order = append(order, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// What can pay them: live payments and credit adjustments
+	type source struct {
+		paymentID, creditID *int
+		dateUnix            int64
+		remaining           int
+	}
+	var sources []*source
+	payments := map[int]*source{}
+	rows, err = q.Query(`SELECT paymentId, paymentAmount, paymentDateUnix FROM payments WHERE leaseId=? AND deletedUnix IS NULL`, leaseID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var id int
+		src := &source{}
+		if err := rows.Scan(&id, &src.remaining, &src.dateUnix); err != nil {
+			rows.Close()
+			return err
+		}
+		src.paymentID = &id
+		payments[id] = src
+		sources = append(sources, src)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows, err = q.Query(`SELECT chargeId, -chargeAmount, chargeDueUnix FROM charges WHERE leaseId=? AND chargeAmount < 0 AND waivedUnix IS NULL`, leaseID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var id int
+		src := &source{}
+		if err := rows.Scan(&id, &src.remaining, &src.dateUnix); err != nil {
+			rows.Close()
+			return err
+		}
+		src.creditID = &id
+		sources = append(sources, src)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	sort.SliceStable(sources, func(i, j int) bool { return sources[i].dateUnix < sources[j].dateUnix })
+
+	if _, err := q.Exec(`DELETE FROM paymentAllocations
+		WHERE allocationExplicit = 0 AND chargeId IN (SELECT chargeId FROM charges WHERE leaseId=?)`, leaseID); err != nil {
+		return err
+	}
+
+	// Explicit allocations first, in the order they were made
+	type explicitRow struct{ id, paymentID, chargeID, amount int }
+	var explicit []explicitRow
+	rows, err = q.Query(`SELECT a.allocationId, a.paymentId, a.chargeId, a.allocatedAmount FROM paymentAllocations a
+		JOIN payments p ON p.paymentId = a.paymentId
+		WHERE p.leaseId=? AND p.deletedUnix IS NULL AND a.allocationExplicit = 1 ORDER BY a.allocationId`, leaseID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var e explicitRow
+		if err := rows.Scan(&e.id, &e.paymentID, &e.chargeID, &e.amount); err != nil {
+			rows.Close()
+			return err
+		}
+		explicit = append(explicit, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, e := range explicit {
+		src := payments[e.paymentID]
+		amount := min(e.amount, src.remaining, open[e.chargeID])
+		if amount != e.amount {
+			if amount <= 0 {
+				_, err = q.Exec(`DELETE FROM paymentAllocations WHERE allocationId=?`, e.id)
+			} else {
+				_, err = q.Exec(`UPDATE paymentAllocations SET allocatedAmount=? WHERE allocationId=?`, amount, e.id)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if amount > 0 {
+			src.remaining -= amount
+			open[e.chargeID] -= amount
+		}
+	}
+
+	// Then everything else, oldest charge first
+	for _, src := range sources {
+		for _, chargeID := range order {
+			if src.remaining <= 0 {
+				break
+			}
+			amount := min(src.remaining, open[chargeID])
+			if amount <= 0 {
+				continue
+			}
+			if _, err := q.Exec(`INSERT INTO paymentAllocations (chargeId, paymentId, creditChargeId, allocatedAmount) VALUES (?, ?, ?, ?)`,
+				chargeID, src.paymentID, src.creditID, amount); err != nil {
+				return err
+			}
+			src.remaining -= amount
+			open[chargeID] -= amount
+		}
+	}
+	return nil
+}
+
+// setExplicitAllocations replaces the allocations a payment of amount on
+// leaseID was told to make. Each must name a different open charge of the
+// lease, for a positive amount no larger than what other payments' explicit
+// allocations leave of it, and together they cannot exceed the payment.
+// Errors wrap errInvalidAllocation. Call reallocateLease afterwards.
+func setExplicitAllocations(q sqlQuerier, paymentID, leaseID, amount int, allocs []PaymentAllocation) error {
+	if _, err := q.Exec(`DELETE FROM paymentAllocations WHERE paymentId=?`, paymentID); err != nil {
+		return err
+	}
+	total := 0
+	seen := map[int]bool{}
+	for _, a := range allocs {
+		if a.Amount <= 0 {
+			return fmt.Errorf("%w: amount for charge %d must be positive", errInvalidAllocation, a.ChargeID)
+		}
+		if seen[a.ChargeID] {
+			return fmt.Errorf("%w: charge %d is listed twice", errInvalidAllocation, a.ChargeID)
+		}
+		seen[a.ChargeID] = true
+		total += a.Amount
+
+		var chargeAmount int
+		var waived bool
+		err := q.QueryRow(`SELECT chargeAmount, waivedUnix IS NOT NULL FROM charges WHERE chargeId=? AND leaseId=?`, a.ChargeID, leaseID).
+			Scan(&chargeAmount, &waived)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: charge %d is not on lease %d", errInvalidAllocation, a.ChargeID, leaseID)
+		}
+		if err != nil {
+			return err
+		}
+		if waived || chargeAmount <= 0 {
+			return fmt.Errorf("%w: charge %d cannot be paid", errInvalidAllocation, a.ChargeID)
+		}
+		var taken int
+		if err := q.QueryRow(`SELECT COALESCE(SUM(a.allocatedAmount), 0) FROM paymentAllocations a
+			WHERE a.chargeId=? AND a.allocationExplicit = 1 AND `+liveAllocationSQL, a.ChargeID).Scan(&taken); err != nil {
+			return err
+		}
+		if a.Amount > chargeAmount-taken {
+			return fmt.Errorf("%w: charge %d has only %d left to pay", errInvalidAllocation, a.ChargeID, chargeAmount-taken)
+		}
+		if _, err := q.Exec(`INSERT INTO paymentAllocations (chargeId, paymentId, allocatedAmount, allocationExplicit) VALUES (?, ?, ?, 1)`,
+			a.ChargeID, paymentID, a.Amount); err != nil {
+			return err
+		}
+	}
+	if total > amount {
+		return fmt.Errorf("%w: allocations total %d, more than the payment of %d", errInvalidAllocation, total, amount)
+	}
+	return nil
+}
+
+// GetPaymentAllocations returns how a payment is allocated, oldest charge
+// first, and the part of it left over as credit.
+func GetPaymentAllocations(db *sql.DB, paymentID int) ([]PaymentAllocation, int, error) {
+	var amount int
+	var trashed bool
+	if err := db.QueryRow(`SELECT paymentAmount, deletedUnix IS NOT NULL FROM payments WHERE paymentId=?`, paymentID).Scan(&amount, &trashed); err != nil {
+		return nil, 0, err
+	}
+	allocs := []PaymentAllocation{}
+	if trashed {
+		return allocs, 0, nil
+	}
+	rows, err := db.Query(`SELECT a.chargeId, a.allocatedAmount, a.allocationExplicit, c.chargeType, COALESCE(c.chargeDescription, ''), c.chargeDueUnix
+		FROM paymentAllocations a JOIN charges c ON c.chargeId = a.chargeId
+		WHERE a.paymentId=? ORDER BY c.chargeDueUnix, c.chargeId`, paymentID)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	left := amount
+	for rows.Next() {
+		var a PaymentAllocation
+		var explicit int
+		if err := rows.Scan(&a.ChargeID, &a.Amount, &explicit, &a.ChargeType, &a.ChargeDescription, &a.ChargeDueUnix); err != nil {
+			return nil, 0, err
+		}
+		a.Explicit = explicit == 1
+		left -= a.Amount
+		allocs = append(allocs, a)
+	}
+	return allocs, max(left, 0), rows.Err()
+}
+
+// reallocatePaymentLease reallocates the lease a payment belongs to, after
+// the payment was moved to or out of the trash.
+func reallocatePaymentLease(q sqlQuerier, paymentID int) error {
+	var leaseID int
+	if err := q.QueryRow(`SELECT leaseId FROM payments WHERE paymentId=?`, paymentID).Scan(&leaseID); err != nil {
+		return err
+	}
+	return reallocateLease(q, leaseID)
+}
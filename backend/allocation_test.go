@@ -0,0 +1,350 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Tests for allocation.go: payments pay the oldest charges first, explicit
+// allocations are checked against the payment and the charge, credit is taken
+// by later charges, and allocations follow payments that are updated, deleted
+// and restored. Also checks the 0008 backfill against reallocateLease.
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// allocationFixture is a lease with rent of 1000 charged for January to
+// March 2025.
+type allocationFixture struct {
+	s                *Store
+	ownerID, leaseID int
+	jan, feb, mar    int // rent charge IDs
+}
+
+func newAllocationFixture(t *testing.T) *allocationFixture {
+	t.Helper()
+	f := &allocationFixture{s: newTestStore(t)}
+	s := f.s
+	f.ownerID = createTestUser(t, s, "owner@example.com", "owner")
+	propertyID, err := s.Properties.Create(&Property{OwnerUserID: f.ownerID, PropertyName: "Maple", PropertyStreet: "1 Maple St", PropertyCity: "Morgantown"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unitID, err := s.Units.Create(&PropertyUnit{PropertyID: propertyID, PropertyUnitNumber: "1A", PropertyUnitRentDefault: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenantID, err := s.Tenants.Create(&Tenant{OwnerUserID: f.ownerID, TenantFirstName: "Tomasz", TenantLastName: "Renter", TenantEmail: "tomasz@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.leaseID, err = s.Leases.Create(&Lease{TenantID: tenantID, PropertyUnitID: unitID, LeaseStartUnix: f.day(time.January, 1).Unix(), LeaseRentAmount: 1000, LeaseRentDueDay: 1, LeaseStatus: "active"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Ledger.PostLeaseRentCharges(f.leaseID, f.day(time.March, 15)); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := queryIDs(s.DB, `SELECT chargeId FROM charges WHERE leaseId=? ORDER BY chargeDueUnix`, f.leaseID)
+	if err != nil || len(ids) != 3 {
+		t.Fatalf("rent charges %v, %v; want 3", ids, err)
+	}
+	f.jan, f.feb, f.mar = ids[0], ids[1], ids[2]
+	return f
+}
+
+func (f *allocationFixture) day(m time.Month, d int) time.Time {
+	return time.Date(2025, m, d, 9, 0, 0, 0, time.Local)
+}
+
+// pay records a payment on the fixture's lease.
+func (f *allocationFixture) pay(t *testing.T, amount int, date time.Time, allocs []PaymentAllocation) int {
+	t.Helper()
+	id, err := f.s.Payments.Create(&Payment{LeaseID: f.leaseID, PaymentAmount: amount, PaymentDateUnix: date.Unix(), PaymentMethod: "check", Allocations: allocs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// allocated returns the amount a payment pays toward each charge.
+func (f *allocationFixture) allocated(t *testing.T, paymentID int) map[int]int {
+	t.Helper()
+	rows, err := f.s.DB.Query(`SELECT chargeId, allocatedAmount FROM paymentAllocations WHERE paymentId=?`, paymentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	out := map[int]int{}
+	for rows.Next() {
+		var chargeID, amount int
+		if err := rows.Scan(&chargeID, &amount); err != nil {
+			t.Fatal(err)
+		}
+		out[chargeID] += amount
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func (f *allocationFixture) checkAllocated(t *testing.T, paymentID int, want map[int]int) {
+	t.Helper()
+	if got := f.allocated(t, paymentID); !reflect.DeepEqual(got, want) {
+		t.Errorf("payment %d allocated %v, want %v", paymentID, got, want)
+	}
+}
+
+func TestAllocateOldestFirst(t *testing.T) {
+	f := newAllocationFixture(t)
+	first := f.pay(t, 1500, f.day(time.February, 3), nil)
+	f.checkAllocated(t, first, map[int]int{f.jan: 1000, f.feb: 500})
+	second := f.pay(t, 1000, f.day(time.March, 3), nil)
+	f.checkAllocated(t, second, map[int]int{f.feb: 500, f.mar: 500})
+	checkBalance(t, f.s, f.ownerID, f.leaseID, f.day(time.March, 15), 500)
+
+	// A payment dated earlier goes first, whenever it was recorded
+	early := f.pay(t, 300, f.day(time.January, 2), nil)
+	f.checkAllocated(t, early, map[int]int{f.jan: 300})
+	f.checkAllocated(t, first, map[int]int{f.jan: 700, f.feb: 800})
+	f.checkAllocated(t, second, map[int]int{f.feb: 200, f.mar: 800})
+}
+
+func TestExplicitAllocations(t *testing.T) {
+	f := newAllocationFixture(t)
+	h := buildRouter(f.s, f.s.DB, nil)
+	// Another payment already chose 600 of March
+	f.pay(t, 600, f.day(time.January, 2), []PaymentAllocation{{ChargeID: f.mar, Amount: 600}})
+
+	tests := []struct {
+		name   string
+		amount int
+		allocs []PaymentAllocation
+	}{
+		{"more than the payment", 1000, []PaymentAllocation{{ChargeID: f.jan, Amount: 700}, {ChargeID: f.feb, Amount: 400}}},
+		{"more than the charge", 2000, []PaymentAllocation{{ChargeID: f.jan, Amount: 1200}}},
+		{"more than the charge has left", 1000, []PaymentAllocation{{ChargeID: f.mar, Amount: 500}}},
+		{"charge listed twice", 1000, []PaymentAllocation{{ChargeID: f.jan, Amount: 100}, {ChargeID: f.jan, Amount: 100}}},
+		{"not a positive amount", 1000, []PaymentAllocation{{ChargeID: f.jan, Amount: 0}}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := f.s.Payments.Create(&Payment{LeaseID: f.leaseID, PaymentAmount: tc.amount, PaymentDateUnix: f.day(time.February, 3).Unix(), PaymentMethod: "check", Allocations: tc.allocs})
+			if !errors.Is(err, errInvalidAllocation) {
+				t.Errorf("create = %v, want errInvalidAllocation", err)
+			}
+			body := `{"leaseId":` + strconv.Itoa(f.leaseID) + `,"paymentAmount":` + strconv.Itoa(tc.amount) + `,"paymentDateUnix":1738573200,"allocations":[`
+			for i, a := range tc.allocs {
+				if i > 0 {
+					body += ","
+				}
+				body += `{"chargeId":` + strconv.Itoa(a.ChargeID) + `,"amount":` + strconv.Itoa(a.Amount) + `}`
+			}
+			body += `]}`
+			if w := sendAs(h, f.ownerID, http.MethodPost, "/v1/payments", "", body); w.Code != http.StatusBadRequest {
+				t.Errorf("POST status %d, want 400", w.Code)
+			}
+		})
+	}
+	var n int
+	if err := f.s.DB.QueryRow(`SELECT COUNT(*) FROM payments`).Scan(&n); err != nil || n != 1 {
+		t.Errorf("%d payments stored (%v), want only the first", n, err)
+	}
+
+	// Within bounds the chosen charge is paid first and the rest goes oldest first
+	id := f.pay(t, 1000, f.day(time.February, 3), []PaymentAllocation{{ChargeID: f.mar, Amount: 400}})
+	f.checkAllocated(t, id, map[int]int{f.mar: 400, f.jan: 600})
+	allocs, _, err := f.s.Payments.Allocations(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range allocs {
+		if a.Explicit != (a.ChargeID == f.mar) {
+			t.Errorf("allocation to charge %d explicit=%v", a.ChargeID, a.Explicit)
+		}
+	}
+}
+
+func TestCreditCarriedForward(t *testing.T) {
+	f := newAllocationFixture(t)
+	s := f.s
+	id := f.pay(t, 3500, f.day(time.January, 2), nil)
+	if _, left, err := s.Payments.Allocations(id); err != nil || left != 500 {
+		t.Errorf("credit left = %d, %v; want 500", left, err)
+	}
+	checkBalance(t, s, f.ownerID, f.leaseID, f.day(time.March, 15), -500)
+
+	// The next charge takes the credit as it is posted
+	if _, err := s.Ledger.PostLeaseRentCharges(f.leaseID, f.day(time.April, 15)); err != nil {
+		t.Fatal(err)
+	}
+	var apr int
+	if err := s.DB.QueryRow(`SELECT chargeId FROM charges WHERE leaseId=? AND chargePeriod=?`, f.leaseID, "2025-04").Scan(&apr); err != nil {
+		t.Fatal(err)
+	}
+	f.checkAllocated(t, id, map[int]int{f.jan: 1000, f.feb: 1000, f.mar: 1000, apr: 500})
+	checkBalance(t, s, f.ownerID, f.leaseID, f.day(time.April, 15), 500)
+
+	// A credit adjustment is allocated like a payment
+	credit, err := s.Ledger.CreateCharge(&Charge{LeaseID: f.leaseID, ChargeType: ChargeAdjustment, ChargeAmount: -200, ChargeDueUnix: f.day(time.April, 10).Unix(), ChargeDescription: "Goodwill"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fromCredit int
+	if err := s.DB.QueryRow(`SELECT allocatedAmount FROM paymentAllocations WHERE creditChargeId=? AND chargeId=?`, credit, apr).Scan(&fromCredit); err != nil || fromCredit != 200 {
+		t.Errorf("credit adjustment pays %d of April (%v), want 200", fromCredit, err)
+	}
+	checkBalance(t, s, f.ownerID, f.leaseID, f.day(time.April, 15), 300)
+}
+
+func TestReallocateOnPaymentChange(t *testing.T) {
+	f := newAllocationFixture(t)
+	s := f.s
+	first := f.pay(t, 1000, f.day(time.January, 2), nil)
+	second := f.pay(t, 1000, f.day(time.February, 2), nil)
+	f.checkAllocated(t, first, map[int]int{f.jan: 1000})
+	f.checkAllocated(t, second, map[int]int{f.feb: 1000})
+
+	t.Run("update", func(t *testing.T) {
+		p, err := s.Payments.GetByID(f.ownerID, first)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.PaymentAmount = 400
+		if err := s.Payments.Update(f.ownerID, p); err != nil {
+			t.Fatal(err)
+		}
+		f.checkAllocated(t, first, map[int]int{f.jan: 400})
+		f.checkAllocated(t, second, map[int]int{f.jan: 600, f.feb: 400})
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		if err := s.Payments.Delete(f.ownerID, first); err != nil {
+			t.Fatal(err)
+		}
+		f.checkAllocated(t, second, map[int]int{f.jan: 1000})
+		checkBalance(t, s, f.ownerID, f.leaseID, f.day(time.March, 15), 2000)
+	})
+
+	t.Run("restore", func(t *testing.T) {
+		if err := s.Trash.Restore(f.ownerID, "payments", first); err != nil {
+			t.Fatal(err)
+		}
+		f.checkAllocated(t, first, map[int]int{f.jan: 400})
+		f.checkAllocated(t, second, map[int]int{f.jan: 600, f.feb: 400})
+	})
+
+	t.Run("delete is one transaction", func(t *testing.T) {
+		// Reallocating fails, so the payment must stay out of the trash
+		if _, err := s.DB.Exec(`CREATE TRIGGER failAllocation BEFORE INSERT ON paymentAllocations
+			BEGIN SELECT RAISE(ABORT, 'allocation refused'); END`); err != nil {
+			t.Fatal(err)
+		}
+		defer s.DB.Exec(`DROP TRIGGER failAllocation`)
+		if err := s.Payments.Delete(f.ownerID, second); err == nil {
+			t.Fatal("delete succeeded though reallocating failed")
+		}
+		if _, err := s.Payments.GetByID(f.ownerID, second); err != nil {
+			t.Errorf("get after a failed delete: %v", err)
+		}
+		f.checkAllocated(t, second, map[int]int{f.jan: 600, f.feb: 400})
+	})
+}
+
+func TestPaymentAmountPositive(t *testing.T) {
+	f := newAllocationFixture(t)
+	h := buildRouter(f.s, f.s.DB, nil)
+	id := f.pay(t, 1000, f.day(time.January, 2), nil)
+	for _, amount := range []int{0, -500} {
+		body := `{"leaseId":` + strconv.Itoa(f.leaseID) + `,"paymentAmount":` + strconv.Itoa(amount) + `,"paymentDateUnix":1735812000,"paymentMethod":"check"}`
+		if w := sendAs(h, f.ownerID, http.MethodPost, "/v1/payments", "", body); w.Code != http.StatusBadRequest {
+			t.Errorf("POST amount %d: status %d, want 400", amount, w.Code)
+		}
+		if w := sendAs(h, f.ownerID, http.MethodPut, "/v1/payments/"+strconv.Itoa(id), "1", body); w.Code != http.StatusBadRequest {
+			t.Errorf("PUT amount %d: status %d, want 400", amount, w.Code)
+		}
+	}
+	f.checkAllocated(t, id, map[int]int{f.jan: 1000})
+}
+
+func TestBackfillAllocations0008(t *testing.T) {
+	f := newAllocationFixture(t)
+	s := f.s
+	// Payments out of order, one in the trash, a credit, a waived charge and
+	// a charge posted after everything was paid
+	f.pay(t, 700, f.day(time.February, 3), nil)
+	f.pay(t, 1800, f.day(time.January, 5), nil)
+	trashed := f.pay(t, 400, f.day(time.January, 1), nil)
+	if err := s.Payments.Delete(f.ownerID, trashed); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Ledger.CreateCharge(&Charge{LeaseID: f.leaseID, ChargeType: ChargeAdjustment, ChargeAmount: -250, ChargeDueUnix: f.day(time.January, 5).Unix(), ChargeDescription: "Goodwill"}); err != nil {
+		t.Fatal(err)
+	}
+	fee, err := s.Ledger.CreateCharge(&Charge{LeaseID: f.leaseID, ChargeType: ChargeFee, ChargeAmount: 80, ChargeDueUnix: f.day(time.January, 10).Unix(), ChargeDescription: "Key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Ledger.WaiveCharge(f.ownerID, fee, "goodwill"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Ledger.CreateCharge(&Charge{LeaseID: f.leaseID, ChargeType: ChargeFee, ChargeAmount: 500, ChargeDueUnix: f.day(time.April, 1).Unix(), ChargeDescription: "Cleaning"}); err != nil {
+		t.Fatal(err)
+	}
+
+	type row struct{ chargeID, paymentID, creditID, amount int }
+	snapshot := func() []row {
+		rows, err := s.DB.Query(`SELECT chargeId, COALESCE(paymentId, 0), COALESCE(creditChargeId, 0), allocatedAmount FROM paymentAllocations
+			ORDER BY chargeId, paymentId, creditChargeId`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var out []row
+		for rows.Next() {
+			var r row
+			if err := rows.Scan(&r.chargeID, &r.paymentID, &r.creditID, &r.amount); err != nil {
+				t.Fatal(err)
+			}
+			out = append(out, r)
+		}
+		return out
+	}
+	want := snapshot()
+	if len(want) == 0 {
+		t.Fatal("no allocations to compare")
+	}
+	if _, err := s.DB.Exec(`DELETE FROM paymentAllocations`); err != nil {
+		t.Fatal(err)
+	}
+	if err := backfillAllocations0008(s.DB); err != nil {
+		t.Fatal(err)
+	}
+	if got := snapshot(); !reflect.DeepEqual(got, want) {
+		t.Errorf("backfill = %+v, want %+v", got, want)
+	}
+
+	// Leases in the trash are not allocated
+	if err := s.Leases.Delete(f.ownerID, f.leaseID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.DB.Exec(`DELETE FROM paymentAllocations`); err != nil {
+		t.Fatal(err)
+	}
+	if err := backfillAllocations0008(s.DB); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := s.DB.QueryRow(`SELECT COUNT(*) FROM paymentAllocations`).Scan(&n); err != nil || n != 0 {
+		t.Errorf("%d allocations for a trashed lease (%v), want 0", n, err)
+	}
+}
@@ -0,0 +1,250 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Package-level summary:
+// This file implements long-lived API keys for scripts and integrations. A key
+// belongs to the user who created it and acts with that user's role and data
+// scope; it can optionally be limited to read-only requests and given an
+// expiry. Keys are shown once at creation and stored only as SHA-256 hashes.
+// AuthMiddleware accepts a key in place of an access token and records when
+// it was last used. Handlers: CreateAPIKeyHandler, GetAPIKeysHandler,
+// DeleteAPIKeyHandler. DB helpers: CreateAPIKey, GetAPIKeys, RevokeAPIKey,
+// AuthenticateAPIKey.
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/guregu/null"
+)
+
+// apiKeyPrefix marks a bearer value as an API key rather than a JWT.
+const apiKeyPrefix = "rtk_"
+
+// apiKeyLastUsedInterval limits how often lastUsedUnix is rewritten for a busy key.
+const apiKeyLastUsedInterval = time.Minute
+
+var (
+	errInvalidAPIKey = errors.New("invalid API key")
+	errAPIKeyExpired = errors.New("API key expired")
+	errAPIKeyRevoked = errors.New("API key revoked")
+)
+
+// apiKeyBlockedResources are never reachable with an API key, so a leaked key
+// cannot mint further keys or take over the account.
+var apiKeyBlockedResources = map[string]bool{
+	"apiKeys":   true,
+	"password":  true,
+	"twoFactor": true,
+	"logout":    true,
+	"token":     true,
+}
+
+// apiKeyReadOnlyResources can be read but never changed with an API key, so a
+// leaked key cannot change an account's email or role, or add logins of its own.
+var apiKeyReadOnlyResources = map[string]bool{
+	"users": true,
+}
+
+// API KEYS
+type APIKey struct {
+	APIKeyID     int      `db:"apiKeyId" json:"apiKeyId"`
+	UserID       int      `db:"userId" json:"userId"`
+	KeyName      string   `db:"keyName" json:"keyName"`
+	KeyPrefix    string   `db:"keyPrefix" json:"keyPrefix"` // first characters of the key, for display
+	ReadOnly     bool     `db:"readOnly" json:"readOnly"`
+	CreatedUnix  int64    `db:"createdUnix" json:"createdUnix"`
+	ExpiresUnix  null.Int `db:"expiresUnix" json:"expiresUnix"`
+	LastUsedUnix null.Int `db:"lastUsedUnix" json:"lastUsedUnix"`
+	RevokedUnix  null.Int `db:"revokedUnix" json:"revokedUnix"`
+}
+
+// apiKeyPermits reports whether a request authenticated by API key may proceed.
+// Read-only keys, and any key on a read-only resource, are limited to GET requests.
+func apiKeyPermits(r *http.Request, readOnly bool) bool {
+	resource, action := routePermission(r)
+	if apiKeyBlockedResources[resource] {
+		return false
+	}
+	return !(readOnly || apiKeyReadOnlyResources[resource]) || action == ActionRead
+}
+
+// == Handlers =====================================================================
+// POST /apiKeys
+// CreateAPIKeyHandler returns an HTTP handler that creates an API key for the
+// caller. Accepts {keyName, readOnly, expiresUnix}; the plaintext key is in the
+// response and cannot be retrieved again.
+func CreateAPIKeyHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var body struct {
+			KeyName     string   `json:"keyName"`
+			ReadOnly    bool     `json:"readOnly"`
+			ExpiresUnix null.Int `json:"expiresUnix"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		if strings.TrimSpace(body.KeyName) == "" {
+			respondError(w, http.StatusBadRequest, "keyName required")
+			return
+		}
+		if body.ExpiresUnix.Valid && body.ExpiresUnix.Int64 <= time.Now().Unix() {
+			respondError(w, http.StatusBadRequest, "expiresUnix must be in the future")
+			return
+		}
+
+		k := APIKey{
+			UserID:      currentUserID(r),
+			KeyName:     strings.TrimSpace(body.KeyName),
+			ReadOnly:    body.ReadOnly,
+			ExpiresUnix: body.ExpiresUnix,
+		}
+		secret, err := CreateAPIKey(db, &k)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		respondJSON(w, http.StatusCreated, map[string]interface{}{"apiKey": secret, "key": k})
+	}
+}
+
+// GET /apiKeys/
+// GetAPIKeysHandler returns an HTTP handler listing the caller's API keys,
+// including revoked and expired ones. Secrets are never returned.
+func GetAPIKeysHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		keys, err := GetAPIKeys(db, currentUserID(r))
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		respondJSON(w, http.StatusOK, keys)
+	}
+}
+
+// DELETE /apiKeys/delete/{id}
+// DeleteAPIKeyHandler returns an HTTP handler that revokes one of the caller's API keys.
+func DeleteAPIKeyHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(r.URL.Path, "/apiKeys/delete/"), "/"))
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "invalid id")
+			return
+		}
+		if err := RevokeAPIKey(db, currentUserID(r), id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				respondError(w, http.StatusNotFound, "API key not found")
+			} else {
+				respondError(w, http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]string{"status": "revoked"})
+	}
+}
+
+// == SQL Queries =====================================================================
+
+// CreateAPIKey generates a new key, stores its hash, and fills in k's ID,
+// prefix, and creation time. Returns the plaintext key.
+func CreateAPIKey(db *sql.DB, k *APIKey) (string, error) {
+	random, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	secret := apiKeyPrefix + random
+	k.KeyPrefix = secret[:len(apiKeyPrefix)+6]
+	k.CreatedUnix = time.Now().Unix()
+
+	err = db.QueryRow(`INSERT INTO apiKeys (userId, keyName, keyPrefix, keyHash, readOnly, createdUnix, expiresUnix) VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING apiKeyId`,
+		k.UserID, k.KeyName, k.KeyPrefix, hashToken(secret), k.ReadOnly, k.CreatedUnix, k.ExpiresUnix).Scan(&k.APIKeyID)
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// GetAPIKeys retrieves all API keys belonging to a user, newest first.
+// Returns a slice of APIKey and error if query fails.
+func GetAPIKeys(db *sql.DB, userID int) ([]APIKey, error) {
+	rows, err := db.Query(`SELECT apiKeyId, userId, keyName, keyPrefix, readOnly, createdUnix, expiresUnix, lastUsedUnix, revokedUnix
+		FROM apiKeys WHERE userId=? ORDER BY apiKeyId DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []APIKey
+	for rows.Next() {
+		var k APIKey
+		if err := rows.Scan(&k.APIKeyID, &k.UserID, &k.KeyName, &k.KeyPrefix, &k.ReadOnly, &k.CreatedUnix,
+			&k.ExpiresUnix, &k.LastUsedUnix, &k.RevokedUnix); err != nil {
+			return nil, err
+		}
+		out = append(out, k)
+	}
+	return out, rows.Err()
+}
+
+// RevokeAPIKey marks one of a user's API keys revoked.
+// Returns sql.ErrNoRows if the key does not exist, belongs to someone else, or
+// was already revoked.
+func RevokeAPIKey(db *sql.DB, userID, id int) error {
+	return checkAffected(db.Exec(`UPDATE apiKeys SET revokedUnix=? WHERE apiKeyId=? AND userId=? AND revokedUnix IS NULL`,
+		time.Now().Unix(), id, userID))
+}
+
+// AuthenticateAPIKey looks up a presented key by its hash and checks that it is
+// neither revoked nor expired, then records the time it was used.
+func AuthenticateAPIKey(db *sql.DB, secret string) (*APIKey, error) {
+	var k APIKey
+	err := db.QueryRow(`SELECT apiKeyId, userId, readOnly, expiresUnix, lastUsedUnix, revokedUnix FROM apiKeys WHERE keyHash=?`,
+		hashToken(secret)).Scan(&k.APIKeyID, &k.UserID, &k.ReadOnly, &k.ExpiresUnix, &k.LastUsedUnix, &k.RevokedUnix)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if k.RevokedUnix.Valid {
+		return nil, errAPIKeyRevoked
+	}
+	if k.ExpiresUnix.Valid && k.ExpiresUnix.Int64 <= now.Unix() {
+		return nil, errAPIKeyExpired
+	}
+
+	if !k.LastUsedUnix.Valid || now.Unix()-k.LastUsedUnix.Int64 >= int64(apiKeyLastUsedInterval.Seconds()) {
+		if _, err := db.Exec(`UPDATE apiKeys SET lastUsedUnix=? WHERE apiKeyId=?`, now.Unix(), k.APIKeyID); err != nil {
+			log.Print("record API key use: ", err)
+		}
+	}
+	return &k, nil
+}
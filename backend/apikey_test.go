@@ -0,0 +1,44 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Tests for apikey.go: which requests an API key may make.
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIKeyPermits(t *testing.T) {
+	tests := []struct {
+		method, path string
+		readOnly     bool
+		want         bool
+	}{
+		{"GET", "/v1/users/me", false, true},
+		{"GET", "/v1/users/2", false, true},
+		{"PUT", "/v1/users/2", false, false},
+		{"PATCH", "/v1/users/2", false, false},
+		{"DELETE", "/v1/users/2", false, false},
+		{"POST", "/v1/users", false, false},
+		{"PUT", "/users/update", false, false},
+		{"DELETE", "/users/delete/2", false, false},
+		{"POST", "/apiKeys", false, false},
+		{"POST", "/password/change", false, false},
+		{"PUT", "/v1/leases/1", false, true},
+		{"PUT", "/v1/leases/1", true, false},
+		{"GET", "/v1/leases/1", true, true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if got := apiKeyPermits(r, tt.readOnly); got != tt.want {
+			t.Errorf("%s %s (readOnly %v) = %v, want %v", tt.method, tt.path, tt.readOnly, got, tt.want)
+		}
+	}
+}
@@ -12,41 +12,49 @@ package main
 // This file provides authentication logic for login and JWT token generation.
 // Includes the Credentials struct, generateJWT helper, and LoginHandler for
 // validating user credentials and issuing tokens. Uses bcrypt for password
-// verification and JWT for session management.
+// verification and JWT for session management; refresh tokens and session
+// revocation live in session.go.
 
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	jwt "github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 )
 
-var jwtSecret = []byte("super_secret_key") //  Use env variable in production
-
 type Credentials struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
 }
 
-// generateJWT returns a signed JWT token
-// generateJWT creates and signs a JWT token for the given user ID.
+// generateJWT creates and signs a short-lived access token for the given user ID
+// and auth session, signed with the active key from signingKeys.
 // Returns the token string and error if signing fails.
-func generateJWT(userID int) (string, error) {
+func generateJWT(userID int, sessionID string) (string, error) {
+	now := time.Now()
 	claims := jwt.MapClaims{
 		"user_id": userID,
-		"exp":     time.Now().Add(24 * time.Hour).Unix(),
+		"sid":     sessionID,
+		"iat":     now.Unix(),
+		"exp":     now.Add(accessTokenTTL).Unix(),
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	return signingKeys.Sign(claims)
 }
 
 // POST /login
 // LoginHandler returns an HTTP handler for user login.
-// Accepts a JSON body with credentials, verifies password, and responds with a JWT token.
+// Accepts a JSON body with credentials, verifies password, opens a new session,
+// and responds with an access token and refresh token. When the account has
+// two-factor authentication enabled it responds with a TwoFactorChallenge instead.
+// Unknown emails and wrong passwords get the same response, and repeated
+// failures lock the email or client IP for a while (see lockout.go). With 2FA
+// the login only counts as a success once VerifyLoginHandler accepts a code.
 func LoginHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var creds Credentials
@@ -55,29 +63,80 @@ func LoginHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		log.Print("Attempting to log in " + creds.Email + " ...")
+		email := normalizeEmail(creds.Email)
+		ip := clientIP(r)
+		logDebug("Attempting to log in %s from %s ...", email, ip)
+
+		lockedUntil, err := LoginLockedUntil(db, email, ip, time.Now())
+		if err != nil {
+			log.Print(err)
+			respondError(w, http.StatusInternalServerError, "Failed to check login attempts")
+			return
+		}
+		if !lockedUntil.IsZero() {
+			if err := RecordLoginAttempt(db, email, 0, ip, LoginLocked); err != nil {
+				log.Print(err)
+			}
+			retry := int(time.Until(lockedUntil).Seconds()) + 1
+			w.Header().Set("Retry-After", strconv.Itoa(retry))
+			respondError(w, http.StatusTooManyRequests, "Too many failed login attempts. Try again later.")
+			return
+		}
 
 		var userId int
 		var hashedPassword string
 
-		err := db.QueryRow("SELECT userId, userPasswordHash FROM users WHERE userEmail = ?", creds.Email).Scan(&userId, &hashedPassword)
-		if err != nil {
+		err = db.QueryRow("SELECT userId, userPasswordHash FROM users WHERE lower(userEmail) = ?", email).Scan(&userId, &hashedPassword)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
 			log.Print(err)
-			http.Error(w, "User does not exist", http.StatusUnauthorized)
+			respondError(w, http.StatusInternalServerError, "Login failed")
 			return
 		}
+		if err != nil {
+			// Unknown email: spend the same bcrypt time as a real check
+			hashedPassword = string(dummyPasswordHash)
+		}
 
-		if err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(creds.Password)); err != nil {
-			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		if bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(creds.Password)) != nil || userId == 0 {
+			if err := RecordLoginAttempt(db, email, userId, ip, LoginFailure); err != nil {
+				log.Print(err)
+			}
+			respondError(w, http.StatusUnauthorized, "Invalid email or password")
+			return
+		}
+		// Accounts with 2FA get a challenge to complete at /login/verify instead of tokens
+		twoFactor, err := IsTwoFactorEnabled(db, userId)
+		if err != nil {
+			log.Print(err)
+			http.Error(w, "Failed to check two-factor status", http.StatusInternalServerError)
+			return
+		}
+		if twoFactor {
+			challenge, err := CreateLoginChallenge(db, userId)
+			if err != nil {
+				log.Print(err)
+				http.Error(w, "Failed to create login challenge", http.StatusInternalServerError)
+				return
+			}
+			respondJSON(w, http.StatusOK, TwoFactorChallenge{
+				TwoFactorRequired: true,
+				ChallengeToken:    challenge,
+				ExpiresIn:         int(loginChallengeTTL.Seconds()),
+			})
 			return
 		}
 
-		token, err := generateJWT(userId)
+		if err := RecordLoginAttempt(db, email, userId, ip, LoginSuccess); err != nil {
+			log.Print(err)
+		}
+
+		pair, err := startSession(db, userId)
 		if err != nil {
+			log.Print(err)
 			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 			return
 		}
 
-		json.NewEncoder(w).Encode(map[string]string{"token": token})
+		respondJSON(w, http.StatusOK, pair)
 	}
 }
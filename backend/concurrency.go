@@ -0,0 +1,85 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Package-level summary:
+// This file implements optimistic concurrency for updates. Every editable row
+// carries a version, incremented by each UPDATE, and an updatedUnix timestamp.
+// GET responses send the version as an ETag, and a PUT names the version it
+// was based on with If-Match or a "version" field in the body. The update
+// helpers only write when that version is still current, so a stale edit is
+// refused instead of overwriting someone else's change; the handler answers
+// 412 (If-Match) or 409 (body version) with the current record. The
+// deprecated legacy routes need the version too; the app's API client sends
+// back the one it read. Helpers: setETag, requireVersion, staleOrMissing, respondStale.
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// errStaleVersion is returned by update helpers when the row exists but its
+// version no longer matches the one the caller edited.
+var errStaleVersion = errors.New("record was changed since it was read")
+
+// setETag sets the ETag header for a record at version.
+func setETag(w http.ResponseWriter, version int) {
+	if version > 0 {
+		w.Header().Set("ETag", `"`+strconv.Itoa(version)+`"`)
+	}
+}
+
+// requireVersion returns the version an update is based on: the If-Match
+// header when present, otherwise bodyVersion. ifMatch reports which one was
+// used. When neither is given it writes a 428 and returns ok=false.
+func requireVersion(w http.ResponseWriter, r *http.Request, bodyVersion int) (version int, ifMatch bool, ok bool) {
+	if h := strings.TrimSpace(r.Header.Get("If-Match")); h != "" {
+		v, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(h, "W/"), `"`))
+		if err != nil || v < 1 {
+			respondError(w, http.StatusBadRequest, "If-Match must be a single ETag returned by GET")
+			return 0, true, false
+		}
+		return v, true, true
+	}
+	if bodyVersion > 0 {
+		return bodyVersion, false, true
+	}
+	respondError(w, http.StatusPreconditionRequired, "send If-Match with the record's ETag, or its version field")
+	return 0, false, false
+}
+
+// staleOrMissing explains an update that changed no rows, given the result of
+// reading the row back: if it can still be read the version was stale,
+// otherwise the row is gone or not visible (sql.ErrNoRows).
+func staleOrMissing[T any](_ T, err error) error {
+	if err == nil {
+		return errStaleVersion
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return sql.ErrNoRows
+	}
+	return err
+}
+
+// respondStale answers a stale update with the current record and its ETag:
+// 412 Precondition Failed for If-Match, 409 Conflict for a body version.
+func respondStale(w http.ResponseWriter, ifMatch bool, current interface{}, version int) {
+	status := http.StatusConflict
+	if ifMatch {
+		status = http.StatusPreconditionFailed
+	}
+	setETag(w, version)
+	respondJSON(w, status, map[string]interface{}{
+		"error":   errStaleVersion.Error(),
+		"current": current,
+	})
+}
@@ -0,0 +1,111 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Handler tests for concurrency.go: GET returns the record's ETag, and a PUT
+// without a version, with a stale If-Match, or with a stale body version is
+// refused without writing.
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// sendAs serves a request as userID through h with an optional If-Match.
+func sendAs(h http.Handler, userID int, method, path, ifMatch, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r = r.WithContext(context.WithValue(r.Context(), userIDKey, userID))
+	if ifMatch != "" {
+		r.Header.Set("If-Match", ifMatch)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestOptimisticConcurrency(t *testing.T) {
+	s := newTestStore(t)
+	ownerID := createTestUser(t, s, "owner@example.com", "owner")
+	propertyID, err := s.Properties.Create(&Property{OwnerUserID: ownerID, PropertyName: "Maple", PropertyStreet: "1 Maple St", PropertyCity: "Morgantown"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := buildRouter(s, s.DB, nil)
+	path := "/v1/properties/" + strconv.Itoa(propertyID)
+	body := func(name string, version int) string {
+		b, _ := json.Marshal(Property{OwnerUserID: ownerID, PropertyName: name, PropertyStreet: "1 Maple St", PropertyCity: "Morgantown", Version: version})
+		return string(b)
+	}
+	nameNow := func() string {
+		p, err := s.Properties.GetByID(ownerID, propertyID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p.PropertyName
+	}
+
+	w := sendAs(h, ownerID, http.MethodGet, path, "", "")
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != `"1"` {
+		t.Fatalf("GET: status %d, ETag %q; want 200 with \"1\"", w.Code, w.Header().Get("ETag"))
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		ifMatch string
+		body    string
+		status  int
+		etag    string
+	}{
+		{"no version", http.MethodPut, path, "", body("Oak", 0), http.StatusPreconditionRequired, ""},
+		{"no version on legacy route", http.MethodPut, "/properties/update", "", `{"propertyId":` + strconv.Itoa(propertyID) + `,"propertyName":"Oak"}`, http.StatusPreconditionRequired, ""},
+		{"malformed If-Match", http.MethodPut, path, "*", body("Oak", 0), http.StatusBadRequest, ""},
+		{"current If-Match", http.MethodPut, path, `"1"`, body("Birch", 0), http.StatusOK, `"2"`},
+		{"stale If-Match", http.MethodPut, path, `"1"`, body("Oak", 0), http.StatusPreconditionFailed, `"2"`},
+		{"stale body version", http.MethodPut, path, "", body("Oak", 1), http.StatusConflict, `"2"`},
+		{"current body version", http.MethodPut, path, "", body("Cedar", 2), http.StatusOK, `"3"`},
+	}
+	want := "Maple"
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			w := sendAs(h, ownerID, tc.method, tc.path, tc.ifMatch, tc.body)
+			if w.Code != tc.status {
+				t.Fatalf("status %d (%s), want %d", w.Code, strings.TrimSpace(w.Body.String()), tc.status)
+			}
+			if got := w.Header().Get("ETag"); got != tc.etag {
+				t.Errorf("ETag %q, want %q", got, tc.etag)
+			}
+			if w.Code == http.StatusOK {
+				var p Property
+				json.Unmarshal([]byte(tc.body), &p)
+				want = p.PropertyName
+			}
+			if got := nameNow(); got != want {
+				t.Errorf("stored name %q, want %q", got, want)
+			}
+			if w.Code == http.StatusPreconditionFailed || w.Code == http.StatusConflict {
+				var resp struct {
+					Current Property `json:"current"`
+				}
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+					t.Fatal(err)
+				}
+				if resp.Current.PropertyName != want || resp.Current.Version != 2 {
+					t.Errorf("current = %q at version %d, want %q at 2", resp.Current.PropertyName, resp.Current.Version, want)
+				}
+			}
+		})
+	}
+}
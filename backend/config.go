@@ -0,0 +1,501 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Package-level summary:
+// This file loads the server configuration. Every setting has a built-in
+// default and can be overridden, in increasing order of precedence, by a YAML
+// config file (-config or RT_CONFIG), an RT_* environment variable, and a
+// command-line flag. The merged result is validated once at startup and every
+// problem is reported together, so a bad deployment fails before it serves a
+// request. The settings table below is the single list of flags and variables.
+// Helpers: LoadConfig, Config.Validate, Config.Apply, and the leveled
+// logDebug/logInfo/logWarn functions.
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // timezone names work on hosts without a zoneinfo database
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every server setting after defaults, the config file, the
+// environment and flags have been merged.
+type Config struct {
+	ListenAddr       string
+	DBDriver         string
+	DBPath           string // SQLite file path or PostgreSQL connection string
+	TLSCertFile      string
+	TLSKeyFile       string
+	CORSOrigins      []string
+	JWTSecret        string
+	JWTKeysFile      string
+	AccessTokenTTL   time.Duration
+	RefreshTokenTTL  time.Duration
+	LogLevel         string
+	Timezone         string
+	TrashRetention   time.Duration
+	RentPostInterval time.Duration
+	ResetURL         string // page password reset links point to
+	PortalInviteURL  string // page tenant portal invite links point to
+	SMTPHost         string // mail goes to MailFile (or the log) when empty
+	SMTPPort         string
+	SMTPUser         string
+	SMTPPassword     string
+	MailFrom         string
+	MailFile         string
+
+	Location *time.Location // parsed Timezone, set by Validate
+}
+
+// defaultConfig returns the settings used when nothing overrides them.
+func defaultConfig() *Config {
+	return &Config{
+		ListenAddr:       ":8080",
+		DBDriver:         DriverSQLite,
+		AccessTokenTTL:   15 * time.Minute,
+		RefreshTokenTTL:  30 * 24 * time.Hour,
+		LogLevel:         "info",
+		Timezone:         "Local",
+		TrashRetention:   30 * 24 * time.Hour,
+		RentPostInterval: time.Hour,
+		ResetURL:         "http://localhost:8081/reset-password",
+		PortalInviteURL:  "http://localhost:8081/portal/accept-invite",
+		SMTPPort:         "587",
+	}
+}
+
+// fileConfig is the shape of the YAML config file. Values are kept as strings
+// and applied through the same setters as flags and environment variables.
+//
+//	listen: ":8443"
+//	database:
+//	  driver: sqlite
+//	  path: ../rt.db
+//	tls:
+//	  cert: /etc/renttracker/cert.pem
+//	  key: /etc/renttracker/key.pem
+//	cors:
+//	  origins: ["http://localhost:8081"]
+//	jwt:
+//	  keysFile: /etc/renttracker/keys.json
+//	  accessTokenTTL: 15m
+//	  refreshTokenTTL: 720h
+//	log:
+//	  level: info
+//	timezone: America/New_York
+//	trash:
+//	  retention: 720h
+//	rent:
+//	  postInterval: 1h
+//	links:
+//	  passwordReset: https://rent.example.com/reset-password
+//	  portalInvite: https://rent.example.com/portal/accept-invite
+//	mail:
+//	  from: RentTracker <no-reply@example.com>
+//	  smtp:
+//	    host: smtp.example.com
+//	    port: 587
+//	    user: no-reply@example.com
+type fileConfig struct {
+	Listen   *string `yaml:"listen"`
+	Database struct {
+		Driver *string `yaml:"driver"`
+		Path   *string `yaml:"path"`
+	} `yaml:"database"`
+	TLS struct {
+		Cert *string `yaml:"cert"`
+		Key  *string `yaml:"key"`
+	} `yaml:"tls"`
+	CORS struct {
+		Origins []string `yaml:"origins"`
+	} `yaml:"cors"`
+	JWT struct {
+		Secret          *string `yaml:"secret"`
+		KeysFile        *string `yaml:"keysFile"`
+		AccessTokenTTL  *string `yaml:"accessTokenTTL"`
+		RefreshTokenTTL *string `yaml:"refreshTokenTTL"`
+	} `yaml:"jwt"`
+	Log struct {
+		Level *string `yaml:"level"`
+	} `yaml:"log"`
+	Timezone *string `yaml:"timezone"`
+	Trash    struct {
+		Retention *string `yaml:"retention"`
+	} `yaml:"trash"`
+	Rent struct {
+		PostInterval *string `yaml:"postInterval"`
+	} `yaml:"rent"`
+	Links struct {
+		PasswordReset *string `yaml:"passwordReset"`
+		PortalInvite  *string `yaml:"portalInvite"`
+	} `yaml:"links"`
+	Mail struct {
+		From *string `yaml:"from"`
+		File *string `yaml:"file"`
+		SMTP struct {
+			Host     *string `yaml:"host"`
+			Port     *string `yaml:"port"`
+			User     *string `yaml:"user"`
+			Password *string `yaml:"password"`
+		} `yaml:"smtp"`
+	} `yaml:"mail"`
+}
+
+// configSetting ties one setting to its flag and environment variable.
+// A setting with an empty flag name can only be set by file or environment.
+type configSetting struct {
+	flag  string
+	env   string
+	usage string
+	set   func(c *Config, v string) error
+	file  func(f *fileConfig) (string, bool)
+}
+
+// configSettings lists every setting. Keep README "Configuration" in sync.
+var configSettings = []configSetting{
+	{"listen", "RT_LISTEN_ADDR", "address to listen on, host:port",
+		func(c *Config, v string) error { c.ListenAddr = v; return nil },
+		func(f *fileConfig) (string, bool) { return deref(f.Listen) }},
+	{"db-driver", "RT_DB_DRIVER", "database driver: sqlite or postgres",
+		func(c *Config, v string) error { c.DBDriver = strings.ToLower(v); return nil },
+		func(f *fileConfig) (string, bool) { return deref(f.Database.Driver) }},
+	{"db", "RT_DB_DSN", "SQLite file path or PostgreSQL connection string (default ../rt.db for sqlite)",
+		func(c *Config, v string) error { c.DBPath = v; return nil },
+		func(f *fileConfig) (string, bool) { return deref(f.Database.Path) }},
+	{"tls-cert", "RT_TLS_CERT", "TLS certificate file; serves HTTPS together with -tls-key",
+		func(c *Config, v string) error { c.TLSCertFile = v; return nil },
+		func(f *fileConfig) (string, bool) { return deref(f.TLS.Cert) }},
+	{"tls-key", "RT_TLS_KEY", "TLS private key file",
+		func(c *Config, v string) error { c.TLSKeyFile = v; return nil },
+		func(f *fileConfig) (string, bool) { return deref(f.TLS.Key) }},
+	{"cors-origins", "RT_CORS_ORIGINS", "comma-separated origins allowed to call the API from a browser, or *",
+		func(c *Config, v string) error { c.CORSOrigins = splitList(v); return nil },
+		func(f *fileConfig) (string, bool) {
+			return strings.Join(f.CORS.Origins, ","), f.CORS.Origins != nil
+		}},
+	{"", "RT_JWT_SECRET", "",
+		func(c *Config, v string) error { c.JWTSecret = v; return nil },
+		func(f *fileConfig) (string, bool) { return deref(f.JWT.Secret) }},
+	{"jwt-keys-file", "RT_JWT_KEYS_FILE", "JSON signing key set file (see keys.go)",
+		func(c *Config, v string) error { c.JWTKeysFile = v; return nil },
+		func(f *fileConfig) (string, bool) { return deref(f.JWT.KeysFile) }},
+	{"access-token-ttl", "RT_ACCESS_TOKEN_TTL", "lifetime of access tokens, e.g. 15m",
+		func(c *Config, v string) error { return parseDurationSetting(&c.AccessTokenTTL, v) },
+		func(f *fileConfig) (string, bool) { return deref(f.JWT.AccessTokenTTL) }},
+	{"refresh-token-ttl", "RT_REFRESH_TOKEN_TTL", "lifetime of refresh tokens, e.g. 720h",
+		func(c *Config, v string) error { return parseDurationSetting(&c.RefreshTokenTTL, v) },
+		func(f *fileConfig) (string, bool) { return deref(f.JWT.RefreshTokenTTL) }},
+	{"log-level", "RT_LOG_LEVEL", "debug, info, warn or error",
+		func(c *Config, v string) error { c.LogLevel = strings.ToLower(v); return nil },
+		func(f *fileConfig) (string, bool) { return deref(f.Log.Level) }},
+	{"timezone", "RT_TIMEZONE", "IANA timezone for dates and billing periods, e.g. America/New_York",
+		func(c *Config, v string) error { c.Timezone = v; return nil },
+		func(f *fileConfig) (string, bool) { return deref(f.Timezone) }},
+	{"trash-retention", "RT_TRASH_RETENTION", "how long deleted records stay in the trash before they are purged, e.g. 720h; 0 keeps them",
+		func(c *Config, v string) error { return parseDurationSetting(&c.TrashRetention, v) },
+		func(f *fileConfig) (string, bool) { return deref(f.Trash.Retention) }},
+	{"rent-post-interval", "RT_RENT_POST_INTERVAL", "how often to post the rent charges that have fallen due, e.g. 1h; 0 turns automatic posting off",
+		func(c *Config, v string) error { return parseDurationSetting(&c.RentPostInterval, v) },
+		func(f *fileConfig) (string, bool) { return deref(f.Rent.PostInterval) }},
+	{"reset-url", "RT_RESET_URL", "page password reset links point to; the token is added as ?token=",
+		func(c *Config, v string) error { c.ResetURL = v; return nil },
+		func(f *fileConfig) (string, bool) { return deref(f.Links.PasswordReset) }},
+	{"portal-invite-url", "RT_PORTAL_INVITE_URL", "page tenant portal invite links point to; the token is added as ?token=",
+		func(c *Config, v string) error { c.PortalInviteURL = v; return nil },
+		func(f *fileConfig) (string, bool) { return deref(f.Links.PortalInvite) }},
+	{"smtp-host", "RT_SMTP_HOST", "SMTP server for outgoing mail; without one mail is written to -mail-file or the log",
+		func(c *Config, v string) error { c.SMTPHost = v; return nil },
+		func(f *fileConfig) (string, bool) { return deref(f.Mail.SMTP.Host) }},
+	{"smtp-port", "RT_SMTP_PORT", "SMTP server port",
+		func(c *Config, v string) error { c.SMTPPort = v; return nil },
+		func(f *fileConfig) (string, bool) { return deref(f.Mail.SMTP.Port) }},
+	{"smtp-user", "RT_SMTP_USER", "SMTP username; PLAIN auth is used when set",
+		func(c *Config, v string) error { c.SMTPUser = v; return nil },
+		func(f *fileConfig) (string, bool) { return deref(f.Mail.SMTP.User) }},
+	{"", "RT_SMTP_PASSWORD", "",
+		func(c *Config, v string) error { c.SMTPPassword = v; return nil },
+		func(f *fileConfig) (string, bool) { return deref(f.Mail.SMTP.Password) }},
+	{"mail-from", "RT_MAIL_FROM", "From address of outgoing mail",
+		func(c *Config, v string) error { c.MailFrom = v; return nil },
+		func(f *fileConfig) (string, bool) { return deref(f.Mail.From) }},
+	{"mail-file", "RT_MAIL_FILE", "file mail is appended to when no SMTP host is set, for local testing",
+		func(c *Config, v string) error { c.MailFile = v; return nil },
+		func(f *fileConfig) (string, bool) { return deref(f.Mail.File) }},
+}
+
+// LoadConfig builds the configuration from defaults, the config file, the
+// environment and args (os.Args[1:]), in that order of precedence, and
+// validates it. It returns the arguments left after the flags, e.g. a
+// "migrate" subcommand.
+func LoadConfig(args []string) (*Config, []string, error) {
+	fs := flag.NewFlagSet("renttracker", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("RT_CONFIG"), "YAML config file (env RT_CONFIG)")
+	flagValues := map[string]*string{}
+	for _, s := range configSettings {
+		if s.flag != "" {
+			flagValues[s.flag] = fs.String(s.flag, "", s.usage+" (env "+s.env+")")
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	cfg := defaultConfig()
+	var errs []error
+
+	if *configPath != "" {
+		f, err := readConfigFile(*configPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, s := range configSettings {
+			if v, ok := s.file(f); ok {
+				if err := s.set(cfg, v); err != nil {
+					errs = append(errs, fmt.Errorf("%s: %w", *configPath, err))
+				}
+			}
+		}
+	}
+
+	for _, s := range configSettings {
+		if v, ok := os.LookupEnv(s.env); ok && v != "" {
+			if err := s.set(cfg, v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", s.env, err))
+			}
+		}
+	}
+
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for _, s := range configSettings {
+		if set[s.flag] {
+			if err := s.set(cfg, *flagValues[s.flag]); err != nil {
+				errs = append(errs, fmt.Errorf("-%s: %w", s.flag, err))
+			}
+		}
+	}
+
+	if cfg.DBPath == "" && cfg.DBDriver == DriverSQLite {
+		cfg.DBPath = "../rt.db"
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return cfg, fs.Args(), nil
+}
+
+// readConfigFile parses a YAML config file. Unknown keys are an error so a
+// misspelt setting is not silently ignored.
+func readConfigFile(path string) (*fileConfig, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file: %w", err)
+	}
+	var f fileConfig
+	dec := yaml.NewDecoder(bytes.NewReader(raw))
+	dec.KnownFields(true)
+	if err := dec.Decode(&f); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	return &f, nil
+}
+
+// Validate checks every setting and returns all problems found at once.
+func (c *Config) Validate() error {
+	var errs []error
+	bad := func(format string, args ...interface{}) { errs = append(errs, fmt.Errorf(format, args...)) }
+
+	if _, port, err := net.SplitHostPort(c.ListenAddr); err != nil || port == "" {
+		bad("listen address %q must look like host:port or :port", c.ListenAddr)
+	}
+
+	switch c.DBDriver {
+	case DriverSQLite, DriverPostgres:
+		if c.DBPath == "" {
+			bad("database path/connection string is required for driver %q", c.DBDriver)
+		}
+	default:
+		bad("database driver %q is not supported (use sqlite or postgres)", c.DBDriver)
+	}
+
+	switch {
+	case (c.TLSCertFile == "") != (c.TLSKeyFile == ""):
+		bad("TLS needs both a certificate and a key file")
+	case c.TLSCertFile != "":
+		if _, err := tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile); err != nil {
+			bad("TLS certificate/key: %v", err)
+		}
+	}
+
+	for _, origin := range c.CORSOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			bad("CORS origin %q must be * or scheme://host[:port]", origin)
+		}
+	}
+
+	if c.JWTSecret != "" && c.JWTKeysFile != "" {
+		bad("set either a JWT secret or a JWT keys file, not both")
+	}
+	if c.JWTKeysFile != "" {
+		if _, err := os.Stat(c.JWTKeysFile); err != nil {
+			bad("JWT keys file: %v", err)
+		}
+	}
+	if c.AccessTokenTTL <= 0 || c.RefreshTokenTTL <= 0 {
+		bad("token lifetimes must be positive")
+	} else if c.RefreshTokenTTL < c.AccessTokenTTL {
+		bad("refresh token lifetime (%s) is shorter than access token lifetime (%s)", c.RefreshTokenTTL, c.AccessTokenTTL)
+	}
+
+	if _, ok := logLevels[c.LogLevel]; !ok {
+		bad("log level %q is not one of debug, info, warn, error", c.LogLevel)
+	}
+
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		bad("timezone %q: %v", c.Timezone, err)
+	}
+	c.Location = loc
+
+	if c.TrashRetention < 0 {
+		bad("trash retention must not be negative")
+	}
+	if c.RentPostInterval < 0 {
+		bad("rent post interval must not be negative")
+	}
+
+	for _, link := range []struct{ name, value string }{
+		{"password reset URL", c.ResetURL},
+		{"portal invite URL", c.PortalInviteURL},
+	} {
+		u, err := url.Parse(link.value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+			bad("%s %q must be an http(s) URL without a query", link.name, link.value)
+		}
+	}
+
+	if c.SMTPHost != "" {
+		if port, err := strconv.Atoi(c.SMTPPort); err != nil || port < 1 || port > 65535 {
+			bad("SMTP port %q must be a number from 1 to 65535", c.SMTPPort)
+		}
+		if _, err := mail.ParseAddress(c.MailFrom); err != nil {
+			bad("mail from address %q: %v", c.MailFrom, err)
+		}
+	} else if c.SMTPUser != "" || c.SMTPPassword != "" {
+		bad("SMTP user or password is set but no SMTP host")
+	}
+
+	if len(errs) > 0 {
+		msgs := make([]string, len(errs))
+		for i, err := range errs {
+			msgs[i] = "  - " + err.Error()
+		}
+		return errors.New("invalid configuration:\n" + strings.Join(msgs, "\n"))
+	}
+	return nil
+}
+
+// Apply installs the process-wide settings: log level, local timezone, token
+// lifetimes, trash retention and the reset and invite link pages. The rest of
+// the config is passed to where it is used.
+func (c *Config) Apply() {
+	logLevel = logLevels[c.LogLevel]
+	time.Local = c.Location
+	accessTokenTTL = c.AccessTokenTTL
+	refreshTokenTTL = c.RefreshTokenTTL
+	trashRetention = c.TrashRetention
+	passwordResetURL = c.ResetURL
+	portalInviteURL = c.PortalInviteURL
+}
+
+// TLSEnabled reports whether the server should serve HTTPS.
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCertFile != ""
+}
+
+func deref(s *string) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	return *s, true
+}
+
+// splitList splits a comma-separated value, dropping blanks.
+func splitList(v string) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+func parseDurationSetting(dst *time.Duration, v string) error {
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", v)
+	}
+	*dst = d
+	return nil
+}
+
+// == Logging ===
+
+const (
+	levelDebug = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLevels = map[string]int{"debug": levelDebug, "info": levelInfo, "warn": levelWarn, "error": levelError}
+
+// logLevel is the minimum level written by logDebug/logInfo/logWarn. Errors
+// logged directly with log.Print are always written.
+var logLevel = levelInfo
+
+func logDebug(format string, args ...interface{}) {
+	if logLevel <= levelDebug {
+		log.Printf("DEBUG "+format, args...)
+	}
+}
+
+func logInfo(format string, args ...interface{}) {
+	if logLevel <= levelInfo {
+		log.Printf(format, args...)
+	}
+}
+
+func logWarn(format string, args ...interface{}) {
+	if logLevel <= levelWarn {
+		log.Printf("WARNING: "+format, args...)
+	}
+}
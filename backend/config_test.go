@@ -0,0 +1,149 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Tests for config.go: defaults, the config file, RT_* variables and flags
+// override each other in that order, and every invalid setting is reported.
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clearConfigEnv unsets every RT_* variable LoadConfig reads until the test
+// ends; LoadConfig treats empty values as unset.
+func clearConfigEnv(t *testing.T) {
+	t.Helper()
+	t.Setenv("RT_CONFIG", "")
+	for _, s := range configSettings {
+		t.Setenv(s.env, "")
+	}
+}
+
+// writeConfigFile writes a YAML config file and returns its path.
+func writeConfigFile(t *testing.T, yaml string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	clearConfigEnv(t)
+	path := writeConfigFile(t, `
+timezone: America/New_York
+log:
+  level: warn
+rent:
+  postInterval: 2h
+trash:
+  retention: 48h
+`)
+	t.Setenv("RT_CONFIG", path)
+	t.Setenv("RT_LOG_LEVEL", "error")
+	t.Setenv("RT_RENT_POST_INTERVAL", "3h")
+	t.Setenv("RT_SMTP_PORT", "2525")
+
+	cfg, rest, err := LoadConfig([]string{"-rent-post-interval", "4h", "-smtp-port", "465", "-access-token-ttl", "5m", "migrate"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"default only", cfg.ListenAddr, ":8080"},
+		{"default sqlite path", cfg.DBPath, "../rt.db"},
+		{"file over default", cfg.Timezone, "America/New_York"},
+		{"file over default (duration)", cfg.TrashRetention, 48 * time.Hour},
+		{"env over file", cfg.LogLevel, "error"},
+		{"flag over env and file", cfg.RentPostInterval, 4 * time.Hour},
+		{"flag over env", cfg.SMTPPort, "465"},
+		{"flag over default", cfg.AccessTokenTTL, 5 * time.Minute},
+	}
+	for _, tc := range tests {
+		if tc.got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, tc.got, tc.want)
+		}
+	}
+	if len(rest) != 1 || rest[0] != "migrate" {
+		t.Errorf("arguments left = %q, want [migrate]", rest)
+	}
+	if cfg.Location == nil || cfg.Location.String() != "America/New_York" {
+		t.Errorf("location = %v, want America/New_York", cfg.Location)
+	}
+
+	// -config wins over RT_CONFIG
+	other := writeConfigFile(t, "log:\n  level: debug\n")
+	t.Setenv("RT_LOG_LEVEL", "")
+	cfg, _, err = LoadConfig([]string{"-config", other})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.LogLevel != "debug" || cfg.Timezone != "Local" {
+		t.Errorf("with -config: log level %q, timezone %q; want debug and Local", cfg.LogLevel, cfg.Timezone)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		env  map[string]string
+		args []string
+		want []string // substrings of the error
+	}{
+		{"unknown file key", "lgo:\n  level: info\n", nil, nil, []string{"lgo"}},
+		{"bad duration in each source", "rent:\n  postInterval: soon\n", map[string]string{"RT_TRASH_RETENTION": "later"}, []string{"-access-token-ttl", "never"},
+			[]string{"config.yaml", "RT_TRASH_RETENTION", "-access-token-ttl"}},
+		{"unknown flag", "", nil, []string{"-colour"}, []string{"colour"}},
+		{"all problems at once", "", map[string]string{"RT_DB_DRIVER": "mysql", "RT_LOG_LEVEL": "loud", "RT_TIMEZONE": "Mars/Olympus"},
+			[]string{"-listen", "8080", "-cors-origins", "localhost:8081", "-refresh-token-ttl", "1m", "-tls-cert", "cert.pem"},
+			[]string{"listen address", "mysql", "TLS needs both", "CORS origin", "refresh token lifetime", "log level", "Mars/Olympus"}},
+		{"links", "links:\n  passwordReset: ftp://example.com/reset\n  portalInvite: https://example.com/invite?x=1\n", nil, nil,
+			[]string{"password reset URL", "portal invite URL"}},
+		{"mail", "", map[string]string{"RT_SMTP_USER": "me"}, nil, []string{"no SMTP host"}},
+		{"smtp", "", map[string]string{"RT_SMTP_HOST": "smtp.example.com", "RT_SMTP_PORT": "70000", "RT_MAIL_FROM": "not an address"}, nil,
+			[]string{"SMTP port", "mail from address"}},
+		{"jwt", "", map[string]string{"RT_JWT_SECRET": "s", "RT_JWT_KEYS_FILE": "/does/not/exist.json"}, nil,
+			[]string{"not both", "JWT keys file"}},
+		{"negative durations", "", map[string]string{"RT_TRASH_RETENTION": "-1h", "RT_RENT_POST_INTERVAL": "-1m"}, nil,
+			[]string{"trash retention", "rent post interval"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			clearConfigEnv(t)
+			if tc.yaml != "" {
+				t.Setenv("RT_CONFIG", writeConfigFile(t, tc.yaml))
+			}
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+			_, _, err := LoadConfig(tc.args)
+			if err == nil {
+				t.Fatal("LoadConfig succeeded, want an error")
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not mention %q", err, want)
+				}
+			}
+		})
+	}
+
+	clearConfigEnv(t)
+	if _, _, err := LoadConfig(nil); err != nil {
+		t.Errorf("the defaults are invalid: %v", err)
+	}
+}
@@ -6,14 +6,16 @@ toolchain go1.24.7
 
 require (
 	github.com/golang-jwt/jwt/v5 v5.3.0
+	github.com/guregu/null v4.0.0+incompatible
+	github.com/lib/pq v1.10.9
 	golang.org/x/crypto v0.42.0
+	gopkg.in/yaml.v3 v3.0.1
 	modernc.org/sqlite v1.39.0
 )
 
 require (
 	github.com/dustin/go-humanize v1.0.1 // indirect
 	github.com/google/uuid v1.6.0 // indirect
-	github.com/guregu/null v4.0.0+incompatible // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/ncruces/go-strftime v0.1.9 // indirect
 	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
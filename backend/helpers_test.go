@@ -0,0 +1,55 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Shared test helpers: a migrated SQLite store in a temporary directory,
+// users to call it as, and keys to sign their tokens.
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestStore opens a new SQLite database in a temporary directory and
+// applies every migration.
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := OpenStore(DriverSQLite, filepath.Join(t.TempDir(), "rt.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.DB.Close() })
+	if _, err := Migrate(s.DB, DriverSQLite, false); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return s
+}
+
+// createTestUser adds a user with role and returns its ID.
+func createTestUser(t *testing.T, s *Store, email, role string) int {
+	t.Helper()
+	u := &User{UserFirstName: "Test", UserLastName: role, UserEmail: email, UserPassword: "x", UserRole: role}
+	if err := s.Users.Create(u); err != nil {
+		t.Fatalf("create %s: %v", email, err)
+	}
+	return u.UserID
+}
+
+// useTestKeys signs tokens with a fixed HMAC key until the test ends.
+func useTestKeys(t *testing.T) {
+	t.Helper()
+	keys, err := LoadKeySet("", strings.Repeat("k", 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := signingKeys
+	signingKeys = keys
+	t.Cleanup(func() { signingKeys = saved })
+}
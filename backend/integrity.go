@@ -0,0 +1,348 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Package-level summary:
+// This file implements the delete policies between properties, units,
+// tenants, leases, payments and maintenance requests. Each parent/child
+// relationship in deleteRelations says what deleting the parent does to its
+// live children: block the delete (409 listing them), cascade it (the
+// children go to the trash too, and come back when the parent is restored),
+// or archive them (they are kept unchanged as history). A delete is planned
+// first and then carried out in one transaction, and the same plan is served
+// by the preview endpoint so a client can show what a delete would affect.
+// Handler: DeletePreviewHandler. DB helpers: PlanDelete, trashWithPolicies,
+// trashRow, and respondDeleteBlocked for the entity Delete handlers.
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// deletePolicy says what deleting a parent does to the live rows that point at it.
+type deletePolicy string
+
+const (
+	policyBlock   deletePolicy = "block"   // refuse the delete while they exist
+	policyCascade deletePolicy = "cascade" // move them to the trash with the parent
+	policyArchive deletePolicy = "archive" // keep them unchanged as history
+)
+
+// deleteRelation is one parent/child relationship between trashTables
+// resources: column is the child's reference to the parent.
+type deleteRelation struct {
+	parent string
+	child  string
+	column string
+	policy deletePolicy
+}
+
+// deleteRelations lists the policy for every relationship. Units go with
+// their property and maintenance requests with their unit, but a lease is a
+// signed agreement and must be deleted on its own before its unit or tenant.
+// Payments and maintenance requests recorded against a lease are history and
+// outlive it; the payments are hidden while the lease is in the trash (see
+// accessibleLeasesSQL), and neither they nor its charges let it be purged.
+var deleteRelations = []deleteRelation{
+	{"properties", "units", "propertyId", policyCascade},
+	{"units", "leases", "propertyUnitId", policyBlock},
+	{"units", "maintenance", "propertyUnitId", policyCascade},
+	{"tenants", "leases", "tenantId", policyBlock},
+	{"leases", "payments", "leaseId", policyArchive},
+	{"leases", "maintenance", "leaseId", policyArchive},
+}
+
+// errParentInTrash is returned when restoring a row whose parent is still in
+// the trash.
+var errParentInTrash = errors.New("the record it belongs to is in the trash")
+
+// sqlQuerier is the part of *sql.DB and *sql.Tx used by the delete planner.
+type sqlQuerier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// DeleteTarget names one row affected by a delete.
+type DeleteTarget struct {
+	Resource string `json:"resource"`
+	ID       int    `json:"id"`
+	Label    string `json:"label"`
+}
+
+// DeletePlan is what deleting a row would do: the rows moved to the trash
+// with it, the rows kept as history, and the rows that prevent the delete.
+type DeletePlan struct {
+	DeleteTarget
+	Allowed   bool           `json:"allowed"`
+	Cascade   []DeleteTarget `json:"cascade"`
+	Archive   []DeleteTarget `json:"archive"`
+	BlockedBy []DeleteTarget `json:"blockedBy"`
+}
+
+// DeleteBlockedError is returned by the Delete helpers when a block policy
+// applies; Plan.BlockedBy lists the rows in the way.
+type DeleteBlockedError struct {
+	Plan *DeletePlan
+}
+
+func (e *DeleteBlockedError) Error() string {
+	return fmt.Sprintf("%s %d still has dependent records (see blockedBy); delete them first", e.Plan.Resource, e.Plan.ID)
+}
+
+// respondDeleteBlocked answers a blocked delete with 409 and the rows in the
+// way. It reports false, writing nothing, when err is not a DeleteBlockedError.
+func respondDeleteBlocked(w http.ResponseWriter, err error) bool {
+	var blocked *DeleteBlockedError
+	if !errors.As(err, &blocked) {
+		return false
+	}
+	respondJSON(w, http.StatusConflict, map[string]interface{}{
+		"error":     blocked.Error(),
+		"blockedBy": blocked.Plan.BlockedBy,
+	})
+	return true
+}
+
+// == Handlers =====================================================================
+// GET
+// DeletePreviewHandler returns an HTTP handler for
+// GET /v1/{resource}/{id}/deletePreview, which reports what deleting the row
+// would affect without changing anything.
+func DeletePreviewHandler(s *Store, resource string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "invalid id")
+			return
+		}
+		plan, err := s.Trash.PlanDelete(currentUserID(r), resource, id)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				respondError(w, http.StatusNotFound, "not found")
+			} else {
+				respondError(w, http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+		respondJSON(w, http.StatusOK, plan)
+	}
+}
+
+// == SQL Queries ==================================================================
+
+// PlanDelete works out what deleting a live row of resource visible to userID
+// would do, following deleteRelations through cascaded children. Returns
+// sql.ErrNoRows if the row is not visible or already in the trash.
+func PlanDelete(q sqlQuerier, userID int, resource string, id int) (*DeletePlan, error) {
+	t, ok := lookupTrashTable(resource)
+	if !ok {
+		return nil, fmt.Errorf("%s cannot be deleted", resource)
+	}
+	plan := &DeletePlan{
+		DeleteTarget: DeleteTarget{Resource: resource, ID: id},
+		Cascade:      []DeleteTarget{},
+		Archive:      []DeleteTarget{},
+		BlockedBy:    []DeleteTarget{},
+	}
+	args := append([]interface{}{id}, scopeArgs(userID, t.scopeArgs)...)
+	err := q.QueryRow(`SELECT `+t.label+` FROM `+t.table+`
+		WHERE `+t.idColumn+`=? AND deletedUnix IS NULL AND `+t.scope, args...).Scan(&plan.Label)
+	if err != nil {
+		return nil, err
+	}
+	if err := plan.walk(q, resource, id); err != nil {
+		return nil, err
+	}
+
+	// A row reached both ways (e.g. a maintenance request on a cascaded unit
+	// that also names an archived lease) goes to the trash
+	archive := plan.Archive[:0]
+	for _, a := range plan.Archive {
+		if !containsTarget(plan.Cascade, a) {
+			archive = append(archive, a)
+		}
+	}
+	plan.Archive = archive
+	plan.Allowed = len(plan.BlockedBy) == 0
+	return plan, nil
+}
+
+// walk applies deleteRelations to the live children of one row, recursing
+// into cascaded children.
+func (p *DeletePlan) walk(q sqlQuerier, resource string, id int) error {
+	for _, rel := range deleteRelations {
+		if rel.parent != resource {
+			continue
+		}
+		children, err := liveChildren(q, rel, id)
+		if err != nil {
+			return err
+		}
+		for _, c := range children {
+			switch rel.policy {
+			case policyBlock:
+				p.BlockedBy = appendTarget(p.BlockedBy, c)
+			case policyArchive:
+				p.Archive = appendTarget(p.Archive, c)
+			case policyCascade:
+				if containsTarget(p.Cascade, c) {
+					continue
+				}
+				p.Cascade = append(p.Cascade, c)
+				if err := p.walk(q, c.Resource, c.ID); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// liveChildren returns the rows outside the trash that reference parentID
+// through rel.
+func liveChildren(q sqlQuerier, rel deleteRelation, parentID int) ([]DeleteTarget, error) {
+	t, _ := lookupTrashTable(rel.child)
+	rows, err := q.Query(`SELECT `+t.idColumn+`, `+t.label+` FROM `+t.table+`
+		WHERE `+rel.column+`=? AND deletedUnix IS NULL ORDER BY `+t.idColumn, parentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []DeleteTarget
+	for rows.Next() {
+		c := DeleteTarget{Resource: rel.child}
+		if err := rows.Scan(&c.ID, &c.Label); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// trashWithPolicies runs trashRow in its own transaction.
+func trashWithPolicies(db *sql.DB, userID int, resource string, id int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := trashRow(tx, userID, resource, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// trashRow moves a row visible to userID to the trash together with its
+// cascaded children. Returns sql.ErrNoRows if the row is not visible or
+// already in the trash, or a *DeleteBlockedError.
+func trashRow(q sqlQuerier, userID int, resource string, id int) error {
+	plan, err := PlanDelete(q, userID, resource, id)
+	if err != nil {
+		return err
+	}
+	if !plan.Allowed {
+		return &DeleteBlockedError{Plan: plan}
+	}
+	now := time.Now().Unix()
+	for _, target := range append([]DeleteTarget{plan.DeleteTarget}, plan.Cascade...) {
+		t, _ := lookupTrashTable(target.Resource)
+		if err := checkAffected(q.Exec(`UPDATE `+t.table+` SET deletedUnix=?, deletedByUserId=?
+			WHERE `+t.idColumn+`=? AND deletedUnix IS NULL`, now, userID, target.ID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreCascaded takes the children cascaded into the trash with a row,
+// recognised by sharing its deletedUnix, back out of it.
+func restoreCascaded(q sqlQuerier, resource string, id int, deletedUnix int64) error {
+	for _, rel := range deleteRelations {
+		if rel.parent != resource || rel.policy != policyCascade {
+			continue
+		}
+		t, _ := lookupTrashTable(rel.child)
+		rows, err := q.Query(`SELECT `+t.idColumn+` FROM `+t.table+` WHERE `+rel.column+`=? AND deletedUnix=?`, id, deletedUnix)
+		if err != nil {
+			return err
+		}
+		var ids []int
+		for rows.Next() {
+			var childID int
+			if err := rows.Scan(&childID); err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, childID)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, childID := range ids {
+			if _, err := q.Exec(`UPDATE `+t.table+` SET deletedUnix=NULL, deletedByUserId=NULL WHERE `+t.idColumn+`=?`, childID); err != nil {
+				return err
+			}
+			if err := restoreCascaded(q, rel.child, childID, deletedUnix); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// trashedParent reports which parent, if any, still keeps a row of resource
+// from being restored: a block or cascade parent that is in the trash. It
+// returns the parent's resource and ID, or "" when there is none.
+func trashedParent(q sqlQuerier, resource string, id int) (string, int, error) {
+	t, _ := lookupTrashTable(resource)
+	for _, rel := range deleteRelations {
+		if rel.child != resource || rel.policy == policyArchive {
+			continue
+		}
+		p, _ := lookupTrashTable(rel.parent)
+		var parentID int
+		err := q.QueryRow(`SELECT p.`+p.idColumn+` FROM `+t.table+` c JOIN `+p.table+` p ON p.`+p.idColumn+` = c.`+rel.column+`
+			WHERE c.`+t.idColumn+`=? AND p.deletedUnix IS NOT NULL`, id).Scan(&parentID)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return "", 0, err
+		}
+		return rel.parent, parentID, nil
+	}
+	return "", 0, nil
+}
+
+func appendTarget(list []DeleteTarget, t DeleteTarget) []DeleteTarget {
+	if containsTarget(list, t) {
+		return list
+	}
+	return append(list, t)
+}
+
+func containsTarget(list []DeleteTarget, t DeleteTarget) bool {
+	for _, x := range list {
+		if x.Resource == t.Resource && x.ID == t.ID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,257 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Tests for integrity.go and the purge in trash.go: each delete policy, the
+// 409 and preview for a blocked delete, the payments hidden with a trashed
+// lease, and leases whose ledger keeps them from being purged.
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// integrityFixture is a property with one unit, a maintenance request on the
+// unit, and a tenant leasing it.
+type integrityFixture struct {
+	s                                     *Store
+	ownerID, propertyID, unitID, tenantID int
+	leaseID, maintenanceID                int
+}
+
+func newIntegrityFixture(t *testing.T) *integrityFixture {
+	t.Helper()
+	f := &integrityFixture{s: newTestStore(t)}
+	s := f.s
+	f.ownerID = createTestUser(t, s, "owner@example.com", "owner")
+	var err error
+	if f.propertyID, err = s.Properties.Create(&Property{OwnerUserID: f.ownerID, PropertyName: "Maple", PropertyStreet: "1 Maple St", PropertyCity: "Morgantown"}); err != nil {
+		t.Fatal(err)
+	}
+	if f.unitID, err = s.Units.Create(&PropertyUnit{PropertyID: f.propertyID, PropertyUnitNumber: "2B", PropertyUnitRentDefault: 1000}); err != nil {
+		t.Fatal(err)
+	}
+	if f.maintenanceID, err = s.Maintenance.Create(&MaintenanceRequest{PropertyUnitID: f.unitID, MaintenanceRequestInfo: "Leaking tap", MaintenanceRequestStatus: "open"}); err != nil {
+		t.Fatal(err)
+	}
+	if f.tenantID, err = s.Tenants.Create(&Tenant{OwnerUserID: f.ownerID, TenantFirstName: "Tomasz", TenantLastName: "Renter", TenantEmail: "tomasz@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.Local)
+	if f.leaseID, err = s.Leases.Create(&Lease{TenantID: f.tenantID, PropertyUnitID: f.unitID, LeaseStartUnix: start.Unix(), LeaseRentAmount: 1000, LeaseStatus: "active"}); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// trashed reports whether a row of table is in the trash.
+func (f *integrityFixture) trashed(t *testing.T, table, idColumn string, id int) bool {
+	t.Helper()
+	var deleted sql.NullInt64
+	if err := f.s.DB.QueryRow(`SELECT deletedUnix FROM `+table+` WHERE `+idColumn+`=?`, id).Scan(&deleted); err != nil {
+		t.Fatal(err)
+	}
+	return deleted.Valid
+}
+
+func TestDeleteBlocked(t *testing.T) {
+	f := newIntegrityFixture(t)
+	h := buildRouter(f.s, f.s.DB, nil)
+	want := []DeleteTarget{{Resource: "leases", ID: f.leaseID, Label: "Lease " + strconv.Itoa(f.leaseID)}}
+
+	for _, tc := range []struct{ resource, path string }{
+		{"units", "/v1/units/" + strconv.Itoa(f.unitID)},
+		{"tenants", "/v1/tenants/" + strconv.Itoa(f.tenantID)},
+	} {
+		t.Run(tc.resource, func(t *testing.T) {
+			w := sendAs(h, f.ownerID, http.MethodGet, tc.path+"/deletePreview", "", "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("preview status %d, want 200", w.Code)
+			}
+			var plan DeletePlan
+			if err := json.Unmarshal(w.Body.Bytes(), &plan); err != nil {
+				t.Fatal(err)
+			}
+			if plan.Allowed || !reflect.DeepEqual(plan.BlockedBy, want) {
+				t.Errorf("preview allowed=%v blockedBy=%+v, want false and %+v", plan.Allowed, plan.BlockedBy, want)
+			}
+
+			w = sendAs(h, f.ownerID, http.MethodDelete, tc.path, "", "")
+			if w.Code != http.StatusConflict {
+				t.Fatalf("delete status %d, want 409", w.Code)
+			}
+			var body struct {
+				BlockedBy []DeleteTarget `json:"blockedBy"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(body.BlockedBy, plan.BlockedBy) {
+				t.Errorf("409 blockedBy = %+v, want the preview's %+v", body.BlockedBy, plan.BlockedBy)
+			}
+		})
+	}
+	if f.trashed(t, "propertyUnits", "propertyUnitId", f.unitID) || f.trashed(t, "tenants", "tenantId", f.tenantID) {
+		t.Error("a blocked delete moved its row to the trash")
+	}
+}
+
+func TestDeleteCascade(t *testing.T) {
+	f := newIntegrityFixture(t)
+	s := f.s
+	// Free the unit so the property can go
+	if err := s.Leases.Delete(f.ownerID, f.leaseID); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := s.Trash.PlanDelete(f.ownerID, "properties", f.propertyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantCascade := []DeleteTarget{
+		{Resource: "units", ID: f.unitID, Label: "Unit 2B"},
+		{Resource: "maintenance", ID: f.maintenanceID, Label: "Leaking tap"},
+	}
+	if !plan.Allowed || !reflect.DeepEqual(plan.Cascade, wantCascade) {
+		t.Errorf("plan allowed=%v cascade=%+v, want true and %+v", plan.Allowed, plan.Cascade, wantCascade)
+	}
+
+	if err := s.Properties.Delete(f.ownerID, f.propertyID); err != nil {
+		t.Fatal(err)
+	}
+	if !f.trashed(t, "propertyUnits", "propertyUnitId", f.unitID) || !f.trashed(t, "maintenanceRequests", "maintenanceRequestId", f.maintenanceID) {
+		t.Error("the unit and its maintenance request did not follow the property into the trash")
+	}
+	if err := s.Trash.Restore(f.ownerID, "units", f.unitID); !errors.Is(err, errParentInTrash) {
+		t.Errorf("restoring the unit first: %v, want errParentInTrash", err)
+	}
+	if err := s.Trash.Restore(f.ownerID, "properties", f.propertyID); err != nil {
+		t.Fatal(err)
+	}
+	if f.trashed(t, "propertyUnits", "propertyUnitId", f.unitID) || f.trashed(t, "maintenanceRequests", "maintenanceRequestId", f.maintenanceID) {
+		t.Error("restoring the property left its cascaded rows in the trash")
+	}
+	// The lease was deleted on its own and stays in the trash
+	if !f.trashed(t, "leases", "leaseId", f.leaseID) {
+		t.Error("restoring the property restored a lease deleted separately")
+	}
+}
+
+func TestDeleteArchive(t *testing.T) {
+	f := newIntegrityFixture(t)
+	s := f.s
+	now := time.Date(2025, time.March, 15, 0, 0, 0, 0, time.Local)
+	if _, err := s.Ledger.PostLeaseRentCharges(f.leaseID, now); err != nil {
+		t.Fatal(err)
+	}
+	paymentID, err := s.Payments.Create(&Payment{LeaseID: f.leaseID, PaymentAmount: 1000, PaymentDateUnix: now.Unix(), PaymentMethod: "check"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	search := func() []SearchResult {
+		results, err := s.Search.Search(f.ownerID, SearchQuery{Terms: []string{"check"}, Types: []string{"payment"}, Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return results
+	}
+	if len(search()) != 1 {
+		t.Fatalf("search for the payment = %+v, want it found", search())
+	}
+
+	plan, err := s.Trash.PlanDelete(f.ownerID, "leases", f.leaseID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantArchive := []DeleteTarget{{Resource: "payments", ID: paymentID, Label: "Payment of 1000"}}
+	if !plan.Allowed || len(plan.Cascade) != 0 || !reflect.DeepEqual(plan.Archive, wantArchive) {
+		t.Errorf("plan = %+v, want allowed with archive %+v", plan, wantArchive)
+	}
+	if err := s.Leases.Delete(f.ownerID, f.leaseID); err != nil {
+		t.Fatal(err)
+	}
+	if f.trashed(t, "payments", "paymentId", paymentID) {
+		t.Error("the archived payment went to the trash")
+	}
+
+	// Hidden with its lease, everywhere staff look
+	if _, err := s.Payments.GetByID(f.ownerID, paymentID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("get payment of a trashed lease: %v, want sql.ErrNoRows", err)
+	}
+	if page := listAll(t, s.Payments.List, f.ownerID, paymentListSpec); len(page.Data) != 0 {
+		t.Errorf("payments list shows %d payments of a trashed lease, want 0", len(page.Data))
+	}
+	if results := search(); len(results) != 0 {
+		t.Errorf("search = %+v, want no payments of a trashed lease", results)
+	}
+	if err := s.Payments.Delete(f.ownerID, paymentID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("delete payment of a trashed lease: %v, want sql.ErrNoRows", err)
+	}
+
+	if err := s.Trash.Restore(f.ownerID, "leases", f.leaseID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Payments.GetByID(f.ownerID, paymentID); err != nil {
+		t.Errorf("get payment after restoring its lease: %v", err)
+	}
+}
+
+func TestPurgeKeepsLeaseLedger(t *testing.T) {
+	f := newIntegrityFixture(t)
+	s := f.s
+	h := buildRouter(s, s.DB, nil)
+	now := time.Date(2025, time.March, 15, 0, 0, 0, 0, time.Local)
+	if _, err := s.Ledger.PostLeaseRentCharges(f.leaseID, now); err != nil {
+		t.Fatal(err)
+	}
+	countCharges := func() int {
+		var n int
+		if err := s.DB.QueryRow(`SELECT COUNT(*) FROM charges WHERE leaseId = ?`, f.leaseID).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	if countCharges() != 3 {
+		t.Fatalf("%d charges posted, want 3", countCharges())
+	}
+	if err := s.Leases.Delete(f.ownerID, f.leaseID); err != nil {
+		t.Fatal(err)
+	}
+
+	w := sendAs(h, f.ownerID, http.MethodDelete, "/v1/trash/leases/"+strconv.Itoa(f.leaseID), "", "")
+	if w.Code != http.StatusConflict {
+		t.Errorf("purge status %d, want 409", w.Code)
+	}
+	if n, err := s.Trash.PurgeExpired(now.AddDate(1, 0, 0).Unix()); err != nil || n != 0 {
+		t.Errorf("purge job removed %d rows (%v), want 0", n, err)
+	}
+	if !f.trashed(t, "leases", "leaseId", f.leaseID) || countCharges() != 3 {
+		t.Error("purging the lease removed it or its charges")
+	}
+
+	// A lease with no ledger is purged as before
+	start := time.Now().AddDate(1, 0, 0)
+	emptyID, err := s.Leases.Create(&Lease{TenantID: f.tenantID, PropertyUnitID: f.unitID, LeaseStartUnix: start.Unix(), LeaseRentAmount: 1000, LeaseStatus: "pending"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Leases.Delete(f.ownerID, emptyID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Trash.Purge(f.ownerID, "leases", emptyID); err != nil {
+		t.Errorf("purging a lease without charges: %v", err)
+	}
+}
@@ -0,0 +1,284 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Package-level summary:
+// This file manages the keys used to sign and verify access tokens. Keys are
+// loaded from configuration and identified by a `kid` header so several keys
+// can be accepted at once while one of them is rotated out. HS256 shared
+// secrets, RS256 and EdDSA (Ed25519) keys are supported; public halves of the
+// asymmetric keys are published as a JWKS document so other services can
+// verify RentTracker tokens without the private key. Functions: LoadKeySet,
+// KeySet.Sign, KeySet.Keyfunc, KeySet.JWKS, and JWKSHandler.
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+// signingKeys holds the keys loaded at startup by main.
+var signingKeys *KeySet
+
+// KeyConfig describes one key in the key configuration file.
+// HS256 keys use secret or secretFile; RS256 and EdDSA keys use PEM files.
+// A key with only a publicKeyFile is accepted for verification but never signs.
+type KeyConfig struct {
+	Kid            string `json:"kid"`
+	Alg            string `json:"alg"` // HS256, RS256, EdDSA
+	Secret         string `json:"secret,omitempty"`
+	SecretFile     string `json:"secretFile,omitempty"`
+	PrivateKeyFile string `json:"privateKeyFile,omitempty"`
+	PublicKeyFile  string `json:"publicKeyFile,omitempty"`
+}
+
+// KeySetConfig is the shape of the key configuration file. activeKid selects the
+// key used to sign new tokens; every listed key is accepted when verifying.
+type KeySetConfig struct {
+	ActiveKid string      `json:"activeKid"`
+	Keys      []KeyConfig `json:"keys"`
+}
+
+// SigningKey is a parsed key ready for signing and/or verification.
+type SigningKey struct {
+	Kid       string
+	Method    jwt.SigningMethod
+	SignKey   interface{} // nil for verify-only keys
+	VerifyKey interface{}
+}
+
+// KeySet is the collection of keys accepted by the server.
+type KeySet struct {
+	Active *SigningKey
+	Keys   map[string]*SigningKey
+}
+
+// LoadKeySet builds the key set from the JWT settings in the configuration:
+//   - keysFile (RT_JWT_KEYS_FILE) points at a JSON KeySetConfig (multiple keys, rotation)
+//   - otherwise secret (RT_JWT_SECRET) is used as a single HS256 key with kid "default"
+//   - otherwise a random HS256 key is generated, so tokens do not survive a restart
+func LoadKeySet(path, secret string) (*KeySet, error) {
+	if path != "" {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read keys file: %w", err)
+		}
+		var cfg KeySetConfig
+		if err := json.Unmarshal(raw, &cfg); err != nil {
+			return nil, fmt.Errorf("parse keys file: %w", err)
+		}
+		return NewKeySet(cfg)
+	}
+
+	if secret == "" {
+		logWarn("no RT_JWT_KEYS_FILE or RT_JWT_SECRET set; using a random signing key for this run")
+		var err error
+		if secret, err = randomToken(32); err != nil {
+			return nil, err
+		}
+	}
+	return NewKeySet(KeySetConfig{
+		ActiveKid: "default",
+		Keys:      []KeyConfig{{Kid: "default", Alg: "HS256", Secret: secret}},
+	})
+}
+
+// NewKeySet parses every key in cfg and checks that the active key can sign.
+func NewKeySet(cfg KeySetConfig) (*KeySet, error) {
+	ks := &KeySet{Keys: map[string]*SigningKey{}}
+	for _, kc := range cfg.Keys {
+		if kc.Kid == "" {
+			return nil, errors.New("signing key missing kid")
+		}
+		if _, dup := ks.Keys[kc.Kid]; dup {
+			return nil, fmt.Errorf("duplicate signing key kid %q", kc.Kid)
+		}
+		k, err := parseKeyConfig(kc)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", kc.Kid, err)
+		}
+		ks.Keys[kc.Kid] = k
+	}
+
+	active, ok := ks.Keys[cfg.ActiveKid]
+	if !ok {
+		return nil, fmt.Errorf("activeKid %q does not match any configured key", cfg.ActiveKid)
+	}
+	if active.SignKey == nil {
+		return nil, fmt.Errorf("active key %q has no private key or secret", cfg.ActiveKid)
+	}
+	ks.Active = active
+	return ks, nil
+}
+
+// parseKeyConfig loads the key material for a single KeyConfig.
+func parseKeyConfig(kc KeyConfig) (*SigningKey, error) {
+	k := &SigningKey{Kid: kc.Kid}
+	switch kc.Alg {
+	case "HS256":
+		k.Method = jwt.SigningMethodHS256
+		secret := []byte(kc.Secret)
+		if kc.SecretFile != "" {
+			raw, err := os.ReadFile(kc.SecretFile)
+			if err != nil {
+				return nil, err
+			}
+			secret = bytes.TrimSpace(raw)
+		}
+		if len(secret) < 32 {
+			return nil, errors.New("HS256 secret must be at least 32 bytes")
+		}
+		k.SignKey, k.VerifyKey = secret, secret
+
+	case "RS256":
+		k.Method = jwt.SigningMethodRS256
+		if kc.PrivateKeyFile != "" {
+			pem, err := os.ReadFile(kc.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			priv, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			k.SignKey, k.VerifyKey = priv, &priv.PublicKey
+		} else if kc.PublicKeyFile != "" {
+			pem, err := os.ReadFile(kc.PublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			pub, err := jwt.ParseRSAPublicKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			k.VerifyKey = pub
+		} else {
+			return nil, errors.New("RS256 key needs privateKeyFile or publicKeyFile")
+		}
+
+	case "EdDSA":
+		k.Method = jwt.SigningMethodEdDSA
+		if kc.PrivateKeyFile != "" {
+			pem, err := os.ReadFile(kc.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			priv, err := jwt.ParseEdPrivateKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			edPriv, ok := priv.(ed25519.PrivateKey)
+			if !ok {
+				return nil, errors.New("EdDSA private key is not Ed25519")
+			}
+			k.SignKey, k.VerifyKey = edPriv, edPriv.Public()
+		} else if kc.PublicKeyFile != "" {
+			pem, err := os.ReadFile(kc.PublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			pub, err := jwt.ParseEdPublicKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			k.VerifyKey = pub
+		} else {
+			return nil, errors.New("EdDSA key needs privateKeyFile or publicKeyFile")
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported alg %q", kc.Alg)
+	}
+	return k, nil
+}
+
+// Sign signs claims with the active key and stamps its kid in the header.
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.Active.Method, claims)
+	token.Header["kid"] = ks.Active.Kid
+	return token.SignedString(ks.Active.SignKey)
+}
+
+// Keyfunc resolves the verification key for a token from its kid header and
+// refuses tokens whose alg does not match the key's algorithm.
+func (ks *KeySet) Keyfunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+	key, ok := ks.Keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+	if t.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("alg %q does not match key %q", t.Method.Alg(), kid)
+	}
+	return key.VerifyKey, nil
+}
+
+// ValidMethods lists the algorithms of every configured key.
+func (ks *KeySet) ValidMethods() []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, k := range ks.Keys {
+		if alg := k.Method.Alg(); !seen[alg] {
+			seen[alg] = true
+			out = append(out, alg)
+		}
+	}
+	return out
+}
+
+// JWKS returns the public asymmetric keys as a JSON Web Key Set (RFC 7517).
+// Shared HS256 secrets are never published.
+func (ks *KeySet) JWKS() map[string]interface{} {
+	kids := make([]string, 0, len(ks.Keys))
+	for kid := range ks.Keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	keys := []map[string]string{}
+	for _, kid := range kids {
+		k := ks.Keys[kid]
+		switch pub := k.VerifyKey.(type) {
+		case *rsa.PublicKey:
+			keys = append(keys, map[string]string{
+				"kty": "RSA", "use": "sig", "alg": "RS256", "kid": k.Kid,
+				"n": base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			keys = append(keys, map[string]string{
+				"kty": "OKP", "crv": "Ed25519", "use": "sig", "alg": "EdDSA", "kid": k.Kid,
+				"x": base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+	return map[string]interface{}{"keys": keys}
+}
+
+// GET /.well-known/jwks.json
+// JWKSHandler returns an HTTP handler that publishes the public signing keys.
+func JWKSHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		respondJSON(w, http.StatusOK, signingKeys.JWKS())
+	}
+}
@@ -0,0 +1,211 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Tests for keys.go: tokens verify across a key rotation, tokens naming an
+// unknown kid or the wrong alg are refused, HS256 secrets have a minimum
+// length, and only the public asymmetric keys are published as a JWKS.
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	oldSecret = strings.Repeat("o", 32)
+	newSecret = strings.Repeat("n", 32)
+)
+
+// hsKey is an HS256 key config with secret.
+func hsKey(kid, secret string) KeyConfig {
+	return KeyConfig{Kid: kid, Alg: "HS256", Secret: secret}
+}
+
+// mustKeySet builds a key set or fails the test.
+func mustKeySet(t *testing.T, cfg KeySetConfig) *KeySet {
+	t.Helper()
+	ks, err := NewKeySet(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ks
+}
+
+// verify parses tokenStr against ks the way parseBearerToken does.
+func verify(ks *KeySet, tokenStr string) error {
+	_, err := jwt.Parse(tokenStr, ks.Keyfunc, jwt.WithValidMethods(ks.ValidMethods()))
+	return err
+}
+
+// testClaims are unexpired registered claims.
+func testClaims() jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{Subject: "1", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))}
+}
+
+// writePEM writes a PKCS#8 private key to a PEM file in dir and returns its path.
+func writePEM(t *testing.T, dir, name string, key interface{}) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestKeyRotation(t *testing.T) {
+	before := mustKeySet(t, KeySetConfig{ActiveKid: "old", Keys: []KeyConfig{hsKey("old", oldSecret)}})
+	token, err := before.Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The new key signs; the old one is still accepted until it is removed
+	during := mustKeySet(t, KeySetConfig{ActiveKid: "new", Keys: []KeyConfig{hsKey("old", oldSecret), hsKey("new", newSecret)}})
+	if err := verify(during, token); err != nil {
+		t.Errorf("token signed under the old kid after rotation: %v", err)
+	}
+	fresh, err := during.Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _, err := jwt.NewParser().ParseUnverified(fresh, jwt.MapClaims{})
+	if err != nil || parsed.Header["kid"] != "new" {
+		t.Errorf("new token kid = %v, want new", parsed.Header["kid"])
+	}
+
+	after := mustKeySet(t, KeySetConfig{ActiveKid: "new", Keys: []KeyConfig{hsKey("new", newSecret)}})
+	if err := verify(after, token); err == nil {
+		t.Error("token signed under a removed kid was accepted")
+	}
+	if err := verify(after, fresh); err != nil {
+		t.Errorf("token signed under the active kid: %v", err)
+	}
+}
+
+func TestKeyfuncRejects(t *testing.T) {
+	dir := t.TempDir()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks := mustKeySet(t, KeySetConfig{ActiveKid: "hs", Keys: []KeyConfig{
+		hsKey("hs", oldSecret),
+		{Kid: "rsa", Alg: "RS256", PrivateKeyFile: writePEM(t, dir, "rsa.pem", rsaKey)},
+	}})
+
+	sign := func(method jwt.SigningMethod, kid string, key interface{}) string {
+		t.Helper()
+		token := jwt.NewWithClaims(method, testClaims())
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		s, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"unknown kid", sign(jwt.SigningMethodHS256, "gone", []byte(oldSecret))},
+		{"missing kid", sign(jwt.SigningMethodHS256, "", []byte(oldSecret))},
+		{"HS384 under an HS256 kid", sign(jwt.SigningMethodHS384, "hs", []byte(oldSecret))},
+		{"HS256 keyed with the RSA public key", sign(jwt.SigningMethodHS256, "rsa", pubDER)},
+		{"RS256 under an HS256 kid", sign(jwt.SigningMethodRS256, "hs", rsaKey)},
+	}
+	for _, tt := range tests {
+		if err := verify(ks, tt.token); err == nil {
+			t.Errorf("%s: token accepted", tt.name)
+		}
+	}
+	if err := verify(ks, sign(jwt.SigningMethodRS256, "rsa", rsaKey)); err != nil {
+		t.Errorf("RS256 token under the RSA kid: %v", err)
+	}
+}
+
+func TestHS256SecretLength(t *testing.T) {
+	if _, err := NewKeySet(KeySetConfig{ActiveKid: "k", Keys: []KeyConfig{hsKey("k", strings.Repeat("s", 31))}}); err == nil {
+		t.Error("accepted a 31-byte HS256 secret")
+	}
+	if _, err := NewKeySet(KeySetConfig{ActiveKid: "k", Keys: []KeyConfig{hsKey("k", strings.Repeat("s", 32))}}); err != nil {
+		t.Errorf("32-byte HS256 secret: %v", err)
+	}
+
+	// A secret file is trimmed before its length is checked
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(path, []byte(strings.Repeat("s", 31)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewKeySet(KeySetConfig{ActiveKid: "k", Keys: []KeyConfig{{Kid: "k", Alg: "HS256", SecretFile: path}}}); err == nil {
+		t.Error("accepted a 31-byte HS256 secret file")
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	dir := t.TempDir()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks := mustKeySet(t, KeySetConfig{ActiveKid: "rsa", Keys: []KeyConfig{
+		hsKey("hs", oldSecret),
+		{Kid: "rsa", Alg: "RS256", PrivateKeyFile: writePEM(t, dir, "rsa.pem", rsaKey)},
+		{Kid: "ed", Alg: "EdDSA", PrivateKeyFile: writePEM(t, dir, "ed.pem", edPriv)},
+	}})
+
+	keys, _ := ks.JWKS()["keys"].([]map[string]string)
+	if len(keys) != 2 {
+		t.Fatalf("JWKS has %d keys, want the RSA and Ed25519 keys only: %v", len(keys), keys)
+	}
+	b64 := base64.RawURLEncoding.EncodeToString
+	ed, rs := keys[0], keys[1] // sorted by kid
+	wantEd := map[string]string{"kty": "OKP", "crv": "Ed25519", "use": "sig", "alg": "EdDSA", "kid": "ed", "x": b64(edPub)}
+	wantRSA := map[string]string{"kty": "RSA", "use": "sig", "alg": "RS256", "kid": "rsa",
+		"n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())}
+	for _, c := range []struct{ got, want map[string]string }{{ed, wantEd}, {rs, wantRSA}} {
+		if len(c.got) != len(c.want) {
+			t.Errorf("JWK %v, want %v", c.got, c.want)
+			continue
+		}
+		for k, v := range c.want {
+			if c.got[k] != v {
+				t.Errorf("JWK %s %s = %q, want %q", c.want["kid"], k, c.got[k], v)
+			}
+		}
+	}
+	if rs["e"] != "AQAB" {
+		t.Errorf("RSA exponent %q, want AQAB", rs["e"])
+	}
+}
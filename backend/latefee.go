@@ -0,0 +1,513 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Package-level summary:
+// This file implements late fees. A late fee rule belongs to a lease or to a
+// property, and a lease's own rule takes precedence over its property's. Each
+// run of the rent job (see ledger.go) looks for rent charges still unpaid
+// after the rule's grace days and posts a lateFee charge for the same period:
+// a flat amount, a percentage of the rent, or an amount per day late, capped
+// by lateFeeMax. A daily fee grows with each run until the rent is paid or the
+// cap is reached. Fees can be waived with a reason; a waived charge stays in
+// the ledger but is no longer owed.
+// Handlers: GetLateFeeRuleHandler, PutLateFeeRuleHandler,
+// DeleteLateFeeRuleHandler, WaiveChargeHandler. DB helpers: GetLateFeeRule,
+// SetLateFeeRule, DeleteLateFeeRule, WaiveCharge, AssessLateFees.
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Late fee rule types
+const (
+	LateFeeFlat    = "flat"    // lateFeeAmount once
+	LateFeePercent = "percent" // lateFeePercent of the rent charge once
+	LateFeeDaily   = "daily"   // lateFeeAmount for every day late
+)
+
+var (
+	errChargeWaived      = errors.New("charge is already waived")
+	errChargeNotWaivable = errors.New("only fees and late fees can be waived")
+)
+
+// LATE FEE RULES
+type LateFeeRule struct {
+	LateFeeRuleID    int     `db:"lateFeeRuleId" json:"lateFeeRuleId"`
+	PropertyID       *int    `db:"propertyId" json:"propertyId,omitempty"`
+	LeaseID          *int    `db:"leaseId" json:"leaseId,omitempty"`
+	LateFeeType      string  `db:"lateFeeType" json:"lateFeeType"`
+	LateFeeAmount    int     `db:"lateFeeAmount" json:"lateFeeAmount"`
+	LateFeePercent   float64 `db:"lateFeePercent" json:"lateFeePercent"`
+	LateFeeGraceDays int     `db:"lateFeeGraceDays" json:"lateFeeGraceDays"`
+	LateFeeMax       *int    `db:"lateFeeMax" json:"lateFeeMax,omitempty"`
+	UpdatedUnix      int64   `db:"updatedUnix" json:"updatedUnix"`
+	UpdatedByUserID  *int    `db:"updatedByUserId" json:"updatedByUserId,omitempty"`
+}
+
+// validate checks the fields a client sets. Errors are meant for a 400 response.
+func (r *LateFeeRule) validate() error {
+	switch r.LateFeeType {
+	case LateFeeFlat, LateFeeDaily:
+		if r.LateFeeAmount <= 0 {
+			return fmt.Errorf("lateFeeAmount must be positive for a %s fee", r.LateFeeType)
+		}
+	case LateFeePercent:
+		if r.LateFeePercent <= 0 || r.LateFeePercent > 100 {
+			return errors.New("lateFeePercent must be above 0 and at most 100")
+		}
+	default:
+		return errors.New("lateFeeType must be flat, percent or daily")
+	}
+	if r.LateFeeGraceDays < 0 {
+		return errors.New("lateFeeGraceDays must not be negative")
+	}
+	if r.LateFeeMax != nil && *r.LateFeeMax <= 0 {
+		return errors.New("lateFeeMax must be positive")
+	}
+	return nil
+}
+
+// lateFrom returns the first day a charge due at due counts as late: the day
+// after the grace days end.
+func (r *LateFeeRule) lateFrom(due time.Time) time.Time {
+	return startOfDay(due).AddDate(0, 0, r.LateFeeGraceDays+1)
+}
+
+// fee returns the late fee owed on a rent charge of rent that is daysLate
+// days late (1 on the first late day).
+func (r *LateFeeRule) fee(rent, daysLate int) int {
+	var fee int
+	switch r.LateFeeType {
+	case LateFeeFlat:
+		fee = r.LateFeeAmount
+	case LateFeePercent:
+		fee = int(math.Round(float64(rent) * r.LateFeePercent / 100))
+	case LateFeeDaily:
+		fee = r.LateFeeAmount * daysLate
+	}
+	if r.LateFeeMax != nil && fee > *r.LateFeeMax {
+		fee = *r.LateFeeMax
+	}
+	return fee
+}
+
+// lateFeeOwners maps the resources a rule can belong to onto its column.
+var lateFeeOwners = map[string]string{
+	"leases":     "leaseId",
+	"properties": "propertyId",
+}
+
+// canAccessLateFeeOwner reports whether userID can see the lease or property
+// a rule belongs to.
+func canAccessLateFeeOwner(s *Store, userID int, owner string, id int) (bool, error) {
+	if owner == "leases" {
+		return s.Access.CanAccessLease(userID, id)
+	}
+	return s.Access.CanAccessProperty(userID, id)
+}
+
+// == Handlers =====================================================================
+// GET
+// GetLateFeeRuleHandler returns an HTTP handler for
+// GET /v1/{leases|properties}/{id}/lateFeeRule. For a lease it returns the
+// rule in effect, which is the property's when the lease has none of its own;
+// propertyId or leaseId in the response says which.
+func GetLateFeeRuleHandler(s *Store, owner string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "invalid id")
+			return
+		}
+		if ok, err := canAccessLateFeeOwner(s, currentUserID(r), owner, id); err != nil || !ok {
+			respondError(w, http.StatusNotFound, "not found")
+			return
+		}
+		rule, err := s.Ledger.GetLateFeeRule(owner, id)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				respondError(w, http.StatusNotFound, "no late fee rule")
+			} else {
+				respondError(w, http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+		respondJSON(w, http.StatusOK, rule)
+	}
+}
+
+// PUT
+// PutLateFeeRuleHandler returns an HTTP handler for
+// PUT /v1/{leases|properties}/{id}/lateFeeRule, which sets the rule of a lease
+// or property, replacing any it had. Accepts {lateFeeType, lateFeeAmount,
+// lateFeePercent, lateFeeGraceDays, lateFeeMax}.
+func PutLateFeeRuleHandler(s *Store, owner string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "invalid id")
+			return
+		}
+		var rule LateFeeRule
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		if err := rule.validate(); err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		userID := currentUserID(r)
+		if ok, err := canAccessLateFeeOwner(s, userID, owner, id); err != nil || !ok {
+			respondError(w, http.StatusNotFound, "not found")
+			return
+		}
+		rule.PropertyID, rule.LeaseID = nil, nil
+		if owner == "leases" {
+			rule.LeaseID = &id
+		} else {
+			rule.PropertyID = &id
+		}
+		rule.UpdatedByUserID = &userID
+		if err := s.Ledger.SetLateFeeRule(&rule); err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		respondJSON(w, http.StatusOK, rule)
+	}
+}
+
+// DELETE
+// DeleteLateFeeRuleHandler returns an HTTP handler for
+// DELETE /v1/{leases|properties}/{id}/lateFeeRule. Removing a lease's rule
+// puts its property's rule, if any, back in effect. Late fees already posted
+// are kept.
+func DeleteLateFeeRuleHandler(s *Store, owner string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "invalid id")
+			return
+		}
+		if ok, err := canAccessLateFeeOwner(s, currentUserID(r), owner, id); err != nil || !ok {
+			respondError(w, http.StatusNotFound, "not found")
+			return
+		}
+		if err := s.Ledger.DeleteLateFeeRule(owner, id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				respondError(w, http.StatusNotFound, "no late fee rule")
+			} else {
+				respondError(w, http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+	}
+}
+
+// POST
+// WaiveChargeHandler returns an HTTP handler for POST /v1/charges/{id}/waive,
+// which waives a fee or late fee. Accepts {reason}, which is required. A
+// waived late fee is not posted again, even if the rent stays unpaid.
+func WaiveChargeHandler(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "invalid id")
+			return
+		}
+		var body struct {
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		body.Reason = strings.TrimSpace(body.Reason)
+		if body.Reason == "" {
+			respondError(w, http.StatusBadRequest, "reason required")
+			return
+		}
+		c, err := s.Ledger.WaiveCharge(currentUserID(r), id, body.Reason)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				respondError(w, http.StatusNotFound, "not found")
+			case errors.Is(err, errChargeWaived), errors.Is(err, errChargeNotWaivable):
+				respondError(w, http.StatusConflict, err.Error())
+			default:
+				respondError(w, http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+		respondJSON(w, http.StatusOK, c)
+	}
+}
+
+// == SQL Queries ==================================================================
+
+const lateFeeRuleColumns = `lateFeeRuleId, propertyId, leaseId, lateFeeType, lateFeeAmount, lateFeePercent, lateFeeGraceDays, lateFeeMax, updatedUnix, updatedByUserId`
+
+func lateFeeRuleFields(r *LateFeeRule) []interface{} {
+	return []interface{}{&r.LateFeeRuleID, &r.PropertyID, &r.LeaseID, &r.LateFeeType, &r.LateFeeAmount, &r.LateFeePercent, &r.LateFeeGraceDays, &r.LateFeeMax, &r.UpdatedUnix, &r.UpdatedByUserID}
+}
+
+// GetLateFeeRule returns the rule of a property, or the rule in effect for a
+// lease: its own, otherwise its property's. Returns sql.ErrNoRows if there is none.
+func GetLateFeeRule(db *sql.DB, owner string, id int) (*LateFeeRule, error) {
+	var rule LateFeeRule
+	var err error
+	if owner == "leases" {
+		err = db.QueryRow(`SELECT `+lateFeeRuleColumns+` FROM lateFeeRules WHERE lateFeeRuleId = `+leaseLateFeeRuleSQL, id, id).
+			Scan(lateFeeRuleFields(&rule)...)
+	} else {
+		err = db.QueryRow(`SELECT `+lateFeeRuleColumns+` FROM lateFeeRules WHERE propertyId=?`, id).Scan(lateFeeRuleFields(&rule)...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// leaseLateFeeRuleSQL selects the ID of the rule in effect for a lease. Bind
+// the lease ID twice.
+const leaseLateFeeRuleSQL = `COALESCE(
+	(SELECT lateFeeRuleId FROM lateFeeRules WHERE leaseId = ?),
+	(SELECT r.lateFeeRuleId FROM lateFeeRules r
+		JOIN propertyUnits u ON u.propertyId = r.propertyId
+		JOIN leases rl ON rl.propertyUnitId = u.propertyUnitId
+		WHERE rl.leaseId = ?))`
+
+// SetLateFeeRule stores rule as the rule of its lease or property, replacing
+// the one it had, and fills in its ID and updatedUnix.
+func SetLateFeeRule(db *sql.DB, rule *LateFeeRule) error {
+	column := "propertyId"
+	if rule.LeaseID != nil {
+		column = "leaseId"
+	}
+	rule.UpdatedUnix = time.Now().Unix()
+	return db.QueryRow(`INSERT INTO lateFeeRules (propertyId, leaseId, lateFeeType, lateFeeAmount, lateFeePercent, lateFeeGraceDays, lateFeeMax, updatedUnix, updatedByUserId)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (`+column+`) DO UPDATE SET lateFeeType=excluded.lateFeeType, lateFeeAmount=excluded.lateFeeAmount,
+			lateFeePercent=excluded.lateFeePercent, lateFeeGraceDays=excluded.lateFeeGraceDays, lateFeeMax=excluded.lateFeeMax,
+			updatedUnix=excluded.updatedUnix, updatedByUserId=excluded.updatedByUserId
+		RETURNING lateFeeRuleId`,
+		rule.PropertyID, rule.LeaseID, rule.LateFeeType, rule.LateFeeAmount, rule.LateFeePercent, rule.LateFeeGraceDays, rule.LateFeeMax, rule.UpdatedUnix, rule.UpdatedByUserID).
+		Scan(&rule.LateFeeRuleID)
+}
+
+// DeleteLateFeeRule removes the rule of a lease or property. Returns
+// sql.ErrNoRows if it has none.
+func DeleteLateFeeRule(db *sql.DB, owner string, id int) error {
+	return checkAffected(db.Exec(`DELETE FROM lateFeeRules WHERE `+lateFeeOwners[owner]+`=?`, id))
+}
+
+// WaiveCharge waives a fee or late fee visible to userID, frees what was
+// allocated to it for other charges, and returns it.
+// Returns sql.ErrNoRows if the charge is not visible, errChargeWaived if it
+// was waived already, or errChargeNotWaivable for rent and adjustments.
+func WaiveCharge(db *sql.DB, userID, id int, reason string) (*Charge, error) {
+	args := append([]interface{}{time.Now().Unix(), userID, reason, id, ChargeFee, ChargeLateFee}, scopeArgs(userID, 2)...)
+	err := checkAffected(db.Exec(`UPDATE charges SET waivedUnix=?, waivedByUserId=?, waiveReason=?
+		WHERE chargeId=? AND waivedUnix IS NULL AND chargeType IN (?, ?) AND `+chargeScopeSQL, args...))
+	if err == nil {
+		var leaseID int
+		if err = db.QueryRow(`SELECT leaseId FROM charges WHERE chargeId=?`, id).Scan(&leaseID); err == nil {
+			err = reallocate(db, leaseID)
+		}
+	}
+	c, getErr := GetChargeByID(db, userID, id)
+	if getErr != nil {
+		return nil, getErr
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		if c.WaivedUnix != nil {
+			return nil, errChargeWaived
+		}
+		return nil, errChargeNotWaivable
+	}
+	return c, err
+}
+
+// AssessLateFees posts or increases the late fees owed as of now on every
+// live lease with a rule in effect, and returns how many fee charges it
+// posted or changed. A rent charge owes a fee from the day after its grace
+// days while payment allocations (see allocation.go) leave part of it
+// unpaid. Fees are never reduced here, and a waived fee is left alone. A
+// lease that fails is logged and skipped, as in PostRentCharges.
+func AssessLateFees(db *sql.DB, now time.Time) (int, error) {
+	rows, err := db.Query(`SELECT l.leaseId, ` + prefixColumns("r.", lateFeeRuleColumns) + ` FROM leases l
+		JOIN lateFeeRules r ON r.lateFeeRuleId = ` + strings.ReplaceAll(leaseLateFeeRuleSQL, "?", "l.leaseId") + `
+		WHERE l.deletedUnix IS NULL ORDER BY l.leaseId`)
+	if err != nil {
+		return 0, err
+	}
+	type leaseRule struct {
+		leaseID int
+		rule    LateFeeRule
+	}
+	var leases []leaseRule
+	for rows.Next() {
+		var lr leaseRule
+		if err := rows.Scan(append([]interface{}{&lr.leaseID}, lateFeeRuleFields(&lr.rule)...)...); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		leases = append(leases, lr)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	n, failed := 0, 0
+	for _, lr := range leases {
+		changed, err := assessLeaseLateFees(db, lr.leaseID, &lr.rule, now)
+		n += changed
+		if err != nil {
+			log.Printf("late fees: lease %d: %v", lr.leaseID, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return n, fmt.Errorf("%d of %d leases failed", failed, len(leases))
+	}
+	return n, nil
+}
+
+// assessLeaseLateFees applies rule to the rent charges of one lease.
+func assessLeaseLateFees(db *sql.DB, leaseID int, rule *LateFeeRule, now time.Time) (int, error) {
+	ledger, err := loadLedger(db, leaseID, now)
+	if err != nil {
+		return 0, err
+	}
+	unpaid := map[int]int{}
+	for _, e := range ledger.Entries {
+		if e.EntryType == "charge" && e.ChargeType == ChargeRent && e.Unpaid != nil {
+			unpaid[e.ID] = *e.Unpaid
+		}
+	}
+
+	type lateFee struct {
+		id     int
+		amount int
+		waived bool
+	}
+	fees := map[string]lateFee{}
+	rows, err := db.Query(`SELECT chargeId, chargePeriod, chargeAmount, waivedUnix IS NOT NULL FROM charges WHERE leaseId=? AND chargeType=?`, leaseID, ChargeLateFee)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var f lateFee
+		var period string
+		if err := rows.Scan(&f.id, &period, &f.amount, &f.waived); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		fees[period] = f
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	type rentCharge struct {
+		id, amount int
+		period     string
+		dueUnix    int64
+	}
+	var rents []rentCharge
+	rows, err = db.Query(`SELECT chargeId, chargePeriod, chargeAmount, chargeDueUnix FROM charges
+		WHERE leaseId=? AND chargeType=? AND waivedUnix IS NULL ORDER BY chargeDueUnix`, leaseID, ChargeRent)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var c rentCharge
+		if err := rows.Scan(&c.id, &c.period, &c.amount, &c.dueUnix); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		rents = append(rents, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	today := startOfDay(now.In(time.Local))
+	for _, c := range rents {
+		if unpaid[c.id] == 0 {
+			continue
+		}
+		lateFrom := rule.lateFrom(time.Unix(c.dueUnix, 0).In(time.Local))
+		if today.Before(lateFrom) {
+			continue
+		}
+		daysLate := int(math.Round(today.Sub(lateFrom).Hours()/24)) + 1
+		fee := rule.fee(c.amount, daysLate)
+		existing, posted := fees[c.period]
+		switch {
+		case posted && (existing.waived || existing.amount >= fee):
+			continue
+		case posted:
+			_, err = db.Exec(`UPDATE charges SET chargeAmount=? WHERE chargeId=? AND waivedUnix IS NULL`, fee, existing.id)
+		default:
+			month, _ := time.ParseInLocation("2006-01", c.period, time.Local)
+			_, err = db.Exec(`INSERT INTO charges (leaseId, chargeType, chargePeriod, chargeAmount, chargeDueUnix, chargeDescription, createdUnix)
+				VALUES (?, ?, ?, ?, ?, ?, ?) ON CONFLICT (leaseId, chargeType, chargePeriod) DO NOTHING`,
+				leaseID, ChargeLateFee, c.period, fee, lateFrom.Unix(), "Late fee, rent "+month.Format("January 2006"), now.Unix())
+		}
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	if n > 0 {
+		return n, reallocate(db, leaseID)
+	}
+	return n, nil
+}
+
+// prefixColumns qualifies each name in a comma-separated column list with prefix.
+func prefixColumns(prefix, columns string) string {
+	names := strings.Split(columns, ", ")
+	for i, name := range names {
+		names[i] = prefix + name
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,197 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Tests for latefee.go: the fee a rule charges and the day it starts, and an
+// AssessLateFees run against SQLite covering grace days, the daily cap,
+// waiving, re-assessment and a lease that fails.
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLateFeeRuleFee(t *testing.T) {
+	max := func(n int) *int { return &n }
+	tests := []struct {
+		name     string
+		rule     LateFeeRule
+		rent     int
+		daysLate int
+		want     int
+	}{
+		{"flat", LateFeeRule{LateFeeType: LateFeeFlat, LateFeeAmount: 50}, 1000, 1, 50},
+		{"flat does not grow", LateFeeRule{LateFeeType: LateFeeFlat, LateFeeAmount: 50}, 1000, 20, 50},
+		{"flat over the cap", LateFeeRule{LateFeeType: LateFeeFlat, LateFeeAmount: 50, LateFeeMax: max(40)}, 1000, 1, 40},
+		{"percent", LateFeeRule{LateFeeType: LateFeePercent, LateFeePercent: 5}, 1000, 3, 50},
+		{"percent rounds", LateFeeRule{LateFeeType: LateFeePercent, LateFeePercent: 5}, 1234, 1, 62},
+		{"percent over the cap", LateFeeRule{LateFeeType: LateFeePercent, LateFeePercent: 10, LateFeeMax: max(75)}, 1000, 1, 75},
+		{"daily first day", LateFeeRule{LateFeeType: LateFeeDaily, LateFeeAmount: 10}, 1000, 1, 10},
+		{"daily grows", LateFeeRule{LateFeeType: LateFeeDaily, LateFeeAmount: 10}, 1000, 7, 70},
+		{"daily under the cap", LateFeeRule{LateFeeType: LateFeeDaily, LateFeeAmount: 10, LateFeeMax: max(100)}, 1000, 9, 90},
+		{"daily at the cap", LateFeeRule{LateFeeType: LateFeeDaily, LateFeeAmount: 10, LateFeeMax: max(100)}, 1000, 10, 100},
+		{"daily past the cap", LateFeeRule{LateFeeType: LateFeeDaily, LateFeeAmount: 10, LateFeeMax: max(100)}, 1000, 45, 100},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.rule.fee(tc.rent, tc.daysLate); got != tc.want {
+				t.Errorf("fee(%d, %d) = %d, want %d", tc.rent, tc.daysLate, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestLateFeeRuleLateFrom(t *testing.T) {
+	day := func(m time.Month, d, hour int) time.Time { return time.Date(2025, m, d, hour, 0, 0, 0, time.Local) }
+	tests := []struct {
+		name  string
+		grace int
+		due   time.Time
+		want  time.Time
+	}{
+		{"no grace", 0, day(time.March, 1, 0), day(time.March, 2, 0)},
+		{"grace days", 5, day(time.March, 1, 0), day(time.March, 7, 0)},
+		{"due later in the day", 5, day(time.March, 1, 15), day(time.March, 7, 0)},
+		{"across a month end", 3, day(time.February, 28, 0), day(time.March, 4, 0)},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rule := LateFeeRule{LateFeeType: LateFeeFlat, LateFeeAmount: 50, LateFeeGraceDays: tc.grace}
+			if got := rule.lateFrom(tc.due); !got.Equal(tc.want) {
+				t.Errorf("lateFrom(%s) = %s, want %s", tc.due, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAssessLateFees(t *testing.T) {
+	s := newTestStore(t)
+	ownerID := createTestUser(t, s, "owner@example.com", "owner")
+	day := func(m time.Month, d int) time.Time { return time.Date(2025, m, d, 9, 0, 0, 0, time.Local) }
+	propertyID, err := s.Properties.Create(&Property{OwnerUserID: ownerID, PropertyName: "Maple", PropertyStreet: "1 Maple St", PropertyCity: "Morgantown"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenantID, err := s.Tenants.Create(&Tenant{OwnerUserID: ownerID, TenantFirstName: "Tomasz", TenantLastName: "Renter", TenantEmail: "tomasz@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newLease := func(unit string) int {
+		unitID, err := s.Units.Create(&PropertyUnit{PropertyID: propertyID, PropertyUnitNumber: unit, PropertyUnitRentDefault: 1000})
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaseID, err := s.Leases.Create(&Lease{TenantID: tenantID, PropertyUnitID: unitID, LeaseStartUnix: day(time.January, 1).Unix(), LeaseRentAmount: 1000, LeaseRentDueDay: 1, LeaseStatus: "active"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Ledger.PostLeaseRentCharges(leaseID, day(time.March, 1)); err != nil {
+			t.Fatal(err)
+		}
+		return leaseID
+	}
+	leaseID := newLease("1A")
+	brokenID := newLease("1B")
+	if _, err := s.Payments.Create(&Payment{LeaseID: leaseID, PaymentAmount: 1000, PaymentDateUnix: day(time.January, 1).Unix(), PaymentMethod: "check"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// $10 a day after 3 grace days, at most $100, for every lease on the property
+	max := 100
+	if err := s.Ledger.SetLateFeeRule(&LateFeeRule{PropertyID: &propertyID, LateFeeType: LateFeeDaily, LateFeeAmount: 10, LateFeeGraceDays: 3, LateFeeMax: &max}); err != nil {
+		t.Fatal(err)
+	}
+	// Any late fee posted on the second lease fails
+	if _, err := s.DB.Exec(`CREATE TRIGGER failLateFee BEFORE INSERT ON charges
+		WHEN NEW.chargeType = 'lateFee' AND NEW.leaseId = ` + strconv.Itoa(brokenID) + `
+		BEGIN SELECT RAISE(ABORT, 'late fee refused'); END`); err != nil {
+		t.Fatal(err)
+	}
+
+	// fees returns the lease's late fees by period and whether each is waived.
+	type fee struct {
+		amount int
+		waived bool
+	}
+	fees := func() map[string]fee {
+		rows, err := s.DB.Query(`SELECT chargePeriod, chargeAmount, waivedUnix IS NOT NULL FROM charges WHERE leaseId=? AND chargeType=?`, leaseID, ChargeLateFee)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		out := map[string]fee{}
+		for rows.Next() {
+			var period string
+			var f fee
+			if err := rows.Scan(&period, &f.amount, &f.waived); err != nil {
+				t.Fatal(err)
+			}
+			out[period] = f
+		}
+		return out
+	}
+	feeID := func(period string) int {
+		var id int
+		if err := s.DB.QueryRow(`SELECT chargeId FROM charges WHERE leaseId=? AND chargeType=? AND chargePeriod=?`, leaseID, ChargeLateFee, period).Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	steps := []struct {
+		name    string
+		now     time.Time
+		waive   bool // waive the fees posted so far before the run
+		changed int
+		want    map[string]fee
+	}{
+		// January is paid; February is late from February 5, March from March 5
+		{"within March's grace days", day(time.March, 4), false, 1, map[string]fee{"2025-02": {100, false}}},
+		{"first day late", day(time.March, 5), false, 1, map[string]fee{"2025-02": {100, false}, "2025-03": {10, false}}},
+		{"same day again", day(time.March, 5), false, 0, map[string]fee{"2025-02": {100, false}, "2025-03": {10, false}}},
+		{"daily fee grows", day(time.March, 8), false, 1, map[string]fee{"2025-02": {100, false}, "2025-03": {40, false}}},
+		{"waived fee is left alone", day(time.March, 12), true, 0, map[string]fee{"2025-02": {100, true}, "2025-03": {40, true}}},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			if step.waive {
+				for period := range fees() {
+					if _, err := s.Ledger.WaiveCharge(ownerID, feeID(period), "goodwill"); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			n, err := s.Ledger.AssessLateFees(step.now)
+			if err == nil {
+				t.Error("the failing lease was not reported")
+			}
+			if n != step.changed {
+				t.Errorf("changed %d fees, want %d", n, step.changed)
+			}
+			got := fees()
+			if len(got) != len(step.want) {
+				t.Errorf("fees = %+v, want %+v", got, step.want)
+			}
+			for period, want := range step.want {
+				if got[period] != want {
+					t.Errorf("%s fee = %+v, want %+v", period, got[period], want)
+				}
+			}
+		})
+	}
+
+	var broken int
+	if err := s.DB.QueryRow(`SELECT COUNT(*) FROM charges WHERE leaseId=? AND chargeType=?`, brokenID, ChargeLateFee).Scan(&broken); err != nil {
+		t.Fatal(err)
+	}
+	if broken != 0 {
+		t.Errorf("%d late fees on the failing lease, want 0", broken)
+	}
+}
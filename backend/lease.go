@@ -11,17 +11,17 @@ package main
 // Package-level summary:
 // This file implements lease CRUD HTTP handlers and database helpers for
 // creating, reading, updating, and deleting lease records. Handlers include
-// CreateLeaseHandler, GetLeaseHandler, UpdateLeaseHandler, DeleteLeaseHandler.
-// DB helpers: CreateLease, GetAllLeases, GetLeaseByID, UpdateLease, DeleteLease.
+// CreateLeaseHandler, GetLeaseHandler, UpdateLeaseHandler, PatchLeaseHandler, DeleteLeaseHandler.
+// DB helpers: CreateLease, ListLeases, GetLeaseByID, UpdateLease, DeleteLease.
 
 import (
 	"database/sql"
 	"encoding/json"
-
-	// "errors"
+	"errors"
+	"log"
 	"net/http"
 	"strconv"
-	"strings"
+	"time"
 )
 
 // LEASES
@@ -35,13 +35,16 @@ type Lease struct {
 	LeaseSecurityDeposit int    `db:"leaseSecurityDeposit" json:"leaseSecurityDeposit"`
 	LeaseDocumentLink    string `db:"leaseDocumentLink" json:"leaseDocumentLink"`
 	LeaseStatus          string `db:"leaseStatus" json:"leaseStatus"`
+	LeaseRentDueDay      int    `db:"leaseRentDueDay" json:"leaseRentDueDay"` // day of the month rent falls due; 0 on input means 1
+	Version              int    `db:"version" json:"version,omitempty"`
+	UpdatedUnix          *int64 `db:"updatedUnix" json:"updatedUnix,omitempty"`
 }
 
 // == Handlers ====================================================================
 // POST
 // CreateLeaseHandler returns an HTTP handler for creating a new lease.
 // Accepts a JSON body, validates required fields, inserts into DB, and responds with the created lease.
-func CreateLeaseHandler(db *sql.DB) http.HandlerFunc {
+func CreateLeaseHandler(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -56,47 +59,67 @@ func CreateLeaseHandler(db *sql.DB) http.HandlerFunc {
 			respondError(w, http.StatusBadRequest, "tenantId, propertyUnitId, leaseStartUnix, leaseRentAmount are required")
 			return
 		}
-		id, err := CreateLease(db, &l)
+		if l.LeaseRentDueDay == 0 {
+			l.LeaseRentDueDay = 1
+		}
+		if l.LeaseRentDueDay < 1 || l.LeaseRentDueDay > 31 {
+			respondError(w, http.StatusBadRequest, "leaseRentDueDay must be between 1 and 31")
+			return
+		}
+		if !checkLeaseReferences(w, s, currentUserID(r), &l) {
+			return
+		}
+		id, err := s.Leases.Create(&l)
 		if err != nil {
 			respondError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
 		l.LeaseID = id
+		l.Version = 1
+		postLeaseRent(s, id)
+		setETag(w, l.Version)
 		respondJSON(w, http.StatusCreated, l)
 	}
 }
 
 // GetLeaseHandler returns an HTTP handler for retrieving leases.
 // If no ID is provided, returns all leases; otherwise, returns the lease with the given ID.
-func GetLeaseHandler(db *sql.DB) http.HandlerFunc {
+func GetLeaseHandler(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		idStr := strings.TrimPrefix(r.URL.Path, "/leases/")
-		if idStr == "" || idStr == "/" {
-			list, err := GetAllLeases(db)
+		idStr := r.PathValue("id")
+		if idStr == "" {
+			p, err := ParseListParams(r, leaseListSpec)
+			if err != nil {
+				respondError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			page, err := s.Leases.List(currentUserID(r), p)
 			if err != nil {
 				respondError(w, http.StatusInternalServerError, err.Error())
 				return
 			}
-			respondJSON(w, http.StatusOK, list)
+			respondList(w, r, page)
 			return
 		}
-		id, err := strconv.Atoi(strings.Trim(idStr, "/"))
+		id, err := strconv.Atoi(idStr)
 		if err != nil {
 			respondError(w, http.StatusBadRequest, "invalid id")
 			return
 		}
-		l, err := GetLeaseByID(db, id)
+		l, err := s.Leases.GetByID(currentUserID(r), id)
 		if err != nil {
 			respondError(w, http.StatusNotFound, "not found")
 			return
 		}
+		setETag(w, l.Version)
 		respondJSON(w, http.StatusOK, l)
 	}
 }
 
 // UpdateLeaseHandler returns an HTTP handler for updating a lease.
-// Accepts a JSON body, validates leaseId, updates the DB, and responds with status.
-func UpdateLeaseHandler(db *sql.DB) http.HandlerFunc {
+// Accepts a JSON body, validates leaseId and the version being replaced (see
+// concurrency.go), updates the DB, and responds with status and the new version.
+func UpdateLeaseHandler(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPut {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -107,96 +130,186 @@ func UpdateLeaseHandler(db *sql.DB) http.HandlerFunc {
 			respondError(w, http.StatusBadRequest, "invalid json")
 			return
 		}
+		if !bindPathID(w, r, &l.LeaseID, "leaseId") {
+			return
+		}
 		if l.LeaseID == 0 {
 			respondError(w, http.StatusBadRequest, "leaseId required")
 			return
 		}
-		if err := UpdateLease(db, &l); err != nil {
-			respondError(w, http.StatusInternalServerError, err.Error())
+		if l.TenantID == 0 || l.PropertyUnitID == 0 || l.LeaseStartUnix == 0 || l.LeaseRentAmount == 0 {
+			respondError(w, http.StatusBadRequest, "tenantId, propertyUnitId, leaseStartUnix, leaseRentAmount are required")
+			return
+		}
+		if l.LeaseRentDueDay == 0 {
+			l.LeaseRentDueDay = 1
+		}
+		if l.LeaseRentDueDay < 1 || l.LeaseRentDueDay > 31 {
+			respondError(w, http.StatusBadRequest, "leaseRentDueDay must be between 1 and 31")
+			return
+		}
+		version, ifMatch, ok := requireVersion(w, r, l.Version)
+		if !ok {
+			return
+		}
+		l.Version = version
+		if !checkLeaseReferences(w, s, currentUserID(r), &l) {
+			return
+		}
+		if err := s.Leases.Update(currentUserID(r), &l); err != nil {
+			if errors.Is(err, errStaleVersion) {
+				if current, err := s.Leases.GetByID(currentUserID(r), l.LeaseID); err == nil {
+					respondStale(w, ifMatch, current, current.Version)
+					return
+				}
+				err = sql.ErrNoRows
+			}
+			if errors.Is(err, sql.ErrNoRows) {
+				respondError(w, http.StatusNotFound, "not found")
+			} else {
+				respondError(w, http.StatusInternalServerError, err.Error())
+			}
 			return
 		}
-		respondJSON(w, http.StatusOK, map[string]string{"status": "updated"})
+		postLeaseRent(s, l.LeaseID)
+		setETag(w, l.Version)
+		respondJSON(w, http.StatusOK, map[string]interface{}{"status": "updated", "version": l.Version})
 	}
 }
 
+// PatchLeaseHandler returns an HTTP handler for partially updating a lease.
+// Accepts a JSON merge patch, applies it to the current lease, and passes the
+// result to UpdateLeaseHandler (see patch.go).
+func PatchLeaseHandler(s *Store) http.HandlerFunc {
+	return PatchHandler(func(r *http.Request, id int) (*Lease, error) {
+		return s.Leases.GetByID(currentUserID(r), id)
+	}, UpdateLeaseHandler(s))
+}
+
 // DeleteLeaseHandler returns an HTTP handler for deleting a lease by ID.
 // Accepts a DELETE request, removes the lease from DB, and responds with status.
-func DeleteLeaseHandler(db *sql.DB) http.HandlerFunc {
+func DeleteLeaseHandler(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodDelete {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
-		idStr := strings.TrimPrefix(r.URL.Path, "/leases/")
-		id, err := strconv.Atoi(strings.Trim(idStr, "/"))
+		idStr := r.PathValue("id")
+		id, err := strconv.Atoi(idStr)
 		if err != nil {
 			respondError(w, http.StatusBadRequest, "invalid id")
 			return
 		}
-		if err := DeleteLease(db, id); err != nil {
-			respondError(w, http.StatusInternalServerError, err.Error())
+		if err := s.Leases.Delete(currentUserID(r), id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				respondError(w, http.StatusNotFound, "not found")
+			} else if !respondDeleteBlocked(w, err) {
+				respondError(w, http.StatusInternalServerError, err.Error())
+			}
 			return
 		}
 		respondJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
 	}
 }
 
+// checkLeaseReferences verifies that the tenant and unit a lease points at are
+// visible to the user, writing a 404 and returning false when they are not.
+func checkLeaseReferences(w http.ResponseWriter, s *Store, userID int, l *Lease) bool {
+	if ok, err := s.Access.CanAccessUnit(userID, l.PropertyUnitID); err != nil || !ok {
+		respondError(w, http.StatusNotFound, "propertyUnit not found")
+		return false
+	}
+	if ok, err := s.Access.CanAccessTenant(userID, l.TenantID); err != nil || !ok {
+		respondError(w, http.StatusNotFound, "tenant not found")
+		return false
+	}
+	return true
+}
+
+// postLeaseRent posts the rent charges a new or changed lease already owes,
+// rather than leaving them to the next run of the rent job (see ledger.go).
+// A failure is only logged: the job will post them.
+func postLeaseRent(s *Store, leaseID int) {
+	if _, err := s.Ledger.PostLeaseRentCharges(leaseID, time.Now()); err != nil {
+		log.Printf("rent charges for lease %d: %v", leaseID, err)
+	}
+}
+
 // == SQL Queries =================================================================
 // CreateLease inserts a new lease into the database.
 // Returns the new lease ID and error if insertion fails.
 func CreateLease(db *sql.DB, l *Lease) (int, error) {
-	res, err := db.Exec(`INSERT INTO leases (tenantId, propertyUnitId, leaseStartUnix, leaseEndUnix, leaseRentAmount, leaseSecurityDeposit, leaseDocumentLink, leaseStatus)
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, l.TenantID, l.PropertyUnitID, l.LeaseStartUnix, l.LeaseEndUnix, l.LeaseRentAmount, l.LeaseSecurityDeposit, l.LeaseDocumentLink, l.LeaseStatus)
-	if err != nil {
-		return 0, err
-	}
-	id, _ := res.LastInsertId()
-	return int(id), nil
+	var id int
+	err := db.QueryRow(`INSERT INTO leases (tenantId, propertyUnitId, leaseStartUnix, leaseEndUnix, leaseRentAmount, leaseSecurityDeposit, leaseDocumentLink, leaseStatus, leaseRentDueDay)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING leaseId`, l.TenantID, l.PropertyUnitID, l.LeaseStartUnix, l.LeaseEndUnix, l.LeaseRentAmount, l.LeaseSecurityDeposit, l.LeaseDocumentLink, l.LeaseStatus, l.LeaseRentDueDay).Scan(&id)
+	return id, err
 }
 
-// GetAllLeases retrieves all leases from the database.
-// Returns a slice of Lease and error if query fails.
-func GetAllLeases(db *sql.DB) ([]Lease, error) {
-	rows, err := db.Query(`SELECT leaseId, tenantId, propertyUnitId, leaseStartUnix, leaseEndUnix, leaseRentAmount, leaseSecurityDeposit, leaseDocumentLink, leaseStatus FROM leases`)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
+// leaseListSpec describes how leases are listed, sorted and filtered (see list.go).
+var leaseListSpec = listSpec{
+	columns:     `leaseId, tenantId, propertyUnitId, leaseStartUnix, leaseEndUnix, leaseRentAmount, leaseSecurityDeposit, leaseDocumentLink, leaseStatus, leaseRentDueDay, version, updatedUnix`,
+	table:       `leases`,
+	idColumn:    `leaseId`,
+	defaultSort: "leaseId",
+	sorts: map[string]string{
+		"leaseId":         "leaseId",
+		"leaseStartUnix":  "leaseStartUnix",
+		"leaseEndUnix":    "COALESCE(leaseEndUnix, 0)",
+		"leaseRentAmount": "leaseRentAmount",
+	},
+	filters: map[string]listFilter{
+		"status":         {expr: "leaseStatus", op: "="},
+		"tenantId":       {expr: "tenantId", op: "=", isInt: true},
+		"propertyUnitId": {expr: "propertyUnitId", op: "=", isInt: true},
+		"from":           {expr: "leaseStartUnix", op: ">=", isInt: true},
+		"to":             {expr: "leaseStartUnix", op: "<=", isInt: true},
+	},
+}
 
-	var out []Lease
-	for rows.Next() {
-		var l Lease
-		if err := rows.Scan(&l.LeaseID, &l.TenantID, &l.PropertyUnitID, &l.LeaseStartUnix, &l.LeaseEndUnix, &l.LeaseRentAmount, &l.LeaseSecurityDeposit, &l.LeaseDocumentLink, &l.LeaseStatus); err != nil {
-			return nil, err
-		}
-		out = append(out, l)
-	}
-	return out, nil
+// ListLeases returns one page of the leases visible to userID,
+// filtered and sorted as requested in p.
+func ListLeases(db *sql.DB, userID int, p ListParams) (*Page[Lease], error) {
+	return queryPage(db, leaseListSpec, p, `deletedUnix IS NULL AND propertyUnitId IN (`+accessibleUnitsSQL+`)`, scopeArgs(userID, 2), func(l *Lease) []interface{} {
+		return []interface{}{&l.LeaseID, &l.TenantID, &l.PropertyUnitID, &l.LeaseStartUnix, &l.LeaseEndUnix, &l.LeaseRentAmount, &l.LeaseSecurityDeposit, &l.LeaseDocumentLink, &l.LeaseStatus, &l.LeaseRentDueDay, &l.Version, &l.UpdatedUnix}
+	})
 }
 
-// GetLeaseByID retrieves a lease by leaseId from the database.
+// GetLeaseByID retrieves a lease visible to userID by leaseId from the database.
 // Returns pointer to Lease and error if not found or query fails.
-func GetLeaseByID(db *sql.DB, id int) (*Lease, error) {
+func GetLeaseByID(db *sql.DB, userID, id int) (*Lease, error) {
 	var l Lease
-	err := db.QueryRow(`SELECT leaseId, tenantId, propertyUnitId, leaseStartUnix, leaseEndUnix, leaseRentAmount, leaseSecurityDeposit, leaseDocumentLink, leaseStatus FROM leases WHERE leaseId=?`, id).
-		Scan(&l.LeaseID, &l.TenantID, &l.PropertyUnitID, &l.LeaseStartUnix, &l.LeaseEndUnix, &l.LeaseRentAmount, &l.LeaseSecurityDeposit, &l.LeaseDocumentLink, &l.LeaseStatus)
+	args := append([]interface{}{id}, scopeArgs(userID, 2)...)
+	err := db.QueryRow(`SELECT leaseId, tenantId, propertyUnitId, leaseStartUnix, leaseEndUnix, leaseRentAmount, leaseSecurityDeposit, leaseDocumentLink, leaseStatus, leaseRentDueDay, version, updatedUnix FROM leases
+		WHERE leaseId=? AND deletedUnix IS NULL AND propertyUnitId IN (`+accessibleUnitsSQL+`)`, args...).
+		Scan(&l.LeaseID, &l.TenantID, &l.PropertyUnitID, &l.LeaseStartUnix, &l.LeaseEndUnix, &l.LeaseRentAmount, &l.LeaseSecurityDeposit, &l.LeaseDocumentLink, &l.LeaseStatus, &l.LeaseRentDueDay, &l.Version, &l.UpdatedUnix)
 	if err != nil {
 		return nil, err
 	}
 	return &l, nil
 }
 
-// UpdateLease updates an existing lease in the database.
-// Returns error if update fails.
-func UpdateLease(db *sql.DB, l *Lease) error {
-	_, err := db.Exec(`UPDATE leases SET tenantId=?, propertyUnitId=?, leaseStartUnix=?, leaseEndUnix=?, leaseRentAmount=?, leaseSecurityDeposit=?, leaseDocumentLink=?, leaseStatus=? WHERE leaseId=?`,
-		l.TenantID, l.PropertyUnitID, l.LeaseStartUnix, l.LeaseEndUnix, l.LeaseRentAmount, l.LeaseSecurityDeposit, l.LeaseDocumentLink, l.LeaseStatus, l.LeaseID)
+// UpdateLease updates an existing lease visible to userID in the database if
+// it is still at l.Version (0 skips the check), then advances l.Version.
+// Returns sql.ErrNoRows if the lease is not visible to the user, or
+// errStaleVersion if it was changed in the meantime.
+func UpdateLease(db *sql.DB, userID int, l *Lease) error {
+	now := time.Now().Unix()
+	args := append([]interface{}{l.TenantID, l.PropertyUnitID, l.LeaseStartUnix, l.LeaseEndUnix, l.LeaseRentAmount, l.LeaseSecurityDeposit, l.LeaseDocumentLink, l.LeaseStatus, l.LeaseRentDueDay, now, l.LeaseID, l.Version}, scopeArgs(userID, 2)...)
+	err := db.QueryRow(`UPDATE leases SET tenantId=?, propertyUnitId=?, leaseStartUnix=?, leaseEndUnix=?, leaseRentAmount=?, leaseSecurityDeposit=?, leaseDocumentLink=?, leaseStatus=?, leaseRentDueDay=?, version=version+1, updatedUnix=?
+		WHERE leaseId=? AND deletedUnix IS NULL AND ? IN (0, version) AND leaseId IN (`+accessibleLeasesSQL+`) RETURNING version`, args...).Scan(&l.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return staleOrMissing(GetLeaseByID(db, userID, l.LeaseID))
+	}
+	if err == nil {
+		l.UpdatedUnix = &now
+	}
 	return err
 }
 
-// DeleteLease removes a lease from the database by leaseId.
-// Returns error if deletion fails.
-func DeleteLease(db *sql.DB, id int) error {
-	_, err := db.Exec(`DELETE FROM leases WHERE leaseId=?`, id)
-	return err
+// DeleteLease moves a lease visible to userID to the trash (see trash.go),
+// applying the delete policies for what depends on it (see integrity.go).
+// Returns sql.ErrNoRows if the lease is not visible to the user or is already in
+// the trash, or a *DeleteBlockedError if dependent records prevent the delete.
+func DeleteLease(db *sql.DB, userID, id int) error {
+	return trashWithPolicies(db, userID, "leases", id)
 }
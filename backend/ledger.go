@@ -0,0 +1,767 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Package-level summary:
+// This file implements the rent ledger. A lease owes the charges posted
+// against it (migration 0005): one rent charge per billing month, posted from
+// leases.leaseRentAmount by PostRentCharges, plus fees and adjustments added
+// by staff. Its payments are the credits. A lease's ledger lists both in date
+// order with a running balance, and shows what each charge still owes after
+// the payments allocated to it (see allocation.go).
+// Rent for a month falls due on the lease's leaseRentDueDay and is posted by a
+// background job once that day arrives, catching up on any months it missed;
+// staff can also post a given month by hand.
+// Handlers: CreateChargeHandler, PostRentChargesHandler, GetChargeHandler,
+// GetLeaseLedgerHandler, GetTenantBalanceHandler. DB helpers: CreateCharge,
+// ListCharges, GetChargeByID, GetLeaseLedger, GetTenantBalance,
+// PostRentCharges, PostLeaseRentCharges, PostRentChargesForPeriod, and
+// StartRentChargePoster for the background job.
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Charge types
+const (
+	ChargeRent       = "rent"       // posted monthly from the lease
+	ChargeFee        = "fee"        // one-off charge added by staff
+	ChargeAdjustment = "adjustment" // correction; negative amounts are credits
+	ChargeLateFee    = "lateFee"    // posted for late rent (see latefee.go)
+)
+
+// CHARGES
+type Charge struct {
+	ChargeID          int     `db:"chargeId" json:"chargeId"`
+	LeaseID           int     `db:"leaseId" json:"leaseId"`
+	ChargeType        string  `db:"chargeType" json:"chargeType"`
+	ChargePeriod      *string `db:"chargePeriod" json:"chargePeriod,omitempty"` // YYYY-MM, rent only
+	ChargeAmount      int     `db:"chargeAmount" json:"chargeAmount"`
+	ChargeDueUnix     int64   `db:"chargeDueUnix" json:"chargeDueUnix"`
+	ChargeDescription string  `db:"chargeDescription" json:"chargeDescription"`
+	CreatedUnix       int64   `db:"createdUnix" json:"createdUnix"`
+	CreatedByUserID   *int    `db:"createdByUserId" json:"createdByUserId,omitempty"`
+	WaivedUnix        *int64  `db:"waivedUnix" json:"waivedUnix,omitempty"`
+	WaivedByUserID    *int    `db:"waivedByUserId" json:"waivedByUserId,omitempty"`
+	WaiveReason       *string `db:"waiveReason" json:"waiveReason,omitempty"`
+}
+
+// LeaseBalance summarizes what is owed on one lease. Balance counts every
+// charge posted so far; PastDue only the part of the charges already due that
+// payments have not covered.
+type LeaseBalance struct {
+	LeaseID      int `json:"leaseId"`
+	MonthsDue    int `json:"monthsDue"`    // rent charges posted
+	RentCharged  int `json:"rentCharged"`  // total of the rent charges
+	TotalCharged int `json:"totalCharged"` // rent, fees and adjustments, less waived charges
+	LateFees     int `json:"lateFees"`     // late fees charged and not waived
+	TotalPaid    int `json:"totalPaid"`
+	Balance      int `json:"balance"` // positive when the tenant owes money, negative for a credit
+	PastDue      int `json:"pastDue"`
+	// UnappliedCredit is money paid or credited that no charge has taken yet;
+	// it goes to the next charges posted
+	UnappliedCredit int `json:"unappliedCredit"`
+}
+
+// LedgerEntry is one line of a lease ledger: a charge (debit) or a payment
+// (credit), with the balance after it.
+type LedgerEntry struct {
+	EntryType   string `json:"entryType"` // "charge" or "payment"
+	ID          int    `json:"id"`        // chargeId or paymentId
+	DateUnix    int64  `json:"dateUnix"`  // due date of a charge, date of a payment
+	ChargeType  string `json:"chargeType,omitempty"`
+	Description string `json:"description"`
+	Debit       int    `json:"debit"`
+	Credit      int    `json:"credit"`
+	Balance     int    `json:"balance"`
+	Unpaid      *int   `json:"unpaid,omitempty"` // charges only: the part payments have not covered
+	Waived      bool   `json:"waived,omitempty"` // a waived charge has no debit
+}
+
+// Ledger is a lease's charges and payments in date order, with its totals.
+type Ledger struct {
+	LeaseBalance
+	Entries []LedgerEntry `json:"entries"`
+}
+
+// TenantBalance totals the balances of a tenant's leases.
+type TenantBalance struct {
+	TenantID int            `json:"tenantId"`
+	Balance  int            `json:"balance"`
+	PastDue  int            `json:"pastDue"`
+	Leases   []LeaseBalance `json:"leases"`
+}
+
+// == Handlers =====================================================================
+// POST
+// CreateChargeHandler returns an HTTP handler for adding a fee or adjustment
+// to a lease. Rent charges are posted by the ledger and cannot be added here.
+// chargeDueUnix defaults to now.
+func CreateChargeHandler(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var c Charge
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		if c.ChargeType == "" {
+			c.ChargeType = ChargeFee
+		}
+		if c.LeaseID == 0 || c.ChargeAmount == 0 {
+			respondError(w, http.StatusBadRequest, "leaseId, chargeAmount required")
+			return
+		}
+		if c.ChargeType != ChargeFee && c.ChargeType != ChargeAdjustment {
+			respondError(w, http.StatusBadRequest, "chargeType must be fee or adjustment")
+			return
+		}
+		if c.ChargeAmount < 0 && c.ChargeType != ChargeAdjustment {
+			respondError(w, http.StatusBadRequest, "only an adjustment can have a negative chargeAmount")
+			return
+		}
+		userID := currentUserID(r)
+		if ok, err := s.Access.CanAccessLease(userID, c.LeaseID); err != nil || !ok {
+			respondError(w, http.StatusNotFound, "lease not found")
+			return
+		}
+		if c.ChargeDueUnix == 0 {
+			c.ChargeDueUnix = time.Now().Unix()
+		}
+		c.ChargePeriod = nil
+		c.CreatedByUserID = &userID
+		id, err := s.Ledger.CreateCharge(&c)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		c.ChargeID = id
+		respondJSON(w, http.StatusCreated, c)
+	}
+}
+
+// PostRentChargesHandler returns an HTTP handler for POST /v1/charges/rent,
+// which posts the rent charges for one month without waiting for their due
+// dates. Accepts {period: "YYYY-MM", leaseId}; period defaults to the current
+// month, and without leaseId every active lease visible to the caller is
+// charged. Months already charged are left alone, so it is safe to repeat.
+func PostRentChargesHandler(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var body struct {
+			Period  string `json:"period"`
+			LeaseID int    `json:"leaseId"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+			respondError(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		now := time.Now()
+		month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local)
+		if body.Period != "" {
+			var err error
+			if month, err = time.ParseInLocation("2006-01", body.Period, time.Local); err != nil {
+				respondError(w, http.StatusBadRequest, "period must be YYYY-MM")
+				return
+			}
+		}
+		userID := currentUserID(r)
+		if body.LeaseID > 0 {
+			if ok, err := s.Access.CanAccessLease(userID, body.LeaseID); err != nil || !ok {
+				respondError(w, http.StatusNotFound, "lease not found")
+				return
+			}
+		}
+		n, err := s.Ledger.PostRentChargesForPeriod(userID, month, body.LeaseID)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]interface{}{"period": month.Format("2006-01"), "posted": n})
+	}
+}
+
+// GET
+// GetChargeHandler returns an HTTP handler for retrieving charges.
+// If no ID is provided, returns a page of charges; otherwise, returns the charge with the given ID.
+func GetChargeHandler(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idStr := r.PathValue("id")
+		if idStr == "" {
+			p, err := ParseListParams(r, chargeListSpec)
+			if err != nil {
+				respondError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			page, err := s.Ledger.ListCharges(currentUserID(r), p)
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			respondList(w, r, page)
+			return
+		}
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "invalid id")
+			return
+		}
+		c, err := s.Ledger.GetCharge(currentUserID(r), id)
+		if err != nil {
+			respondError(w, http.StatusNotFound, "not found")
+			return
+		}
+		respondJSON(w, http.StatusOK, c)
+	}
+}
+
+// GetLeaseLedgerHandler returns an HTTP handler for GET /v1/leases/{id}/ledger,
+// the lease's charges and payments with a running balance.
+func GetLeaseLedgerHandler(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "invalid id")
+			return
+		}
+		ledger, err := s.Ledger.LeaseLedger(currentUserID(r), id, time.Now())
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				respondError(w, http.StatusNotFound, "not found")
+			} else {
+				respondError(w, http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+		respondJSON(w, http.StatusOK, ledger)
+	}
+}
+
+// GetTenantBalanceHandler returns an HTTP handler for
+// GET /v1/tenants/{id}/balance, the balance of each of the tenant's leases
+// visible to the caller and their total.
+func GetTenantBalanceHandler(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "invalid id")
+			return
+		}
+		balance, err := s.Ledger.TenantBalance(currentUserID(r), id, time.Now())
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				respondError(w, http.StatusNotFound, "not found")
+			} else {
+				respondError(w, http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+		respondJSON(w, http.StatusOK, balance)
+	}
+}
+
+// StartRentChargePoster posts the rent charges that have come due, and the
+// late fees owed on them (see latefee.go), once at startup and then every
+// interval. An interval of 0 turns the job off.
+func StartRentChargePoster(s *Store, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		for {
+			n, err := s.Ledger.PostRentCharges(time.Now())
+			if err != nil {
+				log.Print("rent charges: ", err)
+			} else if n > 0 {
+				logInfo("rent charges: posted %d", n)
+			}
+			n, err = s.Ledger.AssessLateFees(time.Now())
+			if err != nil {
+				log.Print("late fees: ", err)
+			} else if n > 0 {
+				logInfo("late fees: posted or increased %d", n)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// == SQL Queries ==================================================================
+
+// CreateCharge inserts a charge into the database and reallocates the
+// lease's payments, so any credit pays it. Returns the new charge ID and error
+// if insertion fails.
+func CreateCharge(db *sql.DB, c *Charge) (int, error) {
+	c.CreatedUnix = time.Now().Unix()
+	var id int
+	err := db.QueryRow(`INSERT INTO charges (leaseId, chargeType, chargePeriod, chargeAmount, chargeDueUnix, chargeDescription, createdUnix, createdByUserId)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?) RETURNING chargeId`,
+		c.LeaseID, c.ChargeType, c.ChargePeriod, c.ChargeAmount, c.ChargeDueUnix, c.ChargeDescription, c.CreatedUnix, c.CreatedByUserID).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, reallocate(db, c.LeaseID)
+}
+
+// chargeColumns are the charge columns in Charge field order.
+const chargeColumns = `chargeId, leaseId, chargeType, chargePeriod, chargeAmount, chargeDueUnix, COALESCE(chargeDescription, ''), createdUnix, createdByUserId, waivedUnix, waivedByUserId, waiveReason`
+
+// chargeScopeSQL limits charges to the live leases visible to the caller.
+// Bind the user ID twice.
+const chargeScopeSQL = `leaseId IN (` + accessibleLeasesSQL + `)`
+
+func chargeFields(c *Charge) []interface{} {
+	return []interface{}{&c.ChargeID, &c.LeaseID, &c.ChargeType, &c.ChargePeriod, &c.ChargeAmount, &c.ChargeDueUnix, &c.ChargeDescription, &c.CreatedUnix, &c.CreatedByUserID, &c.WaivedUnix, &c.WaivedByUserID, &c.WaiveReason}
+}
+
+// chargeListSpec describes how charges are listed, sorted and filtered (see list.go).
+var chargeListSpec = listSpec{
+	columns:     chargeColumns,
+	table:       `charges`,
+	idColumn:    `chargeId`,
+	defaultSort: "-chargeDueUnix",
+	sorts: map[string]string{
+		"chargeId":      "chargeId",
+		"chargeDueUnix": "chargeDueUnix",
+		"chargeAmount":  "chargeAmount",
+	},
+	filters: map[string]listFilter{
+		"leaseId": {expr: "leaseId", op: "=", isInt: true},
+		"type":    {expr: "chargeType", op: "="},
+		"period":  {expr: "chargePeriod", op: "="},
+		"from":    {expr: "chargeDueUnix", op: ">=", isInt: true},
+		"to":      {expr: "chargeDueUnix", op: "<=", isInt: true},
+	},
+}
+
+// ListCharges returns one page of the charges visible to userID,
+// filtered and sorted as requested in p.
+func ListCharges(db *sql.DB, userID int, p ListParams) (*Page[Charge], error) {
+	return queryPage(db, chargeListSpec, p, chargeScopeSQL, scopeArgs(userID, 2), chargeFields)
+}
+
+// GetChargeByID retrieves a charge visible to userID by chargeId.
+func GetChargeByID(db *sql.DB, userID, id int) (*Charge, error) {
+	var c Charge
+	args := append([]interface{}{id}, scopeArgs(userID, 2)...)
+	err := db.QueryRow(`SELECT `+chargeColumns+` FROM charges WHERE chargeId=? AND `+chargeScopeSQL, args...).Scan(chargeFields(&c)...)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// GetLeaseLedger builds the ledger of a live lease visible to userID as of
+// now. Returns sql.ErrNoRows if the lease is not visible.
+func GetLeaseLedger(db *sql.DB, userID, leaseID int, now time.Time) (*Ledger, error) {
+	ok, err := canAccessLease(db, userID, leaseID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return loadLedger(db, leaseID, now)
+}
+
+// GetTenantBalance totals the balances of the live leases of a tenant visible
+// to userID. Returns sql.ErrNoRows if the tenant is not visible.
+func GetTenantBalance(db *sql.DB, userID, tenantID int, now time.Time) (*TenantBalance, error) {
+	ok, err := canAccessTenant(db, userID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	args := append([]interface{}{tenantID}, scopeArgs(userID, 2)...)
+	leaseIDs, err := queryIDs(db, `SELECT leaseId FROM leases
+		WHERE tenantId=? AND deletedUnix IS NULL AND leaseId IN (`+accessibleLeasesSQL+`) ORDER BY leaseId`, args...)
+	if err != nil {
+		return nil, err
+	}
+	return sumLeaseBalances(db, tenantID, leaseIDs, now)
+}
+
+// sumLeaseBalances builds the TenantBalance of tenantID from the ledgers of leaseIDs.
+func sumLeaseBalances(db *sql.DB, tenantID int, leaseIDs []int, now time.Time) (*TenantBalance, error) {
+	out := &TenantBalance{TenantID: tenantID, Leases: []LeaseBalance{}}
+	for _, id := range leaseIDs {
+		ledger, err := loadLedger(db, id, now)
+		if err != nil {
+			return nil, err
+		}
+		out.Leases = append(out.Leases, ledger.LeaseBalance)
+		out.Balance += ledger.Balance
+		out.PastDue += ledger.PastDue
+	}
+	return out, nil
+}
+
+// loadLedger builds the ledger of a lease from its charges and live payments.
+// What each charge still owes comes from the payment allocations, and
+// PastDue is what remains unpaid on the charges due by now.
+func loadLedger(db *sql.DB, leaseID int, now time.Time) (*Ledger, error) {
+	ledger := &Ledger{LeaseBalance: LeaseBalance{LeaseID: leaseID}, Entries: []LedgerEntry{}}
+
+	rows, err := db.Query(`SELECT chargeId, chargeType, chargeAmount, chargeDueUnix, COALESCE(chargeDescription, ''), waivedUnix IS NOT NULL, COALESCE(waiveReason, '') FROM charges
+		WHERE leaseId=? ORDER BY chargeDueUnix, chargeId`, leaseID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		e := LedgerEntry{EntryType: "charge"}
+		var amount int
+		var reason string
+		if err := rows.Scan(&e.ID, &e.ChargeType, &amount, &e.DateUnix, &e.Description, &e.Waived, &reason); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if e.Waived {
+			e.Description += " (waived: " + reason + ")"
+			ledger.Entries = append(ledger.Entries, e)
+			continue
+		}
+		if e.ChargeType == ChargeLateFee {
+			ledger.LateFees += amount
+		}
+		if amount < 0 {
+			e.Credit = -amount
+		} else {
+			e.Debit = amount
+		}
+		ledger.TotalCharged += amount
+		if e.ChargeType == ChargeRent {
+			ledger.MonthsDue++
+			ledger.RentCharged += amount
+		}
+		ledger.Entries = append(ledger.Entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(`SELECT paymentId, paymentAmount, paymentDateUnix, COALESCE(paymentMethod, '') FROM payments
+		WHERE leaseId=? AND deletedUnix IS NULL ORDER BY paymentDateUnix, paymentId`, leaseID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		e := LedgerEntry{EntryType: "payment", Description: "Payment"}
+		var method string
+		if err := rows.Scan(&e.ID, &e.Credit, &e.DateUnix, &method); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if method != "" {
+			e.Description += " (" + method + ")"
+		}
+		ledger.TotalPaid += e.Credit
+		ledger.Entries = append(ledger.Entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Charges sort before payments on the same date, so a payment made on
+	// the due date shows the charge it settles above it
+	sort.SliceStable(ledger.Entries, func(i, j int) bool {
+		a, b := ledger.Entries[i], ledger.Entries[j]
+		if a.DateUnix != b.DateUnix {
+			return a.DateUnix < b.DateUnix
+		}
+		return a.EntryType == "charge" && b.EntryType == "payment"
+	})
+
+	// What each charge has been paid, from the allocations (see allocation.go)
+	allocated := map[int]int{}
+	totalAllocated := 0
+	rows, err = db.Query(`SELECT a.chargeId, SUM(a.allocatedAmount) FROM paymentAllocations a
+		JOIN charges c ON c.chargeId = a.chargeId
+		WHERE c.leaseId=? AND `+liveAllocationSQL+` GROUP BY a.chargeId`, leaseID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id, amount int
+		if err := rows.Scan(&id, &amount); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		allocated[id] = amount
+		totalAllocated += amount
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	credits := 0
+	balance := 0
+	for i := range ledger.Entries {
+		e := &ledger.Entries[i]
+		balance += e.Debit - e.Credit
+		e.Balance = balance
+		if e.EntryType != "charge" {
+			continue
+		}
+		if e.Credit > 0 {
+			credits += e.Credit
+		}
+		if e.Debit == 0 {
+			continue
+		}
+		unpaid := max(e.Debit-allocated[e.ID], 0)
+		e.Unpaid = &unpaid
+		if e.DateUnix <= now.Unix() {
+			ledger.PastDue += unpaid
+		}
+	}
+	ledger.UnappliedCredit = max(ledger.TotalPaid+credits-totalAllocated, 0)
+	ledger.Balance = ledger.TotalCharged - ledger.TotalPaid
+	return ledger, nil
+}
+
+// rentLease is what the rent job reads from a lease.
+type rentLease struct {
+	id        int
+	startUnix int64
+	endUnix   *int64
+	rent      int
+	dueDay    int
+}
+
+// dueDate returns when the rent for month (the first of a billing month)
+// falls due: the lease's due day, or the last day of a shorter month, but not
+// before the lease starts or after it ends. ok is false when the lease does
+// not cover the month.
+func (l rentLease) dueDate(month time.Time) (due time.Time, ok bool) {
+	start := time.Unix(l.startUnix, 0).In(time.Local)
+	if month.Before(time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, time.Local)) {
+		return time.Time{}, false
+	}
+	var end time.Time
+	if l.endUnix != nil {
+		end = time.Unix(*l.endUnix, 0).In(time.Local)
+		if month.After(end) {
+			return time.Time{}, false
+		}
+	}
+	lastDay := month.AddDate(0, 1, -1).Day()
+	due = time.Date(month.Year(), month.Month(), min(l.dueDay, lastDay), 0, 0, 0, 0, time.Local)
+	if day := startOfDay(start); due.Before(day) {
+		due = day
+	}
+	if l.endUnix != nil {
+		if day := startOfDay(end); due.After(day) {
+			due = day
+		}
+	}
+	return due, true
+}
+
+// PostRentCharges posts the rent charges of every active lease that have
+// fallen due by through, including any missed earlier months. It is safe to
+// run again, or after being interrupted: a lease has at most one rent charge
+// per month. A lease that fails is logged and skipped so the others are still
+// billed. Returns the number of charges posted.
+func PostRentCharges(db *sql.DB, through time.Time) (int, error) {
+	leases, err := loadRentLeases(db, `leaseStatus = 'active'`)
+	if err != nil {
+		return 0, err
+	}
+	total, failed := 0, 0
+	for _, l := range leases {
+		n, err := postDueRent(db, l, through)
+		total += n
+		if err != nil {
+			log.Printf("rent charges: lease %d: %v", l.id, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return total, fmt.Errorf("%d of %d leases failed", failed, len(leases))
+	}
+	return total, nil
+}
+
+// PostLeaseRentCharges is PostRentCharges for one lease, which must be live
+// and active; otherwise nothing is posted.
+func PostLeaseRentCharges(db *sql.DB, leaseID int, through time.Time) (int, error) {
+	leases, err := loadRentLeases(db, `leaseStatus = 'active' AND leaseId=?`, leaseID)
+	if err != nil || len(leases) == 0 {
+		return 0, err
+	}
+	return postDueRent(db, leases[0], through)
+}
+
+// PostRentChargesForPeriod posts the rent charge for month, the first of a
+// billing month, whether or not it has fallen due yet. It covers one lease
+// visible to userID when leaseID is set, whatever its status, and otherwise
+// every active lease visible to userID. Leases that do not cover the month are
+// skipped. Returns the number of charges posted.
+func PostRentChargesForPeriod(db *sql.DB, userID int, month time.Time, leaseID int) (int, error) {
+	where := `leaseId IN (` + accessibleLeasesSQL + `)`
+	args := scopeArgs(userID, 2)
+	if leaseID > 0 {
+		where += ` AND leaseId=?`
+		args = append(args, leaseID)
+	} else {
+		where += ` AND leaseStatus = 'active'`
+	}
+	leases, err := loadRentLeases(db, where, args...)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, l := range leases {
+		due, ok := l.dueDate(month)
+		if !ok {
+			continue
+		}
+		added, err := postRentCharge(db, l, month, due)
+		if err != nil {
+			return n, err
+		}
+		if added {
+			n++
+			if err := reallocate(db, l.id); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// loadRentLeases reads the live leases with a rent that match where.
+func loadRentLeases(db *sql.DB, where string, args ...interface{}) ([]rentLease, error) {
+	rows, err := db.Query(`SELECT leaseId, leaseStartUnix, leaseEndUnix, leaseRentAmount, leaseRentDueDay FROM leases
+		WHERE deletedUnix IS NULL AND leaseRentAmount > 0 AND `+where+` ORDER BY leaseId`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []rentLease
+	for rows.Next() {
+		var l rentLease
+		if err := rows.Scan(&l.id, &l.startUnix, &l.endUnix, &l.rent, &l.dueDay); err != nil {
+			return nil, err
+		}
+		out = append(out, l)
+	}
+	return out, rows.Err()
+}
+
+// postDueRent posts the missing rent charges of l from its start month
+// through the last month whose due date is not after through, then
+// reallocates the lease's payments so any credit pays them.
+func postDueRent(db *sql.DB, l rentLease, through time.Time) (int, error) {
+	posted := map[string]bool{}
+	rows, err := db.Query(`SELECT chargePeriod FROM charges WHERE leaseId=? AND chargeType=?`, l.id, ChargeRent)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var period string
+		if err := rows.Scan(&period); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		posted[period] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	start := time.Unix(l.startUnix, 0).In(time.Local)
+	for month := time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, time.Local); ; month = month.AddDate(0, 1, 0) {
+		due, ok := l.dueDate(month)
+		if !ok || due.After(through) {
+			if n > 0 {
+				return n, reallocate(db, l.id)
+			}
+			return n, nil
+		}
+		if posted[month.Format("2006-01")] {
+			continue
+		}
+		added, err := postRentCharge(db, l, month, due)
+		if err != nil {
+			return n, err
+		}
+		if added {
+			n++
+		}
+	}
+}
+
+// postRentCharge inserts the rent charge of l for month unless it exists.
+// It reports whether a charge was added.
+func postRentCharge(db *sql.DB, l rentLease, month, due time.Time) (bool, error) {
+	res, err := db.Exec(`INSERT INTO charges (leaseId, chargeType, chargePeriod, chargeAmount, chargeDueUnix, chargeDescription, createdUnix)
+		VALUES (?, ?, ?, ?, ?, ?, ?) ON CONFLICT (leaseId, chargeType, chargePeriod) DO NOTHING`,
+		l.id, ChargeRent, month.Format("2006-01"), l.rent, due.Unix(), "Rent "+month.Format("January 2006"), time.Now().Unix())
+	if err != nil {
+		return false, err
+	}
+	added, err := res.RowsAffected()
+	return added > 0, err
+}
+
+// startOfDay returns midnight at the start of t's day in t's location.
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// queryIDs runs a query selecting one integer column and returns its values.
+func queryIDs(q sqlQuerier, query string, args ...interface{}) ([]int, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
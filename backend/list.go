@@ -0,0 +1,284 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Package-level summary:
+// This file implements pagination, filtering and sorting for list endpoints.
+// Each entity describes its list query with a listSpec (columns, sortable
+// fields, filters); ParseListParams reads ?limit, ?cursor, ?sort and the
+// filter parameters from the request, and queryPage runs the scoped query.
+// Paging is keyset based: the opaque cursor records the sort value and ID of
+// the last row returned, so pages stay stable while rows are added. /v1 list
+// endpoints respond with a Page envelope; the deprecated legacy routes still
+// return a bare array of every row unless ?limit is given.
+// Helpers: ParseListParams, queryPage, respondList.
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// legacyRouteKey marks requests that arrived on a deprecated route (see router.go).
+const legacyRouteKey contextKey = "legacyRoute"
+
+// Page is the response envelope for /v1 list endpoints. Total counts every
+// row matching the filters; NextCursor is null on the last page.
+type Page[T any] struct {
+	Data       []T     `json:"data"`
+	Total      int     `json:"total"`
+	Limit      int     `json:"limit"`
+	NextCursor *string `json:"nextCursor"`
+}
+
+// ListParams is a parsed list request.
+type ListParams struct {
+	Limit   int    // 0 returns every row
+	Sort    string // API field name from listSpec.sorts
+	Desc    bool
+	Cursor  *listCursor
+	Filters []listCondition
+}
+
+// errNullSortValue is returned when a sort expression yields NULL, which a
+// keyset cursor cannot resume from: NULL compares neither above nor below.
+var errNullSortValue = errors.New("sort expression returned NULL")
+
+// listCursor is the decoded form of the opaque ?cursor value.
+type listCursor struct {
+	Sort  string      `json:"s"` // sort the cursor was issued for, e.g. "-paymentDateUnix"
+	Value interface{} `json:"v"`
+	ID    int64       `json:"id"`
+}
+
+// listSpec describes how an entity is listed.
+type listSpec struct {
+	columns     string // SELECT list, in the order the scan function expects
+	table       string
+	idColumn    string
+	defaultSort string            // e.g. "-paymentDateUnix"
+	sorts       map[string]string // API field -> SQL expression; wrap nullable columns in COALESCE
+	filters     map[string]listFilter
+}
+
+// listFilter maps a query parameter onto a comparison.
+type listFilter struct {
+	expr  string
+	op    string // "=", ">=" or "<="
+	isInt bool
+}
+
+// listCondition is a filter with its value bound.
+type listCondition struct {
+	sql string
+	arg interface{}
+}
+
+// ParseListParams reads paging, sorting and filter parameters for spec.
+// Unknown sort fields, malformed numbers and cursors are reported as errors
+// meant for a 400 response.
+func ParseListParams(r *http.Request, spec listSpec) (ListParams, error) {
+	q := r.URL.Query()
+	var p ListParams
+
+	if !isLegacyRoute(r) {
+		p.Limit = defaultPageSize
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageSize {
+			return p, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+		p.Limit = n
+	}
+
+	sortBy := q.Get("sort")
+	if sortBy == "" {
+		sortBy = spec.defaultSort
+	}
+	p.Desc = strings.HasPrefix(sortBy, "-")
+	p.Sort = strings.TrimPrefix(sortBy, "-")
+	if _, ok := spec.sorts[p.Sort]; !ok {
+		return p, fmt.Errorf("cannot sort by %q; allowed: %s", p.Sort, strings.Join(sortedKeys(spec.sorts), ", "))
+	}
+
+	if v := q.Get("cursor"); v != "" {
+		c, err := decodeCursor(v)
+		if err != nil || c.Sort != sortBy {
+			return p, errors.New("invalid cursor for this sort order")
+		}
+		p.Cursor = c
+	}
+
+	for _, name := range sortedKeys(spec.filters) {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+		f := spec.filters[name]
+		var arg interface{} = v
+		if f.isInt {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return p, fmt.Errorf("%s must be a number", name)
+			}
+			arg = n
+		}
+		p.Filters = append(p.Filters, listCondition{sql: f.expr + " " + f.op + " ?", arg: arg})
+	}
+	return p, nil
+}
+
+// queryPage runs the list query for spec. scope is a SQL condition limiting
+// rows to those the caller may see, with its arguments in scopeArgs; fields
+// returns the scan destinations for one row in the order of spec.columns.
+func queryPage[T any](db *sql.DB, spec listSpec, p ListParams, scope string, scopeArgs []interface{}, fields func(*T) []interface{}) (*Page[T], error) {
+	where := " WHERE " + scope
+	args := append([]interface{}{}, scopeArgs...)
+	for _, c := range p.Filters {
+		where += " AND " + c.sql
+		args = append(args, c.arg)
+	}
+
+	page := &Page[T]{Data: []T{}, Limit: p.Limit}
+	if err := db.QueryRow(`SELECT COUNT(*) FROM `+spec.table+where, args...).Scan(&page.Total); err != nil {
+		return nil, err
+	}
+
+	sortExpr := spec.sorts[p.Sort]
+	cmp, dir := ">", "ASC"
+	if p.Desc {
+		cmp, dir = "<", "DESC"
+	}
+	if p.Cursor != nil {
+		where += " AND (" + sortExpr + " " + cmp + " ? OR (" + sortExpr + " = ? AND " + spec.idColumn + " " + cmp + " ?))"
+		args = append(args, p.Cursor.Value, p.Cursor.Value, p.Cursor.ID)
+	}
+	query := `SELECT ` + spec.columns + `, ` + sortExpr + `, ` + spec.idColumn + ` FROM ` + spec.table + where +
+		` ORDER BY ` + sortExpr + ` ` + dir + `, ` + spec.idColumn + ` ` + dir
+	if p.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, p.Limit+1)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var last listCursor
+	for rows.Next() {
+		if p.Limit > 0 && len(page.Data) == p.Limit {
+			// A row beyond the page exists: hand out a cursor to the last one kept
+			c, err := encodeCursor(last)
+			if err != nil {
+				return nil, err
+			}
+			page.NextCursor = &c
+			break
+		}
+		var item T
+		last = listCursor{Sort: sortParam(p)}
+		if err := rows.Scan(append(fields(&item), &last.Value, &last.ID)...); err != nil {
+			return nil, err
+		}
+		if b, ok := last.Value.([]byte); ok {
+			last.Value = string(b)
+		}
+		if last.Value == nil {
+			return nil, fmt.Errorf("list %s by %s: %w", spec.table, p.Sort, errNullSortValue)
+		}
+		page.Data = append(page.Data, item)
+	}
+	return page, rows.Err()
+}
+
+// respondList writes a list result: the Page envelope on /v1 routes, or just
+// the rows on legacy routes, whose clients expect a bare array.
+func respondList[T any](w http.ResponseWriter, r *http.Request, page *Page[T]) {
+	if isLegacyRoute(r) {
+		respondJSON(w, http.StatusOK, page.Data)
+		return
+	}
+	respondJSON(w, http.StatusOK, page)
+}
+
+// isLegacyRoute reports whether the request came in on a deprecated route.
+func isLegacyRoute(r *http.Request) bool {
+	legacy, _ := r.Context().Value(legacyRouteKey).(bool)
+	return legacy
+}
+
+// withLegacyRoute marks the request as arriving on a deprecated route.
+func withLegacyRoute(ctx context.Context) context.Context {
+	return context.WithValue(ctx, legacyRouteKey, true)
+}
+
+func sortParam(p ListParams) string {
+	if p.Desc {
+		return "-" + p.Sort
+	}
+	return p.Sort
+}
+
+func encodeCursor(c listCursor) (string, error) {
+	raw, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func decodeCursor(s string) (*listCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(strings.NewReader(string(raw)))
+	dec.UseNumber()
+	var c listCursor
+	if err := dec.Decode(&c); err != nil {
+		return nil, err
+	}
+	switch v := c.Value.(type) {
+	case json.Number:
+		n, err := v.Int64()
+		if err != nil {
+			return nil, err
+		}
+		c.Value = n
+	case string:
+	default:
+		return nil, errors.New("bad cursor value")
+	}
+	return &c, nil
+}
+
+// sortedKeys returns the keys of m in order, for stable messages and queries.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
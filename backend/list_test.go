@@ -0,0 +1,103 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Tests for list.go: paging with a cursor visits every row once, whatever the
+// sort order, including rows whose sort column is NULL.
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// pageThrough lists one row at a time in sort order and returns the IDs seen.
+func pageThrough[T any](t *testing.T, spec listSpec, sort string, list func(ListParams) (*Page[T], error), id func(T) int) []int {
+	t.Helper()
+	var ids []int
+	cursor := ""
+	for i := 0; i < 100; i++ {
+		q := url.Values{"limit": {"1"}, "sort": {sort}}
+		if cursor != "" {
+			q.Set("cursor", cursor)
+		}
+		p, err := ParseListParams(httptest.NewRequest(http.MethodGet, "/v1/list?"+q.Encode(), nil), spec)
+		if err != nil {
+			t.Fatalf("sort %s: %v", sort, err)
+		}
+		page, err := list(p)
+		if err != nil {
+			t.Fatalf("sort %s: %v", sort, err)
+		}
+		for _, item := range page.Data {
+			ids = append(ids, id(item))
+		}
+		if page.NextCursor == nil {
+			return ids
+		}
+		cursor = *page.NextCursor
+	}
+	t.Fatalf("sort %s: paging did not end", sort)
+	return nil
+}
+
+// checkEveryRow fails unless ids holds each of want exactly once.
+func checkEveryRow(t *testing.T, sort string, ids []int, want int) {
+	t.Helper()
+	seen := map[int]bool{}
+	for _, id := range ids {
+		seen[id] = true
+	}
+	if len(ids) != want || len(seen) != want {
+		t.Errorf("sort %s: paged %v, want %d distinct rows", sort, ids, want)
+	}
+}
+
+func TestListPagesPastNullSortValues(t *testing.T) {
+	s := newTestStore(t)
+	ownerID := createTestUser(t, s, "owner@example.com", "owner")
+	for _, q := range []string{
+		`INSERT INTO properties (ownerUserId, propertyName, propertyCity) VALUES (?, 'Maple', 'Morgantown')`,
+		`INSERT INTO properties (ownerUserId) VALUES (?)`,
+		`INSERT INTO properties (ownerUserId) VALUES (?)`,
+		`INSERT INTO properties (ownerUserId, propertyName) VALUES (?, 'Elm')`,
+	} {
+		if _, err := s.DB.Exec(q, ownerID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, q := range []string{
+		`INSERT INTO propertyUnits (propertyId, propertyUnitNumber, propertyUnitBeds) VALUES (1, '1A', 2)`,
+		`INSERT INTO propertyUnits (propertyId) VALUES (1)`,
+		`INSERT INTO propertyUnits (propertyId) VALUES (1)`,
+		`INSERT INTO propertyUnits (propertyId, propertyUnitNumber) VALUES (1, '2B')`,
+	} {
+		if _, err := s.DB.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, field := range sortedKeys(propertyListSpec.sorts) {
+		for _, sort := range []string{field, "-" + field} {
+			ids := pageThrough(t, propertyListSpec, sort, func(p ListParams) (*Page[Property], error) {
+				return s.Properties.List(ownerID, p)
+			}, func(p Property) int { return p.PropertyID })
+			checkEveryRow(t, sort, ids, 4)
+		}
+	}
+	for _, field := range sortedKeys(unitListSpec.sorts) {
+		for _, sort := range []string{field, "-" + field} {
+			ids := pageThrough(t, unitListSpec, sort, func(p ListParams) (*Page[PropertyUnit], error) {
+				return s.Units.List(ownerID, p)
+			}, func(u PropertyUnit) int { return u.PropertyUnitID })
+			checkEveryRow(t, sort, ids, 4)
+		}
+	}
+}
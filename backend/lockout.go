@@ -0,0 +1,227 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Package-level summary:
+// This file implements brute-force protection for /login. Every attempt is
+// recorded in the loginAttempts table with the submitted email, client IP and
+// outcome. Consecutive failures against one email, or many failures from one
+// IP, lock further attempts for a period that doubles with each additional
+// failure. Owners can review attempts against their own and their staff's
+// accounts. Handlers: GetLoginAttemptsHandler. DB helpers: RecordLoginAttempt,
+// LoginLockedUntil, GetLoginAttempts.
+
+import (
+	"database/sql"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Outcomes stored in loginAttempts.outcome
+const (
+	LoginSuccess = "success"
+	LoginFailure = "failure"
+	LoginLocked  = "locked" // rejected without checking the password
+)
+
+const (
+	accountLockThreshold = 5                // consecutive failures per email before locking
+	ipLockThreshold      = 20               // failures per IP within ipFailureWindow before locking
+	ipFailureWindow      = 15 * time.Minute // how far back IP failures are counted
+	accountFailureWindow = 24 * time.Hour   // how far back email failures are counted
+	lockoutBase          = 30 * time.Second // first lockout; doubles with each further failure
+	lockoutMax           = time.Hour
+)
+
+// dummyPasswordHash is compared against when the email is unknown so the
+// response time does not reveal whether an account exists.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("rent-tracker-dummy-password"), bcrypt.DefaultCost)
+
+// LOGIN ATTEMPTS
+type LoginAttempt struct {
+	LoginAttemptID int    `db:"loginAttemptId" json:"loginAttemptId"`
+	AttemptEmail   string `db:"attemptEmail" json:"attemptEmail"`
+	UserID         int    `db:"userId" json:"userId"`
+	IPAddress      string `db:"ipAddress" json:"ipAddress"`
+	Outcome        string `db:"outcome" json:"outcome"`
+	AttemptedUnix  int64  `db:"attemptedUnix" json:"attemptedUnix"`
+}
+
+// clientIP returns the host part of the request's remote address.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// normalizeEmail lowercases and trims an email so attempts are counted per address.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// lockoutDuration returns how long to lock after failures, given the threshold:
+// lockoutBase at the threshold, doubling for each failure beyond it.
+func lockoutDuration(failures, threshold int) time.Duration {
+	if failures < threshold {
+		return 0
+	}
+	d := lockoutBase
+	for i := threshold; i < failures && d < lockoutMax; i++ {
+		d *= 2
+	}
+	if d > lockoutMax {
+		d = lockoutMax
+	}
+	return d
+}
+
+// == Handlers =====================================================================
+// GET /loginAttempts?email=&ip=&outcome=&since=&limit=
+// GetLoginAttemptsHandler returns an HTTP handler listing recent login attempts
+// against the caller's account and the staff accounts they manage, newest first.
+func GetLoginAttemptsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		q := r.URL.Query()
+		var since int64
+		if s := q.Get("since"); s != "" {
+			v, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				respondError(w, http.StatusBadRequest, "since must be a unix timestamp")
+				return
+			}
+			since = v
+		}
+		limit := 100
+		if s := q.Get("limit"); s != "" {
+			v, err := strconv.Atoi(s)
+			if err != nil || v <= 0 || v > 1000 {
+				respondError(w, http.StatusBadRequest, "limit must be between 1 and 1000")
+				return
+			}
+			limit = v
+		}
+		outcome := q.Get("outcome")
+		if outcome != "" && outcome != LoginSuccess && outcome != LoginFailure && outcome != LoginLocked {
+			respondError(w, http.StatusBadRequest, "outcome must be success, failure, or locked")
+			return
+		}
+
+		attempts, err := GetLoginAttempts(db, currentUserID(r), normalizeEmail(q.Get("email")), q.Get("ip"), outcome, since, limit)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		respondJSON(w, http.StatusOK, attempts)
+	}
+}
+
+// == SQL Queries =====================================================================
+
+// RecordLoginAttempt stores one login attempt. userID is 0 when the email did
+// not match an account.
+func RecordLoginAttempt(db *sql.DB, email string, userID int, ip, outcome string) error {
+	var uid interface{}
+	if userID != 0 {
+		uid = userID
+	}
+	_, err := db.Exec(`INSERT INTO loginAttempts (attemptEmail, userId, ipAddress, outcome, attemptedUnix) VALUES (?, ?, ?, ?, ?)`,
+		email, uid, ip, outcome, time.Now().Unix())
+	return err
+}
+
+// LoginLockedUntil returns the time until which logins for email or from ip are
+// locked, or the zero time when neither is locked.
+func LoginLockedUntil(db *sql.DB, email, ip string, now time.Time) (time.Time, error) {
+	var until time.Time
+
+	// Per account: failures since the last success, within accountFailureWindow
+	var failures int
+	var last sql.NullInt64
+	err := db.QueryRow(`SELECT COUNT(*), MAX(attemptedUnix) FROM loginAttempts
+		WHERE attemptEmail = ? AND outcome = ? AND attemptedUnix > ?
+		AND loginAttemptId > COALESCE((SELECT MAX(loginAttemptId) FROM loginAttempts WHERE attemptEmail = ? AND outcome = ?), 0)`,
+		email, LoginFailure, now.Add(-accountFailureWindow).Unix(), email, LoginSuccess).Scan(&failures, &last)
+	if err != nil {
+		return until, err
+	}
+	if d := lockoutDuration(failures, accountLockThreshold); d > 0 && last.Valid {
+		until = time.Unix(last.Int64, 0).Add(d)
+	}
+
+	// Per IP: failures within ipFailureWindow regardless of email
+	err = db.QueryRow(`SELECT COUNT(*), MAX(attemptedUnix) FROM loginAttempts
+		WHERE ipAddress = ? AND outcome = ? AND attemptedUnix > ?`,
+		ip, LoginFailure, now.Add(-ipFailureWindow).Unix()).Scan(&failures, &last)
+	if err != nil {
+		return until, err
+	}
+	if d := lockoutDuration(failures, ipLockThreshold); d > 0 && last.Valid {
+		if ipUntil := time.Unix(last.Int64, 0).Add(d); ipUntil.After(until) {
+			until = ipUntil
+		}
+	}
+
+	if !until.After(now) {
+		return time.Time{}, nil
+	}
+	return until, nil
+}
+
+// GetLoginAttempts returns attempts against accounts the user manages (matched
+// by user ID, or by email for locked attempts that never reached the user
+// lookup), newest first, optionally filtered by email, IP, outcome, and start time.
+func GetLoginAttempts(db *sql.DB, userID int, email, ip, outcome string, since int64, limit int) ([]LoginAttempt, error) {
+	query := `SELECT loginAttemptId, attemptEmail, COALESCE(userId,0), ipAddress, outcome, attemptedUnix
+		FROM loginAttempts
+		WHERE (userId IN (` + managedUsersSQL + `)
+			OR attemptEmail IN (SELECT lower(userEmail) FROM users WHERE userId IN (` + managedUsersSQL + `)))
+		AND attemptedUnix >= ?`
+	args := append(scopeArgs(userID, 4), since)
+	if email != "" {
+		query += ` AND attemptEmail = ?`
+		args = append(args, email)
+	}
+	if ip != "" {
+		query += ` AND ipAddress = ?`
+		args = append(args, ip)
+	}
+	if outcome != "" {
+		query += ` AND outcome = ?`
+		args = append(args, outcome)
+	}
+	query += ` ORDER BY attemptedUnix DESC, loginAttemptId DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []LoginAttempt
+	for rows.Next() {
+		var a LoginAttempt
+		if err := rows.Scan(&a.LoginAttemptID, &a.AttemptEmail, &a.UserID, &a.IPAddress, &a.Outcome, &a.AttemptedUnix); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,100 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Package-level summary:
+// This file defines the Mailer interface used to deliver account emails such
+// as password reset links, plus two implementations: SMTPMailer for real
+// delivery and FileMailer, a local stand-in that appends messages to a file
+// (or the server log) so reset links can be picked up during development.
+// NewMailer selects the implementation from the configuration.
+
+import (
+	"fmt"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mailer delivers a plain-text email.
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// SMTPMailer sends mail through an SMTP server using PLAIN auth when a
+// username is configured.
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// Send delivers the message via SMTP.
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	msg := strings.Join([]string{
+		"From: " + m.From,
+		"To: " + to,
+		"Subject: " + subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+	return smtp.SendMail(m.Host+":"+m.Port, auth, m.From, []string{to}, []byte(msg))
+}
+
+// FileMailer writes messages to Path instead of sending them, or to the server
+// log when Path is empty. Intended for local testing only.
+type FileMailer struct {
+	Path string
+	mu   sync.Mutex
+}
+
+// Send records the message.
+func (m *FileMailer) Send(to, subject, body string) error {
+	entry := fmt.Sprintf("To: %s\nSubject: %s\nDate: %s\n\n%s\n----\n", to, subject, time.Now().Format(time.RFC1123Z), body)
+	if m.Path == "" {
+		logInfo("mail (not sent):\n%s", entry)
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, err := os.OpenFile(m.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(entry)
+	return err
+}
+
+// NewMailer returns an SMTPMailer when an SMTP host is configured, otherwise
+// a FileMailer writing to the configured mail file (or the log).
+func NewMailer(c *Config) Mailer {
+	if c.SMTPHost != "" {
+		return &SMTPMailer{
+			Host:     c.SMTPHost,
+			Port:     c.SMTPPort,
+			Username: c.SMTPUser,
+			Password: c.SMTPPassword,
+			From:     c.MailFrom,
+		}
+	}
+	return &FileMailer{Path: c.MailFile}
+}
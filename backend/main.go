@@ -10,99 +10,88 @@ package main
 
 // Package-level summary:
 // This file is the main entry point for the RentTracker backend server. It
-// opens the SQLite database, sets up HTTP routes for all API endpoints, and
-// starts the server on port 8080. Route registration covers users, login,
-// dashboard, rent, property, unit, tenant, lease, payment, maintenance, and activity log endpoints.
+// loads the configuration (see config.go), opens the database, brings its schema
+// up to date, sets up HTTP routes for all API endpoints, and starts the server
+// on the configured address, over TLS when a certificate is set. Routes are registered by
+// NewRouter in router.go.
+// All routes are wrapped in AuthMiddleware and RBACMiddleware so only login, token
+// refresh, and registration are public and every other call is checked against the caller's role.
 
 import (
-	"database/sql"
+	"errors"
+	"flag"
 	"log"
 	"net/http"
+	"os"
 
 	_ "modernc.org/sqlite"
 )
 
-// main initializes the SQLite database, sets up HTTP routes for all API endpoints,
-// and starts the RentTracker backend server on port 8080.
-// It registers handlers for users, login, dashboard, rent, property, unit, tenant,
-// lease, payment, maintenance, and activity log endpoints.
+// main loads the configuration, opens the database (SQLite or PostgreSQL), applies migrations,
+// sets up HTTP routes for all API endpoints, and starts the RentTracker backend server.
 func main() {
-	// Open SQLite database file
-	db, err := sql.Open("sqlite", "../rt.db")
+	// Settings come from defaults, an optional config file, RT_* environment
+	// variables and flags, in that order (see config.go)
+	cfg, args, err := LoadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal("config: ", err)
+	}
+	cfg.Apply()
+
+	// Open the database: SQLite by default, or PostgreSQL (see repository.go)
+	store, err := OpenStore(cfg.DBDriver, cfg.DBPath)
 	if err != nil {
 		log.Fatal("open db:", err)
 	}
+	db := store.DB
 	defer db.Close()
 
-	// Set pragmas for better defaults (optional)
-	if _, err := db.Exec(`PRAGMA foreign_keys = ON;`); err != nil {
-		log.Fatal("enable fk:", err)
+	// "main [flags] migrate [-dry-run] [-status]" manages the schema and exits; otherwise
+	// pending migrations are applied before serving (see migrate.go)
+	if len(args) > 0 && args[0] == "migrate" {
+		if err := runMigrateCommand(db, cfg.DBDriver, args[1:]); err != nil {
+			log.Fatal("migrate: ", err)
+		}
+		return
+	}
+	if len(args) > 0 {
+		log.Fatalf("unknown command %q", args[0])
+	}
+	if err := migrateOnStartup(db, cfg.DBDriver); err != nil {
+		log.Fatal("migrate: ", err)
 	}
 
-	mux := http.NewServeMux()
-
-	// Register new user endpoint
-	mux.Handle("/users", CreateUserHandler(db))
-
-	// Login endpoint
-	mux.Handle("/login", LoginHandler(db))
-
-	// Dashboard endpoints
-	mux.Handle("/overduePayments", GetOverduePaymentsHandler(db))
-	mux.Handle("/maintenanceRequestStatus", GetMaintenanceRequestsHandler(db))
-	mux.Handle("/leaseOverview", GetLeasesHandler(db))
-
-	// Rent endpoints
-	mux.Handle("/upcomingPayments", GetUpcomingRentHandler(db))
-	mux.Handle("/payments", CreatePaymentHandler(db))
-
-	// User endpoints
-	mux.Handle("/users/", GetUserHandler(db))
-	mux.Handle("/users/me", GetCurrentUserHandler(db))
-	mux.Handle("/users/update", UpdateUserHandler(db))
-	mux.Handle("/users/delete/", DeleteUserHandler(db))
-
-	// Property endpoints
-	mux.Handle("/properties", CreatePropertyHandler(db))
-	mux.Handle("/properties/", GetPropertyHandler(db))
-	mux.Handle("/properties/update", UpdatePropertyHandler(db))
-	mux.Handle("/properties/delete/", DeletePropertyHandler(db))
-
-	// Unit endpoints
-	mux.Handle("/units", CreatePropertyUnitHandler(db))
-	mux.Handle("/units/", GetPropertyUnitHandler(db))
-	mux.Handle("/units/update", UpdatePropertyUnitHandler(db))
-	mux.Handle("/units/delete/", DeletePropertyUnitHandler(db))
+	// Load token signing keys (see keys.go)
+	signingKeys, err = LoadKeySet(cfg.JWTKeysFile, cfg.JWTSecret)
+	if err != nil {
+		log.Fatal("load signing keys:", err)
+	}
 
-	// Tenant endpoints
-	mux.Handle("/tenants", CreateTenantHandler(db))
-	mux.Handle("/tenants/", GetTenantHandler(db))
-	mux.Handle("/tenants/update", UpdateTenantHandler(db))
-	mux.Handle("/tenants/delete/", DeleteTenantHandler(db))
+	// Deleted records stay in the trash for the configured retention (see trash.go)
+	StartTrashPurger(store, cfg.TrashRetention)
 
-	// Lease endpoints
-	mux.Handle("/leases", CreateLeaseHandler(db))
-	mux.Handle("/leases/", GetLeaseHandler(db))
-	mux.Handle("/leases/update", UpdateLeaseHandler(db))
-	mux.Handle("/leases/delete/", DeleteLeaseHandler(db))
+	// Monthly rent charges are posted as they come due (see ledger.go)
+	StartRentChargePoster(store, cfg.RentPostInterval)
 
-	// Payment endpoints
-	mux.Handle("/payments/", GetPaymentHandler(db))
-	mux.Handle("/payments/update", UpdatePaymentHandler(db))
-	mux.Handle("/payments/delete/", DeletePaymentHandler(db))
+	// Outgoing mail for password resets and tenant invites (see mailer.go)
+	mailer := NewMailer(cfg)
 
-	// Maintenance endpoints
-	mux.Handle("/maintenance", CreateMaintenanceHandler(db))
-	mux.Handle("/maintenance/", GetMaintenanceHandler(db))
-	mux.Handle("/maintenance/update", UpdateMaintenanceHandler(db))
-	mux.Handle("/maintenance/delete/", DeleteMaintenanceHandler(db))
+	// Routes are registered in router.go: the /v1 entity API, its deprecated
+	// legacy aliases, and the auth and portal endpoints
+	mux := NewRouter(store, db, mailer)
 
-	// Activity log endpoints
-	mux.Handle("/activity", CreateActivityLogHandler(db))
-	mux.Handle("/activity/", GetActivityLogHandler(db))
-	mux.Handle("/activity/update", UpdateActivityLogHandler(db))
-	mux.Handle("/activity/delete/", DeleteActivityLogHandler(db))
+	// Every route except login and registration requires a valid bearer token,
+	// and the caller's role must be permitted by the rolePermissions matrix.
+	// CORS preflights from allowed origins are answered before authentication.
+	handler := CORSMiddleware(cfg.CORSOrigins, AuthMiddleware(db, RBACMiddleware(db, mux)))
 
-	log.Println("Server running on :8080")
-	log.Fatal(http.ListenAndServe(":8080", mux))
+	if cfg.TLSEnabled() {
+		logInfo("Server running on %s (HTTPS)", cfg.ListenAddr)
+		log.Fatal(http.ListenAndServeTLS(cfg.ListenAddr, cfg.TLSCertFile, cfg.TLSKeyFile, handler))
+	}
+	logInfo("Server running on %s", cfg.ListenAddr)
+	log.Fatal(http.ListenAndServe(cfg.ListenAddr, handler))
 }
@@ -10,16 +10,17 @@ package main
 
 // Package-level summary:
 // This file implements maintenance request CRUD HTTP handlers and database helpers.
-// Handlers: CreateMaintenanceHandler, GetMaintenanceHandler, UpdateMaintenanceHandler,
-// DeleteMaintenanceHandler. DB helpers: CreateMaintenanceRequest, GetAllMaintenanceRequests,
+// Handlers: CreateMaintenanceHandler, GetMaintenanceHandler, UpdateMaintenanceHandler, PatchMaintenanceHandler,
+// DeleteMaintenanceHandler. DB helpers: CreateMaintenanceRequest, ListMaintenanceRequests,
 // GetMaintenanceRequestByID, UpdateMaintenanceRequest, DeleteMaintenanceRequest.
 
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
-	"strings"
+	"time"
 )
 
 // MAINTENANCE REQUESTS
@@ -34,12 +35,14 @@ type MaintenanceRequest struct {
 	MaintenanceRequestCreatedUnix   int64  `db:"maintenanceRequestCreatedUnix" json:"maintenanceRequestCreatedUnix"`
 	MaintenanceRequestCompletedUnix *int64 `db:"maintenanceRequestCompletedUnix" json:"maintenanceRequestCompletedUnix,omitempty"`
 	MaintenanceRequestAssignedTo    string `db:"maintenanceAssignedTo" json:"maintenanceAssignedTo"`
+	Version                         int    `db:"version" json:"version,omitempty"`
+	UpdatedUnix                     *int64 `db:"updatedUnix" json:"updatedUnix,omitempty"`
 }
 
 // == Handlers ======================================================================================
 // CreateMaintenanceHandler returns an HTTP handler for creating a new maintenance request.
 // Accepts a JSON body, validates required fields, inserts into DB, and responds with the created request.
-func CreateMaintenanceHandler(db *sql.DB) http.HandlerFunc {
+func CreateMaintenanceHandler(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -54,47 +57,58 @@ func CreateMaintenanceHandler(db *sql.DB) http.HandlerFunc {
 			respondError(w, http.StatusBadRequest, "propertyUnitId, info, createdUnix required")
 			return
 		}
-		id, err := CreateMaintenanceRequest(db, &m)
+		if !checkMaintenanceReferences(w, s, currentUserID(r), &m) {
+			return
+		}
+		id, err := s.Maintenance.Create(&m)
 		if err != nil {
 			respondError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
 		m.MaintenanceRequestID = id
+		m.Version = 1
+		setETag(w, m.Version)
 		respondJSON(w, http.StatusCreated, m)
 	}
 }
 
 // GetMaintenanceHandler returns an HTTP handler for retrieving maintenance requests.
 // If no ID is provided, returns all requests; otherwise, returns the request with the given ID.
-func GetMaintenanceHandler(db *sql.DB) http.HandlerFunc {
+func GetMaintenanceHandler(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		idStr := strings.TrimPrefix(r.URL.Path, "/maintenance/")
-		if idStr == "" || idStr == "/" {
-			list, err := GetAllMaintenanceRequests(db)
+		idStr := r.PathValue("id")
+		if idStr == "" {
+			p, err := ParseListParams(r, maintenanceListSpec)
+			if err != nil {
+				respondError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			page, err := s.Maintenance.List(currentUserID(r), p)
 			if err != nil {
 				respondError(w, http.StatusInternalServerError, err.Error())
 				return
 			}
-			respondJSON(w, http.StatusOK, list)
+			respondList(w, r, page)
 			return
 		}
-		id, err := strconv.Atoi(strings.Trim(idStr, "/"))
+		id, err := strconv.Atoi(idStr)
 		if err != nil {
 			respondError(w, http.StatusBadRequest, "invalid id")
 			return
 		}
-		m, err := GetMaintenanceRequestByID(db, id)
+		m, err := s.Maintenance.GetByID(currentUserID(r), id)
 		if err != nil {
 			respondError(w, http.StatusNotFound, "not found")
 			return
 		}
+		setETag(w, m.Version)
 		respondJSON(w, http.StatusOK, m)
 	}
 }
 
 // UpdateMaintenanceHandler returns an HTTP handler for updating a maintenance request.
 // Accepts a JSON body, validates maintenanceRequestId, updates the DB, and responds with status.
-func UpdateMaintenanceHandler(db *sql.DB) http.HandlerFunc {
+func UpdateMaintenanceHandler(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPut {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -105,94 +119,172 @@ func UpdateMaintenanceHandler(db *sql.DB) http.HandlerFunc {
 			respondError(w, http.StatusBadRequest, "invalid json")
 			return
 		}
+		if !bindPathID(w, r, &m.MaintenanceRequestID, "maintenanceRequestId") {
+			return
+		}
 		if m.MaintenanceRequestID == 0 {
 			respondError(w, http.StatusBadRequest, "maintenanceRequestId required")
 			return
 		}
-		if err := UpdateMaintenanceRequest(db, &m); err != nil {
-			respondError(w, http.StatusInternalServerError, err.Error())
+		if m.PropertyUnitID == 0 || m.MaintenanceRequestInfo == "" || m.MaintenanceRequestCreatedUnix == 0 {
+			respondError(w, http.StatusBadRequest, "propertyUnitId, info, createdUnix required")
 			return
 		}
-		respondJSON(w, http.StatusOK, map[string]string{"status": "updated"})
+		if !checkMaintenanceReferences(w, s, currentUserID(r), &m) {
+			return
+		}
+		version, ifMatch, ok := requireVersion(w, r, m.Version)
+		if !ok {
+			return
+		}
+		m.Version = version
+		if err := s.Maintenance.Update(currentUserID(r), &m); err != nil {
+			if errors.Is(err, errStaleVersion) {
+				if current, err := s.Maintenance.GetByID(currentUserID(r), m.MaintenanceRequestID); err == nil {
+					respondStale(w, ifMatch, current, current.Version)
+					return
+				}
+				err = sql.ErrNoRows
+			}
+			if errors.Is(err, sql.ErrNoRows) {
+				respondError(w, http.StatusNotFound, "not found")
+			} else {
+				respondError(w, http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+		setETag(w, m.Version)
+		respondJSON(w, http.StatusOK, map[string]interface{}{"status": "updated", "version": m.Version})
 	}
 }
 
+// PatchMaintenanceHandler returns an HTTP handler for partially updating a maintenance request.
+// Accepts a JSON merge patch, applies it to the current maintenance request, and passes the
+// result to UpdateMaintenanceHandler (see patch.go).
+func PatchMaintenanceHandler(s *Store) http.HandlerFunc {
+	return PatchHandler(func(r *http.Request, id int) (*MaintenanceRequest, error) {
+		return s.Maintenance.GetByID(currentUserID(r), id)
+	}, UpdateMaintenanceHandler(s))
+}
+
 // DeleteMaintenanceHandler returns an HTTP handler for deleting a maintenance request by ID.
 // Accepts a DELETE request, removes the request from DB, and responds with status.
-func DeleteMaintenanceHandler(db *sql.DB) http.HandlerFunc {
+func DeleteMaintenanceHandler(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodDelete {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
-		idStr := strings.TrimPrefix(r.URL.Path, "/maintenance/")
-		id, err := strconv.Atoi(strings.Trim(idStr, "/"))
+		idStr := r.PathValue("id")
+		id, err := strconv.Atoi(idStr)
 		if err != nil {
 			respondError(w, http.StatusBadRequest, "invalid id")
 			return
 		}
-		if err := DeleteMaintenanceRequest(db, id); err != nil {
-			respondError(w, http.StatusInternalServerError, err.Error())
+		if err := s.Maintenance.Delete(currentUserID(r), id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				respondError(w, http.StatusNotFound, "not found")
+			} else if !respondDeleteBlocked(w, err) {
+				respondError(w, http.StatusInternalServerError, err.Error())
+			}
 			return
 		}
 		respondJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
 	}
 }
 
+// checkMaintenanceReferences verifies that the unit and optional lease a request
+// points at are visible to the user, writing a 404 and returning false when not.
+func checkMaintenanceReferences(w http.ResponseWriter, s *Store, userID int, m *MaintenanceRequest) bool {
+	if ok, err := s.Access.CanAccessUnit(userID, m.PropertyUnitID); err != nil || !ok {
+		respondError(w, http.StatusNotFound, "propertyUnit not found")
+		return false
+	}
+	if m.LeaseID != nil {
+		if ok, err := s.Access.CanAccessLease(userID, *m.LeaseID); err != nil || !ok {
+			respondError(w, http.StatusNotFound, "lease not found")
+			return false
+		}
+	}
+	return true
+}
+
 // == SQL Queries =====================================================================
 // CreateMaintenanceRequest inserts a new maintenance request into the database.
 // Returns the new request ID and error if insertion fails.
 func CreateMaintenanceRequest(db *sql.DB, m *MaintenanceRequest) (int, error) {
-	res, err := db.Exec(`INSERT INTO maintenanceRequests (propertyUnitId, leaseId, maintenanceRequestInfo, maintenanceRequestPriority, maintenanceRequestCategory, maintenanceRequestStatus, maintenanceRequestCreatedUnix, maintenanceRequestCompletedUnix, maintenanceAssignedTo)
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, m.PropertyUnitID, m.LeaseID, m.MaintenanceRequestInfo, m.MaintenanceRequestPriority, m.MaintenanceRequestCategory, m.MaintenanceRequestStatus, m.MaintenanceRequestCreatedUnix, m.MaintenanceRequestCompletedUnix, m.MaintenanceRequestAssignedTo)
-	if err != nil {
-		return 0, err
-	}
-	id, _ := res.LastInsertId()
-	return int(id), nil
+	var id int
+	err := db.QueryRow(`INSERT INTO maintenanceRequests (propertyUnitId, leaseId, maintenanceRequestInfo, maintenanceRequestPriority, maintenanceRequestCategory, maintenanceRequestStatus, maintenanceRequestCreatedUnix, maintenanceRequestCompletedUnix, maintenanceAssignedTo)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING maintenanceRequestId`, m.PropertyUnitID, m.LeaseID, m.MaintenanceRequestInfo, m.MaintenanceRequestPriority, m.MaintenanceRequestCategory, m.MaintenanceRequestStatus, m.MaintenanceRequestCreatedUnix, m.MaintenanceRequestCompletedUnix, m.MaintenanceRequestAssignedTo).Scan(&id)
+	return id, err
 }
 
-// UpdateMaintenanceRequest updates an existing maintenance request in the database.
-// Returns error if update fails.
-func UpdateMaintenanceRequest(db *sql.DB, m *MaintenanceRequest) error {
-	_, err := db.Exec(`UPDATE maintenanceRequests SET propertyUnitId=?, leaseId=?, maintenanceRequestInfo=?, maintenanceRequestPriority=?, maintenanceRequestCategory=?, maintenanceRequestStatus=?, maintenanceRequestCreatedUnix=?, maintenanceRequestCompletedUnix=?, maintenanceAssignedTo=? WHERE maintenanceRequestId=?`,
-		m.PropertyUnitID, m.LeaseID, m.MaintenanceRequestInfo, m.MaintenanceRequestPriority, m.MaintenanceRequestCategory, m.MaintenanceRequestStatus, m.MaintenanceRequestCreatedUnix, m.MaintenanceRequestCompletedUnix, m.MaintenanceRequestAssignedTo, m.MaintenanceRequestID)
+// UpdateMaintenanceRequest updates an existing maintenance request visible to userID in the database if
+// it is still at m.Version (0 skips the check), then advances m.Version.
+// Returns sql.ErrNoRows if the request is not visible to the user, or
+// errStaleVersion if it was changed in the meantime.
+func UpdateMaintenanceRequest(db *sql.DB, userID int, m *MaintenanceRequest) error {
+	now := time.Now().Unix()
+	args := append([]interface{}{m.PropertyUnitID, m.LeaseID, m.MaintenanceRequestInfo, m.MaintenanceRequestPriority, m.MaintenanceRequestCategory, m.MaintenanceRequestStatus, m.MaintenanceRequestCreatedUnix, m.MaintenanceRequestCompletedUnix, m.MaintenanceRequestAssignedTo, now, m.MaintenanceRequestID, m.Version}, scopeArgs(userID, 2)...)
+	err := db.QueryRow(`UPDATE maintenanceRequests SET propertyUnitId=?, leaseId=?, maintenanceRequestInfo=?, maintenanceRequestPriority=?, maintenanceRequestCategory=?, maintenanceRequestStatus=?, maintenanceRequestCreatedUnix=?, maintenanceRequestCompletedUnix=?, maintenanceAssignedTo=?, version=version+1, updatedUnix=?
+		WHERE maintenanceRequestId=? AND deletedUnix IS NULL AND ? IN (0, version) AND propertyUnitId IN (`+accessibleUnitsSQL+`) RETURNING version`, args...).Scan(&m.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return staleOrMissing(GetMaintenanceRequestByID(db, userID, m.MaintenanceRequestID))
+	}
+	if err == nil {
+		m.UpdatedUnix = &now
+	}
 	return err
 }
 
-// DeleteMaintenanceRequest removes a maintenance request from the database by ID.
-// Returns error if deletion fails.
-func DeleteMaintenanceRequest(db *sql.DB, id int) error {
-	_, err := db.Exec(`DELETE FROM maintenanceRequests WHERE maintenanceRequestId=?`, id)
-	return err
+// DeleteMaintenanceRequest moves a maintenance request visible to userID to the trash (see trash.go),
+// applying the delete policies for what depends on it (see integrity.go).
+// Returns sql.ErrNoRows if the request is not visible to the user or is already in
+// the trash, or a *DeleteBlockedError if dependent records prevent the delete.
+func DeleteMaintenanceRequest(db *sql.DB, userID, id int) error {
+	return trashWithPolicies(db, userID, "maintenance", id)
 }
 
-// GetAllMaintenanceRequests retrieves all maintenance requests from the database.
-// Returns a slice of MaintenanceRequest and error if query fails.
-func GetAllMaintenanceRequests(db *sql.DB) ([]MaintenanceRequest, error) {
-	rows, err := db.Query(`SELECT maintenanceRequestId, propertyUnitId, leaseId, maintenanceRequestInfo, maintenanceRequestPriority, maintenanceRequestCategory, maintenanceRequestStatus, maintenanceRequestCreatedUnix, maintenanceRequestCompletedUnix, maintenanceAssignedTo FROM maintenanceRequests`)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
+// maintenanceListSpec describes how maintenance requests are listed, sorted and filtered (see list.go).
+var maintenanceListSpec = listSpec{
+	columns:     `maintenanceRequestId, propertyUnitId, leaseId, maintenanceRequestInfo, COALESCE(maintenanceRequestPriority, ''), COALESCE(maintenanceRequestCategory, ''), COALESCE(maintenanceRequestStatus, ''), maintenanceRequestCreatedUnix, maintenanceRequestCompletedUnix, COALESCE(maintenanceAssignedTo, ''), version, updatedUnix`,
+	table:       `maintenanceRequests`,
+	idColumn:    `maintenanceRequestId`,
+	defaultSort: "-maintenanceRequestCreatedUnix",
+	sorts: map[string]string{
+		"maintenanceRequestId":            "maintenanceRequestId",
+		"maintenanceRequestCreatedUnix":   "maintenanceRequestCreatedUnix",
+		"maintenanceRequestCompletedUnix": "COALESCE(maintenanceRequestCompletedUnix, 0)",
+		"maintenanceRequestPriority":      "COALESCE(maintenanceRequestPriority, '')",
+		"maintenanceRequestStatus":        "COALESCE(maintenanceRequestStatus, '')",
+	},
+	filters: map[string]listFilter{
+		"status":         {expr: "maintenanceRequestStatus", op: "="},
+		"priority":       {expr: "maintenanceRequestPriority", op: "="},
+		"category":       {expr: "maintenanceRequestCategory", op: "="},
+		"propertyUnitId": {expr: "propertyUnitId", op: "=", isInt: true},
+		"leaseId":        {expr: "leaseId", op: "=", isInt: true},
+		"from":           {expr: "maintenanceRequestCreatedUnix", op: ">=", isInt: true},
+		"to":             {expr: "maintenanceRequestCreatedUnix", op: "<=", isInt: true},
+	},
+}
 
-	var out []MaintenanceRequest
-	for rows.Next() {
-		var m MaintenanceRequest
-		if err := rows.Scan(&m.MaintenanceRequestID, &m.PropertyUnitID, &m.LeaseID, &m.MaintenanceRequestInfo, &m.MaintenanceRequestPriority, &m.MaintenanceRequestCategory, &m.MaintenanceRequestStatus, &m.MaintenanceRequestCreatedUnix, &m.MaintenanceRequestCompletedUnix, &m.MaintenanceRequestAssignedTo); err != nil {
-			return nil, err
-		}
-		out = append(out, m)
-	}
-	return out, nil
+// ListMaintenanceRequests returns one page of the maintenance requests visible to userID,
+// filtered and sorted as requested in p.
+func ListMaintenanceRequests(db *sql.DB, userID int, p ListParams) (*Page[MaintenanceRequest], error) {
+	return queryPage(db, maintenanceListSpec, p, `deletedUnix IS NULL AND propertyUnitId IN (`+accessibleUnitsSQL+`)`, scopeArgs(userID, 2), func(m *MaintenanceRequest) []interface{} {
+		return []interface{}{&m.MaintenanceRequestID, &m.PropertyUnitID, &m.LeaseID, &m.MaintenanceRequestInfo, &m.MaintenanceRequestPriority, &m.MaintenanceRequestCategory, &m.MaintenanceRequestStatus, &m.MaintenanceRequestCreatedUnix, &m.MaintenanceRequestCompletedUnix, &m.MaintenanceRequestAssignedTo, &m.Version, &m.UpdatedUnix}
+	})
 }
 
-// GetMaintenanceRequestByID retrieves a maintenance request by ID from the database.
+// GetMaintenanceRequestByID retrieves a maintenance request visible to userID by ID from the database.
 // Returns pointer to MaintenanceRequest and error if not found or query fails.
-func GetMaintenanceRequestByID(db *sql.DB, id int) (*MaintenanceRequest, error) {
+func GetMaintenanceRequestByID(db *sql.DB, userID, id int) (*MaintenanceRequest, error) {
 	var m MaintenanceRequest
-	err := db.QueryRow(`SELECT maintenanceRequestId, propertyUnitId, leaseId, maintenanceRequestInfo, maintenanceRequestPriority, maintenanceRequestCategory, maintenanceRequestStatus, maintenanceRequestCreatedUnix, maintenanceRequestCompletedUnix, maintenanceAssignedTo FROM maintenanceRequests WHERE maintenanceRequestId=?`, id).
-		Scan(&m.MaintenanceRequestID, &m.PropertyUnitID, &m.LeaseID, &m.MaintenanceRequestInfo, &m.MaintenanceRequestPriority, &m.MaintenanceRequestCategory, &m.MaintenanceRequestStatus, &m.MaintenanceRequestCreatedUnix, &m.MaintenanceRequestCompletedUnix, &m.MaintenanceRequestAssignedTo)
+	args := append([]interface{}{id}, scopeArgs(userID, 2)...)
+	err := db.QueryRow(`SELECT maintenanceRequestId, propertyUnitId, leaseId, maintenanceRequestInfo, COALESCE(maintenanceRequestPriority, ''), COALESCE(maintenanceRequestCategory, ''), COALESCE(maintenanceRequestStatus, ''), maintenanceRequestCreatedUnix, maintenanceRequestCompletedUnix, COALESCE(maintenanceAssignedTo, ''), version, updatedUnix FROM maintenanceRequests
+		WHERE maintenanceRequestId=? AND deletedUnix IS NULL AND propertyUnitId IN (`+accessibleUnitsSQL+`)`, args...).
+		Scan(&m.MaintenanceRequestID, &m.PropertyUnitID, &m.LeaseID, &m.MaintenanceRequestInfo, &m.MaintenanceRequestPriority, &m.MaintenanceRequestCategory, &m.MaintenanceRequestStatus, &m.MaintenanceRequestCreatedUnix, &m.MaintenanceRequestCompletedUnix, &m.MaintenanceRequestAssignedTo, &m.Version, &m.UpdatedUnix)
 	if err != nil {
 		return nil, err
 	}
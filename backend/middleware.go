@@ -0,0 +1,190 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Package-level summary:
+// This file provides HTTP middleware for the RentTracker backend. AuthMiddleware
+// validates the bearer JWT issued by LoginHandler on every non-public route,
+// rejects tokens whose session has been revoked, accepts API keys as an
+// alternative, and stores the authenticated user ID and session ID in the
+// request context. Helpers:
+// isPublicRoute, authenticateRequest, parseBearerToken, userIDFromContext.
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+type contextKey string
+
+const userIDKey contextKey = "userId"
+
+// isPublicRoute reports whether a request may be served without a token.
+// Only login (including the 2FA step), token refresh, password reset, the
+// public key set, and account registration are reachable anonymously. The
+// tenant portal authenticates its own callers (see TenantAuthMiddleware).
+func isPublicRoute(r *http.Request) bool {
+	switch {
+	case r.URL.Path == "/login", r.URL.Path == "/login/verify":
+		return true
+	case r.URL.Path == "/token/refresh":
+		return true
+	case r.URL.Path == "/password/reset/request", r.URL.Path == "/password/reset/confirm":
+		return true
+	case r.URL.Path == "/.well-known/jwks.json":
+		return true
+	case (r.URL.Path == "/users" || r.URL.Path == apiPrefix+"/users") && r.Method == http.MethodPost:
+		return true
+	case strings.HasPrefix(r.URL.Path, "/portal/"):
+		return true
+	}
+	return false
+}
+
+// AuthMiddleware wraps a handler and rejects requests that do not carry a valid
+// "Authorization: Bearer <token>" header. The bearer value is either an access
+// token issued by LoginHandler or an API key (see apikey.go). On success the
+// user ID is stored in the request context for downstream handlers, provided the
+// token's session has not been revoked by logout or a password change. Public routes
+// are always served, but still pick up the caller's identity when a valid token
+// is present so handlers can tell anonymous and authenticated callers apart.
+func AuthMiddleware(db *sql.DB, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isPublicRoute(r) {
+			if id, err := authenticateRequest(db, r); err == nil && (id.APIKeyID == 0 || apiKeyPermits(r, id.ReadOnly)) {
+				r = r.WithContext(withIdentity(r.Context(), id))
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		id, err := authenticateRequest(db, r)
+		if err != nil {
+			switch {
+			case errors.Is(err, errMissingToken):
+				respondError(w, http.StatusUnauthorized, "Missing authorization token")
+			case errors.Is(err, errMalformedHeader):
+				respondError(w, http.StatusUnauthorized, "Malformed authorization header")
+			case errors.Is(err, jwt.ErrTokenExpired):
+				respondError(w, http.StatusUnauthorized, "Token expired")
+			case errors.Is(err, errSessionRevoked):
+				respondError(w, http.StatusUnauthorized, "Session revoked")
+			case errors.Is(err, errAPIKeyExpired):
+				respondError(w, http.StatusUnauthorized, "API key expired")
+			case errors.Is(err, errAPIKeyRevoked):
+				respondError(w, http.StatusUnauthorized, "API key revoked")
+			case errors.Is(err, errInvalidAPIKey):
+				respondError(w, http.StatusUnauthorized, "Invalid API key")
+			default:
+				respondError(w, http.StatusUnauthorized, "Invalid token")
+			}
+			return
+		}
+
+		if id.APIKeyID != 0 && !apiKeyPermits(r, id.ReadOnly) {
+			respondError(w, http.StatusForbidden, "API key is not permitted to access this endpoint")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(withIdentity(r.Context(), id)))
+	})
+}
+
+var (
+	errMissingToken    = errors.New("missing authorization token")
+	errMalformedHeader = errors.New("malformed authorization header")
+	errSessionRevoked  = errors.New("session revoked")
+)
+
+// authIdentity describes who a request was authenticated as.
+type authIdentity struct {
+	UserID    int
+	SessionID string // empty for API keys
+	APIKeyID  int    // 0 for access tokens
+	ReadOnly  bool   // API key restricted to reads
+}
+
+// withIdentity stores the authenticated user and session IDs in a context.
+func withIdentity(ctx context.Context, id authIdentity) context.Context {
+	ctx = context.WithValue(ctx, userIDKey, id.UserID)
+	return context.WithValue(ctx, sessionIDKey, id.SessionID)
+}
+
+// authenticateRequest extracts the bearer token from the Authorization header
+// and verifies it. Access tokens must belong to a session that is still
+// active; values with the API key prefix are checked against the apiKeys table.
+func authenticateRequest(db *sql.DB, r *http.Request) (authIdentity, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return authIdentity{}, errMissingToken
+	}
+	tokenStr, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok || tokenStr == "" {
+		return authIdentity{}, errMalformedHeader
+	}
+	if strings.HasPrefix(tokenStr, apiKeyPrefix) {
+		key, err := AuthenticateAPIKey(db, tokenStr)
+		if err != nil {
+			return authIdentity{}, err
+		}
+		return authIdentity{UserID: key.UserID, APIKeyID: key.APIKeyID, ReadOnly: key.ReadOnly}, nil
+	}
+
+	userID, sessionID, err := parseBearerToken(tokenStr)
+	if err != nil {
+		return authIdentity{}, err
+	}
+	active, err := IsSessionActive(db, userID, sessionID)
+	if err != nil {
+		return authIdentity{}, err
+	}
+	if !active {
+		return authIdentity{}, errSessionRevoked
+	}
+	return authIdentity{UserID: userID, SessionID: sessionID}, nil
+}
+
+// parseBearerToken verifies the signature and expiry of a token string and
+// returns the user ID and session ID stored in its claims.
+func parseBearerToken(tokenStr string) (int, string, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(tokenStr, claims, signingKeys.Keyfunc,
+		jwt.WithValidMethods(signingKeys.ValidMethods()), jwt.WithExpirationRequired())
+	if err != nil {
+		return 0, "", err
+	}
+
+	// Staff tokens have no audience; tenant portal tokens do (see portal.go)
+	if _, ok := claims["aud"]; ok {
+		return 0, "", errors.New("token is not a staff token")
+	}
+
+	// JSON numbers decode as float64 in MapClaims
+	rawID, ok := claims["user_id"].(float64)
+	if !ok || rawID <= 0 {
+		return 0, "", errors.New("token missing user_id claim")
+	}
+	sessionID, ok := claims["sid"].(string)
+	if !ok || sessionID == "" {
+		return 0, "", errors.New("token missing sid claim")
+	}
+	return int(rawID), sessionID, nil
+}
+
+// userIDFromContext returns the authenticated user ID stored by AuthMiddleware.
+// The boolean is false when the request was not authenticated.
+func userIDFromContext(ctx context.Context) (int, bool) {
+	id, ok := ctx.Value(userIDKey).(int)
+	return id, ok
+}
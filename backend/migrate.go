@@ -0,0 +1,413 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Package-level summary:
+// This file implements versioned schema migrations. Migrations are the SQL files
+// in backend/migrations/<driver>, embedded into the binary and named
+// NNNN_description.sql; SQLite and PostgreSQL keep parallel sets with the same
+// versions. They run in version order, each in its own transaction, and are recorded in
+// the schema_migrations table with a SHA-256 checksum of the file. A migration
+// that has already been applied must not be edited: a changed checksum stops
+// the server instead of leaving the schema in an unknown state. Migrations run
+// at startup, or through "main migrate [-dry-run] [-status]". A SQLite
+// database created from rt.sql before migrations existed is adopted by
+// adding the columns its old tables lack (adoptLegacySchema).
+// Helpers: loadMigrations, Migrate, adoptLegacySchema, GetMigrationStatus,
+// runMigrateCommand, migrateOnStartup.
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/sqlite/*.sql migrations/postgres/*.sql
+var migrationFiles embed.FS
+
+// Migration is one embedded schema change.
+type Migration struct {
+	Version  int
+	Name     string
+	SQL      string
+	Checksum string // hex SHA-256 of SQL
+}
+
+// AppliedMigration is a row of schema_migrations.
+type AppliedMigration struct {
+	Version     int
+	Name        string
+	Checksum    string
+	AppliedUnix int64
+}
+
+// loadMigrations reads the embedded migration files for a database driver and
+// returns them in version order. File names must start with a unique numeric version.
+func loadMigrations(driver string) ([]Migration, error) {
+	dir := path.Join("migrations", driver)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Migration
+	seen := map[int]string{}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".sql") {
+			continue
+		}
+		prefix, name, ok := strings.Cut(strings.TrimSuffix(e.Name(), ".sql"), "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: name must look like 0001_description.sql", e.Name())
+		}
+		if other, dup := seen[version]; dup {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", other, e.Name(), version)
+		}
+		seen[version] = e.Name()
+
+		body, err := migrationFiles.ReadFile(path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(body)
+		out = append(out, Migration{Version: version, Name: name, SQL: string(body), Checksum: hex.EncodeToString(sum[:])})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// ensureMigrationsTable creates schema_migrations if it does not exist yet.
+func ensureMigrationsTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		checksum TEXT NOT NULL,
+		appliedUnix INTEGER NOT NULL
+	)`)
+	return err
+}
+
+// GetAppliedMigrations returns the rows of schema_migrations keyed by version.
+func GetAppliedMigrations(db *sql.DB) (map[int]AppliedMigration, error) {
+	rows, err := db.Query(`SELECT version, name, checksum, appliedUnix FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[int]AppliedMigration{}
+	for rows.Next() {
+		var a AppliedMigration
+		if err := rows.Scan(&a.Version, &a.Name, &a.Checksum, &a.AppliedUnix); err != nil {
+			return nil, err
+		}
+		out[a.Version] = a
+	}
+	return out, rows.Err()
+}
+
+// pendingMigrations compares the embedded migrations with those applied and
+// returns the ones still to run. It fails if an applied migration has been
+// edited, or if the database has a migration this binary does not know about.
+func pendingMigrations(all []Migration, applied map[int]AppliedMigration) ([]Migration, error) {
+	known := map[int]bool{}
+	var pending []Migration
+	for _, m := range all {
+		known[m.Version] = true
+		a, ok := applied[m.Version]
+		if !ok {
+			pending = append(pending, m)
+			continue
+		}
+		if a.Checksum != m.Checksum {
+			return nil, fmt.Errorf("migration %04d_%s was modified after it was applied (checksum %s, applied %s)",
+				m.Version, m.Name, m.Checksum[:12], a.Checksum[:min(12, len(a.Checksum))])
+		}
+	}
+	for v, a := range applied {
+		if !known[v] {
+			return nil, fmt.Errorf("database has migration %04d_%s, which this build does not include", v, a.Name)
+		}
+	}
+	return pending, nil
+}
+
+// legacyColumn is a column that migration 0001 defines on a table rt.sql
+// already created. 0001 only creates what does not exist, so on a database
+// built from rt.sql the old table is kept and the column must be added.
+type legacyColumn struct {
+	table, column, definition string
+	backfill                  string // fills the new column for existing rows
+}
+
+// legacyColumns are the columns adoptLegacySchema adds.
+var legacyColumns = []legacyColumn{
+	{
+		table:      "tenants",
+		column:     "ownerUserId",
+		definition: "INTEGER REFERENCES users(userId)",
+		// The owner of a property the tenant leases on, else the only owner
+		backfill: `UPDATE tenants SET ownerUserId = COALESCE(
+			(SELECT MIN(p.ownerUserId) FROM leases l
+				JOIN propertyUnits u ON u.propertyUnitId = l.propertyUnitId
+				JOIN properties p ON p.propertyId = u.propertyId
+				WHERE l.tenantId = tenants.tenantId AND p.ownerUserId IN (SELECT userId FROM users)),
+			(SELECT MIN(userId) FROM users WHERE COALESCE(userRole, 'owner') = 'owner' HAVING COUNT(*) = 1))`,
+	},
+}
+
+// adoptLegacySchema adds each of legacyColumns whose table exists without it,
+// and backfills it. It runs before every migration pass, so it also repairs a
+// database adopted by a build that lacked it; on any other database it
+// changes nothing. Only SQLite databases were created from rt.sql.
+func adoptLegacySchema(tx *sql.Tx, driver string) error {
+	if driver != DriverSQLite {
+		return nil
+	}
+	for _, c := range legacyColumns {
+		var tables, columns int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, c.table).Scan(&tables); err != nil {
+			return err
+		}
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, c.table, c.column).Scan(&columns); err != nil {
+			return err
+		}
+		if tables == 0 || columns > 0 {
+			continue
+		}
+		if _, err := tx.Exec(`ALTER TABLE ` + c.table + ` ADD COLUMN ` + c.column + ` ` + c.definition); err != nil {
+			return fmt.Errorf("adopt %s.%s: %w", c.table, c.column, err)
+		}
+		if _, err := tx.Exec(c.backfill); err != nil {
+			return fmt.Errorf("backfill %s.%s: %w", c.table, c.column, err)
+		}
+		logInfo("added %s.%s to the database created from rt.sql", c.table, c.column)
+	}
+	return nil
+}
+
+// Migrate applies every pending migration in order and returns the ones it
+// ran. Each migration commits separately, so a failure leaves the earlier ones
+// applied. With dryRun set all pending migrations are executed in one
+// transaction that is then rolled back, so SQL errors are still reported.
+// Either way adoptLegacySchema runs first.
+func Migrate(db *sql.DB, driver string, dryRun bool) ([]Migration, error) {
+	all, err := loadMigrations(driver)
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureMigrationsTable(db); err != nil {
+		return nil, err
+	}
+	applied, err := GetAppliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := pendingMigrations(all, applied)
+	if err != nil {
+		return nil, err
+	}
+
+	if dryRun {
+		tx, err := db.Begin()
+		if err != nil {
+			return nil, err
+		}
+		defer tx.Rollback()
+		if err := adoptLegacySchema(tx, driver); err != nil {
+			return nil, err
+		}
+		for i, m := range pending {
+			if err := applyMigration(tx, m); err != nil {
+				return pending[:i], fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+			}
+		}
+		return pending, nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	if err := adoptLegacySchema(tx, driver); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	for i, m := range pending {
+		tx, err := db.Begin()
+		if err != nil {
+			return pending[:i], err
+		}
+		if err := applyMigration(tx, m); err != nil {
+			tx.Rollback()
+			return pending[:i], fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return pending[:i], err
+		}
+	}
+	return pending, nil
+}
+
+// migrationBackfills fill in data that the migration files cannot derive
+// portably. Each runs in the transaction of the migration with its version, so
+// it happens exactly once, when the migration is applied. A backfill only uses
+// the schema as of its migration, since later code may expect columns that do
+// not exist yet at that point.
+var migrationBackfills = map[int]func(q sqlQuerier) error{
+	8: backfillAllocations0008, // allocate the payments recorded before 0008
+}
+
+// allocations0008SQL allocates the live payments and credit adjustments of
+// every live lease to its open charges, oldest first, as reallocateLease did
+// when migration 0008 was written. There are no explicit allocations yet.
+// Each charge and each source covers a range of the lease's running total;
+// an allocation is where a source's range overlaps a charge's.
+const allocations0008SQL = `WITH chargeRanges AS (
+	SELECT leaseId, chargeId,
+		SUM(chargeAmount) OVER w - chargeAmount AS lo, SUM(chargeAmount) OVER w AS hi
+	FROM charges
+	WHERE chargeAmount > 0 AND waivedUnix IS NULL AND leaseId IN (SELECT leaseId FROM leases WHERE deletedUnix IS NULL)
+	WINDOW w AS (PARTITION BY leaseId ORDER BY chargeDueUnix, chargeId ROWS BETWEEN UNBOUNDED PRECEDING AND CURRENT ROW)
+), sources AS (
+	SELECT leaseId, paymentId, NULL AS creditChargeId, paymentAmount AS amount, paymentDateUnix AS dateUnix, 0 AS kind, paymentId AS id
+	FROM payments WHERE deletedUnix IS NULL
+	UNION ALL
+	SELECT leaseId, NULL, chargeId, -chargeAmount, chargeDueUnix, 1, chargeId
+	FROM charges WHERE chargeAmount < 0 AND waivedUnix IS NULL
+), sourceRanges AS (
+	SELECT leaseId, paymentId, creditChargeId,
+		SUM(amount) OVER w - amount AS lo, SUM(amount) OVER w AS hi
+	FROM sources
+	WINDOW w AS (PARTITION BY leaseId ORDER BY dateUnix, kind, id ROWS BETWEEN UNBOUNDED PRECEDING AND CURRENT ROW)
+), overlaps AS (
+	SELECT c.chargeId, s.paymentId, s.creditChargeId,
+		CASE WHEN c.hi < s.hi THEN c.hi ELSE s.hi END - CASE WHEN c.lo > s.lo THEN c.lo ELSE s.lo END AS amount
+	FROM chargeRanges c JOIN sourceRanges s ON s.leaseId = c.leaseId
+)
+INSERT INTO paymentAllocations (chargeId, paymentId, creditChargeId, allocatedAmount)
+SELECT chargeId, paymentId, creditChargeId, amount FROM overlaps WHERE amount > 0`
+
+// backfillAllocations0008 runs allocations0008SQL.
+func backfillAllocations0008(q sqlQuerier) error {
+	_, err := q.Exec(allocations0008SQL)
+	return err
+}
+
+// applyMigration runs one migration and its backfill, if any, and records it
+// in schema_migrations.
+func applyMigration(tx *sql.Tx, m Migration) error {
+	if _, err := tx.Exec(m.SQL); err != nil {
+		return err
+	}
+	if backfill, ok := migrationBackfills[m.Version]; ok {
+		if err := backfill(tx); err != nil {
+			return err
+		}
+	}
+	_, err := tx.Exec(`INSERT INTO schema_migrations (version, name, checksum, appliedUnix) VALUES (?, ?, ?, ?)`,
+		m.Version, m.Name, m.Checksum, time.Now().Unix())
+	return err
+}
+
+// MigrationStatus describes one embedded migration and whether it has run.
+type MigrationStatus struct {
+	Migration
+	AppliedUnix int64 // 0 when pending
+}
+
+// GetMigrationStatus lists every embedded migration with the time it was applied.
+func GetMigrationStatus(db *sql.DB, driver string) ([]MigrationStatus, error) {
+	all, err := loadMigrations(driver)
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureMigrationsTable(db); err != nil {
+		return nil, err
+	}
+	applied, err := GetAppliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := pendingMigrations(all, applied); err != nil {
+		return nil, err
+	}
+
+	out := make([]MigrationStatus, 0, len(all))
+	for _, m := range all {
+		out = append(out, MigrationStatus{Migration: m, AppliedUnix: applied[m.Version].AppliedUnix})
+	}
+	return out, nil
+}
+
+// runMigrateCommand implements "main migrate". It applies pending migrations
+// to the database and exits, or with -status only lists them.
+func runMigrateCommand(db *sql.DB, driver string, args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "run pending migrations in a transaction that is rolled back")
+	status := flags.Bool("status", false, "list migrations and whether each has been applied")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *status {
+		list, err := GetMigrationStatus(db, driver)
+		if err != nil {
+			return err
+		}
+		for _, s := range list {
+			state := "pending"
+			if s.AppliedUnix != 0 {
+				state = "applied " + time.Unix(s.AppliedUnix, 0).UTC().Format(time.RFC3339)
+			}
+			fmt.Fprintf(os.Stdout, "%04d_%s\t%s\t%s\n", s.Version, s.Name, s.Checksum[:12], state)
+		}
+		return nil
+	}
+
+	ran, err := Migrate(db, driver, *dryRun)
+	verb := "applied"
+	if *dryRun {
+		verb = "would apply"
+	}
+	for _, m := range ran {
+		fmt.Fprintf(os.Stdout, "%s %04d_%s\n", verb, m.Version, m.Name)
+	}
+	if err != nil {
+		return err
+	}
+	if len(ran) == 0 {
+		fmt.Fprintln(os.Stdout, "schema is up to date")
+	}
+	return nil
+}
+
+// migrateOnStartup applies pending migrations before the server starts
+// serving, logging each one.
+func migrateOnStartup(db *sql.DB, driver string) error {
+	ran, err := Migrate(db, driver, false)
+	for _, m := range ran {
+		logInfo("applied migration %04d_%s", m.Version, m.Name)
+	}
+	return err
+}
@@ -0,0 +1,190 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Tests for migrate.go: a database built from the old rt.sql is adopted with
+// the columns its tables lack, including one an earlier build already
+// migrated without them, and payments recorded before migration 0008 are
+// allocated when it runs.
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// openLegacyDB creates a SQLite database from testdata/legacy_rt.sql with an
+// owner, a property, a unit, a tenant and a lease, and returns the store and
+// the IDs of the owner and tenant.
+func openLegacyDB(t *testing.T) (s *Store, ownerID, tenantID int) {
+	t.Helper()
+	schema, err := os.ReadFile(filepath.Join("testdata", "legacy_rt.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = OpenStore(DriverSQLite, filepath.Join(t.TempDir(), "rt.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.DB.Close() })
+	if _, err := s.DB.Exec(string(schema)); err != nil {
+		t.Fatalf("load rt.sql: %v", err)
+	}
+	steps := []string{
+		`INSERT INTO users (userFirstName, userLastName, userEmail, userPasswordHash) VALUES ('Olive', 'Owner', 'olive@example.com', 'x')`,
+		`INSERT INTO properties (ownerUserId, propertyStreetAddress) VALUES (1, '1 Main St')`,
+		`INSERT INTO propertyUnits (propertyId, propertyUnitNumber) VALUES (1, '1A')`,
+		`INSERT INTO tenants (tenantFirstName, tenantLastName, tenantEmailAddress, tenantPhoneNumber) VALUES ('Tom', 'Tenant', 'tom@example.com', '555-0100')`,
+		`INSERT INTO leases (tenantId, propertyUnitId, leaseStartUnix, leaseRentAmount) VALUES (1, 1, 1700000000, 1000)`,
+	}
+	for _, q := range steps {
+		if _, err := s.DB.Exec(q); err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+	}
+	return s, 1, 1
+}
+
+// checkAdopted fails unless the tenant was credited to the owner and can be
+// read and listed through the repositories.
+func checkAdopted(t *testing.T, s *Store, ownerID, tenantID int) {
+	t.Helper()
+	var owner sql.NullInt64
+	if err := s.DB.QueryRow(`SELECT ownerUserId FROM tenants WHERE tenantId = ?`, tenantID).Scan(&owner); err != nil {
+		t.Fatalf("read tenants.ownerUserId: %v", err)
+	}
+	if !owner.Valid || int(owner.Int64) != ownerID {
+		t.Errorf("tenants.ownerUserId = %v, want %d", owner, ownerID)
+	}
+	if _, err := s.Tenants.GetByID(ownerID, tenantID); err != nil {
+		t.Errorf("get tenant: %v", err)
+	}
+	p, err := ParseListParams(httptest.NewRequest(http.MethodGet, "/v1/tenants", nil), tenantListSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := s.Tenants.List(ownerID, p)
+	if err != nil {
+		t.Fatalf("list tenants: %v", err)
+	}
+	if len(page.Data) != 1 {
+		t.Errorf("listed %d tenants, want 1", len(page.Data))
+	}
+}
+
+func TestMigrateAdoptsLegacyDatabase(t *testing.T) {
+	s, ownerID, tenantID := openLegacyDB(t)
+	ran, err := Migrate(s.DB, DriverSQLite, false)
+	if err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	all, _ := loadMigrations(DriverSQLite)
+	if len(ran) != len(all) {
+		t.Errorf("applied %d migrations, want %d", len(ran), len(all))
+	}
+	checkAdopted(t, s, ownerID, tenantID)
+
+	ran, err = Migrate(s.DB, DriverSQLite, false)
+	if err != nil || len(ran) != 0 {
+		t.Errorf("second migrate applied %d, err %v; want nothing", len(ran), err)
+	}
+}
+
+func TestMigrateRepairsLegacyDatabaseAdoptedWithoutColumns(t *testing.T) {
+	s, ownerID, tenantID := openLegacyDB(t)
+
+	// Apply every migration the way builds before adoptLegacySchema did
+	if err := ensureMigrationsTable(s.DB); err != nil {
+		t.Fatal(err)
+	}
+	all, err := loadMigrations(DriverSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range all {
+		tx, err := s.DB.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := applyMigration(tx, m); err != nil {
+			t.Fatalf("migration %04d_%s: %v", m.Version, m.Name, err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Tenants.GetByID(ownerID, tenantID); err == nil {
+		t.Fatal("tenant readable before the repair; the test no longer reproduces the missing column")
+	}
+
+	if _, err := Migrate(s.DB, DriverSQLite, false); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	checkAdopted(t, s, ownerID, tenantID)
+}
+
+func TestMigrationAllocatesEarlierPayments(t *testing.T) {
+	s, err := OpenStore(DriverSQLite, filepath.Join(t.TempDir(), "rt.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.DB.Close() })
+	if err := ensureMigrationsTable(s.DB); err != nil {
+		t.Fatal(err)
+	}
+	all, err := loadMigrations(DriverSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range all {
+		if m.Version >= 8 {
+			break
+		}
+		tx, err := s.DB.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := applyMigration(tx, m); err != nil {
+			t.Fatalf("migration %04d_%s: %v", m.Version, m.Name, err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A charge and a payment recorded before allocations existed
+	steps := []string{
+		`INSERT INTO users (userFirstName, userLastName, userEmail, userPasswordHash) VALUES ('Olive', 'Owner', 'olive@example.com', 'x')`,
+		`INSERT INTO properties (ownerUserId, propertyStreetAddress) VALUES (1, '1 Main St')`,
+		`INSERT INTO propertyUnits (propertyId, propertyUnitNumber) VALUES (1, '1A')`,
+		`INSERT INTO tenants (ownerUserId, tenantFirstName, tenantLastName) VALUES (1, 'Tom', 'Tenant')`,
+		`INSERT INTO leases (tenantId, propertyUnitId, leaseStartUnix, leaseRentAmount) VALUES (1, 1, 1700000000, 1000)`,
+		`INSERT INTO charges (leaseId, chargeType, chargePeriod, chargeAmount, chargeDueUnix, createdUnix) VALUES (1, 'rent', '2023-12', 1000, 1701388800, 1701388800)`,
+		`INSERT INTO payments (leaseId, paymentAmount, paymentDateUnix) VALUES (1, 600, 1701475200)`,
+	}
+	for _, q := range steps {
+		if _, err := s.DB.Exec(q); err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+	}
+
+	if _, err := Migrate(s.DB, DriverSQLite, false); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	allocs, unallocated, err := s.Payments.Allocations(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(allocs) != 1 || allocs[0].Amount != 600 || unallocated != 0 {
+		t.Errorf("allocations = %+v with %d left over, want 600 to the rent charge", allocs, unallocated)
+	}
+}
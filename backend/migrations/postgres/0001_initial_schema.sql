@@ -0,0 +1,329 @@
+-- 0001: initial schema, PostgreSQL version of sqlite/0001_initial_schema.sql.
+-- Unquoted identifiers fold to lower case in PostgreSQL; queries use the same
+-- camelCase names unquoted, so they resolve the same way. Unix timestamps are
+-- BIGINT and calendar months are compared in UTC, as SQLite does.
+
+-- USERS (landlords, managers, assistants, etc.)
+CREATE TABLE users (
+    userId INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    userFirstName TEXT NOT NULL,
+    userLastName TEXT NOT NULL,
+    userEmail TEXT UNIQUE NOT NULL,
+    userPhoneNumber TEXT,
+    userPasswordHash TEXT NOT NULL,
+    userRole TEXT DEFAULT 'owner' -- owner, manager, assistant
+);
+
+-- AUTH SESSIONS (one per login; revoking a session invalidates its tokens)
+CREATE TABLE authSessions (
+    sessionId TEXT PRIMARY KEY,
+    userId INTEGER NOT NULL REFERENCES users(userId) ON DELETE CASCADE,
+    createdUnix BIGINT NOT NULL,
+    revokedUnix BIGINT
+);
+
+-- REFRESH TOKENS (hashed, single use, rotated on every refresh)
+CREATE TABLE refreshTokens (
+    tokenHash TEXT PRIMARY KEY,
+    sessionId TEXT NOT NULL REFERENCES authSessions(sessionId) ON DELETE CASCADE,
+    userId INTEGER NOT NULL REFERENCES users(userId) ON DELETE CASCADE,
+    issuedUnix BIGINT NOT NULL,
+    expiresUnix BIGINT NOT NULL,
+    usedUnix BIGINT
+);
+
+-- PASSWORD RESET TOKENS (hashed, single use, short-lived)
+CREATE TABLE passwordResetTokens (
+    tokenHash TEXT PRIMARY KEY,
+    userId INTEGER NOT NULL REFERENCES users(userId) ON DELETE CASCADE,
+    createdUnix BIGINT NOT NULL,
+    expiresUnix BIGINT NOT NULL,
+    usedUnix BIGINT
+);
+
+-- API KEYS (long-lived credentials for scripts; hashed, optionally read-only and expiring)
+CREATE TABLE apiKeys (
+    apiKeyId INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    userId INTEGER NOT NULL REFERENCES users(userId) ON DELETE CASCADE,
+    keyName TEXT NOT NULL,
+    keyPrefix TEXT NOT NULL,
+    keyHash TEXT UNIQUE NOT NULL,
+    readOnly BOOLEAN NOT NULL DEFAULT FALSE,
+    createdUnix BIGINT NOT NULL,
+    expiresUnix BIGINT,
+    lastUsedUnix BIGINT,
+    revokedUnix BIGINT
+);
+
+-- LOGIN ATTEMPTS (every /login try; drives lockout and is reviewable by owners)
+CREATE TABLE loginAttempts (
+    loginAttemptId INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    attemptEmail TEXT NOT NULL, -- lowercased as submitted, even if no account matches
+    userId INTEGER REFERENCES users(userId) ON DELETE SET NULL,
+    ipAddress TEXT NOT NULL,
+    outcome TEXT NOT NULL, -- success, failure, locked
+    attemptedUnix BIGINT NOT NULL
+);
+CREATE INDEX idx_loginAttempts_email ON loginAttempts(attemptEmail, attemptedUnix);
+CREATE INDEX idx_loginAttempts_ip ON loginAttempts(ipAddress, attemptedUnix);
+
+-- TWO-FACTOR AUTH (RFC 6238 TOTP; enabledUnix is set once the first code is confirmed)
+CREATE TABLE userTotp (
+    userId INTEGER PRIMARY KEY REFERENCES users(userId) ON DELETE CASCADE,
+    totpSecret TEXT NOT NULL,
+    enabledUnix BIGINT,
+    lastUsedStep INTEGER NOT NULL DEFAULT 0 -- blocks replay of an accepted code
+);
+
+-- 2FA RECOVERY CODES (hashed, single use)
+CREATE TABLE recoveryCodes (
+    codeHash TEXT PRIMARY KEY,
+    userId INTEGER NOT NULL REFERENCES users(userId) ON DELETE CASCADE,
+    usedUnix BIGINT
+);
+
+-- LOGIN CHALLENGES (issued after the password step when 2FA is enabled)
+CREATE TABLE loginChallenges (
+    challengeHash TEXT PRIMARY KEY,
+    userId INTEGER NOT NULL REFERENCES users(userId) ON DELETE CASCADE,
+    createdUnix BIGINT NOT NULL,
+    expiresUnix BIGINT NOT NULL,
+    usedUnix BIGINT,
+    failedAttempts INTEGER NOT NULL DEFAULT 0
+);
+
+-- PROPERTIES (owned by users)
+CREATE TABLE properties (
+    propertyId INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    ownerUserId INTEGER REFERENCES users(userId),
+    propertyName TEXT,
+    propertyStreetAddress TEXT,
+    propertyCity TEXT,
+    propertyState TEXT,
+    propertyZip TEXT,
+    propertyType TEXT, -- single-family, multi-family, apartment
+    propertyYearBuilt INTEGER,
+    propertyNotes TEXT
+);
+
+-- PROPERTY ACCESS (grants staff access to properties they do not own)
+CREATE TABLE propertyAccess (
+    propertyId INTEGER NOT NULL REFERENCES properties(propertyId) ON DELETE CASCADE,
+    userId INTEGER NOT NULL REFERENCES users(userId) ON DELETE CASCADE,
+    grantedByUserId INTEGER REFERENCES users(userId),
+    grantedUnix BIGINT NOT NULL,
+    PRIMARY KEY (propertyId, userId)
+);
+
+-- UNITS (belonging to properties)
+CREATE TABLE propertyUnits (
+    propertyUnitId INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    propertyId INTEGER REFERENCES properties(propertyId),
+    propertyUnitNumber TEXT,
+    propertyUnitBeds INTEGER,
+    propertyUnitBaths INTEGER,
+    propertyUnitSqFt INTEGER,
+    propertyUnitRentDefault INTEGER,
+    propertyUnitNotes TEXT
+);
+
+-- TENANTS (people who sign leases)
+CREATE TABLE tenants (
+    tenantId INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    ownerUserId INTEGER REFERENCES users(userId), -- landlord account that created the tenant
+    tenantFirstName TEXT NOT NULL,
+    tenantLastName TEXT NOT NULL,
+    tenantEmailAddress TEXT,
+    tenantPhoneNumber TEXT
+);
+
+-- TENANT PORTAL ACCOUNTS (tenant logins, created by invitation)
+CREATE TABLE tenantAccounts (
+    tenantId INTEGER PRIMARY KEY REFERENCES tenants(tenantId) ON DELETE CASCADE,
+    accountEmail TEXT NOT NULL UNIQUE, -- lowercased
+    passwordHash TEXT, -- NULL until the invite is accepted
+    invitedByUserId INTEGER REFERENCES users(userId) ON DELETE SET NULL,
+    invitedUnix BIGINT NOT NULL,
+    activatedUnix BIGINT,
+    disabledUnix BIGINT
+);
+
+CREATE TABLE tenantInvites (
+    tokenHash TEXT PRIMARY KEY, -- SHA-256 of the token emailed to the tenant
+    tenantId INTEGER NOT NULL REFERENCES tenants(tenantId) ON DELETE CASCADE,
+    createdUnix BIGINT NOT NULL,
+    expiresUnix BIGINT NOT NULL,
+    usedUnix BIGINT
+);
+
+CREATE TABLE tenantSessions (
+    sessionId TEXT PRIMARY KEY,
+    tenantId INTEGER NOT NULL REFERENCES tenants(tenantId) ON DELETE CASCADE,
+    createdUnix BIGINT NOT NULL,
+    revokedUnix BIGINT
+);
+
+-- LEASES (link tenants to units over time)
+CREATE TABLE leases (
+    leaseId INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    tenantId INTEGER REFERENCES tenants(tenantId),
+    propertyUnitId INTEGER REFERENCES propertyUnits(propertyUnitId),
+    leaseStartUnix BIGINT NOT NULL,
+    leaseEndUnix BIGINT,
+    leaseRentAmount INTEGER NOT NULL,
+    leaseSecurityDeposit INTEGER,
+    leaseDocumentLink TEXT,
+    leaseStatus TEXT DEFAULT 'active' -- active, expired, renewed
+);
+
+-- PAYMENTS (linked to leases, not directly to tenants)
+CREATE TABLE payments (
+    paymentId INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    leaseId INTEGER REFERENCES leases(leaseId),
+    paymentAmount INTEGER NOT NULL,
+    paymentDateUnix BIGINT NOT NULL,
+    paymentMethod TEXT, -- cash, check, ACH, Zelle, etc.
+    paymentNotes TEXT,
+    paymentConfirmation BYTEA
+);
+
+-- MAINTENANCE REQUESTS (per unit, may involve lease but usually tied to unit)
+CREATE TABLE maintenanceRequests (
+    maintenanceRequestId INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    propertyUnitId INTEGER REFERENCES propertyUnits(propertyUnitId),
+    leaseId INTEGER REFERENCES leases(leaseId), -- optional, if tenant reported
+    maintenanceRequestInfo TEXT NOT NULL,
+    maintenanceRequestPriority TEXT DEFAULT 'normal', -- low, normal, urgent
+    maintenanceRequestCategory TEXT, -- plumbing, HVAC, etc.
+    maintenanceRequestStatus TEXT DEFAULT 'open', -- open, in progress, completed
+    maintenanceRequestCreatedUnix BIGINT NOT NULL,
+    maintenanceRequestCompletedUnix BIGINT,
+    maintenanceAssignedTo TEXT
+);
+
+-- ACTIVITY LOG (audit trail, optional)
+CREATE TABLE activityLogs (
+    logId INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    userId INTEGER REFERENCES users(userId),
+    entityType TEXT, -- e.g. 'payment', 'maintenance', 'lease'
+    entityId INTEGER,
+    action TEXT, -- created, updated, deleted
+    timestampUnix BIGINT NOT NULL
+);
+
+
+-- == Views =====================================================================
+-- Overdue Rent (dashboard)
+
+-- Overdue: No payment for the current month (from the 1st)
+CREATE VIEW overduePayments AS
+SELECT 
+    l.leaseId as leaseId,
+    p.propertyId AS propertyId,
+    t.tenantFirstName AS firstName,
+    t.tenantLastName AS lastName,
+    p.propertyStreetAddress AS address,
+    u.propertyUnitNumber AS unit,
+    l.leaseRentAmount AS rentAmount,
+    COALESCE(
+        (
+            SELECT MAX(pay.paymentDateUnix)
+            FROM payments pay
+            WHERE pay.leaseId = l.leaseId
+                AND date_trunc('month', to_timestamp(pay.paymentDateUnix) AT TIME ZONE 'UTC') = date_trunc('month', now() AT TIME ZONE 'UTC')
+        ), 0
+    ) AS lastPaymentUnix,
+    CASE 
+        WHEN (
+            SELECT COUNT(*)
+            FROM payments pay
+            WHERE pay.leaseId = l.leaseId
+                AND date_trunc('month', to_timestamp(pay.paymentDateUnix) AT TIME ZONE 'UTC') = date_trunc('month', now() AT TIME ZONE 'UTC')
+        ) = 0 THEN 'Overdue'
+        ELSE 'Current'
+    END AS paymentStatus
+FROM leases l
+JOIN tenants t ON l.tenantId = t.tenantId
+JOIN propertyUnits u ON l.propertyUnitId = u.propertyUnitId
+JOIN properties p ON u.propertyId = p.propertyId
+WHERE l.leaseStatus = 'active'
+    -- Only include leases that do NOT have a payment for the current month
+    AND (
+        SELECT COUNT(*)
+        FROM payments pay
+        WHERE pay.leaseId = l.leaseId
+            AND date_trunc('month', to_timestamp(pay.paymentDateUnix) AT TIME ZONE 'UTC') = date_trunc('month', now() AT TIME ZONE 'UTC')
+    ) = 0;
+
+
+-- Maintenance requests (dashboard)
+CREATE VIEW maintenanceRequestsView AS
+SELECT
+    m.maintenanceRequestId AS maintenanceRequestId,
+    u.propertyId AS propertyId,
+    t.tenantFirstName AS firstName,
+    t.tenantLastName AS lastName,
+    p.propertyStreetAddress AS address,
+    u.propertyUnitNumber AS unit,
+    m.maintenanceRequestInfo AS description,
+    m.maintenanceRequestStatus AS maintenanceStatus,
+    m.maintenanceRequestCreatedUnix AS dateCreated,
+    m.maintenanceRequestPriority AS priority,
+    m.maintenanceRequestCategory AS category
+FROM maintenanceRequests m
+LEFT JOIN leases l ON m.leaseId = l.leaseId
+LEFT JOIN tenants t ON l.tenantId = t.tenantId
+LEFT JOIN propertyUnits u ON m.propertyUnitId = u.propertyUnitId
+LEFT JOIN properties p ON u.propertyId = p.propertyId
+WHERE m.maintenanceRequestStatus != 'completed';
+
+-- Lease renewals (dashboard)
+CREATE VIEW leasesView AS
+SELECT
+    p.propertyId AS propertyId,
+    t.tenantFirstName AS firstName,
+    t.tenantLastName AS lastName,
+    p.propertyStreetAddress AS address,
+    u.propertyUnitNumber AS unit,
+    l.leaseStartUnix AS leaseStartDate,
+    l.leaseRentAmount AS rentAmount,
+    l.leaseStatus AS leaseStatus
+FROM leases l
+JOIN tenants t ON l.tenantId = t.tenantId
+JOIN propertyUnits u ON l.propertyUnitId = u.propertyUnitId
+JOIN properties p ON u.propertyId = p.propertyId;
+
+-- Upcoming Rent (next due payments)
+
+-- Upcoming: Rent for next month not yet paid
+CREATE VIEW upcomingPayments AS
+SELECT 
+    l.leaseId as leaseId,
+    p.propertyId AS propertyId,
+    t.tenantFirstName AS firstName,
+    t.tenantLastName AS lastName,
+    p.propertyStreetAddress AS address,
+    u.propertyUnitNumber AS unit,
+    l.leaseRentAmount AS rentAmount,
+    COALESCE(
+        (
+            SELECT MAX(pay.paymentDateUnix)
+            FROM payments pay
+            WHERE pay.leaseId = l.leaseId
+                AND date_trunc('month', to_timestamp(pay.paymentDateUnix) AT TIME ZONE 'UTC') = date_trunc('month', now() AT TIME ZONE 'UTC')
+        ), 0
+    ) AS lastPaymentUnix,
+    CASE 
+        WHEN (
+            SELECT COUNT(*)
+            FROM payments pay
+            WHERE pay.leaseId = l.leaseId
+                AND date_trunc('month', to_timestamp(pay.paymentDateUnix) AT TIME ZONE 'UTC') = date_trunc('month', now() AT TIME ZONE 'UTC')
+        ) = 0 THEN 'Due'
+        ELSE 'Paid'
+    END AS paymentStatus
+FROM leases l
+JOIN tenants t ON l.tenantId = t.tenantId
+JOIN propertyUnits u ON l.propertyUnitId = u.propertyUnitId
+JOIN properties p ON u.propertyId = p.propertyId
+WHERE l.leaseStatus = 'active';
@@ -0,0 +1,152 @@
+-- 0002: full-text search index, PostgreSQL version of sqlite/0002_search_index.sql.
+-- searchDocuments renders each searchable row as a (title, body) document;
+-- searchIndex stores them with a weighted tsvector (title above body), kept in
+-- sync by the trigger functions below.
+
+CREATE VIEW searchDocuments AS
+SELECT 'tenant'::TEXT AS entityType, t.tenantId AS entityId,
+       t.tenantFirstName || ' ' || t.tenantLastName AS title,
+       COALESCE(t.tenantEmailAddress, '') || ' ' || COALESCE(t.tenantPhoneNumber, '') AS body
+FROM tenants t
+UNION ALL
+SELECT 'property', p.propertyId,
+       COALESCE(p.propertyName, ''),
+       COALESCE(p.propertyStreetAddress, '') || ' ' || COALESCE(p.propertyCity, '') || ' ' ||
+       COALESCE(p.propertyState, '') || ' ' || COALESCE(p.propertyZip, '') || ' ' ||
+       COALESCE(p.propertyType, '') || ' ' || COALESCE(p.propertyNotes, '')
+FROM properties p
+UNION ALL
+SELECT 'unit', u.propertyUnitId,
+       'Unit ' || COALESCE(u.propertyUnitNumber, '') || COALESCE(', ' || p.propertyName, ''),
+       COALESCE(u.propertyUnitNotes, '') || ' ' || COALESCE(p.propertyStreetAddress, '') || ' ' ||
+       COALESCE(p.propertyCity, '')
+FROM propertyUnits u
+LEFT JOIN properties p ON p.propertyId = u.propertyId
+UNION ALL
+SELECT 'maintenance', m.maintenanceRequestId,
+       m.maintenanceRequestInfo,
+       COALESCE(m.maintenanceRequestCategory, '') || ' ' || COALESCE(m.maintenanceRequestStatus, '') || ' ' ||
+       COALESCE(m.maintenanceAssignedTo, '') || ' Unit ' || COALESCE(u.propertyUnitNumber, '') || ' ' ||
+       COALESCE(p.propertyName, '') || ' ' || COALESCE(p.propertyStreetAddress, '')
+FROM maintenanceRequests m
+LEFT JOIN propertyUnits u ON u.propertyUnitId = m.propertyUnitId
+LEFT JOIN properties p ON p.propertyId = u.propertyId
+UNION ALL
+SELECT 'payment', pay.paymentId,
+       'Payment of ' || pay.paymentAmount || COALESCE(' by ' || pay.paymentMethod, ''),
+       COALESCE(pay.paymentNotes, '')
+FROM payments pay;
+
+CREATE TABLE searchIndex (
+    entityType TEXT NOT NULL,
+    entityId INTEGER NOT NULL,
+    title TEXT NOT NULL,
+    body TEXT NOT NULL,
+    document TSVECTOR GENERATED ALWAYS AS (
+        setweight(to_tsvector('english', title), 'A') || setweight(to_tsvector('english', body), 'B')
+    ) STORED,
+    PRIMARY KEY (entityType, entityId)
+);
+
+CREATE INDEX searchIndexDocument ON searchIndex USING GIN (document);
+
+INSERT INTO searchIndex (entityType, entityId, title, body)
+SELECT entityType, entityId, title, body FROM searchDocuments;
+
+-- searchReindex replaces the documents of one entity type for the given ids.
+CREATE FUNCTION searchReindex(kind TEXT, ids INTEGER[]) RETURNS void AS $$
+BEGIN
+    DELETE FROM searchIndex WHERE entityType = kind AND entityId = ANY (ids);
+    INSERT INTO searchIndex (entityType, entityId, title, body)
+    SELECT entityType, entityId, title, body FROM searchDocuments
+    WHERE entityType = kind AND entityId = ANY (ids);
+END;
+$$ LANGUAGE plpgsql;
+
+-- == Tenants ===================================================================
+CREATE FUNCTION searchTenantsSync() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        DELETE FROM searchIndex WHERE entityType = 'tenant' AND entityId = OLD.tenantId;
+        RETURN OLD;
+    END IF;
+    PERFORM searchReindex('tenant', ARRAY[NEW.tenantId]);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER searchTenants AFTER INSERT OR UPDATE OR DELETE ON tenants
+    FOR EACH ROW EXECUTE FUNCTION searchTenantsSync();
+
+-- == Properties ================================================================
+-- Unit and maintenance documents include the property name and address, so
+-- they are rebuilt when a property changes.
+CREATE FUNCTION searchPropertiesSync() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        DELETE FROM searchIndex WHERE entityType = 'property' AND entityId = OLD.propertyId;
+        RETURN OLD;
+    END IF;
+    PERFORM searchReindex('property', ARRAY[NEW.propertyId]);
+    IF TG_OP = 'UPDATE' THEN
+        PERFORM searchReindex('unit', ARRAY(SELECT propertyUnitId FROM propertyUnits WHERE propertyId = NEW.propertyId));
+        PERFORM searchReindex('maintenance', ARRAY(
+            SELECT m.maintenanceRequestId FROM maintenanceRequests m
+            JOIN propertyUnits u ON u.propertyUnitId = m.propertyUnitId
+            WHERE u.propertyId = NEW.propertyId));
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER searchProperties AFTER INSERT OR UPDATE OR DELETE ON properties
+    FOR EACH ROW EXECUTE FUNCTION searchPropertiesSync();
+
+-- == Units =====================================================================
+CREATE FUNCTION searchUnitsSync() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        DELETE FROM searchIndex WHERE entityType = 'unit' AND entityId = OLD.propertyUnitId;
+        RETURN OLD;
+    END IF;
+    PERFORM searchReindex('unit', ARRAY[NEW.propertyUnitId]);
+    IF TG_OP = 'UPDATE' THEN
+        PERFORM searchReindex('maintenance', ARRAY(
+            SELECT maintenanceRequestId FROM maintenanceRequests WHERE propertyUnitId = NEW.propertyUnitId));
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER searchUnits AFTER INSERT OR UPDATE OR DELETE ON propertyUnits
+    FOR EACH ROW EXECUTE FUNCTION searchUnitsSync();
+
+-- == Maintenance requests ======================================================
+CREATE FUNCTION searchMaintenanceSync() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        DELETE FROM searchIndex WHERE entityType = 'maintenance' AND entityId = OLD.maintenanceRequestId;
+        RETURN OLD;
+    END IF;
+    PERFORM searchReindex('maintenance', ARRAY[NEW.maintenanceRequestId]);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER searchMaintenance AFTER INSERT OR UPDATE OR DELETE ON maintenanceRequests
+    FOR EACH ROW EXECUTE FUNCTION searchMaintenanceSync();
+
+-- == Payments ==================================================================
+CREATE FUNCTION searchPaymentsSync() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        DELETE FROM searchIndex WHERE entityType = 'payment' AND entityId = OLD.paymentId;
+        RETURN OLD;
+    END IF;
+    PERFORM searchReindex('payment', ARRAY[NEW.paymentId]);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER searchPayments AFTER INSERT OR UPDATE OR DELETE ON payments
+    FOR EACH ROW EXECUTE FUNCTION searchPaymentsSync();
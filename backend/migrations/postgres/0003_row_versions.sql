@@ -0,0 +1,11 @@
+-- 0003: row versions for optimistic concurrency, PostgreSQL version of
+-- sqlite/0003_row_versions.sql.
+
+ALTER TABLE users ADD COLUMN version INTEGER NOT NULL DEFAULT 1, ADD COLUMN updatedUnix BIGINT;
+ALTER TABLE properties ADD COLUMN version INTEGER NOT NULL DEFAULT 1, ADD COLUMN updatedUnix BIGINT;
+ALTER TABLE propertyUnits ADD COLUMN version INTEGER NOT NULL DEFAULT 1, ADD COLUMN updatedUnix BIGINT;
+ALTER TABLE tenants ADD COLUMN version INTEGER NOT NULL DEFAULT 1, ADD COLUMN updatedUnix BIGINT;
+ALTER TABLE leases ADD COLUMN version INTEGER NOT NULL DEFAULT 1, ADD COLUMN updatedUnix BIGINT;
+ALTER TABLE payments ADD COLUMN version INTEGER NOT NULL DEFAULT 1, ADD COLUMN updatedUnix BIGINT;
+ALTER TABLE maintenanceRequests ADD COLUMN version INTEGER NOT NULL DEFAULT 1, ADD COLUMN updatedUnix BIGINT;
+ALTER TABLE activityLogs ADD COLUMN version INTEGER NOT NULL DEFAULT 1, ADD COLUMN updatedUnix BIGINT;
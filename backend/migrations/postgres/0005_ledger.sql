@@ -0,0 +1,77 @@
+-- 0005: rent ledger, PostgreSQL version of sqlite/0005_ledger.sql.
+
+CREATE TABLE charges (
+    chargeId INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    leaseId INTEGER NOT NULL REFERENCES leases(leaseId) ON DELETE CASCADE,
+    chargeType TEXT NOT NULL DEFAULT 'rent', -- rent, fee, adjustment
+    chargePeriod TEXT, -- YYYY-MM billing period of a rent charge
+    chargeAmount INTEGER NOT NULL, -- negative for a credit adjustment
+    chargeDueUnix BIGINT NOT NULL,
+    chargeDescription TEXT,
+    createdUnix BIGINT NOT NULL,
+    createdByUserId INTEGER REFERENCES users(userId) ON DELETE SET NULL, -- NULL when posted automatically
+    UNIQUE (leaseId, chargeType, chargePeriod) -- one rent charge per lease and period
+);
+
+CREATE INDEX idxChargesLeaseDue ON charges(leaseId, chargeDueUnix);
+
+-- == Views =====================================================================
+-- Per-lease totals: everything charged, what has fallen due by now, and what
+-- has been paid. The balance is charged - paid; the past-due amount is
+-- chargedToDate - paid when positive.
+CREATE VIEW leaseBalances AS
+SELECT
+    l.leaseId AS leaseId,
+    COALESCE((SELECT SUM(c.chargeAmount) FROM charges c WHERE c.leaseId = l.leaseId), 0) AS charged,
+    COALESCE((SELECT SUM(c.chargeAmount) FROM charges c
+        WHERE c.leaseId = l.leaseId AND c.chargeDueUnix <= CAST(EXTRACT(EPOCH FROM now()) AS BIGINT)), 0) AS chargedToDate,
+    COALESCE((SELECT SUM(pay.paymentAmount) FROM payments pay
+        WHERE pay.leaseId = l.leaseId AND pay.deletedUnix IS NULL), 0) AS paid
+FROM leases l
+WHERE l.deletedUnix IS NULL;
+
+DROP VIEW overduePayments;
+DROP VIEW upcomingPayments;
+
+-- Overdue: charges that have fallen due exceed the payments made, whatever
+-- month they are from and whether or not the lease is still active
+CREATE VIEW overduePayments AS
+SELECT
+    l.leaseId AS leaseId,
+    p.propertyId AS propertyId,
+    t.tenantFirstName AS firstName,
+    t.tenantLastName AS lastName,
+    p.propertyStreetAddress AS address,
+    u.propertyUnitNumber AS unit,
+    l.leaseRentAmount AS rentAmount,
+    COALESCE((SELECT MAX(pay.paymentDateUnix) FROM payments pay
+        WHERE pay.leaseId = l.leaseId AND pay.deletedUnix IS NULL), 0) AS lastPaymentUnix,
+    'Overdue' AS paymentStatus,
+    b.chargedToDate - b.paid AS amountDue
+FROM leases l
+JOIN leaseBalances b ON b.leaseId = l.leaseId
+JOIN tenants t ON l.tenantId = t.tenantId
+JOIN propertyUnits u ON l.propertyUnitId = u.propertyUnitId
+JOIN properties p ON u.propertyId = p.propertyId
+WHERE b.chargedToDate > b.paid;
+
+-- Upcoming: active leases with what is still owed on the charges posted so far
+CREATE VIEW upcomingPayments AS
+SELECT
+    l.leaseId AS leaseId,
+    p.propertyId AS propertyId,
+    t.tenantFirstName AS firstName,
+    t.tenantLastName AS lastName,
+    p.propertyStreetAddress AS address,
+    u.propertyUnitNumber AS unit,
+    l.leaseRentAmount AS rentAmount,
+    COALESCE((SELECT MAX(pay.paymentDateUnix) FROM payments pay
+        WHERE pay.leaseId = l.leaseId AND pay.deletedUnix IS NULL), 0) AS lastPaymentUnix,
+    CASE WHEN b.charged > b.paid THEN 'Due' ELSE 'Paid' END AS paymentStatus,
+    CASE WHEN b.charged > b.paid THEN b.charged - b.paid ELSE 0 END AS amountDue
+FROM leases l
+JOIN leaseBalances b ON b.leaseId = l.leaseId
+JOIN tenants t ON l.tenantId = t.tenantId
+JOIN propertyUnits u ON l.propertyUnitId = u.propertyUnitId
+JOIN properties p ON u.propertyId = p.propertyId
+WHERE l.leaseStatus = 'active';
@@ -0,0 +1,4 @@
+-- 0006: per-lease rent due day, PostgreSQL version of
+-- sqlite/0006_rent_due_day.sql.
+
+ALTER TABLE leases ADD COLUMN leaseRentDueDay INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,15 @@
+-- 0008: payment allocations, PostgreSQL version of
+-- sqlite/0008_payment_allocations.sql.
+
+CREATE TABLE paymentAllocations (
+    allocationId INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    chargeId INTEGER NOT NULL REFERENCES charges(chargeId) ON DELETE CASCADE,
+    paymentId INTEGER REFERENCES payments(paymentId) ON DELETE CASCADE,
+    creditChargeId INTEGER REFERENCES charges(chargeId) ON DELETE CASCADE,
+    allocatedAmount INTEGER NOT NULL,
+    allocationExplicit INTEGER NOT NULL DEFAULT 0, -- 1 when chosen by the client
+    CHECK ((paymentId IS NULL) <> (creditChargeId IS NULL))
+);
+
+CREATE INDEX idxPaymentAllocationsCharge ON paymentAllocations(chargeId);
+CREATE INDEX idxPaymentAllocationsPayment ON paymentAllocations(paymentId);
@@ -0,0 +1,11 @@
+-- 0009: who created each user, PostgreSQL version of
+-- sqlite/0009_user_creator.sql.
+
+ALTER TABLE users ADD COLUMN createdByUserId INTEGER REFERENCES users(userId) ON DELETE SET NULL;
+
+UPDATE users SET createdByUserId = (
+    SELECT MIN(p.ownerUserId) FROM propertyAccess pa JOIN properties p ON p.propertyId = pa.propertyId
+    WHERE pa.userId = users.userId)
+WHERE COALESCE(userRole, 'owner') <> 'owner'
+    AND (SELECT COUNT(DISTINCT p.ownerUserId) FROM propertyAccess pa JOIN properties p ON p.propertyId = pa.propertyId
+        WHERE pa.userId = users.userId) = 1;
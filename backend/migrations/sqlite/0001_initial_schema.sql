@@ -0,0 +1,328 @@
+-- 0001: initial schema (formerly rt.sql).
+-- Every statement is IF NOT EXISTS so a database created from rt.sql before
+-- migrations existed is adopted without losing data.
+
+-- USERS (landlords, managers, assistants, etc.)
+CREATE TABLE IF NOT EXISTS users (
+    userId INTEGER PRIMARY KEY AUTOINCREMENT,
+    userFirstName TEXT NOT NULL,
+    userLastName TEXT NOT NULL,
+    userEmail TEXT UNIQUE NOT NULL,
+    userPhoneNumber TEXT,
+    userPasswordHash TEXT NOT NULL,
+    userRole TEXT DEFAULT 'owner' -- owner, manager, assistant
+);
+
+-- AUTH SESSIONS (one per login; revoking a session invalidates its tokens)
+CREATE TABLE IF NOT EXISTS authSessions (
+    sessionId TEXT PRIMARY KEY,
+    userId INTEGER NOT NULL REFERENCES users(userId) ON DELETE CASCADE,
+    createdUnix INTEGER NOT NULL,
+    revokedUnix INTEGER
+);
+
+-- REFRESH TOKENS (hashed, single use, rotated on every refresh)
+CREATE TABLE IF NOT EXISTS refreshTokens (
+    tokenHash TEXT PRIMARY KEY,
+    sessionId TEXT NOT NULL REFERENCES authSessions(sessionId) ON DELETE CASCADE,
+    userId INTEGER NOT NULL REFERENCES users(userId) ON DELETE CASCADE,
+    issuedUnix INTEGER NOT NULL,
+    expiresUnix INTEGER NOT NULL,
+    usedUnix INTEGER
+);
+
+-- PASSWORD RESET TOKENS (hashed, single use, short-lived)
+CREATE TABLE IF NOT EXISTS passwordResetTokens (
+    tokenHash TEXT PRIMARY KEY,
+    userId INTEGER NOT NULL REFERENCES users(userId) ON DELETE CASCADE,
+    createdUnix INTEGER NOT NULL,
+    expiresUnix INTEGER NOT NULL,
+    usedUnix INTEGER
+);
+
+-- API KEYS (long-lived credentials for scripts; hashed, optionally read-only and expiring)
+CREATE TABLE IF NOT EXISTS apiKeys (
+    apiKeyId INTEGER PRIMARY KEY AUTOINCREMENT,
+    userId INTEGER NOT NULL REFERENCES users(userId) ON DELETE CASCADE,
+    keyName TEXT NOT NULL,
+    keyPrefix TEXT NOT NULL,
+    keyHash TEXT UNIQUE NOT NULL,
+    readOnly INTEGER NOT NULL DEFAULT 0,
+    createdUnix INTEGER NOT NULL,
+    expiresUnix INTEGER,
+    lastUsedUnix INTEGER,
+    revokedUnix INTEGER
+);
+
+-- LOGIN ATTEMPTS (every /login try; drives lockout and is reviewable by owners)
+CREATE TABLE IF NOT EXISTS loginAttempts (
+    loginAttemptId INTEGER PRIMARY KEY AUTOINCREMENT,
+    attemptEmail TEXT NOT NULL, -- lowercased as submitted, even if no account matches
+    userId INTEGER REFERENCES users(userId) ON DELETE SET NULL,
+    ipAddress TEXT NOT NULL,
+    outcome TEXT NOT NULL, -- success, failure, locked
+    attemptedUnix INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_loginAttempts_email ON loginAttempts(attemptEmail, attemptedUnix);
+CREATE INDEX IF NOT EXISTS idx_loginAttempts_ip ON loginAttempts(ipAddress, attemptedUnix);
+
+-- TWO-FACTOR AUTH (RFC 6238 TOTP; enabledUnix is set once the first code is confirmed)
+CREATE TABLE IF NOT EXISTS userTotp (
+    userId INTEGER PRIMARY KEY REFERENCES users(userId) ON DELETE CASCADE,
+    totpSecret TEXT NOT NULL,
+    enabledUnix INTEGER,
+    lastUsedStep INTEGER NOT NULL DEFAULT 0 -- blocks replay of an accepted code
+);
+
+-- 2FA RECOVERY CODES (hashed, single use)
+CREATE TABLE IF NOT EXISTS recoveryCodes (
+    codeHash TEXT PRIMARY KEY,
+    userId INTEGER NOT NULL REFERENCES users(userId) ON DELETE CASCADE,
+    usedUnix INTEGER
+);
+
+-- LOGIN CHALLENGES (issued after the password step when 2FA is enabled)
+CREATE TABLE IF NOT EXISTS loginChallenges (
+    challengeHash TEXT PRIMARY KEY,
+    userId INTEGER NOT NULL REFERENCES users(userId) ON DELETE CASCADE,
+    createdUnix INTEGER NOT NULL,
+    expiresUnix INTEGER NOT NULL,
+    usedUnix INTEGER,
+    failedAttempts INTEGER NOT NULL DEFAULT 0
+);
+
+-- PROPERTIES (owned by users)
+CREATE TABLE IF NOT EXISTS properties (
+    propertyId INTEGER PRIMARY KEY AUTOINCREMENT,
+    ownerUserId INTEGER REFERENCES users(userId),
+    propertyName TEXT,
+    propertyStreetAddress TEXT,
+    propertyCity TEXT,
+    propertyState TEXT,
+    propertyZip TEXT,
+    propertyType TEXT, -- single-family, multi-family, apartment
+    propertyYearBuilt INTEGER,
+    propertyNotes TEXT
+);
+
+-- PROPERTY ACCESS (grants staff access to properties they do not own)
+CREATE TABLE IF NOT EXISTS propertyAccess (
+    propertyId INTEGER NOT NULL REFERENCES properties(propertyId) ON DELETE CASCADE,
+    userId INTEGER NOT NULL REFERENCES users(userId) ON DELETE CASCADE,
+    grantedByUserId INTEGER REFERENCES users(userId),
+    grantedUnix INTEGER NOT NULL,
+    PRIMARY KEY (propertyId, userId)
+);
+
+-- UNITS (belonging to properties)
+CREATE TABLE IF NOT EXISTS propertyUnits (
+    propertyUnitId INTEGER PRIMARY KEY AUTOINCREMENT,
+    propertyId INTEGER REFERENCES properties(propertyId),
+    propertyUnitNumber TEXT,
+    propertyUnitBeds INTEGER,
+    propertyUnitBaths INTEGER,
+    propertyUnitSqFt INTEGER,
+    propertyUnitRentDefault INTEGER,
+    propertyUnitNotes TEXT
+);
+
+-- TENANTS (people who sign leases)
+CREATE TABLE IF NOT EXISTS tenants (
+    tenantId INTEGER PRIMARY KEY AUTOINCREMENT,
+    ownerUserId INTEGER REFERENCES users(userId), -- landlord account that created the tenant
+    tenantFirstName TEXT NOT NULL,
+    tenantLastName TEXT NOT NULL,
+    tenantEmailAddress TEXT,
+    tenantPhoneNumber TEXT
+);
+
+-- TENANT PORTAL ACCOUNTS (tenant logins, created by invitation)
+CREATE TABLE IF NOT EXISTS tenantAccounts (
+    tenantId INTEGER PRIMARY KEY REFERENCES tenants(tenantId) ON DELETE CASCADE,
+    accountEmail TEXT NOT NULL UNIQUE, -- lowercased
+    passwordHash TEXT, -- NULL until the invite is accepted
+    invitedByUserId INTEGER REFERENCES users(userId) ON DELETE SET NULL,
+    invitedUnix INTEGER NOT NULL,
+    activatedUnix INTEGER,
+    disabledUnix INTEGER
+);
+
+CREATE TABLE IF NOT EXISTS tenantInvites (
+    tokenHash TEXT PRIMARY KEY, -- SHA-256 of the token emailed to the tenant
+    tenantId INTEGER NOT NULL REFERENCES tenants(tenantId) ON DELETE CASCADE,
+    createdUnix INTEGER NOT NULL,
+    expiresUnix INTEGER NOT NULL,
+    usedUnix INTEGER
+);
+
+CREATE TABLE IF NOT EXISTS tenantSessions (
+    sessionId TEXT PRIMARY KEY,
+    tenantId INTEGER NOT NULL REFERENCES tenants(tenantId) ON DELETE CASCADE,
+    createdUnix INTEGER NOT NULL,
+    revokedUnix INTEGER
+);
+
+-- LEASES (link tenants to units over time)
+CREATE TABLE IF NOT EXISTS leases (
+    leaseId INTEGER PRIMARY KEY AUTOINCREMENT,
+    tenantId INTEGER REFERENCES tenants(tenantId),
+    propertyUnitId INTEGER REFERENCES propertyUnits(propertyUnitId),
+    leaseStartUnix INTEGER NOT NULL,
+    leaseEndUnix INTEGER,
+    leaseRentAmount INTEGER NOT NULL,
+    leaseSecurityDeposit INTEGER,
+    leaseDocumentLink TEXT,
+    leaseStatus TEXT DEFAULT 'active' -- active, expired, renewed
+);
+
+-- PAYMENTS (linked to leases, not directly to tenants)
+CREATE TABLE IF NOT EXISTS payments (
+    paymentId INTEGER PRIMARY KEY AUTOINCREMENT,
+    leaseId INTEGER REFERENCES leases(leaseId),
+    paymentAmount INTEGER NOT NULL,
+    paymentDateUnix INTEGER NOT NULL,
+    paymentMethod TEXT, -- cash, check, ACH, Zelle, etc.
+    paymentNotes TEXT,
+    paymentConfirmation BLOB
+);
+
+-- MAINTENANCE REQUESTS (per unit, may involve lease but usually tied to unit)
+CREATE TABLE IF NOT EXISTS maintenanceRequests (
+    maintenanceRequestId INTEGER PRIMARY KEY AUTOINCREMENT,
+    propertyUnitId INTEGER REFERENCES propertyUnits(propertyUnitId),
+    leaseId INTEGER REFERENCES leases(leaseId), -- optional, if tenant reported
+    maintenanceRequestInfo TEXT NOT NULL,
+    maintenanceRequestPriority TEXT DEFAULT 'normal', -- low, normal, urgent
+    maintenanceRequestCategory TEXT, -- plumbing, HVAC, etc.
+    maintenanceRequestStatus TEXT DEFAULT 'open', -- open, in progress, completed
+    maintenanceRequestCreatedUnix INTEGER NOT NULL,
+    maintenanceRequestCompletedUnix INTEGER,
+    maintenanceAssignedTo TEXT
+);
+
+-- ACTIVITY LOG (audit trail, optional)
+CREATE TABLE IF NOT EXISTS activityLogs (
+    logId INTEGER PRIMARY KEY AUTOINCREMENT,
+    userId INTEGER REFERENCES users(userId),
+    entityType TEXT, -- e.g. 'payment', 'maintenance', 'lease'
+    entityId INTEGER,
+    action TEXT, -- created, updated, deleted
+    timestampUnix INTEGER NOT NULL
+);
+
+
+-- == Views =====================================================================
+-- Overdue Rent (dashboard)
+
+-- Overdue: No payment for the current month (from the 1st)
+CREATE VIEW IF NOT EXISTS overduePayments AS
+SELECT 
+    l.leaseId as leaseId,
+    p.propertyId AS propertyId,
+    t.tenantFirstName AS firstName,
+    t.tenantLastName AS lastName,
+    p.propertyStreetAddress AS address,
+    u.propertyUnitNumber AS unit,
+    l.leaseRentAmount AS rentAmount,
+    COALESCE(
+        (
+            SELECT MAX(pay.paymentDateUnix)
+            FROM payments pay
+            WHERE pay.leaseId = l.leaseId
+                AND strftime('%Y-%m', datetime(pay.paymentDateUnix, 'unixepoch')) = strftime('%Y-%m', 'now')
+        ), 0
+    ) AS lastPaymentUnix,
+    CASE 
+        WHEN (
+            SELECT COUNT(*)
+            FROM payments pay
+            WHERE pay.leaseId = l.leaseId
+                AND strftime('%Y-%m', datetime(pay.paymentDateUnix, 'unixepoch')) = strftime('%Y-%m', 'now')
+        ) = 0 THEN 'Overdue'
+        ELSE 'Current'
+    END AS paymentStatus
+FROM leases l
+JOIN tenants t ON l.tenantId = t.tenantId
+JOIN propertyUnits u ON l.propertyUnitId = u.propertyUnitId
+JOIN properties p ON u.propertyId = p.propertyId
+WHERE l.leaseStatus = 'active'
+    -- Only include leases that do NOT have a payment for the current month
+    AND (
+        SELECT COUNT(*)
+        FROM payments pay
+        WHERE pay.leaseId = l.leaseId
+            AND strftime('%Y-%m', datetime(pay.paymentDateUnix, 'unixepoch')) = strftime('%Y-%m', 'now')
+    ) = 0;
+
+
+-- Maintenance requests (dashboard)
+CREATE VIEW IF NOT EXISTS maintenanceRequestsView AS
+SELECT
+    m.maintenanceRequestId AS maintenanceRequestId,
+    u.propertyId AS propertyId,
+    t.tenantFirstName AS firstName,
+    t.tenantLastName AS lastName,
+    p.propertyStreetAddress AS address,
+    u.propertyUnitNumber AS unit,
+    m.maintenanceRequestInfo AS description,
+    m.maintenanceRequestStatus AS maintenanceStatus,
+    m.maintenanceRequestCreatedUnix AS dateCreated,
+    m.maintenanceRequestPriority AS priority,
+    m.maintenanceRequestCategory AS category
+FROM maintenanceRequests m
+LEFT JOIN leases l ON m.leaseId = l.leaseId
+LEFT JOIN tenants t ON l.tenantId = t.tenantId
+LEFT JOIN propertyUnits u ON m.propertyUnitId = u.propertyUnitId
+LEFT JOIN properties p ON u.propertyId = p.propertyId
+WHERE m.maintenanceRequestStatus != 'completed';
+
+-- Lease renewals (dashboard)
+CREATE VIEW IF NOT EXISTS leasesView AS
+SELECT
+    p.propertyId AS propertyId,
+    t.tenantFirstName AS firstName,
+    t.tenantLastName AS lastName,
+    p.propertyStreetAddress AS address,
+    u.propertyUnitNumber AS unit,
+    l.leaseStartUnix AS leaseStartDate,
+    l.leaseRentAmount AS rentAmount,
+    l.leaseStatus AS leaseStatus
+FROM leases l
+JOIN tenants t ON l.tenantId = t.tenantId
+JOIN propertyUnits u ON l.propertyUnitId = u.propertyUnitId
+JOIN properties p ON u.propertyId = p.propertyId;
+
+-- Upcoming Rent (next due payments)
+
+-- Upcoming: Rent for next month not yet paid
+CREATE VIEW IF NOT EXISTS upcomingPayments AS
+SELECT 
+    l.leaseId as leaseId,
+    p.propertyId AS propertyId,
+    t.tenantFirstName AS firstName,
+    t.tenantLastName AS lastName,
+    p.propertyStreetAddress AS address,
+    u.propertyUnitNumber AS unit,
+    l.leaseRentAmount AS rentAmount,
+    COALESCE(
+        (
+            SELECT MAX(pay.paymentDateUnix)
+            FROM payments pay
+            WHERE pay.leaseId = l.leaseId
+                AND strftime('%Y-%m', datetime(pay.paymentDateUnix, 'unixepoch')) = strftime('%Y-%m', 'now')
+        ), 0
+    ) AS lastPaymentUnix,
+    CASE 
+        WHEN (
+            SELECT COUNT(*)
+            FROM payments pay
+            WHERE pay.leaseId = l.leaseId
+                AND strftime('%Y-%m', datetime(pay.paymentDateUnix, 'unixepoch')) = strftime('%Y-%m', 'now')
+        ) = 0 THEN 'Due'
+        ELSE 'Paid'
+    END AS paymentStatus
+FROM leases l
+JOIN tenants t ON l.tenantId = t.tenantId
+JOIN propertyUnits u ON l.propertyUnitId = u.propertyUnitId
+JOIN properties p ON u.propertyId = p.propertyId
+WHERE l.leaseStatus = 'active';
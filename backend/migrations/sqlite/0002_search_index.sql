@@ -0,0 +1,153 @@
+-- 0002: full-text search index.
+-- searchDocuments renders each searchable row as a (title, body) document;
+-- searchIndex is an FTS5 table over those documents, kept in sync by the
+-- triggers below. The FTS rowid encodes the entity: id * 10 + a type code
+-- (1 tenant, 2 property, 3 unit, 4 maintenance, 5 payment), so a row's
+-- document can be replaced without scanning the index.
+
+CREATE VIEW IF NOT EXISTS searchDocuments AS
+SELECT t.tenantId * 10 + 1 AS docId, 'tenant' AS entityType, t.tenantId AS entityId,
+       t.tenantFirstName || ' ' || t.tenantLastName AS title,
+       COALESCE(t.tenantEmailAddress, '') || ' ' || COALESCE(t.tenantPhoneNumber, '') AS body
+FROM tenants t
+UNION ALL
+SELECT p.propertyId * 10 + 2, 'property', p.propertyId,
+       COALESCE(p.propertyName, ''),
+       COALESCE(p.propertyStreetAddress, '') || ' ' || COALESCE(p.propertyCity, '') || ' ' ||
+       COALESCE(p.propertyState, '') || ' ' || COALESCE(p.propertyZip, '') || ' ' ||
+       COALESCE(p.propertyType, '') || ' ' || COALESCE(p.propertyNotes, '')
+FROM properties p
+UNION ALL
+SELECT u.propertyUnitId * 10 + 3, 'unit', u.propertyUnitId,
+       'Unit ' || COALESCE(u.propertyUnitNumber, '') || COALESCE(', ' || p.propertyName, ''),
+       COALESCE(u.propertyUnitNotes, '') || ' ' || COALESCE(p.propertyStreetAddress, '') || ' ' ||
+       COALESCE(p.propertyCity, '')
+FROM propertyUnits u
+LEFT JOIN properties p ON p.propertyId = u.propertyId
+UNION ALL
+SELECT m.maintenanceRequestId * 10 + 4, 'maintenance', m.maintenanceRequestId,
+       m.maintenanceRequestInfo,
+       COALESCE(m.maintenanceRequestCategory, '') || ' ' || COALESCE(m.maintenanceRequestStatus, '') || ' ' ||
+       COALESCE(m.maintenanceAssignedTo, '') || ' Unit ' || COALESCE(u.propertyUnitNumber, '') || ' ' ||
+       COALESCE(p.propertyName, '') || ' ' || COALESCE(p.propertyStreetAddress, '')
+FROM maintenanceRequests m
+LEFT JOIN propertyUnits u ON u.propertyUnitId = m.propertyUnitId
+LEFT JOIN properties p ON p.propertyId = u.propertyId
+UNION ALL
+SELECT pay.paymentId * 10 + 5, 'payment', pay.paymentId,
+       'Payment of ' || pay.paymentAmount || COALESCE(' by ' || pay.paymentMethod, ''),
+       COALESCE(pay.paymentNotes, '')
+FROM payments pay;
+
+CREATE VIRTUAL TABLE IF NOT EXISTS searchIndex USING fts5(
+    entityType UNINDEXED,
+    entityId UNINDEXED,
+    title,
+    body,
+    tokenize = 'porter unicode61'
+);
+
+INSERT INTO searchIndex (rowid, entityType, entityId, title, body)
+SELECT docId, entityType, entityId, title, body FROM searchDocuments;
+
+-- == Tenants ===================================================================
+CREATE TRIGGER IF NOT EXISTS searchTenantsInsert AFTER INSERT ON tenants BEGIN
+    INSERT INTO searchIndex (rowid, entityType, entityId, title, body)
+    SELECT docId, entityType, entityId, title, body FROM searchDocuments
+    WHERE entityType = 'tenant' AND entityId = NEW.tenantId;
+END;
+
+CREATE TRIGGER IF NOT EXISTS searchTenantsUpdate AFTER UPDATE ON tenants BEGIN
+    DELETE FROM searchIndex WHERE rowid = OLD.tenantId * 10 + 1;
+    INSERT INTO searchIndex (rowid, entityType, entityId, title, body)
+    SELECT docId, entityType, entityId, title, body FROM searchDocuments
+    WHERE entityType = 'tenant' AND entityId = NEW.tenantId;
+END;
+
+CREATE TRIGGER IF NOT EXISTS searchTenantsDelete AFTER DELETE ON tenants BEGIN
+    DELETE FROM searchIndex WHERE rowid = OLD.tenantId * 10 + 1;
+END;
+
+-- == Properties ================================================================
+-- Unit and maintenance documents include the property name and address, so
+-- they are rebuilt when a property changes.
+CREATE TRIGGER IF NOT EXISTS searchPropertiesInsert AFTER INSERT ON properties BEGIN
+    INSERT INTO searchIndex (rowid, entityType, entityId, title, body)
+    SELECT docId, entityType, entityId, title, body FROM searchDocuments
+    WHERE entityType = 'property' AND entityId = NEW.propertyId;
+END;
+
+CREATE TRIGGER IF NOT EXISTS searchPropertiesUpdate AFTER UPDATE ON properties BEGIN
+    DELETE FROM searchIndex WHERE rowid = OLD.propertyId * 10 + 2
+        OR rowid IN (SELECT propertyUnitId * 10 + 3 FROM propertyUnits WHERE propertyId = NEW.propertyId)
+        OR rowid IN (SELECT m.maintenanceRequestId * 10 + 4 FROM maintenanceRequests m
+                     JOIN propertyUnits u ON u.propertyUnitId = m.propertyUnitId
+                     WHERE u.propertyId = NEW.propertyId);
+    INSERT INTO searchIndex (rowid, entityType, entityId, title, body)
+    SELECT docId, entityType, entityId, title, body FROM searchDocuments
+    WHERE (entityType = 'property' AND entityId = NEW.propertyId)
+       OR (entityType = 'unit' AND entityId IN (SELECT propertyUnitId FROM propertyUnits WHERE propertyId = NEW.propertyId))
+       OR (entityType = 'maintenance' AND entityId IN (SELECT m.maintenanceRequestId FROM maintenanceRequests m
+                                                       JOIN propertyUnits u ON u.propertyUnitId = m.propertyUnitId
+                                                       WHERE u.propertyId = NEW.propertyId));
+END;
+
+CREATE TRIGGER IF NOT EXISTS searchPropertiesDelete AFTER DELETE ON properties BEGIN
+    DELETE FROM searchIndex WHERE rowid = OLD.propertyId * 10 + 2;
+END;
+
+-- == Units =====================================================================
+CREATE TRIGGER IF NOT EXISTS searchUnitsInsert AFTER INSERT ON propertyUnits BEGIN
+    INSERT INTO searchIndex (rowid, entityType, entityId, title, body)
+    SELECT docId, entityType, entityId, title, body FROM searchDocuments
+    WHERE entityType = 'unit' AND entityId = NEW.propertyUnitId;
+END;
+
+CREATE TRIGGER IF NOT EXISTS searchUnitsUpdate AFTER UPDATE ON propertyUnits BEGIN
+    DELETE FROM searchIndex WHERE rowid = OLD.propertyUnitId * 10 + 3
+        OR rowid IN (SELECT maintenanceRequestId * 10 + 4 FROM maintenanceRequests WHERE propertyUnitId = NEW.propertyUnitId);
+    INSERT INTO searchIndex (rowid, entityType, entityId, title, body)
+    SELECT docId, entityType, entityId, title, body FROM searchDocuments
+    WHERE (entityType = 'unit' AND entityId = NEW.propertyUnitId)
+       OR (entityType = 'maintenance' AND entityId IN (SELECT maintenanceRequestId FROM maintenanceRequests WHERE propertyUnitId = NEW.propertyUnitId));
+END;
+
+CREATE TRIGGER IF NOT EXISTS searchUnitsDelete AFTER DELETE ON propertyUnits BEGIN
+    DELETE FROM searchIndex WHERE rowid = OLD.propertyUnitId * 10 + 3;
+END;
+
+-- == Maintenance requests ======================================================
+CREATE TRIGGER IF NOT EXISTS searchMaintenanceInsert AFTER INSERT ON maintenanceRequests BEGIN
+    INSERT INTO searchIndex (rowid, entityType, entityId, title, body)
+    SELECT docId, entityType, entityId, title, body FROM searchDocuments
+    WHERE entityType = 'maintenance' AND entityId = NEW.maintenanceRequestId;
+END;
+
+CREATE TRIGGER IF NOT EXISTS searchMaintenanceUpdate AFTER UPDATE ON maintenanceRequests BEGIN
+    DELETE FROM searchIndex WHERE rowid = OLD.maintenanceRequestId * 10 + 4;
+    INSERT INTO searchIndex (rowid, entityType, entityId, title, body)
+    SELECT docId, entityType, entityId, title, body FROM searchDocuments
+    WHERE entityType = 'maintenance' AND entityId = NEW.maintenanceRequestId;
+END;
+
+CREATE TRIGGER IF NOT EXISTS searchMaintenanceDelete AFTER DELETE ON maintenanceRequests BEGIN
+    DELETE FROM searchIndex WHERE rowid = OLD.maintenanceRequestId * 10 + 4;
+END;
+
+-- == Payments ==================================================================
+CREATE TRIGGER IF NOT EXISTS searchPaymentsInsert AFTER INSERT ON payments BEGIN
+    INSERT INTO searchIndex (rowid, entityType, entityId, title, body)
+    SELECT docId, entityType, entityId, title, body FROM searchDocuments
+    WHERE entityType = 'payment' AND entityId = NEW.paymentId;
+END;
+
+CREATE TRIGGER IF NOT EXISTS searchPaymentsUpdate AFTER UPDATE ON payments BEGIN
+    DELETE FROM searchIndex WHERE rowid = OLD.paymentId * 10 + 5;
+    INSERT INTO searchIndex (rowid, entityType, entityId, title, body)
+    SELECT docId, entityType, entityId, title, body FROM searchDocuments
+    WHERE entityType = 'payment' AND entityId = NEW.paymentId;
+END;
+
+CREATE TRIGGER IF NOT EXISTS searchPaymentsDelete AFTER DELETE ON payments BEGIN
+    DELETE FROM searchIndex WHERE rowid = OLD.paymentId * 10 + 5;
+END;
@@ -0,0 +1,27 @@
+-- 0003: row versions for optimistic concurrency.
+-- version starts at 1 and is incremented by every update; updatedUnix records
+-- when the row last changed (NULL until its first update).
+
+ALTER TABLE users ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE users ADD COLUMN updatedUnix INTEGER;
+
+ALTER TABLE properties ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE properties ADD COLUMN updatedUnix INTEGER;
+
+ALTER TABLE propertyUnits ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE propertyUnits ADD COLUMN updatedUnix INTEGER;
+
+ALTER TABLE tenants ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE tenants ADD COLUMN updatedUnix INTEGER;
+
+ALTER TABLE leases ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE leases ADD COLUMN updatedUnix INTEGER;
+
+ALTER TABLE payments ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE payments ADD COLUMN updatedUnix INTEGER;
+
+ALTER TABLE maintenanceRequests ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE maintenanceRequests ADD COLUMN updatedUnix INTEGER;
+
+ALTER TABLE activityLogs ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE activityLogs ADD COLUMN updatedUnix INTEGER;
@@ -0,0 +1,197 @@
+-- 0004: soft delete.
+-- Deleting a property, unit, tenant, lease, payment or maintenance request
+-- moves it to the trash: deletedUnix records when and deletedByUserId by whom.
+-- Trashed rows are left out of every list, view and search result until they
+-- are restored or purged (see trash.go). The dashboard views and the search
+-- documents are recreated below with that filter; for search, the existing
+-- update triggers then drop a row's document when it is trashed and add it
+-- back when it is restored.
+
+ALTER TABLE properties ADD COLUMN deletedUnix INTEGER;
+ALTER TABLE properties ADD COLUMN deletedByUserId INTEGER REFERENCES users(userId) ON DELETE SET NULL;
+
+ALTER TABLE propertyUnits ADD COLUMN deletedUnix INTEGER;
+ALTER TABLE propertyUnits ADD COLUMN deletedByUserId INTEGER REFERENCES users(userId) ON DELETE SET NULL;
+
+ALTER TABLE tenants ADD COLUMN deletedUnix INTEGER;
+ALTER TABLE tenants ADD COLUMN deletedByUserId INTEGER REFERENCES users(userId) ON DELETE SET NULL;
+
+ALTER TABLE leases ADD COLUMN deletedUnix INTEGER;
+ALTER TABLE leases ADD COLUMN deletedByUserId INTEGER REFERENCES users(userId) ON DELETE SET NULL;
+
+ALTER TABLE payments ADD COLUMN deletedUnix INTEGER;
+ALTER TABLE payments ADD COLUMN deletedByUserId INTEGER REFERENCES users(userId) ON DELETE SET NULL;
+
+ALTER TABLE maintenanceRequests ADD COLUMN deletedUnix INTEGER;
+ALTER TABLE maintenanceRequests ADD COLUMN deletedByUserId INTEGER REFERENCES users(userId) ON DELETE SET NULL;
+
+-- The trash listing and the purge job look rows up by deletedUnix
+CREATE INDEX idxPropertiesDeleted ON properties(deletedUnix) WHERE deletedUnix IS NOT NULL;
+CREATE INDEX idxPropertyUnitsDeleted ON propertyUnits(deletedUnix) WHERE deletedUnix IS NOT NULL;
+CREATE INDEX idxTenantsDeleted ON tenants(deletedUnix) WHERE deletedUnix IS NOT NULL;
+CREATE INDEX idxLeasesDeleted ON leases(deletedUnix) WHERE deletedUnix IS NOT NULL;
+CREATE INDEX idxPaymentsDeleted ON payments(deletedUnix) WHERE deletedUnix IS NOT NULL;
+CREATE INDEX idxMaintenanceRequestsDeleted ON maintenanceRequests(deletedUnix) WHERE deletedUnix IS NOT NULL;
+
+-- == Views =====================================================================
+DROP VIEW overduePayments;
+DROP VIEW maintenanceRequestsView;
+DROP VIEW leasesView;
+DROP VIEW upcomingPayments;
+DROP VIEW searchDocuments;
+
+-- Overdue Rent (dashboard)
+
+-- Overdue: No payment for the current month (from the 1st)
+CREATE VIEW overduePayments AS
+SELECT 
+    l.leaseId as leaseId,
+    p.propertyId AS propertyId,
+    t.tenantFirstName AS firstName,
+    t.tenantLastName AS lastName,
+    p.propertyStreetAddress AS address,
+    u.propertyUnitNumber AS unit,
+    l.leaseRentAmount AS rentAmount,
+    COALESCE(
+        (
+            SELECT MAX(pay.paymentDateUnix)
+            FROM payments pay
+            WHERE pay.leaseId = l.leaseId AND pay.deletedUnix IS NULL
+                AND strftime('%Y-%m', datetime(pay.paymentDateUnix, 'unixepoch')) = strftime('%Y-%m', 'now')
+        ), 0
+    ) AS lastPaymentUnix,
+    CASE 
+        WHEN (
+            SELECT COUNT(*)
+            FROM payments pay
+            WHERE pay.leaseId = l.leaseId AND pay.deletedUnix IS NULL
+                AND strftime('%Y-%m', datetime(pay.paymentDateUnix, 'unixepoch')) = strftime('%Y-%m', 'now')
+        ) = 0 THEN 'Overdue'
+        ELSE 'Current'
+    END AS paymentStatus
+FROM leases l
+JOIN tenants t ON l.tenantId = t.tenantId
+JOIN propertyUnits u ON l.propertyUnitId = u.propertyUnitId
+JOIN properties p ON u.propertyId = p.propertyId
+WHERE l.leaseStatus = 'active' AND l.deletedUnix IS NULL
+    -- Only include leases that do NOT have a payment for the current month
+    AND (
+        SELECT COUNT(*)
+        FROM payments pay
+        WHERE pay.leaseId = l.leaseId AND pay.deletedUnix IS NULL
+            AND strftime('%Y-%m', datetime(pay.paymentDateUnix, 'unixepoch')) = strftime('%Y-%m', 'now')
+    ) = 0;
+
+
+-- Maintenance requests (dashboard)
+CREATE VIEW maintenanceRequestsView AS
+SELECT
+    m.maintenanceRequestId AS maintenanceRequestId,
+    u.propertyId AS propertyId,
+    t.tenantFirstName AS firstName,
+    t.tenantLastName AS lastName,
+    p.propertyStreetAddress AS address,
+    u.propertyUnitNumber AS unit,
+    m.maintenanceRequestInfo AS description,
+    m.maintenanceRequestStatus AS maintenanceStatus,
+    m.maintenanceRequestCreatedUnix AS dateCreated,
+    m.maintenanceRequestPriority AS priority,
+    m.maintenanceRequestCategory AS category
+FROM maintenanceRequests m
+LEFT JOIN leases l ON m.leaseId = l.leaseId
+LEFT JOIN tenants t ON l.tenantId = t.tenantId
+LEFT JOIN propertyUnits u ON m.propertyUnitId = u.propertyUnitId
+LEFT JOIN properties p ON u.propertyId = p.propertyId
+WHERE m.maintenanceRequestStatus != 'completed' AND m.deletedUnix IS NULL;
+
+-- Lease renewals (dashboard)
+CREATE VIEW leasesView AS
+SELECT
+    p.propertyId AS propertyId,
+    t.tenantFirstName AS firstName,
+    t.tenantLastName AS lastName,
+    p.propertyStreetAddress AS address,
+    u.propertyUnitNumber AS unit,
+    l.leaseStartUnix AS leaseStartDate,
+    l.leaseRentAmount AS rentAmount,
+    l.leaseStatus AS leaseStatus
+FROM leases l
+JOIN tenants t ON l.tenantId = t.tenantId
+JOIN propertyUnits u ON l.propertyUnitId = u.propertyUnitId
+JOIN properties p ON u.propertyId = p.propertyId
+WHERE l.deletedUnix IS NULL;
+
+-- Upcoming Rent (next due payments)
+
+-- Upcoming: Rent for next month not yet paid
+CREATE VIEW upcomingPayments AS
+SELECT 
+    l.leaseId as leaseId,
+    p.propertyId AS propertyId,
+    t.tenantFirstName AS firstName,
+    t.tenantLastName AS lastName,
+    p.propertyStreetAddress AS address,
+    u.propertyUnitNumber AS unit,
+    l.leaseRentAmount AS rentAmount,
+    COALESCE(
+        (
+            SELECT MAX(pay.paymentDateUnix)
+            FROM payments pay
+            WHERE pay.leaseId = l.leaseId AND pay.deletedUnix IS NULL
+                AND strftime('%Y-%m', datetime(pay.paymentDateUnix, 'unixepoch')) = strftime('%Y-%m', 'now')
+        ), 0
+    ) AS lastPaymentUnix,
+    CASE 
+        WHEN (
+            SELECT COUNT(*)
+            FROM payments pay
+            WHERE pay.leaseId = l.leaseId AND pay.deletedUnix IS NULL
+                AND strftime('%Y-%m', datetime(pay.paymentDateUnix, 'unixepoch')) = strftime('%Y-%m', 'now')
+        ) = 0 THEN 'Due'
+        ELSE 'Paid'
+    END AS paymentStatus
+FROM leases l
+JOIN tenants t ON l.tenantId = t.tenantId
+JOIN propertyUnits u ON l.propertyUnitId = u.propertyUnitId
+JOIN properties p ON u.propertyId = p.propertyId
+WHERE l.leaseStatus = 'active' AND l.deletedUnix IS NULL;
+
+-- Search documents (see 0002)
+CREATE VIEW searchDocuments AS
+SELECT t.tenantId * 10 + 1 AS docId, 'tenant' AS entityType, t.tenantId AS entityId,
+       t.tenantFirstName || ' ' || t.tenantLastName AS title,
+       COALESCE(t.tenantEmailAddress, '') || ' ' || COALESCE(t.tenantPhoneNumber, '') AS body
+FROM tenants t
+WHERE t.deletedUnix IS NULL
+UNION ALL
+SELECT p.propertyId * 10 + 2, 'property', p.propertyId,
+       COALESCE(p.propertyName, ''),
+       COALESCE(p.propertyStreetAddress, '') || ' ' || COALESCE(p.propertyCity, '') || ' ' ||
+       COALESCE(p.propertyState, '') || ' ' || COALESCE(p.propertyZip, '') || ' ' ||
+       COALESCE(p.propertyType, '') || ' ' || COALESCE(p.propertyNotes, '')
+FROM properties p
+WHERE p.deletedUnix IS NULL
+UNION ALL
+SELECT u.propertyUnitId * 10 + 3, 'unit', u.propertyUnitId,
+       'Unit ' || COALESCE(u.propertyUnitNumber, '') || COALESCE(', ' || p.propertyName, ''),
+       COALESCE(u.propertyUnitNotes, '') || ' ' || COALESCE(p.propertyStreetAddress, '') || ' ' ||
+       COALESCE(p.propertyCity, '')
+FROM propertyUnits u
+LEFT JOIN properties p ON p.propertyId = u.propertyId
+WHERE u.deletedUnix IS NULL
+UNION ALL
+SELECT m.maintenanceRequestId * 10 + 4, 'maintenance', m.maintenanceRequestId,
+       m.maintenanceRequestInfo,
+       COALESCE(m.maintenanceRequestCategory, '') || ' ' || COALESCE(m.maintenanceRequestStatus, '') || ' ' ||
+       COALESCE(m.maintenanceAssignedTo, '') || ' Unit ' || COALESCE(u.propertyUnitNumber, '') || ' ' ||
+       COALESCE(p.propertyName, '') || ' ' || COALESCE(p.propertyStreetAddress, '')
+FROM maintenanceRequests m
+LEFT JOIN propertyUnits u ON u.propertyUnitId = m.propertyUnitId
+LEFT JOIN properties p ON p.propertyId = u.propertyId
+WHERE m.deletedUnix IS NULL
+UNION ALL
+SELECT pay.paymentId * 10 + 5, 'payment', pay.paymentId,
+       'Payment of ' || pay.paymentAmount || COALESCE(' by ' || pay.paymentMethod, ''),
+       COALESCE(pay.paymentNotes, '')
+FROM payments pay
+WHERE pay.deletedUnix IS NULL;
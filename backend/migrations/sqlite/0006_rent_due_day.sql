@@ -0,0 +1,6 @@
+-- 0006: per-lease rent due day.
+-- leaseRentDueDay is the day of the month each rent charge falls due (1-31;
+-- in shorter months the last day). The rent job in ledger.go posts a month's
+-- charge once that day has arrived.
+
+ALTER TABLE leases ADD COLUMN leaseRentDueDay INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,88 @@
+-- 0007: late fees.
+-- lateFeeRules holds one rule per lease or per property; a lease's own rule
+-- takes precedence over its property's. When a rent charge is still unpaid
+-- after the grace days, the rent job in latefee.go posts a 'lateFee' charge
+-- for the same period: a flat amount, a percentage of the rent, or an amount
+-- per day late that grows until the rent is paid or the cap is reached.
+-- Charges can be waived, with a reason; waived charges stay in the ledger
+-- but no longer count toward what is owed.
+
+CREATE TABLE IF NOT EXISTS lateFeeRules (
+    lateFeeRuleId INTEGER PRIMARY KEY AUTOINCREMENT,
+    propertyId INTEGER UNIQUE REFERENCES properties(propertyId) ON DELETE CASCADE,
+    leaseId INTEGER UNIQUE REFERENCES leases(leaseId) ON DELETE CASCADE,
+    lateFeeType TEXT NOT NULL, -- flat, percent, daily
+    lateFeeAmount INTEGER NOT NULL DEFAULT 0, -- flat fee, or the fee per day late
+    lateFeePercent REAL NOT NULL DEFAULT 0, -- percent of the rent charge
+    lateFeeGraceDays INTEGER NOT NULL DEFAULT 0,
+    lateFeeMax INTEGER, -- cap on the fee for one rent charge
+    updatedUnix INTEGER NOT NULL,
+    updatedByUserId INTEGER REFERENCES users(userId) ON DELETE SET NULL,
+    CHECK ((propertyId IS NULL) <> (leaseId IS NULL))
+);
+
+ALTER TABLE charges ADD COLUMN waivedUnix INTEGER;
+ALTER TABLE charges ADD COLUMN waivedByUserId INTEGER REFERENCES users(userId) ON DELETE SET NULL;
+ALTER TABLE charges ADD COLUMN waiveReason TEXT;
+
+-- == Views =====================================================================
+-- The ledger views are rebuilt to leave waived charges out, and to show the
+-- late fees charged on each lease.
+DROP VIEW overduePayments;
+DROP VIEW upcomingPayments;
+DROP VIEW leaseBalances;
+
+CREATE VIEW leaseBalances AS
+SELECT
+    l.leaseId AS leaseId,
+    COALESCE((SELECT SUM(c.chargeAmount) FROM charges c
+        WHERE c.leaseId = l.leaseId AND c.waivedUnix IS NULL), 0) AS charged,
+    COALESCE((SELECT SUM(c.chargeAmount) FROM charges c
+        WHERE c.leaseId = l.leaseId AND c.waivedUnix IS NULL AND c.chargeDueUnix <= CAST(strftime('%s', 'now') AS INTEGER)), 0) AS chargedToDate,
+    COALESCE((SELECT SUM(pay.paymentAmount) FROM payments pay
+        WHERE pay.leaseId = l.leaseId AND pay.deletedUnix IS NULL), 0) AS paid,
+    COALESCE((SELECT SUM(c.chargeAmount) FROM charges c
+        WHERE c.leaseId = l.leaseId AND c.waivedUnix IS NULL AND c.chargeType = 'lateFee'), 0) AS lateFees
+FROM leases l
+WHERE l.deletedUnix IS NULL;
+
+CREATE VIEW overduePayments AS
+SELECT
+    l.leaseId AS leaseId,
+    p.propertyId AS propertyId,
+    t.tenantFirstName AS firstName,
+    t.tenantLastName AS lastName,
+    p.propertyStreetAddress AS address,
+    u.propertyUnitNumber AS unit,
+    l.leaseRentAmount AS rentAmount,
+    COALESCE((SELECT MAX(pay.paymentDateUnix) FROM payments pay
+        WHERE pay.leaseId = l.leaseId AND pay.deletedUnix IS NULL), 0) AS lastPaymentUnix,
+    'Overdue' AS paymentStatus,
+    b.chargedToDate - b.paid AS amountDue,
+    b.lateFees AS lateFees
+FROM leases l
+JOIN leaseBalances b ON b.leaseId = l.leaseId
+JOIN tenants t ON l.tenantId = t.tenantId
+JOIN propertyUnits u ON l.propertyUnitId = u.propertyUnitId
+JOIN properties p ON u.propertyId = p.propertyId
+WHERE b.chargedToDate > b.paid;
+
+CREATE VIEW upcomingPayments AS
+SELECT
+    l.leaseId AS leaseId,
+    p.propertyId AS propertyId,
+    t.tenantFirstName AS firstName,
+    t.tenantLastName AS lastName,
+    p.propertyStreetAddress AS address,
+    u.propertyUnitNumber AS unit,
+    l.leaseRentAmount AS rentAmount,
+    COALESCE((SELECT MAX(pay.paymentDateUnix) FROM payments pay
+        WHERE pay.leaseId = l.leaseId AND pay.deletedUnix IS NULL), 0) AS lastPaymentUnix,
+    CASE WHEN b.charged > b.paid THEN 'Due' ELSE 'Paid' END AS paymentStatus,
+    CASE WHEN b.charged > b.paid THEN b.charged - b.paid ELSE 0 END AS amountDue
+FROM leases l
+JOIN leaseBalances b ON b.leaseId = l.leaseId
+JOIN tenants t ON l.tenantId = t.tenantId
+JOIN propertyUnits u ON l.propertyUnitId = u.propertyUnitId
+JOIN properties p ON u.propertyId = p.propertyId
+WHERE l.leaseStatus = 'active';
@@ -0,0 +1,20 @@
+-- 0008: payment allocations.
+-- paymentAllocations records which charges each payment pays for. The source
+-- is a payment or a credit adjustment (a negative charge). Explicit rows are
+-- the targets a client chose for a payment and are kept; the others are
+-- recomputed oldest charge first whenever the lease's charges or payments
+-- change (see allocation.go). Whatever a source has left over is credit that
+-- the next charges take.
+
+CREATE TABLE IF NOT EXISTS paymentAllocations (
+    allocationId INTEGER PRIMARY KEY AUTOINCREMENT,
+    chargeId INTEGER NOT NULL REFERENCES charges(chargeId) ON DELETE CASCADE,
+    paymentId INTEGER REFERENCES payments(paymentId) ON DELETE CASCADE,
+    creditChargeId INTEGER REFERENCES charges(chargeId) ON DELETE CASCADE,
+    allocatedAmount INTEGER NOT NULL,
+    allocationExplicit INTEGER NOT NULL DEFAULT 0, -- 1 when chosen by the client
+    CHECK ((paymentId IS NULL) <> (creditChargeId IS NULL))
+);
+
+CREATE INDEX IF NOT EXISTS idxPaymentAllocationsCharge ON paymentAllocations(chargeId);
+CREATE INDEX IF NOT EXISTS idxPaymentAllocationsPayment ON paymentAllocations(paymentId);
@@ -0,0 +1,14 @@
+-- 0009: who created each user.
+-- An owner may update or delete only the staff accounts they created (see
+-- managedUsersSQL in access.go); a property grant no longer makes the grantee
+-- theirs to manage. Staff created before this migration are credited to the
+-- owner who granted them access, when exactly one owner did.
+
+ALTER TABLE users ADD COLUMN createdByUserId INTEGER REFERENCES users(userId) ON DELETE SET NULL;
+
+UPDATE users SET createdByUserId = (
+    SELECT MIN(p.ownerUserId) FROM propertyAccess pa JOIN properties p ON p.propertyId = pa.propertyId
+    WHERE pa.userId = users.userId)
+WHERE COALESCE(userRole, 'owner') <> 'owner'
+    AND (SELECT COUNT(DISTINCT p.ownerUserId) FROM propertyAccess pa JOIN properties p ON p.propertyId = pa.propertyId
+        WHERE pa.userId = users.userId) = 1;
@@ -0,0 +1,265 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Package-level summary:
+// This file implements account recovery and password changes. A reset request
+// emails a single-use link whose token is stored only as a SHA-256 hash and
+// expires after passwordResetTTL; confirming the reset sets the new password
+// and signs the user out everywhere. Changing a password requires the current
+// one. Handlers: RequestPasswordResetHandler, ConfirmPasswordResetHandler,
+// ChangePasswordHandler. DB helpers: CreatePasswordResetToken,
+// ConsumePasswordResetToken, SetUserPassword.
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	passwordResetTTL  = time.Hour
+	minPasswordLength = 8
+)
+
+var errInvalidResetToken = errors.New("invalid reset token")
+
+// passwordResetURL is the page reset links point to; the token is added as
+// ?token=. Set by Config.Apply.
+var passwordResetURL = "http://localhost:8081/reset-password"
+
+// validatePassword enforces the minimum password policy for new passwords.
+func validatePassword(pw string) error {
+	if len(pw) < minPasswordLength {
+		return errors.New("password must be at least 8 characters")
+	}
+	return nil
+}
+
+// == Handlers =====================================================================
+// POST /password/reset/request
+// RequestPasswordResetHandler returns an HTTP handler that emails a reset link.
+// It always answers 202 so the response does not reveal whether an account
+// exists; the link is created and sent after the response, so a known email
+// takes no longer to answer than an unknown one. Emails match case-insensitively,
+// as at login.
+func RequestPasswordResetHandler(db *sql.DB, mailer Mailer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var body struct {
+			Email string `json:"email"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Email == "" {
+			respondError(w, http.StatusBadRequest, "email required")
+			return
+		}
+
+		accepted := map[string]string{"status": "If the account exists, a reset link has been sent"}
+
+		var userID int
+		var email string
+		err := db.QueryRow(`SELECT userId, userEmail FROM users WHERE lower(userEmail) = ?`, normalizeEmail(body.Email)).Scan(&userID, &email)
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				log.Print(err)
+			}
+			respondJSON(w, http.StatusAccepted, accepted)
+			return
+		}
+
+		go sendPasswordReset(db, mailer, passwordResetURL, userID, email)
+		respondJSON(w, http.StatusAccepted, accepted)
+	}
+}
+
+// sendPasswordReset creates a reset token for a user and emails them the link.
+// It runs off the request path, so failures are only logged.
+func sendPasswordReset(db *sql.DB, mailer Mailer, resetURL string, userID int, email string) {
+	token, err := CreatePasswordResetToken(db, userID)
+	if err != nil {
+		log.Print("create reset token: ", err)
+		return
+	}
+	link := resetURL + "?token=" + url.QueryEscape(token)
+	msg := "A password reset was requested for your RentTracker account.\n\n" +
+		"Reset your password: " + link + "\n\n" +
+		"This link expires in 1 hour. If you did not request a reset, you can ignore this email."
+	if err := mailer.Send(email, "Reset your RentTracker password", msg); err != nil {
+		log.Print("send reset email: ", err)
+	}
+}
+
+// POST /password/reset/confirm
+// ConfirmPasswordResetHandler returns an HTTP handler that sets a new password
+// using a reset token, then revokes every session for the account.
+func ConfirmPasswordResetHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var body struct {
+			Token       string `json:"token"`
+			NewPassword string `json:"newPassword"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Token == "" {
+			respondError(w, http.StatusBadRequest, "token and newPassword required")
+			return
+		}
+		if err := validatePassword(body.NewPassword); err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		hashed, err := bcrypt.GenerateFromPassword([]byte(body.NewPassword), bcrypt.DefaultCost)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to hash password")
+			return
+		}
+
+		userID, err := ConsumePasswordResetToken(db, body.Token, string(hashed))
+		if err != nil {
+			if errors.Is(err, errInvalidResetToken) {
+				respondError(w, http.StatusBadRequest, "Invalid or expired reset token")
+			} else {
+				respondError(w, http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+
+		if _, err := RevokeAllSessions(db, userID); err != nil {
+			log.Print(err)
+		}
+		respondJSON(w, http.StatusOK, map[string]string{"status": "password reset"})
+	}
+}
+
+// POST /password/change
+// ChangePasswordHandler returns an HTTP handler that changes the caller's
+// password after verifying the current one. Other sessions are signed out.
+func ChangePasswordHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var body struct {
+			CurrentPassword string `json:"currentPassword"`
+			NewPassword     string `json:"newPassword"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		if err := validatePassword(body.NewPassword); err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		userID := currentUserID(r)
+		var hashedPassword string
+		if err := db.QueryRow(`SELECT userPasswordHash FROM users WHERE userId=?`, userID).Scan(&hashedPassword); err != nil {
+			respondError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		if err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(body.CurrentPassword)); err != nil {
+			respondError(w, http.StatusUnauthorized, "Current password is incorrect")
+			return
+		}
+
+		hashed, err := bcrypt.GenerateFromPassword([]byte(body.NewPassword), bcrypt.DefaultCost)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to hash password")
+			return
+		}
+		if err := SetUserPassword(db, userID, string(hashed)); err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if err := RevokeOtherSessions(db, userID, sessionIDFromContext(r)); err != nil {
+			log.Print(err)
+		}
+		respondJSON(w, http.StatusOK, map[string]string{"status": "password changed"})
+	}
+}
+
+// == SQL Queries =====================================================================
+
+// CreatePasswordResetToken stores the hash of a new reset token for a user and
+// invalidates any earlier unused tokens. Returns the plaintext token.
+func CreatePasswordResetToken(db *sql.DB, userID int) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE passwordResetTokens SET usedUnix=? WHERE userId=? AND usedUnix IS NULL`, now.Unix(), userID); err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec(`INSERT INTO passwordResetTokens (tokenHash, userId, createdUnix, expiresUnix) VALUES (?, ?, ?, ?)`,
+		hashToken(token), userID, now.Unix(), now.Add(passwordResetTTL).Unix()); err != nil {
+		return "", err
+	}
+	return token, tx.Commit()
+}
+
+// ConsumePasswordResetToken marks a reset token used and stores the new password
+// hash in one transaction. Returns the user ID, or errInvalidResetToken when the
+// token is unknown, expired, or already used.
+func ConsumePasswordResetToken(db *sql.DB, token, passwordHash string) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().Unix()
+	var userID int
+	err = tx.QueryRow(`SELECT userId FROM passwordResetTokens WHERE tokenHash=? AND usedUnix IS NULL AND expiresUnix > ?`,
+		hashToken(token), now).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, errInvalidResetToken
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if err := checkAffected(tx.Exec(`UPDATE passwordResetTokens SET usedUnix=? WHERE tokenHash=? AND usedUnix IS NULL`, now, hashToken(token))); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, errInvalidResetToken
+		}
+		return 0, err
+	}
+	if _, err := tx.Exec(`UPDATE users SET userPasswordHash=? WHERE userId=?`, passwordHash, userID); err != nil {
+		return 0, err
+	}
+	return userID, tx.Commit()
+}
+
+// SetUserPassword stores a new bcrypt password hash for a user.
+// Returns error if the update fails.
+func SetUserPassword(db *sql.DB, userID int, passwordHash string) error {
+	return checkAffected(db.Exec(`UPDATE users SET userPasswordHash=? WHERE userId=?`, passwordHash, userID))
+}
@@ -0,0 +1,152 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Tests for password.go: reset requests answer the same whether or not the
+// account exists, and reset tokens are single use, expire, are replaced by a
+// newer request, and are not spent on a password that fails the policy.
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// chanMailer hands each message it is asked to send to a channel.
+type chanMailer chan mailMessage
+
+type mailMessage struct{ to, subject, body string }
+
+func (m chanMailer) Send(to, subject, body string) error {
+	m <- mailMessage{to, subject, body}
+	return nil
+}
+
+func TestPasswordResetRequest(t *testing.T) {
+	s := newTestStore(t)
+	createTestUser(t, s, "owner@example.com", "owner")
+	mail := make(chanMailer, 4)
+	h := RequestPasswordResetHandler(s.DB, mail)
+	request := func(email string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(http.MethodPost, "/password/reset/request", strings.NewReader(`{"email":"`+email+`"}`)))
+		return w
+	}
+
+	unknown := request("nobody@example.com")
+	known := request("Owner@Example.com")
+	if unknown.Code != http.StatusAccepted || known.Code != unknown.Code || known.Body.String() != unknown.Body.String() {
+		t.Errorf("known email: %d %s; unknown: %d %s; want the same 202", known.Code, known.Body, unknown.Code, unknown.Body)
+	}
+	select {
+	case m := <-mail:
+		if m.to != "owner@example.com" || !strings.Contains(m.body, passwordResetURL+"?token=") {
+			t.Errorf("mail = %+v, want a reset link to owner@example.com", m)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reset email sent for a known account")
+	}
+	select {
+	case m := <-mail:
+		t.Errorf("unexpected mail %+v", m)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestPasswordResetConfirm(t *testing.T) {
+	s := newTestStore(t)
+	userID := createTestUser(t, s, "owner@example.com", "owner")
+	h := ConfirmPasswordResetHandler(s.DB)
+	confirm := func(token, password string) int {
+		body := `{"token":"` + token + `","newPassword":"` + password + `"}`
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(http.MethodPost, "/password/reset/confirm", strings.NewReader(body)))
+		return w.Code
+	}
+	newToken := func() string {
+		token, err := CreatePasswordResetToken(s.DB, userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Tokens travel in a link, so they must survive one unchanged
+		if url.QueryEscape(token) != token {
+			t.Errorf("token %q needs escaping", token)
+		}
+		return token
+	}
+	passwordIs := func(password string) bool {
+		var hash string
+		if err := s.DB.QueryRow(`SELECT userPasswordHash FROM users WHERE userId=?`, userID).Scan(&hash); err != nil {
+			t.Fatal(err)
+		}
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	}
+
+	t.Run("single use", func(t *testing.T) {
+		token := newToken()
+		if code := confirm(token, "first password"); code != http.StatusOK {
+			t.Fatalf("confirm: status %d, want 200", code)
+		}
+		if !passwordIs("first password") {
+			t.Error("the new password was not stored")
+		}
+		if code := confirm(token, "second password"); code != http.StatusBadRequest {
+			t.Errorf("reusing the token: status %d, want 400", code)
+		}
+		if !passwordIs("first password") {
+			t.Error("a used token changed the password")
+		}
+	})
+
+	t.Run("replaced by a newer request", func(t *testing.T) {
+		old := newToken()
+		newer := newToken()
+		if code := confirm(old, "older password"); code != http.StatusBadRequest {
+			t.Errorf("older token: status %d, want 400", code)
+		}
+		if code := confirm(newer, "newer password"); code != http.StatusOK {
+			t.Errorf("newer token: status %d, want 200", code)
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		token := newToken()
+		if _, err := s.DB.Exec(`UPDATE passwordResetTokens SET expiresUnix=? WHERE tokenHash=?`, time.Now().Add(-time.Minute).Unix(), hashToken(token)); err != nil {
+			t.Fatal(err)
+		}
+		if code := confirm(token, "expired password"); code != http.StatusBadRequest {
+			t.Errorf("expired token: status %d, want 400", code)
+		}
+		if passwordIs("expired password") {
+			t.Error("an expired token changed the password")
+		}
+	})
+
+	t.Run("password policy", func(t *testing.T) {
+		token := newToken()
+		for _, password := range []string{"", "short", strings.Repeat("x", minPasswordLength-1)} {
+			if code := confirm(token, password); code != http.StatusBadRequest {
+				t.Errorf("password %q: status %d, want 400", password, code)
+			}
+		}
+		// A refused password does not spend the token
+		if code := confirm(token, strings.Repeat("x", minPasswordLength)); code != http.StatusOK {
+			t.Errorf("then a valid password: status %d, want 200", code)
+		}
+	})
+
+	if code := confirm("not-a-token", "some password"); code != http.StatusBadRequest {
+		t.Errorf("unknown token: status %d, want 400", code)
+	}
+}
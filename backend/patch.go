@@ -0,0 +1,142 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Package-level summary:
+// This file implements partial updates with JSON Merge Patch (RFC 7396).
+// PATCH /v1/{resource}/{id} reads the current record, merges the patch into
+// its JSON form (members set to null are cleared, nested objects are merged,
+// everything else replaces), and hands the merged record to the resource's
+// PUT handler. Validation, access checks and the version check in
+// concurrency.go therefore apply to the merged result exactly as they do to a
+// full update. The stored version and update time cannot be patched: a
+// "version" member is the version the caller expects, and "updatedUnix" is
+// refused. A patch that changes the record's ID is refused by the PUT handler.
+// Handler: PatchHandler. Helper: mergePatch.
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+// maxPatchBytes bounds the size of a PATCH body.
+const maxPatchBytes = 1 << 20
+
+// mergePatchType is the media type of a PATCH body.
+const mergePatchType = "application/merge-patch+json"
+
+// readOnlyPatchMembers are the members a patch cannot set.
+var readOnlyPatchMembers = []string{"updatedUnix"}
+
+// PatchHandler returns a handler for PATCH /v1/{resource}/{id}. The body must
+// be a JSON object sent as application/merge-patch+json; any other
+// Content-Type is answered with 415. get loads the record the
+// caller is patching (an error means 404), and put is the resource's PUT
+// handler, which receives the merged record as its body.
+//
+// The stored version is not carried into the merged record: the caller must
+// still send If-Match or a "version" member, so a patch cannot silently apply
+// on top of changes it has not seen.
+func PatchHandler[T any](get func(r *http.Request, id int) (*T, error), put http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "invalid id")
+			return
+		}
+
+		if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != mergePatchType {
+			w.Header().Set("Accept-Patch", mergePatchType)
+			respondError(w, http.StatusUnsupportedMediaType, "Content-Type must be "+mergePatchType)
+			return
+		}
+
+		patch, err := io.ReadAll(io.LimitReader(r.Body, maxPatchBytes))
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "could not read body")
+			return
+		}
+		var patchDoc interface{}
+		if err := json.Unmarshal(patch, &patchDoc); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		patchObj, ok := patchDoc.(map[string]interface{})
+		if !ok {
+			respondError(w, http.StatusBadRequest, "patch must be a JSON object")
+			return
+		}
+		for _, name := range readOnlyPatchMembers {
+			if _, ok := patchObj[name]; ok {
+				respondError(w, http.StatusBadRequest, name+" cannot be patched")
+				return
+			}
+		}
+
+		current, err := get(r, id)
+		if err != nil {
+			respondError(w, http.StatusNotFound, "not found")
+			return
+		}
+		raw, err := json.Marshal(current)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		var doc map[string]interface{}
+		if err := json.Unmarshal(raw, &doc); err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		delete(doc, "version")
+		delete(doc, "updatedUnix")
+
+		merged, err := json.Marshal(mergePatch(doc, patchDoc))
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		r2 := r.Clone(r.Context())
+		r2.Method = http.MethodPut
+		r2.Header.Set("Content-Type", "application/json")
+		r2.Body = io.NopCloser(bytes.NewReader(merged))
+		r2.ContentLength = int64(len(merged))
+		put.ServeHTTP(w, r2)
+	}
+}
+
+// mergePatch applies patch to target as described in RFC 7396 and returns
+// the result. target may be modified.
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+	for name, value := range p {
+		if value == nil {
+			delete(t, name)
+			continue
+		}
+		t[name] = mergePatch(t[name], value)
+	}
+	return t
+}
@@ -0,0 +1,169 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Tests for patch.go: mergePatch against the examples of RFC 7396, and PATCH
+// on a lease: cleared and required fields, members that cannot be patched,
+// and the Content-Type check.
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMergePatch(t *testing.T) {
+	// From RFC 7396, appendix A
+	tests := []struct{ target, patch, want string }{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	decode := func(s string) interface{} {
+		var v interface{}
+		if err := json.Unmarshal([]byte(s), &v); err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	for _, tc := range tests {
+		if got := mergePatch(decode(tc.target), decode(tc.patch)); !reflect.DeepEqual(got, decode(tc.want)) {
+			t.Errorf("merge %s into %s = %v, want %s", tc.patch, tc.target, got, tc.want)
+		}
+	}
+}
+
+func TestPatchHandler(t *testing.T) {
+	s := newTestStore(t)
+	ownerID := createTestUser(t, s, "owner@example.com", "owner")
+	propertyID, err := s.Properties.Create(&Property{OwnerUserID: ownerID, PropertyName: "Maple", PropertyStreet: "1 Maple St", PropertyCity: "Morgantown"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unitID, err := s.Units.Create(&PropertyUnit{PropertyID: propertyID, PropertyUnitNumber: "1A", PropertyUnitRentDefault: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenantID, err := s.Tenants.Create(&Tenant{OwnerUserID: ownerID, TenantFirstName: "Tomasz", TenantLastName: "Renter", TenantEmail: "tomasz@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().AddDate(0, 1, 0)
+	end := start.AddDate(1, 0, 0).Unix()
+	leaseID, err := s.Leases.Create(&Lease{TenantID: tenantID, PropertyUnitID: unitID, LeaseStartUnix: start.Unix(), LeaseEndUnix: &end, LeaseRentAmount: 1000, LeaseRentDueDay: 1, LeaseStatus: "pending", LeaseDocumentLink: "lease.pdf"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := buildRouter(s, s.DB, nil)
+	path := "/v1/leases/" + strconv.Itoa(leaseID)
+	patch := func(contentType, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPatch, path, strings.NewReader(body))
+		r = r.WithContext(context.WithValue(r.Context(), userIDKey, ownerID))
+		if contentType != "" {
+			r.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	lease := func() *Lease {
+		l, err := s.Leases.GetByID(ownerID, leaseID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return l
+	}
+	version := func() string { return strconv.Itoa(lease().Version) }
+
+	t.Run("content type", func(t *testing.T) {
+		for _, ct := range []string{"", "application/json", "text/plain", "application/merge-patch"} {
+			w := patch(ct, `{"leaseRentAmount":1100,"version":`+version()+`}`)
+			if w.Code != http.StatusUnsupportedMediaType {
+				t.Errorf("Content-Type %q: status %d, want 415", ct, w.Code)
+			}
+			if got := w.Header().Get("Accept-Patch"); got != mergePatchType {
+				t.Errorf("Content-Type %q: Accept-Patch %q, want %q", ct, got, mergePatchType)
+			}
+		}
+		if lease().LeaseRentAmount != 1000 {
+			t.Error("a refused patch changed the lease")
+		}
+		w := patch(mergePatchType+"; charset=utf-8", `{"leaseRentAmount":1100,"version":`+version()+`}`)
+		if w.Code != http.StatusOK {
+			t.Errorf("with charset: status %d (%s), want 200", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("null clears", func(t *testing.T) {
+		before := lease()
+		w := patch(mergePatchType, `{"leaseEndUnix":null,"leaseDocumentLink":null,"version":`+version()+`}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %d (%s), want 200", w.Code, w.Body.String())
+		}
+		after := lease()
+		if after.LeaseEndUnix != nil || after.LeaseDocumentLink != "" {
+			t.Errorf("end %v, document %q; want both cleared", after.LeaseEndUnix, after.LeaseDocumentLink)
+		}
+		// Members the patch leaves out keep their values
+		if after.LeaseRentAmount != before.LeaseRentAmount || after.TenantID != before.TenantID || after.LeaseStatus != before.LeaseStatus {
+			t.Errorf("lease = %+v, want the rest as %+v", after, before)
+		}
+	})
+
+	t.Run("null on a required field", func(t *testing.T) {
+		if w := patch(mergePatchType, `{"leaseRentAmount":null,"version":`+version()+`}`); w.Code != http.StatusBadRequest {
+			t.Errorf("status %d, want 400", w.Code)
+		}
+		if lease().LeaseRentAmount != 1100 {
+			t.Error("clearing a required field changed the lease")
+		}
+	})
+
+	t.Run("read-only members", func(t *testing.T) {
+		before := lease()
+		for _, body := range []string{
+			`{"leaseId":` + strconv.Itoa(leaseID+1) + `,"version":` + version() + `}`,
+			`{"updatedUnix":1,"version":` + version() + `}`,
+			`["leaseRentAmount"]`,
+		} {
+			if w := patch(mergePatchType, body); w.Code != http.StatusBadRequest {
+				t.Errorf("%s: status %d, want 400", body, w.Code)
+			}
+		}
+		// version is the version expected, not a new value
+		if w := patch(mergePatchType, `{"leaseRentAmount":1200,"version":`+strconv.Itoa(before.Version+5)+`}`); w.Code != http.StatusConflict {
+			t.Errorf("patch with another version: status %d, want 409", w.Code)
+		}
+		if after := lease(); !reflect.DeepEqual(after, before) {
+			t.Errorf("lease = %+v, want unchanged %+v", after, before)
+		}
+		// and the stored version is not carried into the patch
+		if w := patch(mergePatchType, `{"leaseRentAmount":1200}`); w.Code != http.StatusPreconditionRequired {
+			t.Errorf("patch without a version: status %d, want 428", w.Code)
+		}
+	})
+}
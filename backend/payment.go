@@ -11,15 +11,19 @@ package main
 // Package-level summary:
 // This file implements payment CRUD HTTP handlers and database helpers for
 // creating, reading, updating, and deleting payment records. Handlers include
-// CreatePaymentHandler, GetPaymentHandler, UpdatePaymentHandler, DeletePaymentHandler.
-// DB helpers: CreatePayment, GetAllPayments, GetPaymentByID, UpdatePayment, DeletePayment.
+// CreatePaymentHandler, GetPaymentHandler, UpdatePaymentHandler, PatchPaymentHandler, DeletePaymentHandler.
+// A payment is allocated to the charges of its lease (see allocation.go); the
+// request body may name some of them in "allocations", and a single payment
+// is returned with its allocation breakdown.
+// DB helpers: CreatePayment, ListPayments, GetPaymentByID, UpdatePayment, DeletePayment.
 
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
-	"strings"
+	"time"
 )
 
 // PAYMENTS
@@ -31,13 +35,21 @@ type Payment struct {
 	PaymentMethod       string `db:"paymentMethod" json:"paymentMethod"`
 	PaymentNotes        string `db:"paymentNotes" json:"paymentNotes"`
 	PaymentConfirmation []byte `db:"paymentConfirmation" json:"paymentConfirmation"`
+	Version             int    `db:"version" json:"version,omitempty"`
+	UpdatedUnix         *int64 `db:"updatedUnix" json:"updatedUnix,omitempty"`
+	// Allocations are the charges the payment pays. On create and update they
+	// are the explicit ones; nil on update keeps those already made.
+	Allocations []PaymentAllocation `json:"allocations,omitempty"`
+	// Unallocated is the part of the payment left over as credit.
+	Unallocated *int `json:"unallocated,omitempty"`
 }
 
 // == Handlers =============================================================================
 // POST
 // CreatePaymentHandler returns an HTTP handler for creating a new payment.
-// Accepts a JSON body, validates required fields, inserts into DB, and responds with the created payment.
-func CreatePaymentHandler(db *sql.DB) http.HandlerFunc {
+// Accepts a JSON body, validates required fields, inserts into DB, and responds with the created payment
+// and how it was allocated.
+func CreatePaymentHandler(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -52,41 +64,74 @@ func CreatePaymentHandler(db *sql.DB) http.HandlerFunc {
 			respondError(w, http.StatusBadRequest, "leaseId, paymentAmount, paymentDateUnix required")
 			return
 		}
-		id, err := CreatePayment(db, &p)
+		if p.PaymentAmount < 0 {
+			respondError(w, http.StatusBadRequest, "paymentAmount must be positive")
+			return
+		}
+		if ok, err := s.Access.CanAccessLease(currentUserID(r), p.LeaseID); err != nil || !ok {
+			respondError(w, http.StatusNotFound, "lease not found")
+			return
+		}
+		id, err := s.Payments.Create(&p)
+		if errors.Is(err, errInvalidAllocation) {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
 		if err != nil {
 			respondError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
 		p.PaymentID = id
+		p.Version = 1
+		allocs, left, err := s.Payments.Allocations(id)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		p.Allocations, p.Unallocated = allocs, &left
+		setETag(w, p.Version)
 		respondJSON(w, http.StatusCreated, p)
 	}
 }
 
 // GET
 // GetPaymentHandler returns an HTTP handler for retrieving payments.
-// If no ID is provided, returns all payments; otherwise, returns the payment with the given ID.
-func GetPaymentHandler(db *sql.DB) http.HandlerFunc {
+// If no ID is provided, returns all payments; otherwise, returns the payment with the given ID
+// and how it is allocated.
+func GetPaymentHandler(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		idStr := strings.TrimPrefix(r.URL.Path, "/payments/")
-		if idStr == "" || idStr == "/" {
-			list, err := GetAllPayments(db)
+		idStr := r.PathValue("id")
+		if idStr == "" {
+			p, err := ParseListParams(r, paymentListSpec)
+			if err != nil {
+				respondError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			page, err := s.Payments.List(currentUserID(r), p)
 			if err != nil {
 				respondError(w, http.StatusInternalServerError, err.Error())
 				return
 			}
-			respondJSON(w, http.StatusOK, list)
+			respondList(w, r, page)
 			return
 		}
-		id, err := strconv.Atoi(strings.Trim(idStr, "/"))
+		id, err := strconv.Atoi(idStr)
 		if err != nil {
 			respondError(w, http.StatusBadRequest, "invalid id")
 			return
 		}
-		p, err := GetPaymentByID(db, id)
+		p, err := s.Payments.GetByID(currentUserID(r), id)
 		if err != nil {
 			respondError(w, http.StatusNotFound, "not found")
 			return
 		}
+		allocs, left, err := s.Payments.Allocations(id)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		p.Allocations, p.Unallocated = allocs, &left
+		setETag(w, p.Version)
 		respondJSON(w, http.StatusOK, p)
 	}
 }
@@ -94,7 +139,7 @@ func GetPaymentHandler(db *sql.DB) http.HandlerFunc {
 // PUT
 // UpdatePaymentHandler returns an HTTP handler for updating a payment.
 // Accepts a JSON body, validates paymentId, updates the DB, and responds with status.
-func UpdatePaymentHandler(db *sql.DB) http.HandlerFunc {
+func UpdatePaymentHandler(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPut {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -105,35 +150,83 @@ func UpdatePaymentHandler(db *sql.DB) http.HandlerFunc {
 			respondError(w, http.StatusBadRequest, "invalid json")
 			return
 		}
+		if !bindPathID(w, r, &p.PaymentID, "paymentId") {
+			return
+		}
 		if p.PaymentID == 0 {
 			respondError(w, http.StatusBadRequest, "paymentId required")
 			return
 		}
-		if err := UpdatePayment(db, &p); err != nil {
-			respondError(w, http.StatusInternalServerError, err.Error())
+		if p.LeaseID == 0 || p.PaymentAmount == 0 || p.PaymentDateUnix == 0 {
+			respondError(w, http.StatusBadRequest, "leaseId, paymentAmount, paymentDateUnix required")
 			return
 		}
-		respondJSON(w, http.StatusOK, map[string]string{"status": "updated"})
+		if p.PaymentAmount < 0 {
+			respondError(w, http.StatusBadRequest, "paymentAmount must be positive")
+			return
+		}
+		if ok, err := s.Access.CanAccessLease(currentUserID(r), p.LeaseID); err != nil || !ok {
+			respondError(w, http.StatusNotFound, "lease not found")
+			return
+		}
+		version, ifMatch, ok := requireVersion(w, r, p.Version)
+		if !ok {
+			return
+		}
+		p.Version = version
+		if err := s.Payments.Update(currentUserID(r), &p); err != nil {
+			if errors.Is(err, errStaleVersion) {
+				if current, err := s.Payments.GetByID(currentUserID(r), p.PaymentID); err == nil {
+					respondStale(w, ifMatch, current, current.Version)
+					return
+				}
+				err = sql.ErrNoRows
+			}
+			if errors.Is(err, sql.ErrNoRows) {
+				respondError(w, http.StatusNotFound, "not found")
+			} else if errors.Is(err, errInvalidAllocation) {
+				respondError(w, http.StatusBadRequest, err.Error())
+			} else {
+				respondError(w, http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+		setETag(w, p.Version)
+		respondJSON(w, http.StatusOK, map[string]interface{}{"status": "updated", "version": p.Version})
 	}
 }
 
+// PATCH
+// PatchPaymentHandler returns an HTTP handler for partially updating a payment.
+// Accepts a JSON merge patch, applies it to the current payment, and passes the
+// result to UpdatePaymentHandler (see patch.go).
+func PatchPaymentHandler(s *Store) http.HandlerFunc {
+	return PatchHandler(func(r *http.Request, id int) (*Payment, error) {
+		return s.Payments.GetByID(currentUserID(r), id)
+	}, UpdatePaymentHandler(s))
+}
+
 // DELETE
 // DeletePaymentHandler returns an HTTP handler for deleting a payment by ID.
 // Accepts a DELETE request, removes the payment from DB, and responds with status.
-func DeletePaymentHandler(db *sql.DB) http.HandlerFunc {
+func DeletePaymentHandler(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodDelete {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
-		idStr := strings.TrimPrefix(r.URL.Path, "/payments/")
-		id, err := strconv.Atoi(strings.Trim(idStr, "/"))
+		idStr := r.PathValue("id")
+		id, err := strconv.Atoi(idStr)
 		if err != nil {
 			respondError(w, http.StatusBadRequest, "invalid id")
 			return
 		}
-		if err := DeletePayment(db, id); err != nil {
-			respondError(w, http.StatusInternalServerError, err.Error())
+		if err := s.Payments.Delete(currentUserID(r), id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				respondError(w, http.StatusNotFound, "not found")
+			} else if !respondDeleteBlocked(w, err) {
+				respondError(w, http.StatusInternalServerError, err.Error())
+			}
 			return
 		}
 		respondJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
@@ -141,59 +234,140 @@ func DeletePaymentHandler(db *sql.DB) http.HandlerFunc {
 }
 
 // == SQL Queries ======================================================================
-// CreatePayment inserts a new payment into the database.
-// Returns the new payment ID and error if insertion fails.
+// CreatePayment inserts a new payment into the database with the explicit
+// allocations in p.Allocations, and reallocates its lease.
+// Returns the new payment ID and error if insertion fails; invalid allocations
+// wrap errInvalidAllocation.
 func CreatePayment(db *sql.DB, p *Payment) (int, error) {
-	res, err := db.Exec(`INSERT INTO payments (leaseId, paymentAmount, paymentDateUnix, paymentMethod, paymentNotes, paymentConfirmation)
-	VALUES (?, ?, ?, ?, ?, ?)`, p.LeaseID, p.PaymentAmount, p.PaymentDateUnix, p.PaymentMethod, p.PaymentNotes, p.PaymentConfirmation)
+	tx, err := db.Begin()
 	if err != nil {
 		return 0, err
 	}
-	id, _ := res.LastInsertId()
-	return int(id), nil
-}
-
-// UpdatePayment updates an existing payment in the database.
-// Returns error if update fails.
-func UpdatePayment(db *sql.DB, p *Payment) error {
-	_, err := db.Exec(`UPDATE payments SET leaseId=?, paymentAmount=?, paymentDateUnix=?, paymentMethod=?, paymentNotes=?, paymentConfirmation=? WHERE paymentId=?`,
-		p.LeaseID, p.PaymentAmount, p.PaymentDateUnix, p.PaymentMethod, p.PaymentNotes, p.PaymentConfirmation, p.PaymentID)
-	return err
+	defer tx.Rollback()
+	var id int
+	err = tx.QueryRow(`INSERT INTO payments (leaseId, paymentAmount, paymentDateUnix, paymentMethod, paymentNotes, paymentConfirmation)
+	VALUES (?, ?, ?, ?, ?, ?) RETURNING paymentId`, p.LeaseID, p.PaymentAmount, p.PaymentDateUnix, p.PaymentMethod, p.PaymentNotes, p.PaymentConfirmation).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	if p.Allocations != nil {
+		if err := setExplicitAllocations(tx, id, p.LeaseID, p.PaymentAmount, p.Allocations); err != nil {
+			return 0, err
+		}
+	}
+	if err := reallocateLease(tx, p.LeaseID); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
 }
 
-// DeletePayment removes a payment from the database by paymentId.
-// Returns error if deletion fails.
-func DeletePayment(db *sql.DB, id int) error {
-	_, err := db.Exec(`DELETE FROM payments WHERE paymentId=?`, id)
-	return err
+// UpdatePayment updates an existing payment visible to userID in the database if
+// it is still at p.Version (0 skips the check), then advances p.Version.
+// Explicit allocations are replaced by p.Allocations unless it is nil, and
+// dropped if the payment moves to another lease; both leases are reallocated.
+// Returns sql.ErrNoRows if the payment is not visible to the user,
+// errStaleVersion if it was changed in the meantime, or an error wrapping
+// errInvalidAllocation.
+func UpdatePayment(db *sql.DB, userID int, p *Payment) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var oldLeaseID int
+	if err := tx.QueryRow(`SELECT leaseId FROM payments WHERE paymentId=?`, p.PaymentID).Scan(&oldLeaseID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	now := time.Now().Unix()
+	args := append([]interface{}{p.LeaseID, p.PaymentAmount, p.PaymentDateUnix, p.PaymentMethod, p.PaymentNotes, p.PaymentConfirmation, now, p.PaymentID, p.Version}, scopeArgs(userID, 2)...)
+	err = tx.QueryRow(`UPDATE payments SET leaseId=?, paymentAmount=?, paymentDateUnix=?, paymentMethod=?, paymentNotes=?, paymentConfirmation=?, version=version+1, updatedUnix=?
+		WHERE paymentId=? AND deletedUnix IS NULL AND ? IN (0, version) AND leaseId IN (`+accessibleLeasesSQL+`) RETURNING version`, args...).Scan(&p.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		return staleOrMissing(GetPaymentByID(db, userID, p.PaymentID))
+	}
+	if err != nil {
+		return err
+	}
+	if oldLeaseID != p.LeaseID {
+		if _, err := tx.Exec(`DELETE FROM paymentAllocations WHERE paymentId=?`, p.PaymentID); err != nil {
+			return err
+		}
+		if err := reallocateLease(tx, oldLeaseID); err != nil {
+			return err
+		}
+	}
+	if p.Allocations != nil {
+		if err := setExplicitAllocations(tx, p.PaymentID, p.LeaseID, p.PaymentAmount, p.Allocations); err != nil {
+			return err
+		}
+	}
+	if err := reallocateLease(tx, p.LeaseID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	p.UpdatedUnix = &now
+	return nil
 }
 
-// GetAllPayments retrieves all payments from the database.
-// Returns a slice of Payment and error if query fails.
-func GetAllPayments(db *sql.DB) ([]Payment, error) {
-	rows, err := db.Query(`SELECT paymentId, leaseId, paymentAmount, paymentDateUnix, paymentMethod, paymentNotes, paymentConfirmation FROM payments`)
+// DeletePayment moves a payment visible to userID to the trash (see trash.go),
+// applying the delete policies for what depends on it (see integrity.go), and
+// gives what it paid back to the lease's other payments and credit, in one
+// transaction. Returns sql.ErrNoRows if the payment is not visible to the user
+// or is already in the trash, or a *DeleteBlockedError if dependent records
+// prevent the delete.
+func DeletePayment(db *sql.DB, userID, id int) error {
+	tx, err := db.Begin()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
-
-	var out []Payment
-	for rows.Next() {
-		var p Payment
-		if err := rows.Scan(&p.PaymentID, &p.LeaseID, &p.PaymentAmount, &p.PaymentDateUnix, &p.PaymentMethod, &p.PaymentNotes, &p.PaymentConfirmation); err != nil {
-			return nil, err
-		}
-		out = append(out, p)
+	defer tx.Rollback()
+	if err := trashRow(tx, userID, "payments", id); err != nil {
+		return err
+	}
+	if err := reallocatePaymentLease(tx, id); err != nil {
+		return err
 	}
-	return out, nil
+	return tx.Commit()
+}
+
+// paymentListSpec describes how payments are listed, sorted and filtered (see list.go).
+var paymentListSpec = listSpec{
+	columns:     `paymentId, leaseId, paymentAmount, paymentDateUnix, paymentMethod, paymentNotes, paymentConfirmation, version, updatedUnix`,
+	table:       `payments`,
+	idColumn:    `paymentId`,
+	defaultSort: "-paymentDateUnix",
+	sorts: map[string]string{
+		"paymentId":       "paymentId",
+		"paymentDateUnix": "paymentDateUnix",
+		"paymentAmount":   "paymentAmount",
+	},
+	filters: map[string]listFilter{
+		"leaseId": {expr: "leaseId", op: "=", isInt: true},
+		"method":  {expr: "paymentMethod", op: "="},
+		"from":    {expr: "paymentDateUnix", op: ">=", isInt: true},
+		"to":      {expr: "paymentDateUnix", op: "<=", isInt: true},
+	},
+}
+
+// ListPayments returns one page of the payments visible to userID,
+// filtered and sorted as requested in p.
+func ListPayments(db *sql.DB, userID int, p ListParams) (*Page[Payment], error) {
+	return queryPage(db, paymentListSpec, p, `deletedUnix IS NULL AND leaseId IN (`+accessibleLeasesSQL+`)`, scopeArgs(userID, 2), func(p *Payment) []interface{} {
+		return []interface{}{&p.PaymentID, &p.LeaseID, &p.PaymentAmount, &p.PaymentDateUnix, &p.PaymentMethod, &p.PaymentNotes, &p.PaymentConfirmation, &p.Version, &p.UpdatedUnix}
+	})
 }
 
-// GetPaymentByID retrieves a payment by paymentId from the database.
+// GetPaymentByID retrieves a payment visible to userID by paymentId from the database.
 // Returns pointer to Payment and error if not found or query fails.
-func GetPaymentByID(db *sql.DB, id int) (*Payment, error) {
+func GetPaymentByID(db *sql.DB, userID, id int) (*Payment, error) {
 	var p Payment
-	err := db.QueryRow(`SELECT paymentId, leaseId, paymentAmount, paymentDateUnix, paymentMethod, paymentNotes, paymentConfirmation FROM payments WHERE paymentId=?`, id).
-		Scan(&p.PaymentID, &p.LeaseID, &p.PaymentAmount, &p.PaymentDateUnix, &p.PaymentMethod, &p.PaymentNotes, &p.PaymentConfirmation)
+	args := append([]interface{}{id}, scopeArgs(userID, 2)...)
+	err := db.QueryRow(`SELECT paymentId, leaseId, paymentAmount, paymentDateUnix, paymentMethod, paymentNotes, paymentConfirmation, version, updatedUnix FROM payments
+		WHERE paymentId=? AND deletedUnix IS NULL AND leaseId IN (`+accessibleLeasesSQL+`)`, args...).
+		Scan(&p.PaymentID, &p.LeaseID, &p.PaymentAmount, &p.PaymentDateUnix, &p.PaymentMethod, &p.PaymentNotes, &p.PaymentConfirmation, &p.Version, &p.UpdatedUnix)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,770 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Package-level summary:
+// This file implements the tenant portal. Landlord staff invite a tenant by
+// email; the tenant follows the single-use invite link to set a password and
+// can then log in to a separate, read-mostly API under /portal/. Tenant tokens
+// carry a tenant_id claim and the "tenant-portal" audience, so they are never
+// accepted by AuthMiddleware, and staff tokens are never accepted here. A
+// tenant sees only their own leases, payments and balances, and can submit and
+// track maintenance requests tied to one of their leases.
+// Staff handlers: InviteTenantHandler, DisableTenantPortalHandler.
+// Portal handlers: AcceptTenantInviteHandler, PortalLoginHandler,
+// PortalLogoutHandler, PortalMeHandler, PortalLeasesHandler,
+// PortalPaymentsHandler, PortalBalancesHandler, PortalMaintenanceHandler,
+// PortalMaintenanceByIDHandler. Middleware: TenantAuthMiddleware.
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	tenantInviteTTL  = 7 * 24 * time.Hour
+	tenantSessionTTL = 12 * time.Hour
+	tenantAudience   = "tenant-portal"
+)
+
+const (
+	tenantIDKey        contextKey = "tenantId"
+	tenantSessionIDKey contextKey = "tenantSessionId"
+)
+
+var (
+	errInvalidInvite      = errors.New("invalid tenant invite")
+	errTenantEmailTaken   = errors.New("email already used by another tenant account")
+	errTenantSessionEnded = errors.New("tenant session revoked or account disabled")
+)
+
+// portalInviteURL is the page invite links point to; the token is added as
+// ?token=. Set by Config.Apply.
+var portalInviteURL = "http://localhost:8081/portal/accept-invite"
+
+// PortalPayment is a payment as the tenant sees it. The staff's paymentNotes
+// are left out.
+type PortalPayment struct {
+	PaymentID           int    `json:"paymentId"`
+	LeaseID             int    `json:"leaseId"`
+	PaymentAmount       int    `json:"paymentAmount"`
+	PaymentDateUnix     int64  `json:"paymentDateUnix"`
+	PaymentMethod       string `json:"paymentMethod"`
+	PaymentConfirmation []byte `json:"paymentConfirmation"`
+}
+
+// portalAttemptKey is what tenant logins are recorded under in
+// loginAttempts.attemptEmail. Keeping them apart from staff logins means a
+// tenant and a staff account with the same email never lock, or unlock, each
+// other (see lockout.go).
+func portalAttemptKey(email string) string {
+	return "tenant:" + email
+}
+
+// == Tenant auth ==================================================================
+
+// generateTenantJWT signs an access token for a tenant portal session.
+func generateTenantJWT(tenantID int, sessionID string) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"tenant_id": tenantID,
+		"sid":       sessionID,
+		"aud":       tenantAudience,
+		"iat":       now.Unix(),
+		"exp":       now.Add(tenantSessionTTL).Unix(),
+	}
+	return signingKeys.Sign(claims)
+}
+
+// startTenantSession opens a portal session and returns its access token in
+// the same shape as a staff login (without a refresh token).
+func startTenantSession(db *sql.DB, tenantID int) (*TokenPair, error) {
+	sessionID, err := CreateTenantSession(db, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	token, err := generateTenantJWT(tenantID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{Token: token, ExpiresIn: int(tenantSessionTTL.Seconds())}, nil
+}
+
+// TenantAuthMiddleware wraps a portal handler and requires a tenant access
+// token whose session is active and whose account is still enabled. The tenant
+// ID is stored in the request context.
+func TenantAuthMiddleware(db *sql.DB, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenStr, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || tokenStr == "" {
+			respondError(w, http.StatusUnauthorized, "Missing authorization token")
+			return
+		}
+
+		claims := jwt.MapClaims{}
+		_, err := jwt.ParseWithClaims(tokenStr, claims, signingKeys.Keyfunc,
+			jwt.WithValidMethods(signingKeys.ValidMethods()), jwt.WithExpirationRequired(), jwt.WithAudience(tenantAudience))
+		if err != nil {
+			if errors.Is(err, jwt.ErrTokenExpired) {
+				respondError(w, http.StatusUnauthorized, "Token expired")
+			} else {
+				respondError(w, http.StatusUnauthorized, "Invalid token")
+			}
+			return
+		}
+		rawID, _ := claims["tenant_id"].(float64)
+		sessionID, _ := claims["sid"].(string)
+		if rawID <= 0 || sessionID == "" {
+			respondError(w, http.StatusUnauthorized, "Invalid token")
+			return
+		}
+
+		tenantID := int(rawID)
+		if err := CheckTenantSession(db, tenantID, sessionID); err != nil {
+			if errors.Is(err, errTenantSessionEnded) {
+				respondError(w, http.StatusUnauthorized, "Session revoked")
+			} else {
+				respondError(w, http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), tenantIDKey, tenantID)
+		ctx = context.WithValue(ctx, tenantSessionIDKey, sessionID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// currentTenantID returns the tenant ID stored by TenantAuthMiddleware.
+func currentTenantID(r *http.Request) int {
+	id, _ := r.Context().Value(tenantIDKey).(int)
+	return id
+}
+
+// == Staff handlers ===============================================================
+// POST /v1/tenants/{id}/invite (legacy: POST /tenants/invite)
+// InviteTenantHandler returns an HTTP handler that invites a tenant to the
+// portal. Accepts {tenantId, email}, with tenantId taken from the path when
+// present; email defaults to the tenant's email
+// address. Re-inviting re-enables a disabled account and sends a fresh link.
+func InviteTenantHandler(db *sql.DB, mailer Mailer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var body struct {
+			TenantID int    `json:"tenantId"`
+			Email    string `json:"email"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+			respondError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		if !bindPathID(w, r, &body.TenantID, "tenantId") {
+			return
+		}
+		if body.TenantID == 0 {
+			respondError(w, http.StatusBadRequest, "tenantId required")
+			return
+		}
+		t, err := GetTenantByID(db, currentUserID(r), body.TenantID)
+		if err != nil {
+			respondError(w, http.StatusNotFound, "tenant not found")
+			return
+		}
+		email := normalizeEmail(body.Email)
+		if email == "" {
+			email = normalizeEmail(t.TenantEmail)
+		}
+		if email == "" {
+			respondError(w, http.StatusBadRequest, "email required: tenant has no email address on file")
+			return
+		}
+
+		token, err := InviteTenant(db, t.TenantID, email, currentUserID(r))
+		if err != nil {
+			if errors.Is(err, errTenantEmailTaken) {
+				respondError(w, http.StatusConflict, err.Error())
+			} else {
+				respondError(w, http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+
+		link := portalInviteURL + "?token=" + url.QueryEscape(token)
+		msg := "Hi " + t.TenantFirstName + ",\n\n" +
+			"You have been invited to the RentTracker tenant portal, where you can view your lease, " +
+			"payments and balance, and submit maintenance requests.\n\n" +
+			"Set your password: " + link + "\n\n" +
+			"This link expires in 7 days."
+		if err := mailer.Send(email, "Your RentTracker tenant portal invitation", msg); err != nil {
+			log.Print("send tenant invite: ", err)
+			respondError(w, http.StatusBadGateway, "Invite created but the email could not be sent")
+			return
+		}
+		respondJSON(w, http.StatusCreated, map[string]interface{}{"tenantId": t.TenantID, "email": email, "status": "invited"})
+	}
+}
+
+// DELETE /v1/tenants/{id}/portal (legacy: DELETE /tenants/portal/delete/{id})
+// DisableTenantPortalHandler returns an HTTP handler that turns off a tenant's
+// portal access and ends their sessions. The tenant record itself is kept.
+func DisableTenantPortalHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "invalid tenantId")
+			return
+		}
+		if ok, err := canAccessTenant(db, currentUserID(r), id); err != nil || !ok {
+			respondError(w, http.StatusNotFound, "tenant not found")
+			return
+		}
+		if err := DisableTenantAccount(db, id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				respondError(w, http.StatusNotFound, "tenant has no portal account")
+			} else {
+				respondError(w, http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]string{"status": "disabled"})
+	}
+}
+
+// == Portal handlers ==============================================================
+// POST /portal/invite/accept
+// AcceptTenantInviteHandler returns an HTTP handler that sets the tenant's
+// password from an invite token and logs them in.
+func AcceptTenantInviteHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var body struct {
+			Token    string `json:"token"`
+			Password string `json:"password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Token == "" {
+			respondError(w, http.StatusBadRequest, "token and password required")
+			return
+		}
+		if err := validatePassword(body.Password); err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		hashed, err := bcrypt.GenerateFromPassword([]byte(body.Password), bcrypt.DefaultCost)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to hash password")
+			return
+		}
+
+		tenantID, err := AcceptTenantInvite(db, body.Token, string(hashed))
+		if err != nil {
+			if errors.Is(err, errInvalidInvite) {
+				respondError(w, http.StatusBadRequest, "Invalid or expired invite")
+			} else {
+				respondError(w, http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+
+		pair, err := startTenantSession(db, tenantID)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to generate token")
+			return
+		}
+		respondJSON(w, http.StatusOK, pair)
+	}
+}
+
+// POST /portal/login
+// PortalLoginHandler returns an HTTP handler for tenant login. Shares the
+// lockout rules and uniform error response of the staff LoginHandler, but
+// counts attempts per tenant email separately (see portalAttemptKey).
+func PortalLoginHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var creds Credentials
+		if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid request")
+			return
+		}
+		email := normalizeEmail(creds.Email)
+		key := portalAttemptKey(email)
+		ip := clientIP(r)
+
+		lockedUntil, err := LoginLockedUntil(db, key, ip, time.Now())
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to check login attempts")
+			return
+		}
+		if !lockedUntil.IsZero() {
+			if err := RecordLoginAttempt(db, key, 0, ip, LoginLocked); err != nil {
+				log.Print(err)
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(lockedUntil).Seconds())+1))
+			respondError(w, http.StatusTooManyRequests, "Too many failed login attempts. Try again later.")
+			return
+		}
+
+		tenantID, hashedPassword, err := GetTenantCredentials(db, email)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusInternalServerError, "Login failed")
+			return
+		}
+		if err != nil {
+			hashedPassword = string(dummyPasswordHash)
+		}
+		if bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(creds.Password)) != nil || tenantID == 0 {
+			if err := RecordLoginAttempt(db, key, 0, ip, LoginFailure); err != nil {
+				log.Print(err)
+			}
+			respondError(w, http.StatusUnauthorized, "Invalid email or password")
+			return
+		}
+		if err := RecordLoginAttempt(db, key, 0, ip, LoginSuccess); err != nil {
+			log.Print(err)
+		}
+
+		pair, err := startTenantSession(db, tenantID)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to generate token")
+			return
+		}
+		respondJSON(w, http.StatusOK, pair)
+	}
+}
+
+// POST /portal/logout
+// PortalLogoutHandler returns an HTTP handler that ends the tenant's current session.
+func PortalLogoutHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		sessionID, _ := r.Context().Value(tenantSessionIDKey).(string)
+		if _, err := db.Exec(`UPDATE tenantSessions SET revokedUnix=? WHERE sessionId=? AND revokedUnix IS NULL`,
+			time.Now().Unix(), sessionID); err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]string{"status": "logged out"})
+	}
+}
+
+// GET /portal/me
+// PortalMeHandler returns an HTTP handler for the tenant's own profile.
+func PortalMeHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var t Tenant
+		err := db.QueryRow(`SELECT tenantId, tenantFirstName, tenantLastName, COALESCE(tenantEmailAddress,''), COALESCE(tenantPhoneNumber,'') FROM tenants WHERE tenantId=? AND deletedUnix IS NULL`,
+			currentTenantID(r)).Scan(&t.TenantID, &t.TenantFirstName, &t.TenantLastName, &t.TenantEmail, &t.TenantPhone)
+		if err != nil {
+			respondError(w, http.StatusNotFound, "tenant not found")
+			return
+		}
+		respondJSON(w, http.StatusOK, t)
+	}
+}
+
+// GET /portal/leases
+// PortalLeasesHandler returns an HTTP handler listing the tenant's leases.
+func PortalLeasesHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		leases, err := GetTenantLeases(db, currentTenantID(r))
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		respondJSON(w, http.StatusOK, leases)
+	}
+}
+
+// GET /portal/payments
+// PortalPaymentsHandler returns an HTTP handler listing payments on the tenant's leases.
+func PortalPaymentsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		payments, err := GetTenantPayments(db, currentTenantID(r))
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		respondJSON(w, http.StatusOK, payments)
+	}
+}
+
+// GET /portal/balances
+// PortalBalancesHandler returns an HTTP handler with the balance of each of the tenant's leases.
+func PortalBalancesHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		balances, err := GetTenantBalances(db, currentTenantID(r), time.Now())
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		respondJSON(w, http.StatusOK, balances)
+	}
+}
+
+// GET, POST /portal/maintenance
+// PortalMaintenanceHandler returns an HTTP handler that lists the tenant's
+// maintenance requests (GET) or submits a new one against one of their leases
+// (POST {leaseId, maintenanceRequestInfo, maintenanceRequestPriority, maintenanceRequestCategory}).
+func PortalMaintenanceHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tenantID := currentTenantID(r)
+		switch r.Method {
+		case http.MethodGet:
+			list, err := GetTenantMaintenanceRequests(db, tenantID)
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			respondJSON(w, http.StatusOK, list)
+
+		case http.MethodPost:
+			var m MaintenanceRequest
+			if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+				respondError(w, http.StatusBadRequest, "invalid json")
+				return
+			}
+			if m.LeaseID == nil || strings.TrimSpace(m.MaintenanceRequestInfo) == "" {
+				respondError(w, http.StatusBadRequest, "leaseId and maintenanceRequestInfo required")
+				return
+			}
+			unitID, err := GetTenantLeaseUnit(db, tenantID, *m.LeaseID)
+			if err != nil {
+				respondError(w, http.StatusNotFound, "lease not found")
+				return
+			}
+			switch m.MaintenanceRequestPriority {
+			case "":
+				m.MaintenanceRequestPriority = "normal"
+			case "low", "normal", "urgent":
+			default:
+				respondError(w, http.StatusBadRequest, "maintenanceRequestPriority must be low, normal, or urgent")
+				return
+			}
+
+			// Tenants only describe the problem; status, dates and assignment are set here
+			m.PropertyUnitID = unitID
+			m.MaintenanceRequestStatus = "open"
+			m.MaintenanceRequestCreatedUnix = time.Now().Unix()
+			m.MaintenanceRequestCompletedUnix = nil
+			m.MaintenanceRequestAssignedTo = ""
+			id, err := CreateMaintenanceRequest(db, &m)
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			m.MaintenanceRequestID = id
+			respondJSON(w, http.StatusCreated, m)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// GET /portal/maintenance/{id}
+// PortalMaintenanceByIDHandler returns an HTTP handler for one of the tenant's
+// maintenance requests, so they can track its status.
+func PortalMaintenanceByIDHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(r.URL.Path, "/portal/maintenance/"), "/"))
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "invalid id")
+			return
+		}
+		list, err := GetTenantMaintenanceRequests(db, currentTenantID(r))
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		for _, m := range list {
+			if m.MaintenanceRequestID == id {
+				respondJSON(w, http.StatusOK, m)
+				return
+			}
+		}
+		respondError(w, http.StatusNotFound, "maintenance request not found")
+	}
+}
+
+// == SQL Queries =====================================================================
+
+// InviteTenant creates or re-enables a tenant's portal account and stores a new
+// invite token, invalidating earlier ones. Returns the plaintext token.
+func InviteTenant(db *sql.DB, tenantID int, email string, invitedBy int) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var taken bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM tenantAccounts WHERE accountEmail=? AND tenantId<>?)`, email, tenantID).Scan(&taken); err != nil {
+		return "", err
+	}
+	if taken {
+		return "", errTenantEmailTaken
+	}
+
+	if _, err := tx.Exec(`INSERT INTO tenantAccounts (tenantId, accountEmail, invitedByUserId, invitedUnix) VALUES (?, ?, ?, ?)
+		ON CONFLICT(tenantId) DO UPDATE SET accountEmail=excluded.accountEmail, invitedByUserId=excluded.invitedByUserId,
+			invitedUnix=excluded.invitedUnix, disabledUnix=NULL`,
+		tenantID, email, invitedBy, now.Unix()); err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec(`UPDATE tenantInvites SET usedUnix=? WHERE tenantId=? AND usedUnix IS NULL`, now.Unix(), tenantID); err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec(`INSERT INTO tenantInvites (tokenHash, tenantId, createdUnix, expiresUnix) VALUES (?, ?, ?, ?)`,
+		hashToken(token), tenantID, now.Unix(), now.Add(tenantInviteTTL).Unix()); err != nil {
+		return "", err
+	}
+	return token, tx.Commit()
+}
+
+// AcceptTenantInvite consumes an invite token and sets the account password.
+// Returns the tenant ID, or errInvalidInvite if the token is unknown, expired,
+// used, or the account has since been disabled.
+func AcceptTenantInvite(db *sql.DB, token, passwordHash string) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().Unix()
+	var tenantID int
+	err = tx.QueryRow(`SELECT i.tenantId FROM tenantInvites i JOIN tenantAccounts a ON a.tenantId = i.tenantId
+		WHERE i.tokenHash=? AND i.usedUnix IS NULL AND i.expiresUnix > ? AND a.disabledUnix IS NULL`,
+		hashToken(token), now).Scan(&tenantID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, errInvalidInvite
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if err := checkAffected(tx.Exec(`UPDATE tenantInvites SET usedUnix=? WHERE tokenHash=? AND usedUnix IS NULL`, now, hashToken(token))); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, errInvalidInvite
+		}
+		return 0, err
+	}
+	if _, err := tx.Exec(`UPDATE tenantAccounts SET passwordHash=?, activatedUnix=COALESCE(activatedUnix, ?) WHERE tenantId=?`,
+		passwordHash, now, tenantID); err != nil {
+		return 0, err
+	}
+	// A new password ends any sessions started with the old one
+	if _, err := tx.Exec(`UPDATE tenantSessions SET revokedUnix=? WHERE tenantId=? AND revokedUnix IS NULL`, now, tenantID); err != nil {
+		return 0, err
+	}
+	return tenantID, tx.Commit()
+}
+
+// DisableTenantAccount turns off a tenant's portal account and revokes its sessions.
+// Returns sql.ErrNoRows if the tenant has no portal account.
+func DisableTenantAccount(db *sql.DB, tenantID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().Unix()
+	if err := checkAffected(tx.Exec(`UPDATE tenantAccounts SET disabledUnix=? WHERE tenantId=?`, now, tenantID)); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE tenantSessions SET revokedUnix=? WHERE tenantId=? AND revokedUnix IS NULL`, now, tenantID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE tenantInvites SET usedUnix=? WHERE tenantId=? AND usedUnix IS NULL`, now, tenantID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetTenantCredentials returns the tenant ID and password hash of an active
+// portal account whose tenant is not in the trash. Returns sql.ErrNoRows if
+// there is none.
+func GetTenantCredentials(db *sql.DB, email string) (int, string, error) {
+	var tenantID int
+	var hash string
+	err := db.QueryRow(`SELECT tenantId, passwordHash FROM tenantAccounts
+		WHERE accountEmail=? AND passwordHash IS NOT NULL AND disabledUnix IS NULL
+		AND tenantId IN (SELECT tenantId FROM tenants WHERE deletedUnix IS NULL)`, email).Scan(&tenantID, &hash)
+	return tenantID, hash, err
+}
+
+// CreateTenantSession inserts a new portal session for a tenant.
+// Returns the random session ID and error if insertion fails.
+func CreateTenantSession(db *sql.DB, tenantID int) (string, error) {
+	sessionID, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+	_, err = db.Exec(`INSERT INTO tenantSessions (sessionId, tenantId, createdUnix) VALUES (?, ?, ?)`,
+		sessionID, tenantID, time.Now().Unix())
+	return sessionID, err
+}
+
+// CheckTenantSession returns errTenantSessionEnded unless the session is
+// active and the tenant's account is enabled.
+func CheckTenantSession(db *sql.DB, tenantID int, sessionID string) error {
+	var ok bool
+	err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM tenantSessions s JOIN tenantAccounts a ON a.tenantId = s.tenantId
+		WHERE s.sessionId=? AND s.tenantId=? AND s.revokedUnix IS NULL AND a.disabledUnix IS NULL)`, sessionID, tenantID).Scan(&ok)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errTenantSessionEnded
+	}
+	return nil
+}
+
+// GetTenantLeases retrieves every lease signed by a tenant, except leases in the trash.
+// Returns a slice of Lease and error if query fails.
+func GetTenantLeases(db *sql.DB, tenantID int) ([]Lease, error) {
+	rows, err := db.Query(`SELECT leaseId, tenantId, propertyUnitId, leaseStartUnix, leaseEndUnix, leaseRentAmount, leaseSecurityDeposit, leaseDocumentLink, leaseStatus, leaseRentDueDay FROM leases
+		WHERE tenantId=? AND deletedUnix IS NULL ORDER BY leaseStartUnix DESC`, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Lease
+	for rows.Next() {
+		var l Lease
+		if err := rows.Scan(&l.LeaseID, &l.TenantID, &l.PropertyUnitID, &l.LeaseStartUnix, &l.LeaseEndUnix, &l.LeaseRentAmount, &l.LeaseSecurityDeposit, &l.LeaseDocumentLink, &l.LeaseStatus, &l.LeaseRentDueDay); err != nil {
+			return nil, err
+		}
+		out = append(out, l)
+	}
+	return out, rows.Err()
+}
+
+// GetTenantLeaseUnit returns the unit of a lease if it belongs to the tenant.
+func GetTenantLeaseUnit(db *sql.DB, tenantID, leaseID int) (int, error) {
+	var unitID int
+	err := db.QueryRow(`SELECT propertyUnitId FROM leases WHERE leaseId=? AND tenantId=? AND deletedUnix IS NULL`, leaseID, tenantID).Scan(&unitID)
+	return unitID, err
+}
+
+// GetTenantPayments retrieves payments recorded against a tenant's leases, newest first.
+// Returns a slice of PortalPayment and error if query fails.
+func GetTenantPayments(db *sql.DB, tenantID int) ([]PortalPayment, error) {
+	rows, err := db.Query(`SELECT paymentId, leaseId, paymentAmount, paymentDateUnix, paymentMethod, paymentConfirmation FROM payments
+		WHERE deletedUnix IS NULL AND leaseId IN (SELECT leaseId FROM leases WHERE tenantId=? AND deletedUnix IS NULL) ORDER BY paymentDateUnix DESC`, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []PortalPayment
+	for rows.Next() {
+		var p PortalPayment
+		if err := rows.Scan(&p.PaymentID, &p.LeaseID, &p.PaymentAmount, &p.PaymentDateUnix, &p.PaymentMethod, &p.PaymentConfirmation); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// GetTenantBalances returns the balance of each of a tenant's leases as of
+// now, from the lease ledgers (see ledger.go).
+func GetTenantBalances(db *sql.DB, tenantID int, now time.Time) ([]LeaseBalance, error) {
+	leases, err := GetTenantLeases(db, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int, len(leases))
+	for i, l := range leases {
+		ids[i] = l.LeaseID
+	}
+	balance, err := sumLeaseBalances(db, tenantID, ids, now)
+	if err != nil {
+		return nil, err
+	}
+	return balance.Leases, nil
+}
+
+// GetTenantMaintenanceRequests retrieves maintenance requests tied to a
+// tenant's leases, newest first.
+func GetTenantMaintenanceRequests(db *sql.DB, tenantID int) ([]MaintenanceRequest, error) {
+	rows, err := db.Query(`SELECT maintenanceRequestId, propertyUnitId, leaseId, maintenanceRequestInfo, COALESCE(maintenanceRequestPriority, ''), COALESCE(maintenanceRequestCategory, ''), COALESCE(maintenanceRequestStatus, ''), maintenanceRequestCreatedUnix, maintenanceRequestCompletedUnix, COALESCE(maintenanceAssignedTo, '') FROM maintenanceRequests
+		WHERE deletedUnix IS NULL AND leaseId IN (SELECT leaseId FROM leases WHERE tenantId=? AND deletedUnix IS NULL) ORDER BY maintenanceRequestCreatedUnix DESC`, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []MaintenanceRequest
+	for rows.Next() {
+		var m MaintenanceRequest
+		if err := rows.Scan(&m.MaintenanceRequestID, &m.PropertyUnitID, &m.LeaseID, &m.MaintenanceRequestInfo, &m.MaintenanceRequestPriority, &m.MaintenanceRequestCategory, &m.MaintenanceRequestStatus, &m.MaintenanceRequestCreatedUnix, &m.MaintenanceRequestCompletedUnix, &m.MaintenanceRequestAssignedTo); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
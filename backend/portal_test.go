@@ -0,0 +1,168 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Tests for portal.go: a tenant portal token reads only the tenant's own
+// leases, payments and maintenance requests, is refused on staff routes, and
+// staff tokens are refused on the portal.
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+func TestPortalScope(t *testing.T) {
+	useTestKeys(t)
+	s := newTestStore(t)
+	ownerID := createTestUser(t, s, "owner@example.com", "owner")
+	propertyID, err := s.Properties.Create(&Property{OwnerUserID: ownerID, PropertyName: "Maple", PropertyStreet: "1 Maple St", PropertyCity: "Morgantown"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.Local)
+	// Two tenants, each with a lease, a payment and a maintenance request
+	type tenancy struct{ tenantID, leaseID, paymentID, maintenanceID int }
+	newTenancy := func(name string) tenancy {
+		var tc tenancy
+		var err error
+		if tc.tenantID, err = s.Tenants.Create(&Tenant{OwnerUserID: ownerID, TenantFirstName: name, TenantLastName: "Renter", TenantEmail: strings.ToLower(name) + "@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+		unitID, err := s.Units.Create(&PropertyUnit{PropertyID: propertyID, PropertyUnitNumber: name, PropertyUnitRentDefault: 1000})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.leaseID, err = s.Leases.Create(&Lease{TenantID: tc.tenantID, PropertyUnitID: unitID, LeaseStartUnix: start.Unix(), LeaseRentAmount: 1000, LeaseRentDueDay: 1, LeaseStatus: "active"}); err != nil {
+			t.Fatal(err)
+		}
+		if tc.paymentID, err = s.Payments.Create(&Payment{LeaseID: tc.leaseID, PaymentAmount: 1000, PaymentDateUnix: start.Unix(), PaymentMethod: "check"}); err != nil {
+			t.Fatal(err)
+		}
+		if tc.maintenanceID, err = s.Maintenance.Create(&MaintenanceRequest{PropertyUnitID: unitID, LeaseID: &tc.leaseID, MaintenanceRequestInfo: "Leaking tap", MaintenanceRequestStatus: "open"}); err != nil {
+			t.Fatal(err)
+		}
+		return tc
+	}
+	mine, theirs := newTenancy("Tomasz"), newTenancy("Ula")
+
+	h := AuthMiddleware(s.DB, RBACMiddleware(s.DB, buildRouter(s, s.DB, nil)))
+	send := func(token, method, path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	invite, err := InviteTenant(s.DB, mine.tenantID, "tomasz@example.com", ownerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := send("", http.MethodPost, "/portal/invite/accept", `{"token":"`+invite+`","password":"correct horse"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("accept invite: status %d (%s)", w.Code, w.Body.String())
+	}
+	var pair TokenPair
+	if err := json.Unmarshal(w.Body.Bytes(), &pair); err != nil {
+		t.Fatal(err)
+	}
+	tenantToken := pair.Token
+
+	t.Run("own records only", func(t *testing.T) {
+		w := send(tenantToken, http.MethodGet, "/portal/leases", "")
+		var leases []Lease
+		if err := json.Unmarshal(w.Body.Bytes(), &leases); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("leases: status %d, %v", w.Code, err)
+		}
+		if len(leases) != 1 || leases[0].LeaseID != mine.leaseID {
+			t.Errorf("leases = %+v, want only lease %d", leases, mine.leaseID)
+		}
+
+		w = send(tenantToken, http.MethodGet, "/portal/payments", "")
+		var payments []PortalPayment
+		if err := json.Unmarshal(w.Body.Bytes(), &payments); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("payments: status %d, %v", w.Code, err)
+		}
+		if len(payments) != 1 || payments[0].PaymentID != mine.paymentID {
+			t.Errorf("payments = %+v, want only payment %d", payments, mine.paymentID)
+		}
+
+		w = send(tenantToken, http.MethodGet, "/portal/balances", "")
+		var balances []LeaseBalance
+		if err := json.Unmarshal(w.Body.Bytes(), &balances); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("balances: status %d, %v", w.Code, err)
+		}
+		if len(balances) != 1 || balances[0].LeaseID != mine.leaseID {
+			t.Errorf("balances = %+v, want only lease %d", balances, mine.leaseID)
+		}
+
+		if w := send(tenantToken, http.MethodGet, "/portal/maintenance/"+strconv.Itoa(mine.maintenanceID), ""); w.Code != http.StatusOK {
+			t.Errorf("own maintenance request: status %d, want 200", w.Code)
+		}
+		if w := send(tenantToken, http.MethodGet, "/portal/maintenance/"+strconv.Itoa(theirs.maintenanceID), ""); w.Code != http.StatusNotFound {
+			t.Errorf("another tenant's maintenance request: status %d, want 404", w.Code)
+		}
+		body := `{"leaseId":` + strconv.Itoa(theirs.leaseID) + `,"maintenanceRequestInfo":"Broken window"}`
+		if w := send(tenantToken, http.MethodPost, "/portal/maintenance", body); w.Code != http.StatusNotFound {
+			t.Errorf("request on another tenant's lease: status %d, want 404", w.Code)
+		}
+	})
+
+	t.Run("refused on staff routes", func(t *testing.T) {
+		for _, path := range []string{
+			"/v1/leases",
+			"/v1/leases/" + strconv.Itoa(mine.leaseID),
+			"/v1/payments",
+			"/v1/payments/" + strconv.Itoa(mine.paymentID),
+			"/v1/tenants/" + strconv.Itoa(mine.tenantID),
+			"/leases",
+		} {
+			if w := send(tenantToken, http.MethodGet, path, ""); w.Code != http.StatusUnauthorized {
+				t.Errorf("GET %s: status %d, want 401", path, w.Code)
+			}
+		}
+		if w := send(tenantToken, http.MethodDelete, "/v1/payments/"+strconv.Itoa(mine.paymentID), ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("DELETE payment: status %d, want 401", w.Code)
+		}
+		if _, err := s.Payments.GetByID(ownerID, mine.paymentID); err != nil {
+			t.Errorf("payment after a tenant's delete: %v", err)
+		}
+		// Even with a user_id claim, a portal token is not a staff token
+		forged, err := signingKeys.Sign(jwt.MapClaims{"user_id": ownerID, "sid": "x", "aud": tenantAudience, "exp": time.Now().Add(time.Hour).Unix()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := parseBearerToken(forged); err == nil {
+			t.Error("a portal token with a user_id claim was accepted as a staff token")
+		}
+	})
+
+	t.Run("staff token refused on the portal", func(t *testing.T) {
+		staff, err := startSession(s.DB, ownerID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w := send(staff.Token, http.MethodGet, "/v1/leases", ""); w.Code != http.StatusOK {
+			t.Fatalf("staff token on a staff route: status %d, want 200", w.Code)
+		}
+		for _, path := range []string{"/portal/leases", "/portal/payments", "/portal/me"} {
+			// RBAC refuses it before TenantAuthMiddleware would
+			if w := send(staff.Token, http.MethodGet, path, ""); w.Code != http.StatusUnauthorized && w.Code != http.StatusForbidden {
+				t.Errorf("staff token on %s: status %d, want 401 or 403", path, w.Code)
+			}
+		}
+	})
+}
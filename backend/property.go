@@ -11,15 +11,16 @@ package main
 // Package-level summary:
 // This file implements property CRUD HTTP handlers and database helpers for
 // creating, reading, updating, and deleting property records. Handlers include
-// CreatePropertyHandler, GetPropertyHandler, UpdatePropertyHandler, DeletePropertyHandler.
-// DB helpers: CreateProperty, GetAllProperties, GetPropertyByID, UpdateProperty, DeleteProperty.
+// CreatePropertyHandler, GetPropertyHandler, UpdatePropertyHandler, PatchPropertyHandler, DeletePropertyHandler.
+// DB helpers: CreateProperty, ListProperties, GetPropertyByID, UpdateProperty, DeleteProperty.
 
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
-	"strings"
+	"time"
 )
 
 // PROPERTIES
@@ -34,13 +35,15 @@ type Property struct {
 	PropertyType      string `db:"propertyType" json:"propertyType"`
 	PropertyYearBuilt int    `db:"propertyYearBuilt" json:"propertyYearBuilt"`
 	PropertyNotes     string `db:"propertyNotes" json:"propertyNotes"`
+	Version           int    `db:"version" json:"version,omitempty"`
+	UpdatedUnix       *int64 `db:"updatedUnix" json:"updatedUnix,omitempty"`
 }
 
 // == Handlers ========================================================================
 // POST
 // CreatePropertyHandler returns an HTTP handler for creating a new property.
 // Accepts a JSON body, inserts into DB, and responds with the created property.
-func CreatePropertyHandler(db *sql.DB) http.HandlerFunc {
+func CreatePropertyHandler(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -51,29 +54,58 @@ func CreatePropertyHandler(db *sql.DB) http.HandlerFunc {
 			respondError(w, http.StatusBadRequest, "Invalid JSON")
 			return
 		}
-		id, err := CreateProperty(db, &p)
+
+		// Properties belong to the caller unless they are staff creating one
+		// on behalf of an owner who has already granted them access
+		userID := currentUserID(r)
+		if p.OwnerUserID == 0 {
+			p.OwnerUserID = userID
+		}
+		if ok, err := s.Access.CanActForOwner(userID, p.OwnerUserID); err != nil || !ok {
+			respondError(w, http.StatusForbidden, "Cannot create properties for this owner")
+			return
+		}
+
+		id, err := s.Properties.Create(&p)
 		if err != nil {
 			respondError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
 		p.PropertyID = id
+
+		// Staff keep access to the property they just created
+		if p.OwnerUserID != userID {
+			grant := PropertyAccess{PropertyID: id, UserID: userID, GrantedByUserID: userID, GrantedUnix: time.Now().Unix()}
+			if err := s.PropertyAccess.Grant(&grant); err != nil {
+				respondError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+		}
+		p.Version = 1
+		setETag(w, p.Version)
 		respondJSON(w, http.StatusCreated, p)
 	}
 }
 
 // GET
 // GetPropertyHandler returns an HTTP handler for retrieving properties.
-// If no ID is provided, returns all properties; otherwise, returns the property with the given ID.
-func GetPropertyHandler(db *sql.DB) http.HandlerFunc {
+// If no ID is provided, returns all properties visible to the caller; otherwise,
+// returns the property with the given ID.
+func GetPropertyHandler(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		idStr := strings.TrimPrefix(r.URL.Path, "/properties/")
+		idStr := r.PathValue("id")
 		if idStr == "" {
-			props, err := GetAllProperties(db)
+			p, err := ParseListParams(r, propertyListSpec)
+			if err != nil {
+				respondError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			page, err := s.Properties.List(currentUserID(r), p)
 			if err != nil {
 				respondError(w, http.StatusInternalServerError, err.Error())
 				return
 			}
-			respondJSON(w, http.StatusOK, props)
+			respondList(w, r, page)
 			return
 		}
 		id, err := strconv.Atoi(idStr)
@@ -81,11 +113,12 @@ func GetPropertyHandler(db *sql.DB) http.HandlerFunc {
 			respondError(w, http.StatusBadRequest, "Invalid ID")
 			return
 		}
-		prop, err := GetPropertyByID(db, id)
+		prop, err := s.Properties.GetByID(currentUserID(r), id)
 		if err != nil {
 			respondError(w, http.StatusNotFound, "Not found")
 			return
 		}
+		setETag(w, prop.Version)
 		respondJSON(w, http.StatusOK, prop)
 	}
 }
@@ -93,7 +126,7 @@ func GetPropertyHandler(db *sql.DB) http.HandlerFunc {
 // PUT
 // UpdatePropertyHandler returns an HTTP handler for updating a property.
 // Accepts a JSON body, validates propertyId, updates the DB, and responds with status.
-func UpdatePropertyHandler(db *sql.DB) http.HandlerFunc {
+func UpdatePropertyHandler(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPut {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -104,35 +137,69 @@ func UpdatePropertyHandler(db *sql.DB) http.HandlerFunc {
 			respondError(w, http.StatusBadRequest, "Invalid JSON")
 			return
 		}
+		if !bindPathID(w, r, &p.PropertyID, "propertyId") {
+			return
+		}
 		if p.PropertyID == 0 {
 			respondError(w, http.StatusBadRequest, "Property ID is required")
 			return
 		}
-		if err := UpdateProperty(db, &p); err != nil {
-			respondError(w, http.StatusInternalServerError, err.Error())
+		version, ifMatch, ok := requireVersion(w, r, p.Version)
+		if !ok {
+			return
+		}
+		p.Version = version
+		if err := s.Properties.Update(currentUserID(r), &p); err != nil {
+			if errors.Is(err, errStaleVersion) {
+				if current, err := s.Properties.GetByID(currentUserID(r), p.PropertyID); err == nil {
+					respondStale(w, ifMatch, current, current.Version)
+					return
+				}
+				err = sql.ErrNoRows
+			}
+			if errors.Is(err, sql.ErrNoRows) {
+				respondError(w, http.StatusNotFound, "Not found")
+			} else {
+				respondError(w, http.StatusInternalServerError, err.Error())
+			}
 			return
 		}
-		respondJSON(w, http.StatusOK, map[string]string{"status": "updated"})
+		setETag(w, p.Version)
+		respondJSON(w, http.StatusOK, map[string]interface{}{"status": "updated", "version": p.Version})
 	}
 }
 
+// PATCH
+// PatchPropertyHandler returns an HTTP handler for partially updating a property.
+// Accepts a JSON merge patch, applies it to the current property, and passes the
+// result to UpdatePropertyHandler (see patch.go).
+func PatchPropertyHandler(s *Store) http.HandlerFunc {
+	return PatchHandler(func(r *http.Request, id int) (*Property, error) {
+		return s.Properties.GetByID(currentUserID(r), id)
+	}, UpdatePropertyHandler(s))
+}
+
 // DELETE
 // DeletePropertyHandler returns an HTTP handler for deleting a property by ID.
 // Accepts a DELETE request, removes the property from DB, and responds with status.
-func DeletePropertyHandler(db *sql.DB) http.HandlerFunc {
+func DeletePropertyHandler(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodDelete {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
-		idStr := strings.TrimPrefix(r.URL.Path, "/properties/delete/")
+		idStr := r.PathValue("id")
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
 			respondError(w, http.StatusBadRequest, "Invalid ID")
 			return
 		}
-		if err := DeleteProperty(db, id); err != nil {
-			respondError(w, http.StatusInternalServerError, err.Error())
+		if err := s.Properties.Delete(currentUserID(r), id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				respondError(w, http.StatusNotFound, "Not found")
+			} else if !respondDeleteBlocked(w, err) {
+				respondError(w, http.StatusInternalServerError, err.Error())
+			}
 			return
 		}
 		respondJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
@@ -143,58 +210,77 @@ func DeletePropertyHandler(db *sql.DB) http.HandlerFunc {
 // CreateProperty inserts a new property into the database.
 // Returns the new property ID and error if insertion fails.
 func CreateProperty(db *sql.DB, p *Property) (int, error) {
-	res, err := db.Exec(`
-		INSERT INTO properties (propertyName, propertyStreetAddress, propertyCity, propertyState, propertyZip, ownerUserId)
-		VALUES (?, ?, ?, ?, ?, ?)`,
-		p.PropertyName, p.PropertyStreet, p.PropertyCity, p.PropertyState, p.PropertyZip, p.OwnerUserID)
-	if err != nil {
-		return 0, err
-	}
-	id, _ := res.LastInsertId()
-	return int(id), nil
+	var id int
+	err := db.QueryRow(`
+		INSERT INTO properties (propertyName, propertyStreetAddress, propertyCity, propertyState, propertyZip, propertyType, propertyYearBuilt, propertyNotes, ownerUserId)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING propertyId`,
+		p.PropertyName, p.PropertyStreet, p.PropertyCity, p.PropertyState, p.PropertyZip, p.PropertyType, p.PropertyYearBuilt, p.PropertyNotes, p.OwnerUserID).Scan(&id)
+	return id, err
 }
 
-// UpdateProperty updates an existing property in the database.
-// Returns error if update fails.
-func UpdateProperty(db *sql.DB, p *Property) error {
-	_, err := db.Exec(`UPDATE properties SET propertyName=?, propertyStreetAddress=?, propertyCity=?, propertyState=?, propertyZip=?, ownerUserId=? WHERE propertyId=?`,
-		p.PropertyName, p.PropertyStreet, p.PropertyCity, p.PropertyState, p.PropertyZip, p.OwnerUserID, p.PropertyID)
+// UpdateProperty updates an existing property visible to userID in the database if
+// it is still at p.Version (0 skips the check), then advances p.Version.
+// Returns sql.ErrNoRows if the property is not visible to the user, or
+// errStaleVersion if it was changed in the meantime.
+// Ownership is not transferable through an update, so ownerUserId is left untouched.
+func UpdateProperty(db *sql.DB, userID int, p *Property) error {
+	now := time.Now().Unix()
+	args := append([]interface{}{p.PropertyName, p.PropertyStreet, p.PropertyCity, p.PropertyState, p.PropertyZip, p.PropertyType, p.PropertyYearBuilt, p.PropertyNotes, now, p.PropertyID, p.Version}, scopeArgs(userID, 2)...)
+	err := db.QueryRow(`UPDATE properties SET propertyName=?, propertyStreetAddress=?, propertyCity=?, propertyState=?, propertyZip=?, propertyType=?, propertyYearBuilt=?, propertyNotes=?, version=version+1, updatedUnix=?
+		WHERE propertyId=? AND deletedUnix IS NULL AND ? IN (0, version) AND propertyId IN (`+accessiblePropertiesSQL+`) RETURNING version`, args...).Scan(&p.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return staleOrMissing(GetPropertyByID(db, userID, p.PropertyID))
+	}
+	if err == nil {
+		p.UpdatedUnix = &now
+	}
 	return err
 }
 
-// DeleteProperty removes a property from the database by propertyId.
-// Returns error if deletion fails.
-func DeleteProperty(db *sql.DB, id int) error {
-	_, err := db.Exec(`DELETE FROM properties WHERE propertyId=?`, id)
-	return err
+// DeleteProperty moves a property visible to userID to the trash (see trash.go),
+// applying the delete policies for what depends on it (see integrity.go).
+// Returns sql.ErrNoRows if the property is not visible to the user or is already in
+// the trash, or a *DeleteBlockedError if dependent records prevent the delete.
+func DeleteProperty(db *sql.DB, userID, id int) error {
+	return trashWithPolicies(db, userID, "properties", id)
 }
 
-// GetAllProperties retrieves all properties from the database.
-// Returns a slice of Property and error if query fails.
-func GetAllProperties(db *sql.DB) ([]Property, error) {
-	rows, err := db.Query(`SELECT propertyId, propertyName, propertyStreetAddress, propertyCity, propertyState, propertyZip, ownerUserId FROM properties`)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
+// propertyListSpec describes how properties are listed, sorted and filtered (see list.go).
+var propertyListSpec = listSpec{
+	columns:     `propertyId, COALESCE(propertyName, ''), COALESCE(propertyStreetAddress, ''), COALESCE(propertyCity, ''), COALESCE(propertyState, ''), COALESCE(propertyZip, ''), COALESCE(propertyType, ''), COALESCE(propertyYearBuilt, 0), COALESCE(propertyNotes, ''), ownerUserId, version, updatedUnix`,
+	table:       `properties`,
+	idColumn:    `propertyId`,
+	defaultSort: "propertyId",
+	sorts: map[string]string{
+		"propertyId":   "propertyId",
+		"propertyName": "COALESCE(propertyName, '')",
+		"propertyCity": "COALESCE(propertyCity, '')",
+		"propertyZip":  "COALESCE(propertyZip, '')",
+	},
+	filters: map[string]listFilter{
+		"ownerUserId": {expr: "ownerUserId", op: "=", isInt: true},
+		"city":        {expr: "propertyCity", op: "="},
+		"state":       {expr: "propertyState", op: "="},
+		"zip":         {expr: "propertyZip", op: "="},
+	},
+}
 
-	var props []Property
-	for rows.Next() {
-		var p Property
-		if err := rows.Scan(&p.PropertyID, &p.PropertyName, &p.PropertyStreet, &p.PropertyCity, &p.PropertyState, &p.PropertyZip, &p.OwnerUserID); err != nil {
-			return nil, err
-		}
-		props = append(props, p)
-	}
-	return props, nil
+// ListProperties returns one page of the properties visible to userID,
+// filtered and sorted as requested in p.
+func ListProperties(db *sql.DB, userID int, p ListParams) (*Page[Property], error) {
+	return queryPage(db, propertyListSpec, p, `deletedUnix IS NULL AND propertyId IN (`+accessiblePropertiesSQL+`)`, scopeArgs(userID, 2), func(p *Property) []interface{} {
+		return []interface{}{&p.PropertyID, &p.PropertyName, &p.PropertyStreet, &p.PropertyCity, &p.PropertyState, &p.PropertyZip, &p.PropertyType, &p.PropertyYearBuilt, &p.PropertyNotes, &p.OwnerUserID, &p.Version, &p.UpdatedUnix}
+	})
 }
 
-// GetPropertyByID retrieves a property by propertyId from the database.
+// GetPropertyByID retrieves a property visible to userID by propertyId from the database.
 // Returns pointer to Property and error if not found or query fails.
-func GetPropertyByID(db *sql.DB, id int) (*Property, error) {
+func GetPropertyByID(db *sql.DB, userID, id int) (*Property, error) {
 	var p Property
-	err := db.QueryRow(`SELECT propertyId, propertyName, propertyStreetAddress, propertyCity, propertyState, propertyZip, ownerUserId FROM properties WHERE propertyId=?`, id).
-		Scan(&p.PropertyID, &p.PropertyName, &p.PropertyStreet, &p.PropertyCity, &p.PropertyState, &p.PropertyZip, &p.OwnerUserID)
+	args := append([]interface{}{id}, scopeArgs(userID, 2)...)
+	err := db.QueryRow(`SELECT propertyId, COALESCE(propertyName, ''), COALESCE(propertyStreetAddress, ''), COALESCE(propertyCity, ''), COALESCE(propertyState, ''), COALESCE(propertyZip, ''), COALESCE(propertyType, ''), COALESCE(propertyYearBuilt, 0), COALESCE(propertyNotes, ''), ownerUserId, version, updatedUnix FROM properties
+		WHERE propertyId=? AND deletedUnix IS NULL AND propertyId IN (`+accessiblePropertiesSQL+`)`, args...).
+		Scan(&p.PropertyID, &p.PropertyName, &p.PropertyStreet, &p.PropertyCity, &p.PropertyState, &p.PropertyZip, &p.PropertyType, &p.PropertyYearBuilt, &p.PropertyNotes, &p.OwnerUserID, &p.Version, &p.UpdatedUnix)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,262 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Package-level summary:
+// This file implements role-based access control driven by users.userRole.
+// The rolePermissions table maps every resource exposed in main.go to the
+// roles allowed to read, create, update, or delete it. RBACMiddleware looks up
+// the caller's role, checks it against the table, and rejects the request
+// with a 403 JSON error when the role is not permitted.
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Roles stored in users.userRole
+const (
+	RoleOwner     = "owner"
+	RoleManager   = "manager"
+	RoleAssistant = "assistant"
+)
+
+// Actions derived from the HTTP method of a request
+const (
+	ActionRead   = "read"
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+const userRoleKey contextKey = "userRole"
+
+var (
+	allRoles      = []string{RoleOwner, RoleManager, RoleAssistant}
+	ownerOnly     = []string{RoleOwner}
+	ownerManagers = []string{RoleOwner, RoleManager}
+)
+
+// rolePermissions is the permission matrix: resource -> action -> allowed roles.
+// Owners can do everything; managers can do everything except delete properties
+// or manage users; assistants can read everything and record payments,
+// maintenance, and activity, but cannot delete anything.
+var rolePermissions = map[string]map[string][]string{
+	"users": {
+		ActionRead:   allRoles,
+		ActionCreate: ownerOnly,
+		ActionUpdate: ownerOnly,
+		ActionDelete: ownerOnly,
+	},
+	"properties": {
+		ActionRead:   allRoles,
+		ActionCreate: ownerManagers,
+		ActionUpdate: ownerManagers,
+		ActionDelete: ownerOnly,
+	},
+	"propertyAccess": {
+		ActionRead:   allRoles,
+		ActionCreate: ownerOnly,
+		ActionDelete: ownerOnly,
+	},
+	"units": {
+		ActionRead:   allRoles,
+		ActionCreate: ownerManagers,
+		ActionUpdate: ownerManagers,
+		ActionDelete: ownerManagers,
+	},
+	"tenants": {
+		ActionRead:   allRoles,
+		ActionCreate: ownerManagers,
+		ActionUpdate: ownerManagers,
+		ActionDelete: ownerManagers,
+	},
+	"leases": {
+		ActionRead:   allRoles,
+		ActionCreate: ownerManagers,
+		ActionUpdate: ownerManagers,
+		ActionDelete: ownerManagers,
+	},
+	"payments": {
+		ActionRead:   allRoles,
+		ActionCreate: allRoles,
+		ActionUpdate: allRoles,
+		ActionDelete: ownerManagers,
+	},
+	"charges": {
+		ActionRead:   allRoles,
+		ActionCreate: ownerManagers,
+	},
+	"reports": {
+		ActionRead: allRoles,
+	},
+	"maintenance": {
+		ActionRead:   allRoles,
+		ActionCreate: allRoles,
+		ActionUpdate: allRoles,
+		ActionDelete: ownerManagers,
+	},
+	"activity": {
+		ActionRead:   allRoles,
+		ActionCreate: allRoles,
+		ActionUpdate: ownerOnly,
+		ActionDelete: ownerOnly,
+	},
+	"dashboard": {
+		ActionRead: allRoles,
+	},
+	"search": {
+		ActionRead: allRoles,
+	},
+	"trash": {
+		ActionRead:   allRoles,
+		ActionDelete: ownerOnly,
+	},
+	".well-known": {
+		ActionRead: allRoles,
+	},
+	"login": {
+		ActionCreate: allRoles,
+	},
+	"logout": {
+		ActionCreate: allRoles,
+	},
+	"token": {
+		ActionCreate: allRoles,
+	},
+	"apiKeys": {
+		ActionRead:   allRoles,
+		ActionCreate: allRoles,
+		ActionDelete: allRoles,
+	},
+	"loginAttempts": {
+		ActionRead: ownerOnly,
+	},
+	"password": {
+		ActionCreate: allRoles,
+	},
+	"twoFactor": {
+		ActionRead:   allRoles,
+		ActionCreate: allRoles,
+	},
+}
+
+// dashboardRoutes maps the dashboard view endpoints onto the "dashboard" resource
+var dashboardRoutes = map[string]bool{
+	"overduePayments":          true,
+	"maintenanceRequestStatus": true,
+	"leaseOverview":            true,
+	"upcomingPayments":         true,
+}
+
+// RBACMiddleware wraps a handler and enforces rolePermissions for every route.
+// It must run after AuthMiddleware so the user ID is available in the context.
+// The caller's role is read from the database on each request so role changes
+// take effect immediately, and is stored in the context for handlers.
+func RBACMiddleware(db *sql.DB, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := userIDFromContext(r.Context())
+		if !ok {
+			if isPublicRoute(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			respondError(w, http.StatusUnauthorized, "Not authenticated")
+			return
+		}
+
+		role, err := GetUserRole(db, userID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				respondError(w, http.StatusUnauthorized, "User no longer exists")
+			} else {
+				respondError(w, http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+
+		resource, action := routePermission(r)
+		if !hasPermission(role, resource, action) {
+			respondError(w, http.StatusForbidden, "Role '"+role+"' is not permitted to "+action+" "+resource)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), userRoleKey, role)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// resourceAliases maps legacy path names onto their resource.
+var resourceAliases = map[string]string{
+	"maintenanceRequests": "maintenance",
+}
+
+// routePermission derives the resource and action for a request from the first
+// path segment after the /v1 prefix, if any, and the HTTP method.
+func routePermission(r *http.Request) (string, string) {
+	resource := strings.Trim(strings.TrimPrefix(r.URL.Path, apiPrefix+"/"), "/")
+	if i := strings.Index(resource, "/"); i >= 0 {
+		resource = resource[:i]
+	}
+	if alias, ok := resourceAliases[resource]; ok {
+		resource = alias
+	}
+	if dashboardRoutes[resource] {
+		resource = "dashboard"
+	}
+
+	action := ActionRead
+	switch r.Method {
+	case http.MethodPost:
+		action = ActionCreate
+	case http.MethodPut, http.MethodPatch:
+		action = ActionUpdate
+	case http.MethodDelete:
+		action = ActionDelete
+	}
+	// Taking a row out of the trash needs the same role as deleting it
+	if r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/restore") {
+		action = ActionDelete
+	}
+	// A late fee rule is part of its lease or property: removing it is an edit
+	if r.Method == http.MethodDelete && strings.HasSuffix(r.URL.Path, "/lateFeeRule") {
+		action = ActionUpdate
+	}
+	return resource, action
+}
+
+// hasPermission reports whether role may perform action on resource.
+// Unknown resources and actions are denied.
+func hasPermission(role, resource, action string) bool {
+	for _, allowed := range rolePermissions[resource][action] {
+		if allowed == role {
+			return true
+		}
+	}
+	return false
+}
+
+// isValidRole reports whether role is one of the roles stored in users.userRole.
+func isValidRole(role string) bool {
+	for _, r := range allRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// userRoleFromContext returns the caller's role stored by RBACMiddleware.
+func userRoleFromContext(ctx context.Context) (string, bool) {
+	role, ok := ctx.Value(userRoleKey).(string)
+	return role, ok
+}
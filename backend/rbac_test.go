@@ -0,0 +1,203 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Tests for rbac.go: every registered route is called as each role through
+// RBACMiddleware and must be allowed or refused with 403 exactly as the
+// matrix below says. The matrix restates rolePermissions on purpose, so a
+// change to the policy has to be made in both places.
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// wantPermissions is the expected permission matrix: resource -> action ->
+// the roles allowed, space separated.
+var wantPermissions = map[string]map[string]string{
+	"users":          {ActionRead: "owner manager assistant", ActionCreate: "owner", ActionUpdate: "owner", ActionDelete: "owner"},
+	"properties":     {ActionRead: "owner manager assistant", ActionCreate: "owner manager", ActionUpdate: "owner manager", ActionDelete: "owner"},
+	"propertyAccess": {ActionRead: "owner manager assistant", ActionCreate: "owner", ActionDelete: "owner"},
+	"units":          {ActionRead: "owner manager assistant", ActionCreate: "owner manager", ActionUpdate: "owner manager", ActionDelete: "owner manager"},
+	"tenants":        {ActionRead: "owner manager assistant", ActionCreate: "owner manager", ActionUpdate: "owner manager", ActionDelete: "owner manager"},
+	"leases":         {ActionRead: "owner manager assistant", ActionCreate: "owner manager", ActionUpdate: "owner manager", ActionDelete: "owner manager"},
+	"payments":       {ActionRead: "owner manager assistant", ActionCreate: "owner manager assistant", ActionUpdate: "owner manager assistant", ActionDelete: "owner manager"},
+	"charges":        {ActionRead: "owner manager assistant", ActionCreate: "owner manager"},
+	"reports":        {ActionRead: "owner manager assistant"},
+	"maintenance":    {ActionRead: "owner manager assistant", ActionCreate: "owner manager assistant", ActionUpdate: "owner manager assistant", ActionDelete: "owner manager"},
+	"activity":       {ActionRead: "owner manager assistant", ActionCreate: "owner manager assistant", ActionUpdate: "owner", ActionDelete: "owner"},
+	"dashboard":      {ActionRead: "owner manager assistant"},
+	"search":         {ActionRead: "owner manager assistant"},
+	"trash":          {ActionRead: "owner manager assistant", ActionDelete: "owner"},
+	".well-known":    {ActionRead: "owner manager assistant"},
+	"login":          {ActionCreate: "owner manager assistant"},
+	"logout":         {ActionCreate: "owner manager assistant"},
+	"token":          {ActionCreate: "owner manager assistant"},
+	"apiKeys":        {ActionRead: "owner manager assistant", ActionCreate: "owner manager assistant", ActionDelete: "owner manager assistant"},
+	"loginAttempts":  {ActionRead: "owner"},
+	"password":       {ActionCreate: "owner manager assistant"},
+	"twoFactor":      {ActionRead: "owner manager assistant", ActionCreate: "owner manager assistant"},
+}
+
+// wantRule returns the resource and action a request to path needs: the
+// first path segment after /v1, and the action for the method, restoring
+// from the trash counting as a delete and removing a late fee rule as an edit.
+func wantRule(method, path string) (resource, action string) {
+	resource, _, _ = strings.Cut(strings.TrimPrefix(strings.TrimPrefix(path, "/v1"), "/"), "/")
+	switch resource {
+	case "maintenanceRequests":
+		resource = "maintenance"
+	case "overduePayments", "upcomingPayments", "leaseOverview", "maintenanceRequestStatus":
+		resource = "dashboard"
+	}
+	action = map[string]string{
+		http.MethodGet: ActionRead, http.MethodPost: ActionCreate,
+		http.MethodPut: ActionUpdate, http.MethodPatch: ActionUpdate, http.MethodDelete: ActionDelete,
+	}[method]
+	switch {
+	case method == http.MethodPost && strings.HasSuffix(path, "/restore"):
+		action = ActionDelete
+	case method == http.MethodDelete && strings.HasSuffix(path, "/lateFeeRule"):
+		action = ActionUpdate
+	}
+	return resource, action
+}
+
+var wildcardRE = regexp.MustCompile(`\{[^}]*\}`)
+
+// routeRequests turns a registered pattern into the requests to try: the
+// pattern's method, or every method when it has none, on its path with each
+// wildcard filled in.
+func routeRequests(pattern string) (methods []string, path string) {
+	method, path, ok := strings.Cut(pattern, " ")
+	if !ok {
+		path = method
+		methods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete}
+	} else {
+		methods = []string{method}
+	}
+	path = wildcardRE.ReplaceAllStringFunc(path, func(w string) string {
+		switch w {
+		case "{$}":
+			return ""
+		case "{resource}":
+			return "leases"
+		}
+		return "1"
+	})
+	return methods, path
+}
+
+// rbacRoles creates a user for each role and returns their IDs by role.
+func rbacRoles(t *testing.T, s *Store) map[string]int {
+	return map[string]int{
+		RoleOwner:     createTestUser(t, s, "owner@example.com", RoleOwner),
+		RoleManager:   createTestUser(t, s, "manager@example.com", RoleManager),
+		RoleAssistant: createTestUser(t, s, "assistant@example.com", RoleAssistant),
+	}
+}
+
+// callAs sends a request through RBACMiddleware as userID. A request the
+// middleware lets through gets 204 from the stub handler behind it.
+func callAs(h http.Handler, userID int, method, path string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, nil)
+	r = r.WithContext(context.WithValue(r.Context(), userIDKey, userID))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// checkForbidden fails unless w is a 403 with the JSON error body naming
+// role, action and resource.
+func checkForbidden(t *testing.T, w *httptest.ResponseRecorder, role, action, resource string) {
+	t.Helper()
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status %d, want 403", w.Code)
+		return
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type %q, want application/json", ct)
+	}
+	var body map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("body %q is not a JSON object of strings: %v", w.Body.String(), err)
+	}
+	want := "Role '" + role + "' is not permitted to " + action + " " + resource
+	if len(body) != 1 || body["error"] != want {
+		t.Errorf("body %v, want {\"error\": %q}", body, want)
+	}
+}
+
+func TestRBACEveryRouteAndRole(t *testing.T) {
+	s := newTestStore(t)
+	users := rbacRoles(t, s)
+	stub := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	handler := RBACMiddleware(s.DB, stub)
+
+	patterns := buildRouter(s, s.DB, nil).patterns
+	if len(patterns) == 0 {
+		t.Fatal("no routes registered")
+	}
+	for _, pattern := range patterns {
+		methods, path := routeRequests(pattern)
+		for _, method := range methods {
+			resource, action := wantRule(method, path)
+			allowed, known := wantPermissions[resource]
+			// Portal routes are for tenants and take no staff role
+			if !known && !strings.HasPrefix(path, "/portal/") {
+				t.Errorf("%s: resource %q is missing from wantPermissions", pattern, resource)
+			}
+			for role, id := range users {
+				t.Run(method+" "+path+" as "+role, func(t *testing.T) {
+					w := callAs(handler, id, method, path)
+					if strings.Contains(" "+allowed[action]+" ", " "+role+" ") {
+						if w.Code != http.StatusNoContent {
+							t.Errorf("status %d (%s), want the request let through", w.Code, strings.TrimSpace(w.Body.String()))
+						}
+						return
+					}
+					checkForbidden(t, w, role, action, resource)
+				})
+			}
+		}
+	}
+}
+
+func TestRBACDeniesUnknownResources(t *testing.T) {
+	s := newTestStore(t)
+	users := rbacRoles(t, s)
+	stub := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	handler := RBACMiddleware(s.DB, stub)
+
+	for _, path := range []string{"/v1/widgets", "/v1/widgets/1", "/widgets", "/v1/"} {
+		for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+			for role, id := range users {
+				t.Run(method+" "+path+" as "+role, func(t *testing.T) {
+					resource, action := routePermission(httptest.NewRequest(method, path, nil))
+					checkForbidden(t, callAs(handler, id, method, path), role, action, resource)
+				})
+			}
+		}
+	}
+
+	// Known resources still refuse actions the matrix does not list
+	for _, role := range allRoles {
+		if hasPermission(role, "users", "approve") {
+			t.Errorf("%s may approve users; unknown actions must be denied", role)
+		}
+		if hasPermission(role, "", ActionRead) {
+			t.Errorf("%s may read the empty resource", role)
+		}
+	}
+}
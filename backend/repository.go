@@ -0,0 +1,497 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Package-level summary:
+// This file defines the storage layer used by the entity handlers: one
+// repository interface per entity, the dashboard views, and the access checks
+// that scope every query to the caller. A Store bundles them together with the
+// underlying *sql.DB, which the auth and session code still uses directly.
+// OpenStore selects SQLite (the default) or PostgreSQL by driver name. Both
+// are served by the database/sql implementations at the bottom of this file,
+// which delegate to the DB helpers in each entity file; those helpers only use
+// SQL that both databases accept, and PostgreSQL connections rewrite the
+// placeholders (see postgres.go).
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Supported values for the database driver setting
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+)
+
+// UserRepository stores user accounts.
+type UserRepository interface {
+	Create(u *User) error
+	List(userID int, p ListParams) (*Page[User], error)
+	GetByID(id int) (*User, error)
+	GetRole(id int) (string, error)
+	Update(u *User) error
+	Delete(id int) error
+}
+
+// PropertyRepository stores properties, scoped to the calling user.
+type PropertyRepository interface {
+	Create(p *Property) (int, error)
+	List(userID int, p ListParams) (*Page[Property], error)
+	GetByID(userID, id int) (*Property, error)
+	Update(userID int, p *Property) error
+	Delete(userID, id int) error
+}
+
+// PropertyAccessRepository stores staff grants on properties.
+type PropertyAccessRepository interface {
+	Grant(a *PropertyAccess) error
+	GetByProperty(propertyID int) ([]PropertyAccess, error)
+	Revoke(propertyID, userID int) error
+}
+
+// UnitRepository stores property units, scoped to the calling user.
+type UnitRepository interface {
+	Create(u *PropertyUnit) (int, error)
+	List(userID int, p ListParams) (*Page[PropertyUnit], error)
+	GetByID(userID, id int) (*PropertyUnit, error)
+	Update(userID int, u *PropertyUnit) error
+	Delete(userID, id int) error
+}
+
+// TenantRepository stores tenants, scoped to the calling user.
+type TenantRepository interface {
+	Create(t *Tenant) (int, error)
+	List(userID int, p ListParams) (*Page[Tenant], error)
+	GetByID(userID, id int) (*Tenant, error)
+	Update(userID int, t *Tenant) error
+	Delete(userID, id int) error
+}
+
+// LeaseRepository stores leases, scoped to the calling user.
+type LeaseRepository interface {
+	Create(l *Lease) (int, error)
+	List(userID int, p ListParams) (*Page[Lease], error)
+	GetByID(userID, id int) (*Lease, error)
+	Update(userID int, l *Lease) error
+	Delete(userID, id int) error
+}
+
+// PaymentRepository stores payments, scoped to the calling user.
+type PaymentRepository interface {
+	Create(p *Payment) (int, error)
+	List(userID int, p ListParams) (*Page[Payment], error)
+	GetByID(userID, id int) (*Payment, error)
+	Update(userID int, p *Payment) error
+	Delete(userID, id int) error
+	// Allocations returns how a payment is allocated to charges and the
+	// part left over as credit (see allocation.go).
+	Allocations(id int) ([]PaymentAllocation, int, error)
+}
+
+// MaintenanceRepository stores maintenance requests, scoped to the calling user.
+type MaintenanceRepository interface {
+	Create(m *MaintenanceRequest) (int, error)
+	List(userID int, p ListParams) (*Page[MaintenanceRequest], error)
+	GetByID(userID, id int) (*MaintenanceRequest, error)
+	Update(userID int, m *MaintenanceRequest) error
+	Delete(userID, id int) error
+}
+
+// ActivityLogRepository stores activity log entries, scoped to the calling user.
+type ActivityLogRepository interface {
+	Create(a *ActivityLog) (int, error)
+	List(userID int, p ListParams) (*Page[ActivityLog], error)
+	GetByID(userID, id int) (*ActivityLog, error)
+	Update(userID int, a *ActivityLog) error
+	Delete(userID, id int) error
+}
+
+// DashboardRepository reads the dashboard views for the properties visible to a user.
+type DashboardRepository interface {
+	OverduePayments(userID int) ([]OverduePayment, error)
+	UpcomingPayments(userID int) ([]RentPayment, error)
+	LeaseOverview(userID int) ([]LeaseSummary, error)
+	MaintenanceStatus(userID int) ([]MaintenanceRequestStatus, error)
+}
+
+// AccessChecker answers whether a user may see or act on a record (see access.go).
+type AccessChecker interface {
+	CanAccessProperty(userID, propertyID int) (bool, error)
+	CanAccessUnit(userID, unitID int) (bool, error)
+	CanAccessLease(userID, leaseID int) (bool, error)
+	CanAccessTenant(userID, tenantID int) (bool, error)
+	CanAccessUser(userID, targetID int) (bool, error)
+	CanManageUser(userID, targetID int) (bool, error)
+	CanActForOwner(userID, ownerID int) (bool, error)
+}
+
+// SearchRepository runs full-text searches limited to what a user can see.
+type SearchRepository interface {
+	Search(userID int, q SearchQuery) ([]SearchResult, error)
+}
+
+// TrashRepository lists, restores and purges soft-deleted rows (see trash.go)
+// and previews deletes (see integrity.go).
+// resource is the /v1 path segment of the row's type, e.g. "leases".
+type TrashRepository interface {
+	List(userID int, resource string) ([]TrashItem, error)
+	Restore(userID int, resource string, id int) error
+	Purge(userID int, resource string, id int) error
+	PurgeExpired(before int64) (int, error)
+	PlanDelete(userID int, resource string, id int) (*DeletePlan, error)
+}
+
+// LedgerRepository stores charges and builds lease ledgers and balances
+// (see ledger.go), scoped to the calling user except for rent posting.
+type LedgerRepository interface {
+	CreateCharge(c *Charge) (int, error)
+	ListCharges(userID int, p ListParams) (*Page[Charge], error)
+	GetCharge(userID, id int) (*Charge, error)
+	LeaseLedger(userID, leaseID int, now time.Time) (*Ledger, error)
+	TenantBalance(userID, tenantID int, now time.Time) (*TenantBalance, error)
+	PostRentCharges(through time.Time) (int, error)
+	PostLeaseRentCharges(leaseID int, through time.Time) (int, error)
+	PostRentChargesForPeriod(userID int, month time.Time, leaseID int) (int, error)
+	WaiveCharge(userID, id int, reason string) (*Charge, error)
+
+	// Late fee rules (see latefee.go); owner is "leases" or "properties".
+	// Callers check access to the owner first.
+	GetLateFeeRule(owner string, id int) (*LateFeeRule, error)
+	SetLateFeeRule(rule *LateFeeRule) error
+	DeleteLateFeeRule(owner string, id int) error
+	AssessLateFees(now time.Time) (int, error)
+
+	// AgingReport is the aged receivables report (see aging.go).
+	AgingReport(userID int, groupBy string, now time.Time) (*AgingReport, error)
+}
+
+// Store is the storage used by the handlers.
+type Store struct {
+	Driver string
+	DB     *sql.DB
+
+	Users          UserRepository
+	Properties     PropertyRepository
+	PropertyAccess PropertyAccessRepository
+	Units          UnitRepository
+	Tenants        TenantRepository
+	Leases         LeaseRepository
+	Payments       PaymentRepository
+	Maintenance    MaintenanceRepository
+	Activity       ActivityLogRepository
+	Dashboard      DashboardRepository
+	Access         AccessChecker
+	Search         SearchRepository
+	Trash          TrashRepository
+	Ledger         LedgerRepository
+}
+
+// OpenStore opens the database for driver ("sqlite" or "postgres") and
+// returns a Store backed by it. For SQLite dsn is a file path; for PostgreSQL
+// it is a lib/pq connection string or URL.
+func OpenStore(driver, dsn string) (*Store, error) {
+	var db *sql.DB
+	var err error
+	switch driver {
+	case DriverSQLite:
+		db, err = sql.Open("sqlite", sqliteDSN(dsn))
+		if err != nil {
+			return nil, err
+		}
+	case DriverPostgres:
+		db, err = openPostgres(dsn)
+		if err != nil {
+			return nil, err
+		}
+		if err := db.Ping(); err != nil {
+			db.Close()
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown database driver %q (want %q or %q)", driver, DriverSQLite, DriverPostgres)
+	}
+	return NewStore(driver, db), nil
+}
+
+// sqliteBusyTimeout is how long a SQLite connection waits for another
+// connection's write to finish, e.g. a background job's, before failing with
+// SQLITE_BUSY.
+const sqliteBusyTimeout = 5 * time.Second
+
+// sqliteDSN adds the settings every SQLite connection needs to a file path:
+// foreign key enforcement, which SQLite keeps per connection and so has to be
+// turned on for each connection the pool opens, and the busy timeout. A
+// pragma the DSN already sets is left as it is.
+func sqliteDSN(dsn string) string {
+	pragmas := []string{"foreign_keys(1)", fmt.Sprintf("busy_timeout(%d)", sqliteBusyTimeout.Milliseconds())}
+	for _, pragma := range pragmas {
+		name, _, _ := strings.Cut(pragma, "(")
+		if strings.Contains(dsn, name) {
+			continue
+		}
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+		dsn += sep + "_pragma=" + pragma
+	}
+	return dsn
+}
+
+// NewStore wraps an open database in the database/sql repositories.
+func NewStore(driver string, db *sql.DB) *Store {
+	return &Store{
+		Driver:         driver,
+		DB:             db,
+		Users:          sqlUserRepo{db},
+		Properties:     sqlPropertyRepo{db},
+		PropertyAccess: sqlPropertyAccessRepo{db},
+		Units:          sqlUnitRepo{db},
+		Tenants:        sqlTenantRepo{db},
+		Leases:         sqlLeaseRepo{db},
+		Payments:       sqlPaymentRepo{db},
+		Maintenance:    sqlMaintenanceRepo{db},
+		Activity:       sqlActivityLogRepo{db},
+		Dashboard:      sqlDashboardRepo{db},
+		Access:         sqlAccessChecker{db},
+		Search:         sqlSearchRepo{db, driver},
+		Trash:          sqlTrashRepo{db},
+		Ledger:         sqlLedgerRepo{db},
+	}
+}
+
+// == database/sql implementations =====================================================
+
+type sqlUserRepo struct{ db *sql.DB }
+
+func (r sqlUserRepo) Create(u *User) error { return CreateUser(r.db, u) }
+func (r sqlUserRepo) List(userID int, p ListParams) (*Page[User], error) {
+	return ListUsers(r.db, userID, p)
+}
+func (r sqlUserRepo) GetByID(id int) (*User, error)  { return GetUserByID(r.db, id) }
+func (r sqlUserRepo) GetRole(id int) (string, error) { return GetUserRole(r.db, id) }
+func (r sqlUserRepo) Update(u *User) error           { return UpdateUser(r.db, u) }
+func (r sqlUserRepo) Delete(id int) error            { return DeleteUser(r.db, id) }
+
+type sqlPropertyRepo struct{ db *sql.DB }
+
+func (r sqlPropertyRepo) Create(p *Property) (int, error) { return CreateProperty(r.db, p) }
+func (r sqlPropertyRepo) List(userID int, p ListParams) (*Page[Property], error) {
+	return ListProperties(r.db, userID, p)
+}
+func (r sqlPropertyRepo) GetByID(userID, id int) (*Property, error) {
+	return GetPropertyByID(r.db, userID, id)
+}
+func (r sqlPropertyRepo) Update(userID int, p *Property) error {
+	return UpdateProperty(r.db, userID, p)
+}
+func (r sqlPropertyRepo) Delete(userID, id int) error { return DeleteProperty(r.db, userID, id) }
+
+type sqlPropertyAccessRepo struct{ db *sql.DB }
+
+func (r sqlPropertyAccessRepo) Grant(a *PropertyAccess) error { return GrantPropertyAccess(r.db, a) }
+func (r sqlPropertyAccessRepo) GetByProperty(propertyID int) ([]PropertyAccess, error) {
+	return GetPropertyAccessByProperty(r.db, propertyID)
+}
+func (r sqlPropertyAccessRepo) Revoke(propertyID, userID int) error {
+	return RevokePropertyAccess(r.db, propertyID, userID)
+}
+
+type sqlUnitRepo struct{ db *sql.DB }
+
+func (r sqlUnitRepo) Create(u *PropertyUnit) (int, error) { return CreatePropertyUnit(r.db, u) }
+func (r sqlUnitRepo) List(userID int, p ListParams) (*Page[PropertyUnit], error) {
+	return ListPropertyUnits(r.db, userID, p)
+}
+func (r sqlUnitRepo) GetByID(userID, id int) (*PropertyUnit, error) {
+	return GetPropertyUnitByID(r.db, userID, id)
+}
+func (r sqlUnitRepo) Update(userID int, u *PropertyUnit) error {
+	return UpdatePropertyUnit(r.db, userID, u)
+}
+func (r sqlUnitRepo) Delete(userID, id int) error { return DeletePropertyUnit(r.db, userID, id) }
+
+type sqlTenantRepo struct{ db *sql.DB }
+
+func (r sqlTenantRepo) Create(t *Tenant) (int, error) { return CreateTenant(r.db, t) }
+func (r sqlTenantRepo) List(userID int, p ListParams) (*Page[Tenant], error) {
+	return ListTenants(r.db, userID, p)
+}
+func (r sqlTenantRepo) GetByID(userID, id int) (*Tenant, error) {
+	return GetTenantByID(r.db, userID, id)
+}
+func (r sqlTenantRepo) Update(userID int, t *Tenant) error { return UpdateTenant(r.db, userID, t) }
+func (r sqlTenantRepo) Delete(userID, id int) error        { return DeleteTenant(r.db, userID, id) }
+
+type sqlLeaseRepo struct{ db *sql.DB }
+
+func (r sqlLeaseRepo) Create(l *Lease) (int, error) { return CreateLease(r.db, l) }
+func (r sqlLeaseRepo) List(userID int, p ListParams) (*Page[Lease], error) {
+	return ListLeases(r.db, userID, p)
+}
+func (r sqlLeaseRepo) GetByID(userID, id int) (*Lease, error) { return GetLeaseByID(r.db, userID, id) }
+func (r sqlLeaseRepo) Update(userID int, l *Lease) error      { return UpdateLease(r.db, userID, l) }
+func (r sqlLeaseRepo) Delete(userID, id int) error            { return DeleteLease(r.db, userID, id) }
+
+type sqlPaymentRepo struct{ db *sql.DB }
+
+func (r sqlPaymentRepo) Create(p *Payment) (int, error) { return CreatePayment(r.db, p) }
+func (r sqlPaymentRepo) List(userID int, p ListParams) (*Page[Payment], error) {
+	return ListPayments(r.db, userID, p)
+}
+func (r sqlPaymentRepo) GetByID(userID, id int) (*Payment, error) {
+	return GetPaymentByID(r.db, userID, id)
+}
+func (r sqlPaymentRepo) Update(userID int, p *Payment) error { return UpdatePayment(r.db, userID, p) }
+func (r sqlPaymentRepo) Delete(userID, id int) error         { return DeletePayment(r.db, userID, id) }
+func (r sqlPaymentRepo) Allocations(id int) ([]PaymentAllocation, int, error) {
+	return GetPaymentAllocations(r.db, id)
+}
+
+type sqlMaintenanceRepo struct{ db *sql.DB }
+
+func (r sqlMaintenanceRepo) Create(m *MaintenanceRequest) (int, error) {
+	return CreateMaintenanceRequest(r.db, m)
+}
+func (r sqlMaintenanceRepo) List(userID int, p ListParams) (*Page[MaintenanceRequest], error) {
+	return ListMaintenanceRequests(r.db, userID, p)
+}
+func (r sqlMaintenanceRepo) GetByID(userID, id int) (*MaintenanceRequest, error) {
+	return GetMaintenanceRequestByID(r.db, userID, id)
+}
+func (r sqlMaintenanceRepo) Update(userID int, m *MaintenanceRequest) error {
+	return UpdateMaintenanceRequest(r.db, userID, m)
+}
+func (r sqlMaintenanceRepo) Delete(userID, id int) error {
+	return DeleteMaintenanceRequest(r.db, userID, id)
+}
+
+type sqlActivityLogRepo struct{ db *sql.DB }
+
+func (r sqlActivityLogRepo) Create(a *ActivityLog) (int, error) { return CreateActivityLog(r.db, a) }
+func (r sqlActivityLogRepo) List(userID int, p ListParams) (*Page[ActivityLog], error) {
+	return ListActivityLogs(r.db, userID, p)
+}
+func (r sqlActivityLogRepo) GetByID(userID, id int) (*ActivityLog, error) {
+	return GetActivityLogByID(r.db, userID, id)
+}
+func (r sqlActivityLogRepo) Update(userID int, a *ActivityLog) error {
+	return UpdateActivityLog(r.db, userID, a)
+}
+func (r sqlActivityLogRepo) Delete(userID, id int) error { return DeleteActivityLog(r.db, userID, id) }
+
+type sqlDashboardRepo struct{ db *sql.DB }
+
+func (r sqlDashboardRepo) OverduePayments(userID int) ([]OverduePayment, error) {
+	return GetOverduePayments(r.db, userID)
+}
+func (r sqlDashboardRepo) UpcomingPayments(userID int) ([]RentPayment, error) {
+	return GetUpcomingPayments(r.db, userID)
+}
+func (r sqlDashboardRepo) LeaseOverview(userID int) ([]LeaseSummary, error) {
+	return GetLeaseOverview(r.db, userID)
+}
+func (r sqlDashboardRepo) MaintenanceStatus(userID int) ([]MaintenanceRequestStatus, error) {
+	return GetMaintenanceStatus(r.db, userID)
+}
+
+type sqlAccessChecker struct{ db *sql.DB }
+
+func (c sqlAccessChecker) CanAccessProperty(userID, id int) (bool, error) {
+	return canAccessProperty(c.db, userID, id)
+}
+func (c sqlAccessChecker) CanAccessUnit(userID, id int) (bool, error) {
+	return canAccessUnit(c.db, userID, id)
+}
+func (c sqlAccessChecker) CanAccessLease(userID, id int) (bool, error) {
+	return canAccessLease(c.db, userID, id)
+}
+func (c sqlAccessChecker) CanAccessTenant(userID, id int) (bool, error) {
+	return canAccessTenant(c.db, userID, id)
+}
+func (c sqlAccessChecker) CanAccessUser(userID, id int) (bool, error) {
+	return canAccessUser(c.db, userID, id)
+}
+func (c sqlAccessChecker) CanManageUser(userID, id int) (bool, error) {
+	return canManageUser(c.db, userID, id)
+}
+func (c sqlAccessChecker) CanActForOwner(userID, ownerID int) (bool, error) {
+	return canActForOwner(c.db, userID, ownerID)
+}
+
+type sqlSearchRepo struct {
+	db     *sql.DB
+	driver string
+}
+
+func (r sqlSearchRepo) Search(userID int, q SearchQuery) ([]SearchResult, error) {
+	return SearchDocuments(r.db, r.driver, userID, q)
+}
+
+type sqlTrashRepo struct{ db *sql.DB }
+
+func (r sqlTrashRepo) List(userID int, resource string) ([]TrashItem, error) {
+	return ListTrash(r.db, userID, resource)
+}
+func (r sqlTrashRepo) Restore(userID int, resource string, id int) error {
+	return RestoreTrashed(r.db, userID, resource, id)
+}
+func (r sqlTrashRepo) Purge(userID int, resource string, id int) error {
+	return PurgeTrashed(r.db, userID, resource, id)
+}
+func (r sqlTrashRepo) PurgeExpired(before int64) (int, error) {
+	return PurgeExpiredTrash(r.db, before)
+}
+func (r sqlTrashRepo) PlanDelete(userID int, resource string, id int) (*DeletePlan, error) {
+	return PlanDelete(r.db, userID, resource, id)
+}
+
+type sqlLedgerRepo struct{ db *sql.DB }
+
+func (r sqlLedgerRepo) CreateCharge(c *Charge) (int, error) { return CreateCharge(r.db, c) }
+func (r sqlLedgerRepo) ListCharges(userID int, p ListParams) (*Page[Charge], error) {
+	return ListCharges(r.db, userID, p)
+}
+func (r sqlLedgerRepo) GetCharge(userID, id int) (*Charge, error) {
+	return GetChargeByID(r.db, userID, id)
+}
+func (r sqlLedgerRepo) LeaseLedger(userID, leaseID int, now time.Time) (*Ledger, error) {
+	return GetLeaseLedger(r.db, userID, leaseID, now)
+}
+func (r sqlLedgerRepo) TenantBalance(userID, tenantID int, now time.Time) (*TenantBalance, error) {
+	return GetTenantBalance(r.db, userID, tenantID, now)
+}
+func (r sqlLedgerRepo) PostRentCharges(through time.Time) (int, error) {
+	return PostRentCharges(r.db, through)
+}
+func (r sqlLedgerRepo) PostLeaseRentCharges(leaseID int, through time.Time) (int, error) {
+	return PostLeaseRentCharges(r.db, leaseID, through)
+}
+func (r sqlLedgerRepo) PostRentChargesForPeriod(userID int, month time.Time, leaseID int) (int, error) {
+	return PostRentChargesForPeriod(r.db, userID, month, leaseID)
+}
+func (r sqlLedgerRepo) WaiveCharge(userID, id int, reason string) (*Charge, error) {
+	return WaiveCharge(r.db, userID, id, reason)
+}
+func (r sqlLedgerRepo) GetLateFeeRule(owner string, id int) (*LateFeeRule, error) {
+	return GetLateFeeRule(r.db, owner, id)
+}
+func (r sqlLedgerRepo) SetLateFeeRule(rule *LateFeeRule) error { return SetLateFeeRule(r.db, rule) }
+func (r sqlLedgerRepo) DeleteLateFeeRule(owner string, id int) error {
+	return DeleteLateFeeRule(r.db, owner, id)
+}
+func (r sqlLedgerRepo) AssessLateFees(now time.Time) (int, error) {
+	return AssessLateFees(r.db, now)
+}
+func (r sqlLedgerRepo) AgingReport(userID int, groupBy string, now time.Time) (*AgingReport, error) {
+	return GetAgingReport(r.db, userID, groupBy, now)
+}
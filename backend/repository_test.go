@@ -0,0 +1,335 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Contract tests for repository.go: the same checks run against SQLite and,
+// when RT_TEST_POSTGRES_DSN names a database to use, against PostgreSQL, so
+// the two drivers keep behaving alike. The PostgreSQL run works in a schema
+// of its own and drops it afterwards.
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStoreContractSQLite(t *testing.T) {
+	testStoreContract(t, newTestStore(t))
+}
+
+func TestStoreContractPostgres(t *testing.T) {
+	dsn := os.Getenv("RT_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("set RT_TEST_POSTGRES_DSN to run the contract against PostgreSQL")
+	}
+	admin, err := OpenStore(DriverPostgres, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.DB.Close()
+	schema := fmt.Sprintf("rt_contract_%d", time.Now().UnixNano())
+	if _, err := admin.DB.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if _, err := admin.DB.Exec(`DROP SCHEMA ` + schema + ` CASCADE`); err != nil {
+			t.Errorf("drop schema %s: %v", schema, err)
+		}
+	}()
+
+	// lib/pq sends settings it does not know, like search_path, to the server.
+	sep := " "
+	if strings.Contains(dsn, "://") {
+		sep = "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+	}
+	s, err := OpenStore(DriverPostgres, dsn+sep+"search_path="+schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.DB.Close()
+	if _, err := Migrate(s.DB, DriverPostgres, false); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	testStoreContract(t, s)
+}
+
+// TestSQLiteForeignKeysOnEveryConnection checks that foreign keys are
+// enforced on each connection in the pool, not just the first one opened.
+func TestSQLiteForeignKeysOnEveryConnection(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		conn, err := s.DB.Conn(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		var on int
+		if err := conn.QueryRowContext(ctx, `PRAGMA foreign_keys`).Scan(&on); err != nil {
+			t.Fatal(err)
+		}
+		if on != 1 {
+			t.Errorf("connection %d: foreign_keys = %d, want 1", i, on)
+		}
+	}
+}
+
+// testStoreContract runs the repository checks against a migrated, empty store.
+func testStoreContract(t *testing.T, s *Store) {
+	ownerID := createTestUser(t, s, "owner@example.com", "owner")
+	otherID := createTestUser(t, s, "other@example.com", "owner")
+	month := func(m time.Month, day int) time.Time { return time.Date(2025, m, day, 0, 0, 0, 0, time.Local) }
+	now := month(time.March, 15)
+
+	t.Run("users", func(t *testing.T) {
+		u, err := s.Users.GetByID(ownerID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if role, err := s.Users.GetRole(ownerID); err != nil || role != "owner" {
+			t.Errorf("GetRole = %q, %v; want owner", role, err)
+		}
+		stale := *u
+		u.UserFirstName = "Olive"
+		if err := s.Users.Update(u); err != nil {
+			t.Fatal(err)
+		}
+		if u, err := s.Users.GetByID(ownerID); err != nil || u.UserFirstName != "Olive" {
+			t.Errorf("first name after update = %q, %v; want Olive", u.UserFirstName, err)
+		}
+		if err := s.Users.Update(&stale); !errors.Is(err, errStaleVersion) {
+			t.Errorf("update at an old version: %v, want errStaleVersion", err)
+		}
+	})
+
+	prop := &Property{OwnerUserID: ownerID, PropertyName: "Maple", PropertyStreet: "1 Maple St", PropertyCity: "Morgantown"}
+	propertyID, err := s.Properties.Create(prop)
+	if err != nil {
+		t.Fatalf("create property: %v", err)
+	}
+	t.Run("properties", func(t *testing.T) {
+		p, err := s.Properties.GetByID(ownerID, propertyID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Properties.GetByID(otherID, propertyID); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("another owner's get: %v, want sql.ErrNoRows", err)
+		}
+		version := p.Version
+		p.PropertyName = "Maple Court"
+		if err := s.Properties.Update(ownerID, p); err != nil {
+			t.Fatal(err)
+		}
+		if p.Version != version+1 {
+			t.Errorf("version after update = %d, want %d", p.Version, version+1)
+		}
+		if p, err := s.Properties.GetByID(ownerID, propertyID); err != nil || p.PropertyName != "Maple Court" {
+			t.Errorf("name after update = %q, %v; want Maple Court", p.PropertyName, err)
+		}
+		p.Version = version
+		if err := s.Properties.Update(ownerID, p); !errors.Is(err, errStaleVersion) {
+			t.Errorf("update at an old version: %v, want errStaleVersion", err)
+		}
+		if page := listAll(t, s.Properties.List, ownerID, propertyListSpec); len(page.Data) != 1 {
+			t.Errorf("owner lists %d properties, want 1", len(page.Data))
+		}
+		if page := listAll(t, s.Properties.List, otherID, propertyListSpec); len(page.Data) != 0 {
+			t.Errorf("another owner lists %d properties, want 0", len(page.Data))
+		}
+	})
+
+	t.Run("foreign keys", func(t *testing.T) {
+		if _, err := s.Units.Create(&PropertyUnit{PropertyID: propertyID + 1000}); err == nil {
+			t.Error("created a unit for a property that does not exist")
+		}
+	})
+
+	unitID, err := s.Units.Create(&PropertyUnit{PropertyID: propertyID, PropertyUnitNumber: "2B", PropertyUnitRentDefault: 1000})
+	if err != nil {
+		t.Fatalf("create unit: %v", err)
+	}
+	tenantID, err := s.Tenants.Create(&Tenant{OwnerUserID: ownerID, TenantFirstName: "Tomasz", TenantLastName: "Renter", TenantEmail: "tomasz@example.com"})
+	if err != nil {
+		t.Fatalf("create tenant: %v", err)
+	}
+	leaseID, err := s.Leases.Create(&Lease{TenantID: tenantID, PropertyUnitID: unitID, LeaseStartUnix: month(time.January, 1).Unix(), LeaseRentAmount: 1000, LeaseStatus: "active"})
+	if err != nil {
+		t.Fatalf("create lease: %v", err)
+	}
+
+	t.Run("rent charges", func(t *testing.T) {
+		n, err := s.Ledger.PostLeaseRentCharges(leaseID, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 3 {
+			t.Errorf("posted %d rent charges through March, want 3", n)
+		}
+		if n, err := s.Ledger.PostLeaseRentCharges(leaseID, now); err != nil || n != 0 {
+			t.Errorf("posting again = %d, %v; want 0", n, err)
+		}
+		checkBalance(t, s, ownerID, leaseID, now, 3000)
+	})
+
+	var paymentID int
+	t.Run("payments", func(t *testing.T) {
+		paymentID, err = s.Payments.Create(&Payment{LeaseID: leaseID, PaymentAmount: 1500, PaymentDateUnix: month(time.February, 3).Unix(), PaymentMethod: "check"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := s.Payments.GetByID(ownerID, paymentID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.LeaseID != leaseID || p.PaymentAmount != 1500 {
+			t.Errorf("payment = %+v, want 1500 on lease %d", p, leaseID)
+		}
+		allocs, unallocated, err := s.Payments.Allocations(paymentID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		total := 0
+		for _, a := range allocs {
+			total += a.Amount
+		}
+		if total != 1500 || unallocated != 0 {
+			t.Errorf("allocated %d with %d left over, want 1500 and 0", total, unallocated)
+		}
+		checkBalance(t, s, ownerID, leaseID, now, 1500)
+		if _, err := s.Payments.GetByID(otherID, paymentID); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("another owner's get: %v, want sql.ErrNoRows", err)
+		}
+		if page := listAll(t, s.Payments.List, otherID, paymentListSpec); len(page.Data) != 0 {
+			t.Errorf("another owner lists %d payments, want 0", len(page.Data))
+		}
+		if err := s.Payments.Delete(otherID, paymentID); err == nil {
+			t.Error("another owner deleted the payment")
+		}
+	})
+
+	t.Run("trash", func(t *testing.T) {
+		if err := s.Payments.Delete(ownerID, paymentID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Payments.GetByID(ownerID, paymentID); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("get after delete: %v, want sql.ErrNoRows", err)
+		}
+		checkBalance(t, s, ownerID, leaseID, now, 3000)
+		items, err := s.Trash.List(ownerID, "payments")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != 1 || items[0].ID != paymentID {
+			t.Errorf("trash = %+v, want payment %d", items, paymentID)
+		}
+		if err := s.Trash.Restore(otherID, "payments", paymentID); err == nil {
+			t.Error("another owner restored the payment")
+		}
+		if err := s.Trash.Restore(ownerID, "payments", paymentID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Payments.GetByID(ownerID, paymentID); err != nil {
+			t.Errorf("get after restore: %v", err)
+		}
+		checkBalance(t, s, ownerID, leaseID, now, 1500)
+	})
+
+	t.Run("cascade", func(t *testing.T) {
+		var chargeID int
+		if err := s.DB.QueryRow(`SELECT chargeId FROM paymentAllocations WHERE paymentId = ? ORDER BY chargeId LIMIT 1`, paymentID).Scan(&chargeID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.DB.Exec(`DELETE FROM charges WHERE chargeId = ?`, chargeID); err != nil {
+			t.Fatal(err)
+		}
+		var left int
+		if err := s.DB.QueryRow(`SELECT COUNT(*) FROM paymentAllocations WHERE chargeId = ?`, chargeID).Scan(&left); err != nil {
+			t.Fatal(err)
+		}
+		if left != 0 {
+			t.Errorf("%d allocations left to a deleted charge, want 0", left)
+		}
+	})
+
+	t.Run("leases", func(t *testing.T) {
+		if _, err := s.Leases.GetByID(otherID, leaseID); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("another owner's get: %v, want sql.ErrNoRows", err)
+		}
+		l, err := s.Leases.GetByID(ownerID, leaseID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if l.TenantID != tenantID || l.PropertyUnitID != unitID {
+			t.Errorf("lease = %+v, want tenant %d in unit %d", l, tenantID, unitID)
+		}
+	})
+
+	t.Run("search", func(t *testing.T) {
+		results, err := s.Search.Search(ownerID, SearchQuery{Terms: []string{"tomasz"}, Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 1 || results[0].Type != "tenant" || results[0].ID != tenantID {
+			t.Errorf("owner's search = %+v, want tenant %d", results, tenantID)
+		}
+		results, err = s.Search.Search(otherID, SearchQuery{Terms: []string{"tomasz"}, Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 0 {
+			t.Errorf("another owner's search = %+v, want none", results)
+		}
+	})
+
+	t.Run("aging", func(t *testing.T) {
+		report, err := s.Ledger.AgingReport(ownerID, "lease", now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(report.Rows) != 1 || report.Rows[0].LeaseID != leaseID {
+			t.Errorf("aging rows = %+v, want lease %d", report.Rows, leaseID)
+		}
+	})
+}
+
+// listAll lists the first page of a resource as userID with default parameters.
+func listAll[T any](t *testing.T, list func(int, ListParams) (*Page[T], error), userID int, spec listSpec) *Page[T] {
+	t.Helper()
+	p, err := ParseListParams(httptest.NewRequest(http.MethodGet, "/v1/list", nil), spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := list(userID, p)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	return page
+}
+
+// checkBalance fails unless the lease ledger shows want owed as of now.
+func checkBalance(t *testing.T, s *Store, userID, leaseID int, now time.Time, want int) {
+	t.Helper()
+	ledger, err := s.Ledger.LeaseLedger(userID, leaseID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ledger.Balance != want {
+		t.Errorf("balance = %d, want %d", ledger.Balance, want)
+	}
+}
@@ -0,0 +1,315 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Package-level summary:
+// This file builds the HTTP router. Entity endpoints live under /v1 and follow
+// one shape, with the HTTP method choosing the operation:
+//
+//	GET    /v1/{resource}       list
+//	POST   /v1/{resource}       create
+//	GET    /v1/{resource}/{id}  read one
+//	PUT    /v1/{resource}/{id}  replace
+//	DELETE /v1/{resource}/{id}  delete
+//
+// Routes use net/http's method and wildcard patterns, so a handler reads its ID
+// with r.PathValue("id") and a known path called with the wrong method gets a
+// 405 with an Allow header. The older verb-style paths used by the Expo client
+// (/leases/update, /leases/delete/{id}, ...) remain registered as deprecated
+// aliases of the same handlers; their responses carry Deprecation and Link
+// headers naming the /v1 replacement. Authentication, account and portal
+// endpoints are not versioned. Functions: NewRouter, buildRouter, bindPathID.
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+)
+
+// apiPrefix is the path prefix of the versioned entity API.
+const apiPrefix = "/v1"
+
+// routeMux is a ServeMux that remembers the patterns registered on it, so the
+// route table can be listed, e.g. by the RBAC tests.
+type routeMux struct {
+	*http.ServeMux
+	patterns []string
+}
+
+// Handle registers handler for pattern and records the pattern.
+func (m *routeMux) Handle(pattern string, handler http.Handler) {
+	m.patterns = append(m.patterns, pattern)
+	m.ServeMux.Handle(pattern, handler)
+}
+
+// NewRouter registers every endpoint and returns the mux. Authentication and
+// role checks are applied around it by main.
+func NewRouter(store *Store, db *sql.DB, mailer Mailer) *http.ServeMux {
+	return buildRouter(store, db, mailer).ServeMux
+}
+
+// buildRouter registers every endpoint on a routeMux.
+func buildRouter(store *Store, db *sql.DB, mailer Mailer) *routeMux {
+	mux := &routeMux{ServeMux: http.NewServeMux()}
+
+	// Login and session endpoints
+	mux.Handle("/login", LoginHandler(db))
+	mux.Handle("/token/refresh", RefreshTokenHandler(db))
+	mux.Handle("/logout", LogoutHandler(db))
+	mux.Handle("/logout/all", LogoutAllHandler(db))
+	mux.Handle("/.well-known/jwks.json", JWKSHandler())
+	mux.Handle("/loginAttempts", GetLoginAttemptsHandler(db))
+
+	// Password reset and change endpoints
+	mux.Handle("/password/reset/request", RequestPasswordResetHandler(db, mailer))
+	mux.Handle("/password/reset/confirm", ConfirmPasswordResetHandler(db))
+	mux.Handle("/password/change", ChangePasswordHandler(db))
+
+	// API key endpoints
+	mux.Handle("/apiKeys", CreateAPIKeyHandler(db))
+	mux.Handle("/apiKeys/", GetAPIKeysHandler(db))
+	mux.Handle("/apiKeys/delete/", DeleteAPIKeyHandler(db))
+
+	// Two-factor authentication endpoints
+	mux.Handle("/login/verify", VerifyLoginHandler(db))
+	mux.Handle("/twoFactor", GetTwoFactorStatusHandler(db))
+	mux.Handle("/twoFactor/enroll", EnrollTwoFactorHandler(db))
+	mux.Handle("/twoFactor/activate", ActivateTwoFactorHandler(db))
+	mux.Handle("/twoFactor/disable", DisableTwoFactorHandler(db))
+
+	// Dashboard endpoints
+	mux.Handle("GET /v1/overduePayments", GetOverduePaymentsHandler(store))
+	mux.Handle("GET /v1/upcomingPayments", GetUpcomingRentHandler(store))
+	mux.Handle("GET /v1/leaseOverview", GetLeasesHandler(store))
+	mux.Handle("GET /v1/maintenanceRequestStatus", GetMaintenanceRequestsHandler(store))
+
+	// Full-text search (see search.go)
+	mux.Handle("GET /v1/search", SearchHandler(store))
+
+	// Trash (see trash.go): deleted rows can be listed, restored with
+	// POST /v1/{resource}/{id}/restore, or purged for good. What a delete
+	// would affect is previewed by GET /v1/{resource}/{id}/deletePreview
+	// (see integrity.go)
+	mux.Handle("GET /v1/trash", GetTrashHandler(store))
+	mux.Handle("DELETE /v1/trash/{resource}/{id}", PurgeTrashHandler(store))
+	for _, t := range trashTables {
+		mux.Handle("POST /v1/"+t.resource+"/{id}/restore", RestoreHandler(store, t.resource))
+		mux.Handle("GET /v1/"+t.resource+"/{id}/deletePreview", DeletePreviewHandler(store, t.resource))
+	}
+
+	// User endpoints; POST /v1/users is also the public registration route
+	mux.Handle("GET /v1/users", GetUserHandler(store))
+	mux.Handle("POST /v1/users", CreateUserHandler(store))
+	mux.Handle("GET /v1/users/me", GetCurrentUserHandler(store))
+	mux.Handle("GET /v1/users/{id}", GetUserHandler(store))
+	mux.Handle("PUT /v1/users/{id}", UpdateUserHandler(store))
+	mux.Handle("PATCH /v1/users/{id}", PatchUserHandler(store))
+	mux.Handle("DELETE /v1/users/{id}", DeleteUserHandler(store))
+
+	// Property endpoints
+	mux.Handle("GET /v1/properties", GetPropertyHandler(store))
+	mux.Handle("POST /v1/properties", CreatePropertyHandler(store))
+	mux.Handle("GET /v1/properties/{id}", GetPropertyHandler(store))
+	mux.Handle("PUT /v1/properties/{id}", UpdatePropertyHandler(store))
+	mux.Handle("PATCH /v1/properties/{id}", PatchPropertyHandler(store))
+	mux.Handle("DELETE /v1/properties/{id}", DeletePropertyHandler(store))
+	mux.Handle("GET /v1/properties/{id}/units", GetPropertyUnitsHandler(store))
+
+	// Property access grant endpoints
+	mux.Handle("POST /v1/propertyAccess", CreatePropertyAccessHandler(store))
+	mux.Handle("GET /v1/propertyAccess/{propertyId}", GetPropertyAccessHandler(store))
+	mux.Handle("DELETE /v1/propertyAccess/{propertyId}/{userId}", DeletePropertyAccessHandler(store))
+
+	// Unit endpoints
+	mux.Handle("GET /v1/units", GetPropertyUnitHandler(store))
+	mux.Handle("POST /v1/units", CreatePropertyUnitHandler(store))
+	mux.Handle("GET /v1/units/{id}", GetPropertyUnitHandler(store))
+	mux.Handle("PUT /v1/units/{id}", UpdatePropertyUnitHandler(store))
+	mux.Handle("PATCH /v1/units/{id}", PatchPropertyUnitHandler(store))
+	mux.Handle("DELETE /v1/units/{id}", DeletePropertyUnitHandler(store))
+
+	// Tenant endpoints, including portal invitations (see portal.go)
+	mux.Handle("GET /v1/tenants", GetTenantHandler(store))
+	mux.Handle("POST /v1/tenants", CreateTenantHandler(store))
+	mux.Handle("GET /v1/tenants/{id}", GetTenantHandler(store))
+	mux.Handle("PUT /v1/tenants/{id}", UpdateTenantHandler(store))
+	mux.Handle("PATCH /v1/tenants/{id}", PatchTenantHandler(store))
+	mux.Handle("DELETE /v1/tenants/{id}", DeleteTenantHandler(store))
+	mux.Handle("POST /v1/tenants/{id}/invite", InviteTenantHandler(db, mailer))
+	mux.Handle("DELETE /v1/tenants/{id}/portal", DisableTenantPortalHandler(db))
+
+	// Lease endpoints
+	mux.Handle("GET /v1/leases", GetLeaseHandler(store))
+	mux.Handle("POST /v1/leases", CreateLeaseHandler(store))
+	mux.Handle("GET /v1/leases/{id}", GetLeaseHandler(store))
+	mux.Handle("PUT /v1/leases/{id}", UpdateLeaseHandler(store))
+	mux.Handle("PATCH /v1/leases/{id}", PatchLeaseHandler(store))
+	mux.Handle("DELETE /v1/leases/{id}", DeleteLeaseHandler(store))
+	mux.Handle("GET /v1/leases/{id}/ledger", GetLeaseLedgerHandler(store))
+
+	// Rent ledger (see ledger.go): charges against leases and tenant balances
+	mux.Handle("GET /v1/charges", GetChargeHandler(store))
+	mux.Handle("POST /v1/charges", CreateChargeHandler(store))
+	mux.Handle("POST /v1/charges/rent", PostRentChargesHandler(store))
+	mux.Handle("POST /v1/charges/{id}/waive", WaiveChargeHandler(store))
+	mux.Handle("GET /v1/charges/{id}", GetChargeHandler(store))
+	mux.Handle("GET /v1/tenants/{id}/balance", GetTenantBalanceHandler(store))
+	mux.Handle("GET /v1/reports/aging", GetAgingReportHandler(store))
+
+	// Late fee rules (see latefee.go), per lease or per property
+	for owner := range lateFeeOwners {
+		mux.Handle("GET /v1/"+owner+"/{id}/lateFeeRule", GetLateFeeRuleHandler(store, owner))
+		mux.Handle("PUT /v1/"+owner+"/{id}/lateFeeRule", PutLateFeeRuleHandler(store, owner))
+		mux.Handle("DELETE /v1/"+owner+"/{id}/lateFeeRule", DeleteLateFeeRuleHandler(store, owner))
+	}
+
+	// Payment endpoints
+	mux.Handle("GET /v1/payments", GetPaymentHandler(store))
+	mux.Handle("POST /v1/payments", CreatePaymentHandler(store))
+	mux.Handle("GET /v1/payments/{id}", GetPaymentHandler(store))
+	mux.Handle("PUT /v1/payments/{id}", UpdatePaymentHandler(store))
+	mux.Handle("PATCH /v1/payments/{id}", PatchPaymentHandler(store))
+	mux.Handle("DELETE /v1/payments/{id}", DeletePaymentHandler(store))
+
+	// Maintenance endpoints
+	mux.Handle("GET /v1/maintenance", GetMaintenanceHandler(store))
+	mux.Handle("POST /v1/maintenance", CreateMaintenanceHandler(store))
+	mux.Handle("GET /v1/maintenance/{id}", GetMaintenanceHandler(store))
+	mux.Handle("PUT /v1/maintenance/{id}", UpdateMaintenanceHandler(store))
+	mux.Handle("PATCH /v1/maintenance/{id}", PatchMaintenanceHandler(store))
+	mux.Handle("DELETE /v1/maintenance/{id}", DeleteMaintenanceHandler(store))
+
+	// Activity log endpoints
+	mux.Handle("GET /v1/activity", GetActivityLogHandler(store))
+	mux.Handle("POST /v1/activity", CreateActivityLogHandler(store))
+	mux.Handle("GET /v1/activity/{id}", GetActivityLogHandler(store))
+	mux.Handle("PUT /v1/activity/{id}", UpdateActivityLogHandler(store))
+	mux.Handle("PATCH /v1/activity/{id}", PatchActivityLogHandler(store))
+	mux.Handle("DELETE /v1/activity/{id}", DeleteActivityLogHandler(store))
+
+	registerLegacyRoutes(mux, store, db, mailer)
+
+	// Tenant portal endpoints (see portal.go); tenant tokens are checked by
+	// TenantAuthMiddleware rather than AuthMiddleware
+	mux.Handle("/portal/login", PortalLoginHandler(db))
+	mux.Handle("/portal/invite/accept", AcceptTenantInviteHandler(db))
+	mux.Handle("/portal/logout", TenantAuthMiddleware(db, PortalLogoutHandler(db)))
+	mux.Handle("/portal/me", TenantAuthMiddleware(db, PortalMeHandler(db)))
+	mux.Handle("/portal/leases", TenantAuthMiddleware(db, PortalLeasesHandler(db)))
+	mux.Handle("/portal/payments", TenantAuthMiddleware(db, PortalPaymentsHandler(db)))
+	mux.Handle("/portal/balances", TenantAuthMiddleware(db, PortalBalancesHandler(db)))
+	mux.Handle("/portal/maintenance", TenantAuthMiddleware(db, PortalMaintenanceHandler(db)))
+	mux.Handle("/portal/maintenance/", TenantAuthMiddleware(db, PortalMaintenanceByIDHandler(db)))
+
+	return mux
+}
+
+// registerLegacyRoutes keeps the pre-/v1 entity paths working for existing
+// clients. Each is marked deprecated with a pointer to its /v1 successor.
+func registerLegacyRoutes(mux *routeMux, store *Store, db *sql.DB, mailer Mailer) {
+	legacy := func(pattern, successor string, h http.Handler) {
+		mux.Handle(pattern, deprecated(successor, h))
+	}
+
+	legacy("GET /overduePayments", "/v1/overduePayments", GetOverduePaymentsHandler(store))
+	legacy("GET /upcomingPayments", "/v1/upcomingPayments", GetUpcomingRentHandler(store))
+	legacy("GET /leaseOverview", "/v1/leaseOverview", GetLeasesHandler(store))
+	legacy("GET /maintenanceRequestStatus", "/v1/maintenanceRequestStatus", GetMaintenanceRequestsHandler(store))
+
+	legacy("POST /users", "/v1/users", CreateUserHandler(store))
+	legacy("GET /users/{$}", "/v1/users", GetUserHandler(store))
+	legacy("GET /users/me", "/v1/users/me", GetCurrentUserHandler(store))
+	legacy("GET /users/{id}", "/v1/users/{id}", GetUserHandler(store))
+	legacy("PUT /users/update", "/v1/users/{id}", UpdateUserHandler(store))
+	legacy("DELETE /users/delete/{id}", "/v1/users/{id}", DeleteUserHandler(store))
+
+	legacy("POST /properties", "/v1/properties", CreatePropertyHandler(store))
+	legacy("GET /properties/{$}", "/v1/properties", GetPropertyHandler(store))
+	legacy("GET /properties/{id}", "/v1/properties/{id}", GetPropertyHandler(store))
+	legacy("PUT /properties/update", "/v1/properties/{id}", UpdatePropertyHandler(store))
+	legacy("DELETE /properties/delete/{id}", "/v1/properties/{id}", DeletePropertyHandler(store))
+
+	legacy("POST /propertyAccess", "/v1/propertyAccess", CreatePropertyAccessHandler(store))
+	legacy("GET /propertyAccess/{propertyId}", "/v1/propertyAccess/{propertyId}", GetPropertyAccessHandler(store))
+	legacy("DELETE /propertyAccess/delete/{propertyId}/{userId}", "/v1/propertyAccess/{propertyId}/{userId}", DeletePropertyAccessHandler(store))
+
+	// GET /units/{id} listed the units of a property, not a single unit
+	legacy("POST /units", "/v1/units", CreatePropertyUnitHandler(store))
+	legacy("GET /units/{$}", "/v1/units", GetPropertyUnitHandler(store))
+	legacy("GET /units/{id}", "/v1/properties/{id}/units", GetPropertyUnitsHandler(store))
+	legacy("PUT /units/update", "/v1/units/{id}", UpdatePropertyUnitHandler(store))
+	legacy("DELETE /units/delete/{id}", "/v1/units/{id}", DeletePropertyUnitHandler(store))
+
+	legacy("POST /tenants", "/v1/tenants", CreateTenantHandler(store))
+	legacy("GET /tenants/{$}", "/v1/tenants", GetTenantHandler(store))
+	legacy("PUT /tenants/update", "/v1/tenants/{id}", UpdateTenantHandler(store))
+	legacy("DELETE /tenants/delete/{id}", "/v1/tenants/{id}", DeleteTenantHandler(store))
+	legacy("POST /tenants/invite", "/v1/tenants/{id}/invite", InviteTenantHandler(db, mailer))
+	legacy("DELETE /tenants/portal/delete/{id}", "/v1/tenants/{id}/portal", DisableTenantPortalHandler(db))
+
+	legacy("POST /leases", "/v1/leases", CreateLeaseHandler(store))
+	legacy("GET /leases/{$}", "/v1/leases", GetLeaseHandler(store))
+	legacy("GET /leases/{id}", "/v1/leases/{id}", GetLeaseHandler(store))
+	legacy("PUT /leases/update", "/v1/leases/{id}", UpdateLeaseHandler(store))
+	legacy("DELETE /leases/delete/{id}", "/v1/leases/{id}", DeleteLeaseHandler(store))
+
+	legacy("POST /payments", "/v1/payments", CreatePaymentHandler(store))
+	legacy("GET /payments/{$}", "/v1/payments", GetPaymentHandler(store))
+	legacy("GET /payments/{id}", "/v1/payments/{id}", GetPaymentHandler(store))
+	legacy("PUT /payments/update", "/v1/payments/{id}", UpdatePaymentHandler(store))
+	legacy("DELETE /payments/delete/{id}", "/v1/payments/{id}", DeletePaymentHandler(store))
+
+	// The Expo client calls /maintenanceRequests; the server used /maintenance
+	for _, base := range []string{"/maintenance", "/maintenanceRequests"} {
+		legacy("POST "+base, "/v1/maintenance", CreateMaintenanceHandler(store))
+		legacy("GET "+base+"/{$}", "/v1/maintenance", GetMaintenanceHandler(store))
+		legacy("GET "+base+"/{id}", "/v1/maintenance/{id}", GetMaintenanceHandler(store))
+		legacy("PUT "+base+"/update", "/v1/maintenance/{id}", UpdateMaintenanceHandler(store))
+		legacy("DELETE "+base+"/delete/{id}", "/v1/maintenance/{id}", DeleteMaintenanceHandler(store))
+	}
+
+	legacy("POST /activity", "/v1/activity", CreateActivityLogHandler(store))
+	legacy("GET /activity/{$}", "/v1/activity", GetActivityLogHandler(store))
+	legacy("GET /activity/{id}", "/v1/activity/{id}", GetActivityLogHandler(store))
+	legacy("PUT /activity/update", "/v1/activity/{id}", UpdateActivityLogHandler(store))
+	legacy("DELETE /activity/delete/{id}", "/v1/activity/{id}", DeleteActivityLogHandler(store))
+}
+
+// deprecated marks responses from a legacy route as deprecated and links to
+// the path that replaces it. Handlers can tell legacy requests apart with
+// isLegacyRoute, e.g. to keep the old response shape.
+func deprecated(successor string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+successor+`>; rel="successor-version"`)
+		next.ServeHTTP(w, r.WithContext(withLegacyRoute(r.Context())))
+	})
+}
+
+// bindPathID copies the {id} path value into *dst. Legacy routes such as
+// PUT /leases/update carry the ID only in the body, in which case dst is left
+// as decoded. It responds 400 and returns false when the path value is not a
+// number or contradicts a non-zero ID in the body.
+func bindPathID(w http.ResponseWriter, r *http.Request, dst *int, name string) bool {
+	idStr := r.PathValue("id")
+	if idStr == "" {
+		return true
+	}
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid "+name)
+		return false
+	}
+	if *dst != 0 && *dst != id {
+		respondError(w, http.StatusBadRequest, name+" in the body does not match the URL")
+		return false
+	}
+	*dst = id
+	return true
+}
@@ -0,0 +1,219 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Package-level summary:
+// This file implements full-text search over tenants, properties, units,
+// maintenance requests and payment notes. The searchIndex table (migration
+// 0002) holds one title/body document per row and is kept current by
+// triggers; on SQLite it is an FTS5 table ranked with bm25, on PostgreSQL a
+// tsvector column ranked with ts_rank. Results are limited to the rows the
+// caller can see, using the scope fragments from access.go.
+// Handler: SearchHandler. DB helper: SearchDocuments.
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+	maxSearchTerms     = 10
+
+	// Private-use characters mark the matches in the text the database
+	// returns, so it can be HTML escaped before they become <mark> tags.
+	snippetMarkStart = "\uE000"
+	snippetMarkEnd   = "\uE001"
+)
+
+// snippetMarks turns the match markers into <mark> tags.
+var snippetMarks = strings.NewReplacer(snippetMarkStart, "<mark>", snippetMarkEnd, "</mark>")
+
+// searchTypes are the entity types stored in searchIndex.
+var searchTypes = map[string]bool{
+	"tenant":      true,
+	"property":    true,
+	"unit":        true,
+	"maintenance": true,
+	"payment":     true,
+}
+
+// searchStopwords are dropped from queries; as prefix terms they would match
+// almost every document.
+var searchStopwords = map[string]bool{
+	"a": true, "an": true, "and": true, "at": true, "for": true, "in": true,
+	"is": true, "of": true, "on": true, "or": true, "the": true, "to": true,
+}
+
+// SearchQuery is a parsed search request.
+type SearchQuery struct {
+	Terms []string // lowercased words, each matched as a prefix
+	Types []string // entity types to include; empty means all
+	Limit int
+}
+
+// SearchResult is one ranked match. Snippet is an HTML excerpt of the
+// matching text: the text is escaped and the matched words are wrapped in
+// <mark>...</mark>. Title is plain text. Higher scores are better matches.
+type SearchResult struct {
+	Type    string  `json:"type"`
+	ID      int     `json:"id"`
+	Title   string  `json:"title"`
+	Snippet string  `json:"snippet"`
+	Score   float64 `json:"score"`
+}
+
+// SearchResponse is the body returned by SearchHandler.
+type SearchResponse struct {
+	Query   string         `json:"query"`
+	Results []SearchResult `json:"results"`
+}
+
+// searchScopeSQL limits searchIndex rows to entities visible to the caller.
+// Bind the user ID searchScopeArgs times.
+const searchScopeSQL = `(
+	(entityType = 'tenant' AND entityId IN (` + accessibleTenantsSQL + `))
+	OR (entityType = 'property' AND entityId IN (` + accessiblePropertiesSQL + `))
+	OR (entityType = 'unit' AND entityId IN (` + accessibleUnitsSQL + `))
+	OR (entityType = 'maintenance' AND entityId IN (
+		SELECT maintenanceRequestId FROM maintenanceRequests WHERE propertyUnitId IN (` + accessibleUnitsSQL + `)))
+	OR (entityType = 'payment' AND entityId IN (
+		SELECT paymentId FROM payments WHERE leaseId IN (` + accessibleLeasesSQL + `)))
+)`
+
+const searchScopeArgs = 12
+
+// == Handlers =====================================================================
+
+// SearchHandler handles GET /v1/search?q=...&types=tenant,unit&limit=20 and
+// returns the best matches visible to the caller, best first.
+func SearchHandler(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		query, err := ParseSearchQuery(r)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		results, err := s.Search.Search(currentUserID(r), query)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		respondJSON(w, http.StatusOK, SearchResponse{Query: r.URL.Query().Get("q"), Results: results})
+	}
+}
+
+// ParseSearchQuery reads ?q, ?types and ?limit. Errors are meant for a 400 response.
+func ParseSearchQuery(r *http.Request) (SearchQuery, error) {
+	q := r.URL.Query()
+	query := SearchQuery{Limit: defaultSearchLimit}
+
+	for _, word := range strings.FieldsFunc(strings.ToLower(q.Get("q")), func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+	}) {
+		if searchStopwords[word] || len(query.Terms) == maxSearchTerms {
+			continue
+		}
+		query.Terms = append(query.Terms, word)
+	}
+	if len(query.Terms) == 0 {
+		return query, fmt.Errorf("q must contain at least one search term")
+	}
+
+	if v := q.Get("types"); v != "" {
+		for _, t := range strings.Split(v, ",") {
+			t = strings.TrimSpace(t)
+			if !searchTypes[t] {
+				return query, fmt.Errorf("unknown type %q; allowed: %s", t, strings.Join(sortedKeys(searchTypes), ", "))
+			}
+			query.Types = append(query.Types, t)
+		}
+	}
+
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxSearchLimit {
+			return query, fmt.Errorf("limit must be between 1 and %d", maxSearchLimit)
+		}
+		query.Limit = n
+	}
+	return query, nil
+}
+
+// == SQL Queries ==================================================================
+
+// SearchDocuments runs q against searchIndex for userID. driver selects the
+// SQLite (FTS5) or PostgreSQL (tsvector) form of the query.
+func SearchDocuments(db *sql.DB, driver string, userID int, q SearchQuery) ([]SearchResult, error) {
+	var query string
+	var args []interface{}
+	if driver == DriverPostgres {
+		// Terms are letters and digits only, so they are safe in tsquery syntax
+		match := strings.Join(q.Terms, ":* & ") + ":*"
+		args = append(args, match, match, match)
+		query = `
+			SELECT entityType, entityId, title,
+				ts_headline('english', title || ' ' || body, to_tsquery('english', ?),
+					'StartSel=` + snippetMarkStart + `, StopSel=` + snippetMarkEnd + `, MaxWords=16, MinWords=6'),
+				ts_rank(document, to_tsquery('english', ?)) AS score
+			FROM searchIndex
+			WHERE document @@ to_tsquery('english', ?) AND ` + searchScopeSQL
+	} else {
+		quoted := make([]string, len(q.Terms))
+		for i, t := range q.Terms {
+			quoted[i] = `"` + t + `"*`
+		}
+		args = append(args, strings.Join(quoted, " "))
+		// Title matches weigh five times body matches; bm25 is lower-is-better
+		query = `
+			SELECT entityType, entityId, title,
+				snippet(searchIndex, -1, '` + snippetMarkStart + `', '` + snippetMarkEnd + `', '…', 12),
+				-bm25(searchIndex, 0, 0, 5.0, 1.0) AS score
+			FROM searchIndex
+			WHERE searchIndex MATCH ? AND ` + searchScopeSQL
+	}
+
+	args = append(args, scopeArgs(userID, searchScopeArgs)...)
+	if len(q.Types) > 0 {
+		query += ` AND entityType IN (?` + strings.Repeat(`, ?`, len(q.Types)-1) + `)`
+		for _, t := range q.Types {
+			args = append(args, t)
+		}
+	}
+	query += ` ORDER BY score DESC, entityType, entityId LIMIT ?`
+	args = append(args, q.Limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []SearchResult{}
+	for rows.Next() {
+		var res SearchResult
+		if err := rows.Scan(&res.Type, &res.ID, &res.Title, &res.Snippet, &res.Score); err != nil {
+			return nil, err
+		}
+		// The indexed text is user input, some of it from the tenant portal
+		res.Snippet = snippetMarks.Replace(html.EscapeString(res.Snippet))
+		results = append(results, res)
+	}
+	return results, rows.Err()
+}
@@ -0,0 +1,58 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Tests for search.go: snippets escape the markup in the indexed text but keep
+// the <mark> tags around matches.
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSearchSnippetEscaping(t *testing.T) {
+	s := newTestStore(t)
+	ownerID := createTestUser(t, s, "owner@example.com", "owner")
+	propertyID, err := s.Properties.Create(&Property{OwnerUserID: ownerID, PropertyName: "Maple", PropertyStreet: "1 Maple St", PropertyCity: "Morgantown"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unitID, err := s.Units.Create(&PropertyUnit{PropertyID: propertyID, PropertyUnitNumber: "1A", PropertyUnitRentDefault: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := `Tap is <b>leaky</b> & "dripping" <script>alert(1)</script>`
+	if _, err := s.Maintenance.Create(&MaintenanceRequest{PropertyUnitID: unitID, MaintenanceRequestInfo: info, MaintenanceRequestStatus: "open"}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := sendAs(buildRouter(s, s.DB, nil), ownerID, http.MethodGet, "/v1/search?q=leaky+drip&types=maintenance", "", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d (%s), want 200", w.Code, w.Body.String())
+	}
+	var body struct {
+		Results []SearchResult `json:"results"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Results) != 1 {
+		t.Fatalf("results = %+v, want the maintenance request", body.Results)
+	}
+	snippet := body.Results[0].Snippet
+	want := `Tap is &lt;b&gt;<mark>leaky</mark>&lt;/b&gt; &amp; &#34;<mark>dripping</mark>&#34; &lt;script&gt;alert(1)&lt;/script&gt;`
+	if snippet != want {
+		t.Errorf("snippet = %q\nwant      %q", snippet, want)
+	}
+	if strings.ContainsAny(snippet, snippetMarkStart+snippetMarkEnd) {
+		t.Errorf("snippet %q still has match markers", snippet)
+	}
+}
@@ -0,0 +1,277 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Package-level summary:
+// This file implements login sessions with short-lived access tokens and
+// rotating refresh tokens persisted in SQLite. Every login opens an auth
+// session; access tokens carry the session ID so AuthMiddleware can reject
+// tokens whose session was revoked. Refresh tokens are single use: each
+// refresh marks the presented token used and issues a new one, and replaying
+// a used token revokes the whole session. Handlers: RefreshTokenHandler,
+// LogoutHandler, LogoutAllHandler. DB helpers: CreateSession, IsSessionActive,
+// RevokeSession, RevokeAllSessions, RevokeOtherSessions, CreateRefreshToken,
+// RotateRefreshToken.
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Token lifetimes; overridden from the configuration at startup (see config.go).
+var (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+const sessionIDKey contextKey = "sessionId"
+
+var errInvalidRefreshToken = errors.New("invalid refresh token")
+
+// TokenPair is returned by login and refresh. "token" is kept as the access
+// token key so existing clients continue to work.
+type TokenPair struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresIn    int    `json:"expiresIn"` // access token lifetime in seconds
+}
+
+// == Token helpers ================================================================
+
+// randomToken returns n random bytes encoded as URL-safe base64.
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the hex SHA-256 of a token; only hashes are stored.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueTokenPair mints an access token and a fresh refresh token for a session.
+func issueTokenPair(db *sql.DB, userID int, sessionID string) (*TokenPair, error) {
+	access, err := generateJWT(userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := CreateRefreshToken(db, userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{Token: access, RefreshToken: refresh, ExpiresIn: int(accessTokenTTL.Seconds())}, nil
+}
+
+// startSession opens a new auth session for a user and issues its first token pair.
+func startSession(db *sql.DB, userID int) (*TokenPair, error) {
+	sessionID, err := CreateSession(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	return issueTokenPair(db, userID, sessionID)
+}
+
+// sessionIDFromContext returns the session ID stored by AuthMiddleware.
+func sessionIDFromContext(r *http.Request) string {
+	sid, _ := r.Context().Value(sessionIDKey).(string)
+	return sid
+}
+
+// == Handlers =====================================================================
+// POST /token/refresh
+// RefreshTokenHandler returns an HTTP handler that exchanges a refresh token for
+// a new access token and a new refresh token.
+func RefreshTokenHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var body struct {
+			RefreshToken string `json:"refreshToken"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.RefreshToken == "" {
+			respondError(w, http.StatusBadRequest, "refreshToken required")
+			return
+		}
+
+		userID, sessionID, err := RotateRefreshToken(db, body.RefreshToken)
+		if err != nil {
+			if errors.Is(err, errInvalidRefreshToken) {
+				respondError(w, http.StatusUnauthorized, "Invalid or expired refresh token")
+			} else {
+				respondError(w, http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+
+		pair, err := issueTokenPair(db, userID, sessionID)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to generate token")
+			return
+		}
+		respondJSON(w, http.StatusOK, pair)
+	}
+}
+
+// POST /logout
+// LogoutHandler returns an HTTP handler that revokes the caller's current session,
+// invalidating its access token and every refresh token issued for it.
+func LogoutHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := RevokeSession(db, sessionIDFromContext(r)); err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]string{"status": "logged out"})
+	}
+}
+
+// POST /logout/all
+// LogoutAllHandler returns an HTTP handler that revokes every session belonging
+// to the caller ("sign out all devices").
+func LogoutAllHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		n, err := RevokeAllSessions(db, currentUserID(r))
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]interface{}{"status": "logged out", "sessionsRevoked": n})
+	}
+}
+
+// == SQL Queries =====================================================================
+
+// CreateSession inserts a new auth session for a user.
+// Returns the random session ID and error if insertion fails.
+func CreateSession(db *sql.DB, userID int) (string, error) {
+	sessionID, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+	_, err = db.Exec(`INSERT INTO authSessions (sessionId, userId, createdUnix) VALUES (?, ?, ?)`,
+		sessionID, userID, time.Now().Unix())
+	return sessionID, err
+}
+
+// IsSessionActive reports whether a session exists for the user and has not been revoked.
+func IsSessionActive(db *sql.DB, userID int, sessionID string) (bool, error) {
+	var active bool
+	err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM authSessions WHERE sessionId=? AND userId=? AND revokedUnix IS NULL)`,
+		sessionID, userID).Scan(&active)
+	return active, err
+}
+
+// RevokeSession marks a session revoked.
+// Returns error if the update fails.
+func RevokeSession(db *sql.DB, sessionID string) error {
+	_, err := db.Exec(`UPDATE authSessions SET revokedUnix=? WHERE sessionId=? AND revokedUnix IS NULL`, time.Now().Unix(), sessionID)
+	return err
+}
+
+// RevokeAllSessions marks every active session for a user revoked.
+// Returns the number of sessions revoked and error if the update fails.
+func RevokeAllSessions(db *sql.DB, userID int) (int64, error) {
+	res, err := db.Exec(`UPDATE authSessions SET revokedUnix=? WHERE userId=? AND revokedUnix IS NULL`, time.Now().Unix(), userID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// RevokeOtherSessions marks every active session for a user revoked except keepSessionID.
+// Returns error if the update fails.
+func RevokeOtherSessions(db *sql.DB, userID int, keepSessionID string) error {
+	_, err := db.Exec(`UPDATE authSessions SET revokedUnix=? WHERE userId=? AND sessionId<>? AND revokedUnix IS NULL`,
+		time.Now().Unix(), userID, keepSessionID)
+	return err
+}
+
+// CreateRefreshToken stores the hash of a new refresh token for a session.
+// Returns the plaintext token, which is never persisted.
+func CreateRefreshToken(db *sql.DB, userID int, sessionID string) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	_, err = db.Exec(`INSERT INTO refreshTokens (tokenHash, sessionId, userId, issuedUnix, expiresUnix) VALUES (?, ?, ?, ?, ?)`,
+		hashToken(token), sessionID, userID, now.Unix(), now.Add(refreshTokenTTL).Unix())
+	return token, err
+}
+
+// RotateRefreshToken consumes a refresh token and returns the user and session it
+// belongs to. A token can only be used once; presenting an already-used token is
+// treated as theft and revokes the session. Returns errInvalidRefreshToken when
+// the token is unknown, expired, reused, or its session was revoked.
+func RotateRefreshToken(db *sql.DB, token string) (int, string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, "", err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	var sessionID string
+	var expiresUnix int64
+	var usedUnix sql.NullInt64
+	var sessionRevoked sql.NullInt64
+	err = tx.QueryRow(`SELECT rt.userId, rt.sessionId, rt.expiresUnix, rt.usedUnix, s.revokedUnix
+		FROM refreshTokens rt JOIN authSessions s ON s.sessionId = rt.sessionId
+		WHERE rt.tokenHash=?`, hashToken(token)).Scan(&userID, &sessionID, &expiresUnix, &usedUnix, &sessionRevoked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, "", errInvalidRefreshToken
+	}
+	if err != nil {
+		return 0, "", err
+	}
+
+	now := time.Now().Unix()
+	if usedUnix.Valid {
+		// Replay of a rotated token: kill the whole session
+		if _, err := tx.Exec(`UPDATE authSessions SET revokedUnix=? WHERE sessionId=? AND revokedUnix IS NULL`, now, sessionID); err != nil {
+			return 0, "", err
+		}
+		if err := tx.Commit(); err != nil {
+			return 0, "", err
+		}
+		return 0, "", errInvalidRefreshToken
+	}
+	if sessionRevoked.Valid || expiresUnix <= now {
+		return 0, "", errInvalidRefreshToken
+	}
+
+	if err := checkAffected(tx.Exec(`UPDATE refreshTokens SET usedUnix=? WHERE tokenHash=? AND usedUnix IS NULL`, now, hashToken(token))); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, "", errInvalidRefreshToken
+		}
+		return 0, "", err
+	}
+	return userID, sessionID, tx.Commit()
+}
@@ -11,8 +11,8 @@ package main
 // Package-level summary:
 // This file implements tenant CRUD HTTP handlers and database helpers for
 // creating, reading, updating, and deleting tenant records. Handlers include
-// CreateTenantHandler, GetTenantHandler, UpdateTenantHandler, DeleteTenantHandler.
-// DB helpers: CreateTenant, GetAllTenants, GetTenantByID, UpdateTenant, DeleteTenant.
+// CreateTenantHandler, GetTenantHandler, UpdateTenantHandler, PatchTenantHandler, DeleteTenantHandler.
+// DB helpers: CreateTenant, ListTenants, GetTenantByID, UpdateTenant, DeleteTenant.
 
 import (
 	"database/sql"
@@ -20,23 +20,26 @@ import (
 	"errors"
 	"net/http"
 	"strconv"
-	"strings"
+	"time"
 )
 
 // TENANTS
 type Tenant struct {
 	TenantID        int    `db:"tenantId" json:"tenantId"`
+	OwnerUserID     int    `db:"ownerUserId" json:"ownerUserId"`
 	TenantFirstName string `db:"tenantFirstName" json:"tenantFirstName"`
 	TenantLastName  string `db:"tenantLastName" json:"tenantLastName"`
 	TenantEmail     string `db:"tenantEmailAddress" json:"tenantEmailAddress"`
 	TenantPhone     string `db:"tenantPhoneNumber" json:"tenantPhoneNumber"`
+	Version         int    `db:"version" json:"version,omitempty"`
+	UpdatedUnix     *int64 `db:"updatedUnix" json:"updatedUnix,omitempty"`
 }
 
 // == Handlers =====================================================================
 // POST
 // CreateTenantHandler returns an HTTP handler for creating a new tenant.
 // Accepts a JSON body, validates required fields, inserts into DB, and responds with the created tenant.
-func CreateTenantHandler(db *sql.DB) http.HandlerFunc {
+func CreateTenantHandler(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Only allow POST
 		if r.Method != http.MethodPost {
@@ -61,13 +64,19 @@ func CreateTenantHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
+		// Tenants belong to the landlord account that created them
+		t.OwnerUserID = currentUserID(r)
+
 		// Insert into DB
-		_, err := CreateTenant(db, &t)
+		id, err := s.Tenants.Create(&t)
 		if err != nil {
 			respondError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
+		t.TenantID = id
+		t.Version = 1
 
+		setETag(w, t.Version)
 		respondJSON(w, http.StatusCreated, t)
 	}
 }
@@ -75,21 +84,25 @@ func CreateTenantHandler(db *sql.DB) http.HandlerFunc {
 // GET
 // GetTenantHandler returns an HTTP handler for retrieving tenants.
 // If no tenantId is provided, returns all tenants; otherwise, returns the tenant with the given ID.
-func GetTenantHandler(db *sql.DB) http.HandlerFunc {
+func GetTenantHandler(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Expect tenantId in query param
-		tenantIDStr := r.URL.Query().Get("tenantId")
+		// The ID comes from the path; the legacy GET /tenants/ route took it as ?tenantId=
+		tenantIDStr := r.PathValue("id")
+		if tenantIDStr == "" && isLegacyRoute(r) {
+			tenantIDStr = r.URL.Query().Get("tenantId")
+		}
 		if tenantIDStr == "" {
-			tenants, err := GetAllTenants(db)
+			p, err := ParseListParams(r, tenantListSpec)
 			if err != nil {
-				if errors.Is(err, sql.ErrNoRows) {
-					respondError(w, http.StatusNotFound, "No tenants")
-				} else {
-					respondError(w, http.StatusInternalServerError, err.Error())
-				}
+				respondError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			page, err := s.Tenants.List(currentUserID(r), p)
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, err.Error())
 				return
 			}
-			respondJSON(w, http.StatusOK, tenants)
+			respondList(w, r, page)
 			return
 		}
 		tenantID, err := strconv.Atoi(tenantIDStr)
@@ -98,7 +111,7 @@ func GetTenantHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		tenant, err := GetTenantByID(db, tenantID)
+		tenant, err := s.Tenants.GetByID(currentUserID(r), tenantID)
 		if err != nil {
 			if errors.Is(err, sql.ErrNoRows) {
 				respondError(w, http.StatusNotFound, "Tenant not found")
@@ -108,6 +121,7 @@ func GetTenantHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
+		setETag(w, tenant.Version)
 		respondJSON(w, http.StatusOK, tenant)
 	}
 }
@@ -115,7 +129,7 @@ func GetTenantHandler(db *sql.DB) http.HandlerFunc {
 // PUT
 // UpdateTenantHandler returns an HTTP handler for updating a tenant.
 // Accepts a JSON body, validates tenantId, updates the DB, and responds with status.
-func UpdateTenantHandler(db *sql.DB) http.HandlerFunc {
+func UpdateTenantHandler(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPut {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -128,40 +142,82 @@ func UpdateTenantHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
+		if !bindPathID(w, r, &t.TenantID, "tenantId") {
+			return
+		}
 		if t.TenantID == 0 {
 			respondError(w, http.StatusBadRequest, "Tenant ID is required for update")
 			return
 		}
+		if t.TenantFirstName == "" || t.TenantLastName == "" {
+			respondError(w, http.StatusBadRequest, "Tenant first and last name required")
+			return
+		}
+		if t.TenantEmail == "" {
+			respondError(w, http.StatusBadRequest, "Tenant email required")
+			return
+		}
 
-		err := UpdateTenant(db, &t)
+		version, ifMatch, ok := requireVersion(w, r, t.Version)
+		if !ok {
+			return
+		}
+		t.Version = version
+		err := s.Tenants.Update(currentUserID(r), &t)
 		if err != nil {
-			respondError(w, http.StatusInternalServerError, err.Error())
+			if errors.Is(err, errStaleVersion) {
+				if current, err := s.Tenants.GetByID(currentUserID(r), t.TenantID); err == nil {
+					respondStale(w, ifMatch, current, current.Version)
+					return
+				}
+				err = sql.ErrNoRows
+			}
+			if errors.Is(err, sql.ErrNoRows) {
+				respondError(w, http.StatusNotFound, "Tenant not found")
+			} else {
+				respondError(w, http.StatusInternalServerError, err.Error())
+			}
 			return
 		}
 
-		respondJSON(w, http.StatusOK, map[string]string{"status": "updated"})
+		setETag(w, t.Version)
+		respondJSON(w, http.StatusOK, map[string]interface{}{"status": "updated", "version": t.Version})
 	}
 }
 
+// PATCH
+// PatchTenantHandler returns an HTTP handler for partially updating a tenant.
+// Accepts a JSON merge patch, applies it to the current tenant, and passes the
+// result to UpdateTenantHandler (see patch.go).
+func PatchTenantHandler(s *Store) http.HandlerFunc {
+	return PatchHandler(func(r *http.Request, id int) (*Tenant, error) {
+		return s.Tenants.GetByID(currentUserID(r), id)
+	}, UpdateTenantHandler(s))
+}
+
 // DELETE
 // DeleteTenantHandler returns an HTTP handler for deleting a tenant by ID.
 // Accepts a DELETE request, removes the tenant from DB, and responds with status.
-func DeleteTenantHandler(db *sql.DB) http.HandlerFunc {
+func DeleteTenantHandler(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodDelete {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
-		tenantIDStr := strings.TrimPrefix(r.URL.Path, "/tenants/")
+		tenantIDStr := r.PathValue("id")
 		id, err := strconv.Atoi(tenantIDStr)
 		if err != nil {
 			respondError(w, http.StatusBadRequest, "Invalid tenantId")
 			return
 		}
 
-		if err := DeleteTenant(db, id); err != nil {
-			respondError(w, http.StatusInternalServerError, err.Error())
+		if err := s.Tenants.Delete(currentUserID(r), id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				respondError(w, http.StatusNotFound, "Tenant not found")
+			} else if !respondDeleteBlocked(w, err) {
+				respondError(w, http.StatusInternalServerError, err.Error())
+			}
 			return
 		}
 		respondJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
@@ -173,56 +229,72 @@ func DeleteTenantHandler(db *sql.DB) http.HandlerFunc {
 // CreateTenant inserts a new tenant into the database.
 // Returns the new tenant ID and error if insertion fails.
 func CreateTenant(db *sql.DB, t *Tenant) (int, error) {
-	res, err := db.Exec(`INSERT INTO tenants (tenantFirstName, tenantLastName, tenantEmailAddress, tenantPhoneNumber)
-	VALUES (?, ?, ?, ?)`, t.TenantFirstName, t.TenantLastName, t.TenantEmail, t.TenantPhone)
-	if err != nil {
-		return 0, err
-	}
-	id, _ := res.LastInsertId()
-	return int(id), nil
+	var id int
+	err := db.QueryRow(`INSERT INTO tenants (ownerUserId, tenantFirstName, tenantLastName, tenantEmailAddress, tenantPhoneNumber)
+	VALUES (?, ?, ?, ?, ?) RETURNING tenantId`, t.OwnerUserID, t.TenantFirstName, t.TenantLastName, t.TenantEmail, t.TenantPhone).Scan(&id)
+	return id, err
 }
 
-// UpdateTenant updates an existing tenant in the database.
-// Returns error if update fails.
-func UpdateTenant(db *sql.DB, t *Tenant) error {
-	_, err := db.Exec(`UPDATE tenants SET tenantFirstName=?, tenantLastName=?, tenantEmailAddress=?, tenantPhoneNumber=? WHERE tenantId=?`,
-		t.TenantFirstName, t.TenantLastName, t.TenantEmail, t.TenantPhone, t.TenantID)
+// UpdateTenant updates an existing tenant visible to userID in the database if
+// it is still at t.Version (0 skips the check), then advances t.Version.
+// Returns sql.ErrNoRows if the tenant is not visible to the user, or
+// errStaleVersion if it was changed in the meantime.
+func UpdateTenant(db *sql.DB, userID int, t *Tenant) error {
+	now := time.Now().Unix()
+	args := append([]interface{}{t.TenantFirstName, t.TenantLastName, t.TenantEmail, t.TenantPhone, now, t.TenantID, t.Version}, scopeArgs(userID, 4)...)
+	err := db.QueryRow(`UPDATE tenants SET tenantFirstName=?, tenantLastName=?, tenantEmailAddress=?, tenantPhoneNumber=?, version=version+1, updatedUnix=?
+		WHERE tenantId=? AND deletedUnix IS NULL AND ? IN (0, version) AND tenantId IN (`+accessibleTenantsSQL+`) RETURNING version`, args...).Scan(&t.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return staleOrMissing(GetTenantByID(db, userID, t.TenantID))
+	}
+	if err == nil {
+		t.UpdatedUnix = &now
+	}
 	return err
 }
 
-// DeleteTenant removes a tenant from the database by tenantId.
-// Returns error if deletion fails.
-func DeleteTenant(db *sql.DB, id int) error {
-	_, err := db.Exec(`DELETE FROM tenants WHERE tenantId=?`, id)
-	return err
+// DeleteTenant moves a tenant visible to userID to the trash (see trash.go),
+// applying the delete policies for what depends on it (see integrity.go).
+// Returns sql.ErrNoRows if the tenant is not visible to the user or is already in
+// the trash, or a *DeleteBlockedError if dependent records prevent the delete.
+func DeleteTenant(db *sql.DB, userID, id int) error {
+	return trashWithPolicies(db, userID, "tenants", id)
 }
 
-// GetAllTenants retrieves all tenants from the database.
-// Returns a slice of Tenant and error if query fails.
-func GetAllTenants(db *sql.DB) ([]Tenant, error) {
-	rows, err := db.Query(`SELECT tenantId, tenantFirstName, tenantLastName, tenantEmailAddress, tenantPhoneNumber FROM tenants`)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
+// tenantListSpec describes how tenants are listed, sorted and filtered (see list.go).
+var tenantListSpec = listSpec{
+	columns:     `tenantId, COALESCE(ownerUserId, 0), tenantFirstName, tenantLastName, tenantEmailAddress, tenantPhoneNumber, version, updatedUnix`,
+	table:       `tenants`,
+	idColumn:    `tenantId`,
+	defaultSort: "tenantId",
+	sorts: map[string]string{
+		"tenantId":        "tenantId",
+		"tenantLastName":  "tenantLastName",
+		"tenantFirstName": "tenantFirstName",
+	},
+	filters: map[string]listFilter{
+		"ownerUserId": {expr: "ownerUserId", op: "=", isInt: true},
+		"lastName":    {expr: "tenantLastName", op: "="},
+		"email":       {expr: "tenantEmailAddress", op: "="},
+	},
+}
 
-	var out []Tenant
-	for rows.Next() {
-		var t Tenant
-		if err := rows.Scan(&t.TenantID, &t.TenantFirstName, &t.TenantLastName, &t.TenantEmail, &t.TenantPhone); err != nil {
-			return nil, err
-		}
-		out = append(out, t)
-	}
-	return out, nil
+// ListTenants returns one page of the tenants visible to userID,
+// filtered and sorted as requested in p.
+func ListTenants(db *sql.DB, userID int, p ListParams) (*Page[Tenant], error) {
+	return queryPage(db, tenantListSpec, p, `deletedUnix IS NULL AND tenantId IN (`+accessibleTenantsSQL+`)`, scopeArgs(userID, 4), func(t *Tenant) []interface{} {
+		return []interface{}{&t.TenantID, &t.OwnerUserID, &t.TenantFirstName, &t.TenantLastName, &t.TenantEmail, &t.TenantPhone, &t.Version, &t.UpdatedUnix}
+	})
 }
 
-// GetTenantByID retrieves a tenant by tenantId from the database.
+// GetTenantByID retrieves a tenant visible to userID by tenantId from the database.
 // Returns pointer to Tenant and error if not found or query fails.
-func GetTenantByID(db *sql.DB, id int) (*Tenant, error) {
+func GetTenantByID(db *sql.DB, userID, id int) (*Tenant, error) {
 	var t Tenant
-	err := db.QueryRow(`SELECT tenantId, tenantFirstName, tenantLastName, tenantEmailAddress, tenantPhoneNumber FROM tenants WHERE tenantId=?`, id).
-		Scan(&t.TenantID, &t.TenantFirstName, &t.TenantLastName, &t.TenantEmail, &t.TenantPhone)
+	args := append([]interface{}{id}, scopeArgs(userID, 4)...)
+	err := db.QueryRow(`SELECT tenantId, COALESCE(ownerUserId, 0), tenantFirstName, tenantLastName, tenantEmailAddress, tenantPhoneNumber, version, updatedUnix FROM tenants
+		WHERE tenantId=? AND deletedUnix IS NULL AND tenantId IN (`+accessibleTenantsSQL+`)`, args...).
+		Scan(&t.TenantID, &t.OwnerUserID, &t.TenantFirstName, &t.TenantLastName, &t.TenantEmail, &t.TenantPhone, &t.Version, &t.UpdatedUnix)
 	if err != nil {
 		return nil, err
 	}
@@ -1,3 +1,5 @@
+-- rt.sql as it was before schema migrations (baseline), used by
+-- migrate_test.go to check that such a database is adopted.
 -- USERS (landlords, managers, assistants, etc.)
 DROP TABLE IF EXISTS users;
 CREATE TABLE IF NOT EXISTS users (
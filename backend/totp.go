@@ -0,0 +1,559 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Package-level summary:
+// This file implements optional two-factor authentication with RFC 6238 TOTP.
+// A user enrolls by requesting a secret (returned with an otpauth:// URI for
+// authenticator apps) and then confirming a first code, which enables 2FA and
+// issues one-time recovery codes stored only as SHA-256 hashes. Once enabled,
+// LoginHandler answers a correct password with a short-lived login challenge
+// instead of tokens; the challenge is exchanged for tokens at /login/verify
+// with a current TOTP code or an unused recovery code. Handlers:
+// GetTwoFactorStatusHandler, EnrollTwoFactorHandler, ActivateTwoFactorHandler,
+// DisableTwoFactorHandler, VerifyLoginHandler.
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	totpIssuer         = "RentTracker"
+	totpPeriod         = 30 // seconds per time step
+	totpDigits         = 6
+	totpSkew           = 1 // accept codes one step either side of now
+	recoveryCodeCount  = 10
+	loginChallengeTTL  = 5 * time.Minute
+	maxChallengeErrors = 5
+)
+
+var (
+	errInvalidChallenge = errors.New("invalid login challenge")
+	errTwoFactorEnabled = errors.New("two-factor authentication already enabled")
+	errNotEnrolled      = errors.New("two-factor enrollment not started")
+)
+
+// TwoFactorChallenge is returned by LoginHandler instead of a TokenPair when
+// the account has 2FA enabled.
+type TwoFactorChallenge struct {
+	TwoFactorRequired bool   `json:"twoFactorRequired"`
+	ChallengeToken    string `json:"challengeToken"`
+	ExpiresIn         int    `json:"expiresIn"` // seconds
+}
+
+// == TOTP helpers =================================================================
+
+// newTOTPSecret returns a random 160-bit secret encoded as unpadded base32.
+func newTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b), nil
+}
+
+// totpCode computes the HOTP value (RFC 4226) of secret for a time step.
+func totpCode(secret string, step int64) (string, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%uint32(math.Pow10(totpDigits))), nil
+}
+
+// matchTOTP returns the time step matching code within the allowed skew, or -1.
+// Steps at or before lastUsedStep are rejected so a code cannot be replayed.
+func matchTOTP(secret, code string, now time.Time, lastUsedStep int64) int64 {
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits {
+		return -1
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastUsedStep {
+			continue
+		}
+		expected, err := totpCode(secret, step)
+		if err != nil {
+			return -1
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step
+		}
+	}
+	return -1
+}
+
+// otpauthURI builds the provisioning URI understood by authenticator apps.
+func otpauthURI(email, secret string) string {
+	label := url.PathEscape(totpIssuer + ":" + email)
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", totpIssuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(totpDigits))
+	q.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// newRecoveryCodes returns n random codes formatted as xxxxx-xxxxx.
+func newRecoveryCodes(n int) ([]string, error) {
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, n)
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		s := strings.ToLower(enc.EncodeToString(b))[:10]
+		codes[i] = s[:5] + "-" + s[5:]
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode lowercases a recovery code and strips separators so
+// users can type it with or without the dash.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// == Handlers =====================================================================
+// GET /twoFactor
+// GetTwoFactorStatusHandler returns an HTTP handler reporting whether the caller
+// has 2FA enabled and how many recovery codes remain.
+func GetTwoFactorStatusHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		userID := currentUserID(r)
+		enabled, err := IsTwoFactorEnabled(db, userID)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		remaining, err := CountRecoveryCodes(db, userID)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]interface{}{"enabled": enabled, "recoveryCodesRemaining": remaining})
+	}
+}
+
+// POST /twoFactor/enroll
+// EnrollTwoFactorHandler returns an HTTP handler that provisions a new TOTP
+// secret for the caller. 2FA stays disabled until a code is confirmed.
+func EnrollTwoFactorHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		userID := currentUserID(r)
+		u, err := GetUserByID(db, userID)
+		if err != nil {
+			respondError(w, http.StatusNotFound, "User not found")
+			return
+		}
+
+		secret, err := newTOTPSecret()
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if err := StartTwoFactorEnrollment(db, userID, secret); err != nil {
+			if errors.Is(err, errTwoFactorEnabled) {
+				respondError(w, http.StatusConflict, "Two-factor authentication is already enabled")
+			} else {
+				respondError(w, http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]string{"secret": secret, "otpauthUri": otpauthURI(u.UserEmail, secret)})
+	}
+}
+
+// POST /twoFactor/activate
+// ActivateTwoFactorHandler returns an HTTP handler that confirms enrollment with
+// a first TOTP code, enables 2FA, and returns the recovery codes. The codes are
+// shown only once.
+func ActivateTwoFactorHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var body struct {
+			Code string `json:"code"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Code == "" {
+			respondError(w, http.StatusBadRequest, "code required")
+			return
+		}
+
+		userID := currentUserID(r)
+		secret, enabled, lastStep, err := GetTOTPSecret(db, userID)
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusBadRequest, errNotEnrolled.Error())
+			return
+		}
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if enabled {
+			respondError(w, http.StatusConflict, "Two-factor authentication is already enabled")
+			return
+		}
+		step := matchTOTP(secret, body.Code, time.Now(), lastStep)
+		if step < 0 {
+			respondError(w, http.StatusUnauthorized, "Invalid code")
+			return
+		}
+
+		codes, err := newRecoveryCodes(recoveryCodeCount)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if err := EnableTwoFactor(db, userID, step, codes); err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]interface{}{"enabled": true, "recoveryCodes": codes})
+	}
+}
+
+// POST /twoFactor/disable
+// DisableTwoFactorHandler returns an HTTP handler that turns off 2FA for the
+// caller after re-checking their password, and deletes their recovery codes.
+func DisableTwoFactorHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var body struct {
+			Password string `json:"password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+
+		userID := currentUserID(r)
+		var hashedPassword string
+		if err := db.QueryRow(`SELECT userPasswordHash FROM users WHERE userId=?`, userID).Scan(&hashedPassword); err != nil {
+			respondError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		if err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(body.Password)); err != nil {
+			respondError(w, http.StatusUnauthorized, "Password is incorrect")
+			return
+		}
+
+		if err := DisableTwoFactor(db, userID); err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]bool{"enabled": false})
+	}
+}
+
+// POST /login/verify
+// VerifyLoginHandler returns an HTTP handler that completes a 2FA login. Accepts
+// the challenge token from /login plus either a TOTP code or a recovery code,
+// and responds with an access token and refresh token. Wrong codes count as
+// failed logins toward the account and IP lockout (see lockout.go), and only
+// an accepted code records the login as a success.
+func VerifyLoginHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var body struct {
+			ChallengeToken string `json:"challengeToken"`
+			Code           string `json:"code"`
+			RecoveryCode   string `json:"recoveryCode"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.ChallengeToken == "" {
+			respondError(w, http.StatusBadRequest, "challengeToken required")
+			return
+		}
+		if body.Code == "" && body.RecoveryCode == "" {
+			respondError(w, http.StatusBadRequest, "code or recoveryCode required")
+			return
+		}
+
+		userID, err := GetLoginChallengeUser(db, body.ChallengeToken)
+		if err != nil {
+			if errors.Is(err, errInvalidChallenge) {
+				respondError(w, http.StatusUnauthorized, "Invalid or expired login challenge")
+			} else {
+				respondError(w, http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+		var email string
+		if err := db.QueryRow(`SELECT userEmail FROM users WHERE userId=?`, userID).Scan(&email); err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		email, ip := normalizeEmail(email), clientIP(r)
+		lockedUntil, err := LoginLockedUntil(db, email, ip, time.Now())
+		if err != nil {
+			log.Print(err)
+			respondError(w, http.StatusInternalServerError, "Failed to check login attempts")
+			return
+		}
+		if !lockedUntil.IsZero() {
+			if err := RecordLoginAttempt(db, email, userID, ip, LoginLocked); err != nil {
+				log.Print(err)
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(lockedUntil).Seconds())+1))
+			respondError(w, http.StatusTooManyRequests, "Too many failed login attempts. Try again later.")
+			return
+		}
+
+		var ok bool
+		if body.Code != "" {
+			ok, err = UseTOTPCode(db, userID, body.Code)
+		} else {
+			ok, err = UseRecoveryCode(db, userID, body.RecoveryCode)
+		}
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !ok {
+			if err := RecordChallengeFailure(db, body.ChallengeToken); err != nil {
+				log.Print(err)
+			}
+			if err := RecordLoginAttempt(db, email, userID, ip, LoginFailure); err != nil {
+				log.Print(err)
+			}
+			respondError(w, http.StatusUnauthorized, "Invalid code")
+			return
+		}
+
+		if err := ConsumeLoginChallenge(db, body.ChallengeToken); err != nil {
+			if errors.Is(err, errInvalidChallenge) {
+				respondError(w, http.StatusUnauthorized, "Invalid or expired login challenge")
+			} else {
+				respondError(w, http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+		if err := RecordLoginAttempt(db, email, userID, ip, LoginSuccess); err != nil {
+			log.Print(err)
+		}
+
+		pair, err := startSession(db, userID)
+		if err != nil {
+			log.Print(err)
+			respondError(w, http.StatusInternalServerError, "Failed to generate token")
+			return
+		}
+		respondJSON(w, http.StatusOK, pair)
+	}
+}
+
+// == SQL Queries =====================================================================
+
+// IsTwoFactorEnabled reports whether a user has confirmed TOTP enrollment.
+func IsTwoFactorEnabled(db *sql.DB, userID int) (bool, error) {
+	var enabled bool
+	err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM userTotp WHERE userId=? AND enabledUnix IS NOT NULL)`, userID).Scan(&enabled)
+	return enabled, err
+}
+
+// GetTOTPSecret returns a user's TOTP secret, whether it is enabled, and the
+// last time step accepted. Returns sql.ErrNoRows if the user never enrolled.
+func GetTOTPSecret(db *sql.DB, userID int) (string, bool, int64, error) {
+	var secret string
+	var enabledUnix sql.NullInt64
+	var lastStep int64
+	err := db.QueryRow(`SELECT totpSecret, enabledUnix, lastUsedStep FROM userTotp WHERE userId=?`, userID).
+		Scan(&secret, &enabledUnix, &lastStep)
+	return secret, enabledUnix.Valid, lastStep, err
+}
+
+// StartTwoFactorEnrollment stores a pending (not yet enabled) secret, replacing
+// any earlier pending one. Returns errTwoFactorEnabled if 2FA is already on.
+func StartTwoFactorEnrollment(db *sql.DB, userID int, secret string) error {
+	res, err := db.Exec(`INSERT INTO userTotp (userId, totpSecret) VALUES (?, ?)
+		ON CONFLICT(userId) DO UPDATE SET totpSecret=excluded.totpSecret, lastUsedStep=0
+		WHERE userTotp.enabledUnix IS NULL`, userID, secret)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errTwoFactorEnabled
+	}
+	return nil
+}
+
+// EnableTwoFactor marks enrollment confirmed at the given time step and replaces
+// the user's recovery codes with the hashes of codes.
+func EnableTwoFactor(db *sql.DB, userID int, step int64, codes []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkAffected(tx.Exec(`UPDATE userTotp SET enabledUnix=?, lastUsedStep=? WHERE userId=? AND enabledUnix IS NULL`,
+		time.Now().Unix(), step, userID)); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM recoveryCodes WHERE userId=?`, userID); err != nil {
+		return err
+	}
+	for _, c := range codes {
+		if _, err := tx.Exec(`INSERT INTO recoveryCodes (codeHash, userId) VALUES (?, ?)`,
+			hashToken(normalizeRecoveryCode(c)), userID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DisableTwoFactor removes a user's TOTP secret and recovery codes.
+func DisableTwoFactor(db *sql.DB, userID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM userTotp WHERE userId=?`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM recoveryCodes WHERE userId=?`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CountRecoveryCodes returns how many unused recovery codes a user has left.
+func CountRecoveryCodes(db *sql.DB, userID int) (int, error) {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM recoveryCodes WHERE userId=? AND usedUnix IS NULL`, userID).Scan(&n)
+	return n, err
+}
+
+// UseTOTPCode checks a TOTP code for an enabled user and records its time step
+// so the same code cannot be used twice.
+func UseTOTPCode(db *sql.DB, userID int, code string) (bool, error) {
+	secret, enabled, lastStep, err := GetTOTPSecret(db, userID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !enabled) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	step := matchTOTP(secret, code, time.Now(), lastStep)
+	if step < 0 {
+		return false, nil
+	}
+	res, err := db.Exec(`UPDATE userTotp SET lastUsedStep=? WHERE userId=? AND lastUsedStep < ?`, step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// UseRecoveryCode marks an unused recovery code as used.
+// Returns false if the code is unknown or already used.
+func UseRecoveryCode(db *sql.DB, userID int, code string) (bool, error) {
+	res, err := db.Exec(`UPDATE recoveryCodes SET usedUnix=? WHERE codeHash=? AND userId=? AND usedUnix IS NULL`,
+		time.Now().Unix(), hashToken(normalizeRecoveryCode(code)), userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// CreateLoginChallenge stores the hash of a new login challenge for a user who
+// passed the password check. Returns the plaintext challenge token.
+func CreateLoginChallenge(db *sql.DB, userID int) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	_, err = db.Exec(`INSERT INTO loginChallenges (challengeHash, userId, createdUnix, expiresUnix) VALUES (?, ?, ?, ?)`,
+		hashToken(token), userID, now.Unix(), now.Add(loginChallengeTTL).Unix())
+	return token, err
+}
+
+// GetLoginChallengeUser returns the user a live challenge belongs to.
+// Returns errInvalidChallenge if it is unknown, expired, used, or has failed
+// too many times.
+func GetLoginChallengeUser(db *sql.DB, token string) (int, error) {
+	var userID int
+	err := db.QueryRow(`SELECT userId FROM loginChallenges
+		WHERE challengeHash=? AND usedUnix IS NULL AND expiresUnix > ? AND failedAttempts < ?`,
+		hashToken(token), time.Now().Unix(), maxChallengeErrors).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, errInvalidChallenge
+	}
+	return userID, err
+}
+
+// RecordChallengeFailure counts a wrong code against a challenge.
+func RecordChallengeFailure(db *sql.DB, token string) error {
+	_, err := db.Exec(`UPDATE loginChallenges SET failedAttempts = failedAttempts + 1 WHERE challengeHash=?`, hashToken(token))
+	return err
+}
+
+// ConsumeLoginChallenge marks a challenge used so it cannot be exchanged twice.
+// Returns errInvalidChallenge if it was already used.
+func ConsumeLoginChallenge(db *sql.DB, token string) error {
+	err := checkAffected(db.Exec(`UPDATE loginChallenges SET usedUnix=? WHERE challengeHash=? AND usedUnix IS NULL`,
+		time.Now().Unix(), hashToken(token)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return errInvalidChallenge
+	}
+	return err
+}
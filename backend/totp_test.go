@@ -0,0 +1,99 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Tests for totp.go: the second factor is part of the login lockout.
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestTwoFactorLoginLockout(t *testing.T) {
+	s := newTestStore(t)
+	useTestKeys(t)
+	hash, err := bcrypt.GenerateFromPassword([]byte("pw"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var userID int
+	if err := s.DB.QueryRow(`INSERT INTO users (userFirstName, userLastName, userEmail, userPasswordHash, userRole)
+		VALUES ('Olive', 'Owner', 'Olive@example.com', ?, 'owner') RETURNING userId`, string(hash)).Scan(&userID); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := newTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := StartTwoFactorEnrollment(s.DB, userID, secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := EnableTwoFactor(s.DB, userID, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(h http.HandlerFunc, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+		return rec
+	}
+	login := func() *httptest.ResponseRecorder {
+		return post(LoginHandler(s.DB), `{"email":"olive@example.com","password":"pw"}`)
+	}
+	challenge := func() string {
+		t.Helper()
+		rec := login()
+		var c TwoFactorChallenge
+		if err := json.NewDecoder(rec.Body).Decode(&c); err != nil || rec.Code != http.StatusOK || !c.TwoFactorRequired {
+			t.Fatalf("login = %d, want a 2FA challenge", rec.Code)
+		}
+		return c.ChallengeToken
+	}
+	successes := func() int {
+		t.Helper()
+		var n int
+		if err := s.DB.QueryRow(`SELECT COUNT(*) FROM loginAttempts WHERE outcome = ?`, LoginSuccess).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	// The password alone is not a successful login
+	token := challenge()
+	if n := successes(); n != 0 {
+		t.Errorf("%d successes recorded before the code, want 0", n)
+	}
+	code, err := totpCode(secret, time.Now().Unix()/totpPeriod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := post(VerifyLoginHandler(s.DB), `{"challengeToken":"`+token+`","code":"`+code+`"}`); rec.Code != http.StatusOK {
+		t.Fatalf("verify with the right code = %d: %s", rec.Code, rec.Body)
+	}
+	if n := successes(); n != 1 {
+		t.Errorf("%d successes recorded after the code, want 1", n)
+	}
+
+	// Wrong codes, each on a fresh challenge, lock the account
+	for i := 0; i < accountLockThreshold; i++ {
+		token := challenge()
+		if rec := post(VerifyLoginHandler(s.DB), `{"challengeToken":"`+token+`","code":"abcdef"}`); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("verify with a wrong code = %d, want 401", rec.Code)
+		}
+	}
+	if rec := login(); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("login after %d wrong codes = %d, want 429", accountLockThreshold, rec.Code)
+	}
+}
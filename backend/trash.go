@@ -0,0 +1,345 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Package-level summary:
+// This file implements the trash. Deleting a property, unit, tenant, lease,
+// payment or maintenance request only sets its deletedUnix and deletedByUserId
+// (migration 0004), which hides it from lists, lookups, the dashboard views and
+// search. Trashed rows can be listed, restored, or purged for good; a purge
+// job removes them once they are older than the configured retention. A row is
+// only purged when nothing references it any more, so children go first.
+// Handlers: GetTrashHandler, RestoreHandler, PurgeTrashHandler. DB helpers:
+// ListTrash, RestoreTrashed, PurgeTrashed, PurgeExpiredTrash. Job:
+// StartTrashPurger.
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// trashRetention is how long a row stays in the trash before the purge job
+// removes it; zero keeps it until it is purged by hand. Set by Config.Apply.
+var trashRetention = 30 * 24 * time.Hour
+
+// trashPurgeInterval is how often the purge job runs.
+const trashPurgeInterval = time.Hour
+
+// errStillReferenced is returned when purging a row other rows still point at.
+var errStillReferenced = errors.New("still referenced by other records; purge those first")
+
+// trashTable describes one soft-deleted table. resource is its /v1 path
+// segment, label a SQL expression naming a row in the listing, scope limits
+// rows to those the caller may see (bind the user ID scopeArgs times), and
+// children are the "table.column" references that block a purge. A lease's
+// charges are among them, so purging never takes its ledger with it (they
+// would go ON DELETE CASCADE).
+type trashTable struct {
+	resource  string
+	table     string
+	idColumn  string
+	label     string
+	scope     string
+	scopeArgs int
+	children  []string
+}
+
+// trashTables lists the soft-deleted tables, children before parents, which
+// is the order the purge job removes them in.
+var trashTables = []trashTable{
+	{"payments", "payments", "paymentId", `'Payment of ' || paymentAmount`,
+		`leaseId IN (` + accessibleLeasesSQL + `)`, 2, nil},
+	{"maintenance", "maintenanceRequests", "maintenanceRequestId", `COALESCE(maintenanceRequestInfo, '')`,
+		`propertyUnitId IN (` + accessibleUnitsSQL + `)`, 2, nil},
+	{"leases", "leases", "leaseId", `'Lease ' || leaseId`,
+		`propertyUnitId IN (` + accessibleUnitsSQL + `)`, 2,
+		[]string{"payments.leaseId", "maintenanceRequests.leaseId", "charges.leaseId"}},
+	{"tenants", "tenants", "tenantId", `tenantFirstName || ' ' || tenantLastName`,
+		`tenantId IN (` + accessibleTenantsSQL + `)`, 4,
+		[]string{"leases.tenantId"}},
+	{"units", "propertyUnits", "propertyUnitId", `'Unit ' || COALESCE(propertyUnitNumber, '')`,
+		`propertyId IN (` + accessiblePropertiesSQL + `)`, 2,
+		[]string{"leases.propertyUnitId", "maintenanceRequests.propertyUnitId"}},
+	{"properties", "properties", "propertyId", `COALESCE(propertyName, '')`,
+		`propertyId IN (` + accessiblePropertiesSQL + `)`, 2,
+		[]string{"propertyUnits.propertyId"}},
+}
+
+// lookupTrashTable returns the trashTable for a /v1 resource name.
+func lookupTrashTable(resource string) (trashTable, bool) {
+	for _, t := range trashTables {
+		if t.resource == resource {
+			return t, true
+		}
+	}
+	return trashTable{}, false
+}
+
+// unreferencedSQL is a condition that holds when no child row points at the
+// row of t being deleted.
+func (t trashTable) unreferencedSQL() string {
+	conds := []string{"1=1"}
+	for _, child := range t.children {
+		table, column, _ := strings.Cut(child, ".")
+		conds = append(conds, `NOT EXISTS (SELECT 1 FROM `+table+` c WHERE c.`+column+` = `+t.table+`.`+t.idColumn+`)`)
+	}
+	return strings.Join(conds, " AND ")
+}
+
+// TrashItem is one row in the trash. PurgeAfterUnix is when the purge job
+// may remove it, or nil when the trash is kept indefinitely.
+type TrashItem struct {
+	Resource        string `json:"resource"`
+	ID              int    `json:"id"`
+	Label           string `json:"label"`
+	DeletedUnix     int64  `json:"deletedUnix"`
+	DeletedByUserID *int   `json:"deletedByUserId"`
+	PurgeAfterUnix  *int64 `json:"purgeAfterUnix"`
+}
+
+// == Handlers =====================================================================
+// GET
+// GetTrashHandler returns an HTTP handler for GET /v1/trash, listing the
+// trashed rows visible to the caller, most recently deleted first. ?resource=
+// narrows it to one resource, e.g. leases.
+func GetTrashHandler(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		resource := r.URL.Query().Get("resource")
+		if _, ok := lookupTrashTable(resource); resource != "" && !ok {
+			respondError(w, http.StatusBadRequest, "unknown resource "+strconv.Quote(resource))
+			return
+		}
+		items, err := s.Trash.List(currentUserID(r), resource)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]interface{}{"data": items})
+	}
+}
+
+// POST
+// RestoreHandler returns an HTTP handler for POST /v1/{resource}/{id}/restore,
+// which takes a row of resource out of the trash, along with anything that was
+// cascaded into the trash with it.
+func RestoreHandler(s *Store, resource string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "invalid id")
+			return
+		}
+		if err := s.Trash.Restore(currentUserID(r), resource, id); err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				respondError(w, http.StatusNotFound, "not found in trash")
+			case errors.Is(err, errParentInTrash):
+				respondError(w, http.StatusConflict, err.Error())
+			default:
+				respondError(w, http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]string{"status": "restored"})
+	}
+}
+
+// DELETE
+// PurgeTrashHandler returns an HTTP handler for DELETE /v1/trash/{resource}/{id},
+// which permanently removes a trashed row without waiting for the retention.
+func PurgeTrashHandler(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		resource := r.PathValue("resource")
+		if _, ok := lookupTrashTable(resource); !ok {
+			respondError(w, http.StatusNotFound, "unknown resource "+strconv.Quote(resource))
+			return
+		}
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "invalid id")
+			return
+		}
+		if err := s.Trash.Purge(currentUserID(r), resource, id); err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				respondError(w, http.StatusNotFound, "not found in trash")
+			case errors.Is(err, errStillReferenced):
+				respondError(w, http.StatusConflict, err.Error())
+			default:
+				respondError(w, http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]string{"status": "purged"})
+	}
+}
+
+// == Purge job ====================================================================
+
+// StartTrashPurger purges expired trash in the background, once at startup
+// and then every trashPurgeInterval. A zero retention disables it.
+func StartTrashPurger(s *Store, retention time.Duration) {
+	if retention <= 0 {
+		return
+	}
+	go func() {
+		for {
+			n, err := s.Trash.PurgeExpired(time.Now().Add(-retention).Unix())
+			if err != nil {
+				log.Print("trash purge: ", err)
+			} else if n > 0 {
+				logInfo("trash purge: removed %d records deleted more than %s ago", n, retention)
+			}
+			time.Sleep(trashPurgeInterval)
+		}
+	}()
+}
+
+// == SQL Queries ==================================================================
+
+// ListTrash returns the trashed rows visible to userID, most recently deleted
+// first. An empty resource lists every soft-deleted table.
+func ListTrash(db *sql.DB, userID int, resource string) ([]TrashItem, error) {
+	items := []TrashItem{}
+	for _, t := range trashTables {
+		if resource != "" && t.resource != resource {
+			continue
+		}
+		rows, err := db.Query(`SELECT `+t.idColumn+`, `+t.label+`, deletedUnix, deletedByUserId FROM `+t.table+`
+			WHERE deletedUnix IS NOT NULL AND `+t.scope, scopeArgs(userID, t.scopeArgs)...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			item := TrashItem{Resource: t.resource}
+			if err := rows.Scan(&item.ID, &item.Label, &item.DeletedUnix, &item.DeletedByUserID); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if trashRetention > 0 {
+				purge := item.DeletedUnix + int64(trashRetention/time.Second)
+				item.PurgeAfterUnix = &purge
+			}
+			items = append(items, item)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].DeletedUnix > items[j].DeletedUnix })
+	return items, nil
+}
+
+// RestoreTrashed takes a trashed row visible to userID out of the trash,
+// together with the children cascaded into the trash with it (see
+// integrity.go). Returns sql.ErrNoRows if there is no such row in the trash,
+// or errParentInTrash if the row it belongs to must be restored first.
+func RestoreTrashed(db *sql.DB, userID int, resource string, id int) error {
+	t, ok := lookupTrashTable(resource)
+	if !ok {
+		return fmt.Errorf("%s cannot be restored", resource)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var deletedUnix int64
+	args := append([]interface{}{id}, scopeArgs(userID, t.scopeArgs)...)
+	err = tx.QueryRow(`SELECT deletedUnix FROM `+t.table+`
+		WHERE `+t.idColumn+`=? AND deletedUnix IS NOT NULL AND `+t.scope, args...).Scan(&deletedUnix)
+	if err != nil {
+		return err
+	}
+	parent, parentID, err := trashedParent(tx, resource, id)
+	if err != nil {
+		return err
+	}
+	if parent != "" {
+		return fmt.Errorf("%w; restore %s %d first", errParentInTrash, parent, parentID)
+	}
+	if _, err := tx.Exec(`UPDATE `+t.table+` SET deletedUnix=NULL, deletedByUserId=NULL WHERE `+t.idColumn+`=?`, id); err != nil {
+		return err
+	}
+	if err := restoreCascaded(tx, resource, id, deletedUnix); err != nil {
+		return err
+	}
+	// A restored payment takes back its share of the lease's charges
+	if resource == "payments" {
+		if err := reallocatePaymentLease(tx, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// PurgeTrashed permanently deletes a trashed row visible to userID. Returns
+// sql.ErrNoRows if there is no such row in the trash, or errStillReferenced
+// if other rows, trashed or not, still point at it.
+func PurgeTrashed(db *sql.DB, userID int, resource string, id int) error {
+	t, ok := lookupTrashTable(resource)
+	if !ok {
+		return fmt.Errorf("%s cannot be purged", resource)
+	}
+	args := append([]interface{}{id}, scopeArgs(userID, t.scopeArgs)...)
+	var trashed bool
+	err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM `+t.table+`
+		WHERE `+t.idColumn+`=? AND deletedUnix IS NOT NULL AND `+t.scope+`)`, args...).Scan(&trashed)
+	if err != nil {
+		return err
+	}
+	if !trashed {
+		return sql.ErrNoRows
+	}
+	err = checkAffected(db.Exec(`DELETE FROM `+t.table+` WHERE `+t.idColumn+`=? AND deletedUnix IS NOT NULL AND `+t.unreferencedSQL(), id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return errStillReferenced
+	}
+	return err
+}
+
+// PurgeExpiredTrash permanently deletes rows trashed before the given Unix
+// time, children first, skipping any row still referenced. Returns how many
+// rows were removed.
+func PurgeExpiredTrash(db *sql.DB, before int64) (int, error) {
+	total := 0
+	for _, t := range trashTables {
+		res, err := db.Exec(`DELETE FROM `+t.table+` WHERE deletedUnix IS NOT NULL AND deletedUnix < ? AND `+t.unreferencedSQL(), before)
+		if err != nil {
+			return total, fmt.Errorf("%s: %w", t.table, err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += int(n)
+	}
+	return total, nil
+}
@@ -11,15 +11,16 @@ package main
 // Package-level summary:
 // This file implements property unit CRUD HTTP handlers and database helpers for
 // creating, reading, updating, and deleting property unit records. Handlers include
-// CreatePropertyUnitHandler, GetPropertyUnitHandler, UpdatePropertyUnitHandler, DeletePropertyUnitHandler.
-// DB helpers: CreatePropertyUnit, GetAllPropertyUnits, GetPropertyUnitsByID, UpdatePropertyUnit, DeletePropertyUnit.
+// CreatePropertyUnitHandler, GetPropertyUnitHandler, GetPropertyUnitsHandler, UpdatePropertyUnitHandler, PatchPropertyUnitHandler,
+// DeletePropertyUnitHandler. DB helpers: CreatePropertyUnit, ListPropertyUnits, GetPropertyUnitByID, UpdatePropertyUnit, DeletePropertyUnit.
 
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
-	"strings"
+	"time"
 )
 
 // PROPERTY UNITS
@@ -32,13 +33,15 @@ type PropertyUnit struct {
 	PropertyUnitSqFt        int    `db:"propertyUnitSqFt" json:"propertyUnitSqFt"`
 	PropertyUnitRentDefault int    `db:"propertyUnitRentDefault" json:"propertyUnitRentDefault"`
 	PropertyUnitNotes       string `db:"propertyUnitNotes" json:"propertyUnitNotes"`
+	Version                 int    `db:"version" json:"version,omitempty"`
+	UpdatedUnix             *int64 `db:"updatedUnix" json:"updatedUnix,omitempty"`
 }
 
 // == Handlers =====================================================================
 // POST
 // CreatePropertyUnitHandler returns an HTTP handler for creating a new property unit.
 // Accepts a JSON body, validates required fields, inserts into DB, and responds with the created unit.
-func CreatePropertyUnitHandler(db *sql.DB) http.HandlerFunc {
+func CreatePropertyUnitHandler(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -53,49 +56,86 @@ func CreatePropertyUnitHandler(db *sql.DB) http.HandlerFunc {
 			respondError(w, http.StatusBadRequest, "propertyId required")
 			return
 		}
-		id, err := CreatePropertyUnit(db, &u)
+		if ok, err := s.Access.CanAccessProperty(currentUserID(r), u.PropertyID); err != nil || !ok {
+			respondError(w, http.StatusNotFound, "property not found")
+			return
+		}
+		id, err := s.Units.Create(&u)
 		if err != nil {
 			respondError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
 		u.PropertyUnitID = id
+		u.Version = 1
+		setETag(w, u.Version)
 		respondJSON(w, http.StatusCreated, u)
 	}
 }
 
 // GET
 // GetPropertyUnitHandler returns an HTTP handler for retrieving property units.
-// If no ID is provided, returns all units; otherwise, returns the unit(s) with the given ID.
-func GetPropertyUnitHandler(db *sql.DB) http.HandlerFunc {
+// If no ID is provided, returns all units; otherwise, returns the unit with the given ID.
+func GetPropertyUnitHandler(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		idStr := strings.TrimPrefix(r.URL.Path, "/units/")
-		if idStr == "" || idStr == "/" {
-			units, err := GetAllPropertyUnits(db)
+		idStr := r.PathValue("id")
+		if idStr == "" {
+			p, err := ParseListParams(r, unitListSpec)
+			if err != nil {
+				respondError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			page, err := s.Units.List(currentUserID(r), p)
 			if err != nil {
 				respondError(w, http.StatusInternalServerError, err.Error())
 				return
 			}
-			respondJSON(w, http.StatusOK, units)
+			respondList(w, r, page)
 			return
 		}
-		id, err := strconv.Atoi(strings.Trim(idStr, "/"))
+		id, err := strconv.Atoi(idStr)
 		if err != nil {
 			respondError(w, http.StatusBadRequest, "invalid id")
 			return
 		}
-		u, err := GetPropertyUnitsByID(db, id)
+		u, err := s.Units.GetByID(currentUserID(r), id)
 		if err != nil {
 			respondError(w, http.StatusNotFound, "not found")
 			return
 		}
+		setETag(w, u.Version)
 		respondJSON(w, http.StatusOK, u)
 	}
 }
 
+// GetPropertyUnitsHandler returns an HTTP handler for listing the units of the
+// property identified by the {id} path value (/v1/properties/{id}/units, or the
+// legacy /units/{propertyId}).
+func GetPropertyUnitsHandler(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "invalid id")
+			return
+		}
+		p, err := ParseListParams(r, unitListSpec)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		p.Filters = append(p.Filters, listCondition{sql: "propertyId = ?", arg: id})
+		page, err := s.Units.List(currentUserID(r), p)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		respondList(w, r, page)
+	}
+}
+
 // PUT
 // UpdatePropertyUnitHandler returns an HTTP handler for updating a property unit.
 // Accepts a JSON body, validates propertyUnitId, updates the DB, and responds with status.
-func UpdatePropertyUnitHandler(db *sql.DB) http.HandlerFunc {
+func UpdatePropertyUnitHandler(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPut {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -106,35 +146,77 @@ func UpdatePropertyUnitHandler(db *sql.DB) http.HandlerFunc {
 			respondError(w, http.StatusBadRequest, "invalid json")
 			return
 		}
+		if !bindPathID(w, r, &u.PropertyUnitID, "propertyUnitId") {
+			return
+		}
 		if u.PropertyUnitID == 0 {
 			respondError(w, http.StatusBadRequest, "propertyUnitId required")
 			return
 		}
-		if err := UpdatePropertyUnit(db, &u); err != nil {
-			respondError(w, http.StatusInternalServerError, err.Error())
+		if u.PropertyID == 0 {
+			respondError(w, http.StatusBadRequest, "propertyId required")
 			return
 		}
-		respondJSON(w, http.StatusOK, map[string]string{"status": "updated"})
+		if ok, err := s.Access.CanAccessProperty(currentUserID(r), u.PropertyID); err != nil || !ok {
+			respondError(w, http.StatusNotFound, "property not found")
+			return
+		}
+		version, ifMatch, ok := requireVersion(w, r, u.Version)
+		if !ok {
+			return
+		}
+		u.Version = version
+		if err := s.Units.Update(currentUserID(r), &u); err != nil {
+			if errors.Is(err, errStaleVersion) {
+				if current, err := s.Units.GetByID(currentUserID(r), u.PropertyUnitID); err == nil {
+					respondStale(w, ifMatch, current, current.Version)
+					return
+				}
+				err = sql.ErrNoRows
+			}
+			if errors.Is(err, sql.ErrNoRows) {
+				respondError(w, http.StatusNotFound, "not found")
+			} else {
+				respondError(w, http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+		setETag(w, u.Version)
+		respondJSON(w, http.StatusOK, map[string]interface{}{"status": "updated", "version": u.Version})
 	}
 }
 
+// PATCH
+// PatchPropertyUnitHandler returns an HTTP handler for partially updating a property unit.
+// Accepts a JSON merge patch, applies it to the current property unit, and passes the
+// result to UpdatePropertyUnitHandler (see patch.go).
+func PatchPropertyUnitHandler(s *Store) http.HandlerFunc {
+	return PatchHandler(func(r *http.Request, id int) (*PropertyUnit, error) {
+		return s.Units.GetByID(currentUserID(r), id)
+	}, UpdatePropertyUnitHandler(s))
+}
+
 // DELETE
 // DeletePropertyUnitHandler returns an HTTP handler for deleting a property unit by ID.
 // Accepts a DELETE request, removes the unit from DB, and responds with status.
-func DeletePropertyUnitHandler(db *sql.DB) http.HandlerFunc {
+func DeletePropertyUnitHandler(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodDelete {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
-		idStr := strings.TrimPrefix(r.URL.Path, "/units/")
-		id, err := strconv.Atoi(strings.Trim(idStr, "/"))
+		idStr := r.PathValue("id")
+		id, err := strconv.Atoi(idStr)
 		if err != nil {
 			respondError(w, http.StatusBadRequest, "invalid id")
 			return
 		}
-		if err := DeletePropertyUnit(db, id); err != nil {
-			respondError(w, http.StatusInternalServerError, err.Error())
+		if err := s.Units.Delete(currentUserID(r), id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				respondError(w, http.StatusNotFound, "not found")
+			} else if !respondDeleteBlocked(w, err) {
+				respondError(w, http.StatusInternalServerError, err.Error())
+			}
 			return
 		}
 		respondJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
@@ -146,66 +228,76 @@ func DeletePropertyUnitHandler(db *sql.DB) http.HandlerFunc {
 // CreatePropertyUnit inserts a new property unit into the database.
 // Returns the new unit ID and error if insertion fails.
 func CreatePropertyUnit(db *sql.DB, u *PropertyUnit) (int, error) {
-	res, err := db.Exec(`INSERT INTO propertyUnits (propertyId, propertyUnitNumber, propertyUnitBeds, propertyUnitBaths, propertyUnitSqFt, propertyUnitRentDefault, propertyUnitNotes)
-	VALUES (?, ?, ?, ?, ?, ?, ?)`, u.PropertyID, u.PropertyUnitNumber, u.PropertyUnitBeds, u.PropertyUnitBaths, u.PropertyUnitSqFt, u.PropertyUnitRentDefault, u.PropertyUnitNotes)
-	if err != nil {
-		return 0, err
-	}
-	id, _ := res.LastInsertId()
-	return int(id), nil
+	var id int
+	err := db.QueryRow(`INSERT INTO propertyUnits (propertyId, propertyUnitNumber, propertyUnitBeds, propertyUnitBaths, propertyUnitSqFt, propertyUnitRentDefault, propertyUnitNotes)
+	VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING propertyUnitId`, u.PropertyID, u.PropertyUnitNumber, u.PropertyUnitBeds, u.PropertyUnitBaths, u.PropertyUnitSqFt, u.PropertyUnitRentDefault, u.PropertyUnitNotes).Scan(&id)
+	return id, err
 }
 
-// UpdatePropertyUnit updates an existing property unit in the database.
-// Returns error if update fails.
-func UpdatePropertyUnit(db *sql.DB, u *PropertyUnit) error {
-	_, err := db.Exec(`UPDATE propertyUnits SET propertyId=?, propertyUnitNumber=?, propertyUnitBeds=?, propertyUnitBaths=?, propertyUnitSqFt=?, propertyUnitRentDefault=?, propertyUnitNotes=? WHERE propertyUnitId=?`,
-		u.PropertyID, u.PropertyUnitNumber, u.PropertyUnitBeds, u.PropertyUnitBaths, u.PropertyUnitSqFt, u.PropertyUnitRentDefault, u.PropertyUnitNotes, u.PropertyUnitID)
+// UpdatePropertyUnit updates an existing property unit visible to userID in the database if
+// it is still at u.Version (0 skips the check), then advances u.Version.
+// Returns sql.ErrNoRows if the unit is not visible to the user, or
+// errStaleVersion if it was changed in the meantime.
+func UpdatePropertyUnit(db *sql.DB, userID int, u *PropertyUnit) error {
+	now := time.Now().Unix()
+	args := append([]interface{}{u.PropertyID, u.PropertyUnitNumber, u.PropertyUnitBeds, u.PropertyUnitBaths, u.PropertyUnitSqFt, u.PropertyUnitRentDefault, u.PropertyUnitNotes, now, u.PropertyUnitID, u.Version}, scopeArgs(userID, 2)...)
+	err := db.QueryRow(`UPDATE propertyUnits SET propertyId=?, propertyUnitNumber=?, propertyUnitBeds=?, propertyUnitBaths=?, propertyUnitSqFt=?, propertyUnitRentDefault=?, propertyUnitNotes=?, version=version+1, updatedUnix=?
+		WHERE propertyUnitId=? AND deletedUnix IS NULL AND ? IN (0, version) AND propertyUnitId IN (`+accessibleUnitsSQL+`) RETURNING version`, args...).Scan(&u.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return staleOrMissing(GetPropertyUnitByID(db, userID, u.PropertyUnitID))
+	}
+	if err == nil {
+		u.UpdatedUnix = &now
+	}
 	return err
 }
 
-// DeletePropertyUnit removes a property unit from the database by propertyUnitId.
-// Returns error if deletion fails.
-func DeletePropertyUnit(db *sql.DB, id int) error {
-	_, err := db.Exec(`DELETE FROM propertyUnits WHERE propertyUnitId=?`, id)
-	return err
+// DeletePropertyUnit moves a property unit visible to userID to the trash (see trash.go),
+// applying the delete policies for what depends on it (see integrity.go).
+// Returns sql.ErrNoRows if the unit is not visible to the user or is already in
+// the trash, or a *DeleteBlockedError if dependent records prevent the delete.
+func DeletePropertyUnit(db *sql.DB, userID, id int) error {
+	return trashWithPolicies(db, userID, "units", id)
 }
 
-// GetAllPropertyUnits retrieves all property units from the database.
-// Returns a slice of PropertyUnit and error if query fails.
-func GetAllPropertyUnits(db *sql.DB) ([]PropertyUnit, error) {
-	rows, err := db.Query(`SELECT propertyUnitId, propertyId, propertyUnitNumber, propertyUnitBeds, propertyUnitBaths, propertyUnitSqFt, propertyUnitRentDefault, propertyUnitNotes FROM propertyUnits`)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
+// unitListSpec describes how property units are listed, sorted and filtered (see list.go).
+var unitListSpec = listSpec{
+	columns:     `propertyUnitId, propertyId, COALESCE(propertyUnitNumber, ''), COALESCE(propertyUnitBeds, 0), COALESCE(propertyUnitBaths, 0), COALESCE(propertyUnitSqFt, 0), COALESCE(propertyUnitRentDefault, 0), COALESCE(propertyUnitNotes, ''), version, updatedUnix`,
+	table:       `propertyUnits`,
+	idColumn:    `propertyUnitId`,
+	defaultSort: "propertyUnitId",
+	sorts: map[string]string{
+		"propertyUnitId":          "propertyUnitId",
+		"propertyUnitNumber":      "COALESCE(propertyUnitNumber, '')",
+		"propertyUnitBeds":        "COALESCE(propertyUnitBeds, 0)",
+		"propertyUnitSqFt":        "COALESCE(propertyUnitSqFt, 0)",
+		"propertyUnitRentDefault": "COALESCE(propertyUnitRentDefault, 0)",
+	},
+	filters: map[string]listFilter{
+		"propertyId": {expr: "propertyId", op: "=", isInt: true},
+		"minBeds":    {expr: "propertyUnitBeds", op: ">=", isInt: true},
+		"maxRent":    {expr: "propertyUnitRentDefault", op: "<=", isInt: true},
+	},
+}
 
-	var out []PropertyUnit
-	for rows.Next() {
-		var u PropertyUnit
-		if err := rows.Scan(&u.PropertyUnitID, &u.PropertyID, &u.PropertyUnitNumber, &u.PropertyUnitBeds, &u.PropertyUnitBaths, &u.PropertyUnitSqFt, &u.PropertyUnitRentDefault, &u.PropertyUnitNotes); err != nil {
-			return nil, err
-		}
-		out = append(out, u)
-	}
-	return out, nil
+// ListPropertyUnits returns one page of the property units visible to userID,
+// filtered and sorted as requested in p.
+func ListPropertyUnits(db *sql.DB, userID int, p ListParams) (*Page[PropertyUnit], error) {
+	return queryPage(db, unitListSpec, p, `deletedUnix IS NULL AND propertyId IN (`+accessiblePropertiesSQL+`)`, scopeArgs(userID, 2), func(u *PropertyUnit) []interface{} {
+		return []interface{}{&u.PropertyUnitID, &u.PropertyID, &u.PropertyUnitNumber, &u.PropertyUnitBeds, &u.PropertyUnitBaths, &u.PropertyUnitSqFt, &u.PropertyUnitRentDefault, &u.PropertyUnitNotes, &u.Version, &u.UpdatedUnix}
+	})
 }
 
-// GetPropertyUnitsByID retrieves property units by propertyId from the database.
-// Returns a slice of PropertyUnit and error if not found or query fails.
-func GetPropertyUnitsByID(db *sql.DB, id int) ([]PropertyUnit, error) {
-	rows, err := db.Query(`SELECT propertyUnitId, propertyId, propertyUnitNumber, propertyUnitBeds, propertyUnitBaths, propertyUnitSqFt, propertyUnitRentDefault, propertyUnitNotes FROM propertyUnits WHERE propertyId=?`, id)
+// GetPropertyUnitByID retrieves a single property unit visible to userID.
+// Returns sql.ErrNoRows if the unit does not exist or is not visible.
+func GetPropertyUnitByID(db *sql.DB, userID, id int) (*PropertyUnit, error) {
+	args := append([]interface{}{id}, scopeArgs(userID, 2)...)
+	var u PropertyUnit
+	err := db.QueryRow(`SELECT propertyUnitId, propertyId, COALESCE(propertyUnitNumber, ''), COALESCE(propertyUnitBeds, 0), COALESCE(propertyUnitBaths, 0), COALESCE(propertyUnitSqFt, 0), COALESCE(propertyUnitRentDefault, 0), COALESCE(propertyUnitNotes, ''), version, updatedUnix FROM propertyUnits
+		WHERE propertyUnitId=? AND deletedUnix IS NULL AND propertyId IN (`+accessiblePropertiesSQL+`)`, args...).
+		Scan(&u.PropertyUnitID, &u.PropertyID, &u.PropertyUnitNumber, &u.PropertyUnitBeds, &u.PropertyUnitBaths, &u.PropertyUnitSqFt, &u.PropertyUnitRentDefault, &u.PropertyUnitNotes, &u.Version, &u.UpdatedUnix)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var out []PropertyUnit
-	for rows.Next() {
-		var u PropertyUnit
-		if err := rows.Scan(&u.PropertyUnitID, &u.PropertyID, &u.PropertyUnitNumber, &u.PropertyUnitBeds, &u.PropertyUnitBaths, &u.PropertyUnitSqFt, &u.PropertyUnitRentDefault, &u.PropertyUnitNotes); err != nil {
-			return nil, err
-		}
-		out = append(out, u)
-	}
-	return out, nil
+	return &u, nil
 }
@@ -11,16 +11,17 @@ package main
 // Package-level summary:
 // This file implements user registration, retrieval, update, and deletion HTTP handlers
 // and database helpers. Handlers: CreateUserHandler, GetUserHandler, GetCurrentUserHandler,
-// UpdateUserHandler, DeleteUserHandler. DB helpers: CreateUser, GetAllUsers, GetUserByID,
-// UpdateUser, DeleteUser. Passwords are hashed using bcrypt.
+// UpdateUserHandler, PatchUserHandler, DeleteUserHandler. DB helpers: CreateUser, ListUsers, GetUserByID,
+// GetUserRole, UpdateUser, DeleteUser. Passwords are hashed using bcrypt.
 
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
-	"strings"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -34,14 +35,19 @@ type User struct {
 	UserPhoneNumber string `db:"userPhoneNumber" json:"userPhoneNumber"`
 	UserPassword    string `db:"userPasswordHash" json:"userPassword"`
 	UserRole        string `db:"userRole" json:"userRole"`
+	Version         int    `db:"version" json:"version,omitempty"`
+	UpdatedUnix     *int64 `db:"updatedUnix" json:"updatedUnix,omitempty"`
+	// CreatedByUserID is the owner who created the account, nil for sign-ups.
+	CreatedByUserID *int `db:"createdByUserId" json:"-"`
 }
 
 // == Handlers ========================================================================
 
 // CreateUserHandler returns an HTTP handler for creating a new user.
-// Accepts a JSON body with user details, hashes the password, and inserts the user into the database.
+// Accepts a JSON body with user details, checks the password policy, hashes the
+// password, and inserts the user into the database.
 // Responds with the created user or error.
-func CreateUserHandler(db *sql.DB) http.HandlerFunc {
+func CreateUserHandler(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var u User
 		if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
@@ -50,6 +56,27 @@ func CreateUserHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
+		// Anonymous sign-ups always create an owner account; only an
+		// authenticated owner (checked by RBACMiddleware) may pick a role.
+		_, authenticated := userRoleFromContext(r.Context())
+		if !authenticated || u.UserRole == "" {
+			u.UserRole = RoleOwner
+		}
+		// Accounts an owner creates are theirs to manage (see managedUsersSQL)
+		u.CreatedByUserID = nil
+		if authenticated {
+			creator := currentUserID(r)
+			u.CreatedByUserID = &creator
+		}
+		if !isValidRole(u.UserRole) {
+			respondError(w, http.StatusBadRequest, "Invalid userRole")
+			return
+		}
+		if err := validatePassword(u.UserPassword); err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
 		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(u.UserPassword), bcrypt.DefaultCost)
 		if err != nil {
 			log.Print(err)
@@ -59,28 +86,36 @@ func CreateUserHandler(db *sql.DB) http.HandlerFunc {
 
 		u.UserPassword = string(hashedPassword)
 
-		err = CreateUser(db, &u)
+		err = s.Users.Create(&u)
 		if err != nil {
 			log.Print(err)
 			respondError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
+		u.UserPassword = "" // never echo the password hash
+		u.Version = 1
+		setETag(w, u.Version)
 		respondJSON(w, http.StatusCreated, u)
 	}
 }
 
 // GetUserHandler returns an HTTP handler for retrieving user(s).
 // If no userId is provided, returns all users. Otherwise, returns the user with the given ID.
-func GetUserHandler(db *sql.DB) http.HandlerFunc {
+func GetUserHandler(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		idStr := strings.TrimPrefix(r.URL.Path, "/users/")
+		idStr := r.PathValue("id")
 		if idStr == "" {
-			users, err := GetAllUsers(db)
+			p, err := ParseListParams(r, userListSpec)
+			if err != nil {
+				respondError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			page, err := s.Users.List(currentUserID(r), p)
 			if err != nil {
 				respondError(w, http.StatusInternalServerError, err.Error())
 				return
 			}
-			respondJSON(w, http.StatusOK, users)
+			respondList(w, r, page)
 			return
 		}
 
@@ -89,36 +124,30 @@ func GetUserHandler(db *sql.DB) http.HandlerFunc {
 			respondError(w, http.StatusBadRequest, "Invalid userId")
 			return
 		}
-		u, err := GetUserByID(db, id)
+		if ok, err := s.Access.CanAccessUser(currentUserID(r), id); err != nil || !ok {
+			respondError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		u, err := s.Users.GetByID(id)
 		if err != nil {
 			respondError(w, http.StatusNotFound, "User not found")
 			return
 		}
+		setETag(w, u.Version)
 		respondJSON(w, http.StatusOK, u)
 	}
 }
 
 // GetCurrentUserHandler returns an HTTP handler for retrieving the current user.
-// If no userId is provided, returns all users. Otherwise, returns the user with the given ID.
-func GetCurrentUserHandler(db *sql.DB) http.HandlerFunc {
+// The user ID is taken from the request context populated by AuthMiddleware.
+func GetCurrentUserHandler(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		idStr := strings.TrimPrefix(r.URL.Path, "/users/")
-		if idStr == "" {
-			users, err := GetAllUsers(db)
-			if err != nil {
-				respondError(w, http.StatusInternalServerError, err.Error())
-				return
-			}
-			respondJSON(w, http.StatusOK, users)
+		id, ok := userIDFromContext(r.Context())
+		if !ok {
+			respondError(w, http.StatusUnauthorized, "Not authenticated")
 			return
 		}
-
-		id, err := strconv.Atoi(idStr)
-		if err != nil {
-			respondError(w, http.StatusBadRequest, "Invalid userId")
-			return
-		}
-		u, err := GetUserByID(db, id)
+		u, err := s.Users.GetByID(id)
 		if err != nil {
 			respondError(w, http.StatusNotFound, "User not found")
 			return
@@ -129,7 +158,7 @@ func GetCurrentUserHandler(db *sql.DB) http.HandlerFunc {
 
 // UpdateUserHandler returns an HTTP handler for updating user details.
 // Accepts a JSON body with updated user info and updates the user in the database.
-func UpdateUserHandler(db *sql.DB) http.HandlerFunc {
+func UpdateUserHandler(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPut {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -141,34 +170,98 @@ func UpdateUserHandler(db *sql.DB) http.HandlerFunc {
 			respondError(w, http.StatusBadRequest, "Invalid JSON")
 			return
 		}
+		if !bindPathID(w, r, &u.UserID, "userId") {
+			return
+		}
 		if u.UserID == 0 {
 			respondError(w, http.StatusBadRequest, "User ID is required")
 			return
 		}
+		if u.UserFirstName == "" || u.UserLastName == "" || u.UserEmail == "" {
+			respondError(w, http.StatusBadRequest, "userFirstName, userLastName and userEmail are required")
+			return
+		}
+		if !isValidRole(u.UserRole) {
+			respondError(w, http.StatusBadRequest, "Invalid userRole")
+			return
+		}
+		if ok, err := s.Access.CanManageUser(currentUserID(r), u.UserID); err != nil || !ok {
+			respondError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		// Nobody changes their own role, and a staff account is never made an owner
+		role, err := s.Users.GetRole(u.UserID)
+		if err != nil {
+			respondError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		if role != u.UserRole && u.UserID == currentUserID(r) {
+			respondError(w, http.StatusForbidden, "You cannot change your own role")
+			return
+		}
+		if role != u.UserRole && u.UserRole == RoleOwner {
+			respondError(w, http.StatusForbidden, errOwnerRole.Error())
+			return
+		}
+		version, ifMatch, ok := requireVersion(w, r, u.Version)
+		if !ok {
+			return
+		}
+		u.Version = version
 
-		if err := UpdateUser(db, &u); err != nil {
-			respondError(w, http.StatusInternalServerError, err.Error())
+		if err := s.Users.Update(&u); err != nil {
+			if errors.Is(err, errStaleVersion) {
+				if current, err := s.Users.GetByID(u.UserID); err == nil {
+					respondStale(w, ifMatch, current, current.Version)
+					return
+				}
+				err = sql.ErrNoRows
+			}
+			if errors.Is(err, errOwnerRole) {
+				respondError(w, http.StatusForbidden, err.Error())
+			} else if errors.Is(err, sql.ErrNoRows) {
+				respondError(w, http.StatusNotFound, "User not found")
+			} else {
+				respondError(w, http.StatusInternalServerError, err.Error())
+			}
 			return
 		}
-		respondJSON(w, http.StatusOK, map[string]string{"status": "updated"})
+		setETag(w, u.Version)
+		respondJSON(w, http.StatusOK, map[string]interface{}{"status": "updated", "version": u.Version})
 	}
 }
 
+// PatchUserHandler returns an HTTP handler for partially updating a user.
+// Accepts a JSON merge patch, applies it to the current user, and passes the
+// result to UpdateUserHandler (see patch.go).
+func PatchUserHandler(s *Store) http.HandlerFunc {
+	return PatchHandler(func(r *http.Request, id int) (*User, error) {
+		if ok, err := s.Access.CanAccessUser(currentUserID(r), id); err != nil || !ok {
+			return nil, sql.ErrNoRows
+		}
+		return s.Users.GetByID(id)
+	}, UpdateUserHandler(s))
+}
+
 // DeleteUserHandler returns an HTTP handler for deleting a user by ID.
 // Accepts a DELETE request and removes the user from the database.
-func DeleteUserHandler(db *sql.DB) http.HandlerFunc {
+func DeleteUserHandler(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodDelete {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
-		idStr := strings.TrimPrefix(r.URL.Path, "/users/")
+		idStr := r.PathValue("id")
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
 			respondError(w, http.StatusBadRequest, "Invalid userId")
 			return
 		}
-		if err := DeleteUser(db, id); err != nil {
+		if ok, err := s.Access.CanManageUser(currentUserID(r), id); err != nil || !ok {
+			respondError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		if err := s.Users.Delete(id); err != nil {
 			respondError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
@@ -182,24 +275,42 @@ func DeleteUserHandler(db *sql.DB) http.HandlerFunc {
 // Hashes the password and sets the UserID field on success.
 // Returns an error if insertion fails.
 func CreateUser(db *sql.DB, u *User) error {
-	query := `INSERT INTO users (userFirstName, userLastName, userEmail, userPhoneNumber, userPasswordHash, userRole)
-              VALUES (?, ?, ?, ?, ?, ?)`
-	res, err := db.Exec(query, u.UserFirstName, u.UserLastName, u.UserEmail, u.UserPhoneNumber, u.UserPassword, u.UserRole)
-	if err != nil {
-		return err
-	}
-	id, _ := res.LastInsertId()
-	u.UserID = int(id)
-	return nil
+	query := `INSERT INTO users (userFirstName, userLastName, userEmail, userPhoneNumber, userPasswordHash, userRole, createdByUserId)
+              VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING userId`
+	return db.QueryRow(query, u.UserFirstName, u.UserLastName, u.UserEmail, u.UserPhoneNumber, u.UserPassword, u.UserRole, u.CreatedByUserID).Scan(&u.UserID)
 }
 
-// UpdateUser updates an existing user's details in the database.
-// Returns an error if the update fails.
+// errOwnerRole is returned when an update would give a staff account, one an
+// owner created, the owner role.
+var errOwnerRole = errors.New("staff accounts cannot be given the owner role")
+
+// UpdateUser updates an existing user's details in the database if the row is
+// still at u.Version (0 skips the check), then advances u.Version.
+// Returns sql.ErrNoRows if the user does not exist, errStaleVersion if it
+// was changed in the meantime, or errOwnerRole if it would make a staff
+// account an owner.
 func UpdateUser(db *sql.DB, u *User) error {
-	_, err := db.Exec(`UPDATE users 
-		SET userFirstName=?, userLastName=?, userEmail=?, userPhoneNumber=?, userRole=? 
-		WHERE userId=?`,
-		u.UserFirstName, u.UserLastName, u.UserEmail, u.UserPhoneNumber, u.UserRole, u.UserID)
+	if u.UserRole == RoleOwner {
+		var createdBy sql.NullInt64
+		var role string
+		if err := db.QueryRow(`SELECT createdByUserId, COALESCE(userRole, 'owner') FROM users WHERE userId=?`, u.UserID).Scan(&createdBy, &role); err != nil {
+			return err
+		}
+		if createdBy.Valid && role != RoleOwner {
+			return errOwnerRole
+		}
+	}
+	now := time.Now().Unix()
+	err := db.QueryRow(`UPDATE users 
+		SET userFirstName=?, userLastName=?, userEmail=?, userPhoneNumber=?, userRole=?, version=version+1, updatedUnix=? 
+		WHERE userId=? AND ? IN (0, version) RETURNING version`,
+		u.UserFirstName, u.UserLastName, u.UserEmail, u.UserPhoneNumber, u.UserRole, now, u.UserID, u.Version).Scan(&u.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return staleOrMissing(GetUserByID(db, u.UserID))
+	}
+	if err == nil {
+		u.UpdatedUnix = &now
+	}
 	return err
 }
 
@@ -210,33 +321,45 @@ func DeleteUser(db *sql.DB, id int) error {
 	return err
 }
 
-// READ
-// GetAllUsers retrieves all users from the database.
-// Returns a slice of User and an error if the query fails.
-func GetAllUsers(db *sql.DB) ([]User, error) {
-	rows, err := db.Query(`SELECT userId, userFirstName, userLastName, userEmail, userPhoneNumber, userRole FROM users`)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
+// userListSpec describes how users are listed, sorted and filtered (see list.go).
+var userListSpec = listSpec{
+	columns:     `userId, userFirstName, userLastName, userEmail, userPhoneNumber, userRole, version, updatedUnix`,
+	table:       `users`,
+	idColumn:    `userId`,
+	defaultSort: "userId",
+	sorts: map[string]string{
+		"userId":        "userId",
+		"userLastName":  "userLastName",
+		"userFirstName": "userFirstName",
+		"userEmail":     "userEmail",
+	},
+	filters: map[string]listFilter{
+		"role": {expr: "userRole", op: "="},
+	},
+}
 
-	var users []User
-	for rows.Next() {
-		var u User
-		if err := rows.Scan(&u.UserID, &u.UserFirstName, &u.UserLastName, &u.UserEmail, &u.UserPhoneNumber, &u.UserRole); err != nil {
-			return nil, err
-		}
-		users = append(users, u)
-	}
-	return users, nil
+// ListUsers returns one page of the users visible to userID,
+// filtered and sorted as requested in p.
+func ListUsers(db *sql.DB, userID int, p ListParams) (*Page[User], error) {
+	return queryPage(db, userListSpec, p, `userId IN (`+accessibleUsersSQL+`)`, scopeArgs(userID, 3), func(u *User) []interface{} {
+		return []interface{}{&u.UserID, &u.UserFirstName, &u.UserLastName, &u.UserEmail, &u.UserPhoneNumber, &u.UserRole, &u.Version, &u.UpdatedUnix}
+	})
+}
+
+// GetUserRole retrieves the userRole for a user by userId from the database.
+// Returns sql.ErrNoRows if the user does not exist.
+func GetUserRole(db *sql.DB, id int) (string, error) {
+	var role string
+	err := db.QueryRow(`SELECT COALESCE(userRole, 'owner') FROM users WHERE userId=?`, id).Scan(&role)
+	return role, err
 }
 
 // GetUserByID retrieves a user by userId from the database.
 // Returns a pointer to User and an error if not found or query fails.
 func GetUserByID(db *sql.DB, id int) (*User, error) {
 	var u User
-	err := db.QueryRow(`SELECT userId, userFirstName, userLastName, userEmail, userPhoneNumber, userRole FROM users WHERE userId=?`, id).
-		Scan(&u.UserID, &u.UserFirstName, &u.UserLastName, &u.UserEmail, &u.UserPhoneNumber, &u.UserRole)
+	err := db.QueryRow(`SELECT userId, userFirstName, userLastName, userEmail, userPhoneNumber, userRole, version, updatedUnix FROM users WHERE userId=?`, id).
+		Scan(&u.UserID, &u.UserFirstName, &u.UserLastName, &u.UserEmail, &u.UserPhoneNumber, &u.UserRole, &u.Version, &u.UpdatedUnix)
 	if err != nil {
 		return nil, err
 	}
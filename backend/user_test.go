@@ -0,0 +1,86 @@
+/*
+ * -----------------------------------------------------------
+ * Author: Madison Nichols
+ * Affiliation: WVU Graduate Student
+ * Course: SENG 564
+ * -----------------------------------------------------------
+ */
+
+package main
+
+// Handler tests for user.go: an owner may change the role of the staff
+// accounts they created, but nobody changes their own role, no staff account
+// is made an owner, and accounts the caller does not manage are not found.
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestUpdateUserRole(t *testing.T) {
+	s := newTestStore(t)
+	ownerID := createTestUser(t, s, "owner@example.com", RoleOwner)
+	otherOwnerID := createTestUser(t, s, "other@example.com", RoleOwner)
+	staff := func(email, role string, creator int) int {
+		u := &User{UserFirstName: "Test", UserLastName: role, UserEmail: email, UserPassword: "x", UserRole: role, CreatedByUserID: &creator}
+		if err := s.Users.Create(u); err != nil {
+			t.Fatal(err)
+		}
+		return u.UserID
+	}
+	managerID := staff("manager@example.com", RoleManager, ownerID)
+	assistantID := staff("assistant@example.com", RoleAssistant, ownerID)
+	otherStaffID := staff("otherstaff@example.com", RoleAssistant, otherOwnerID)
+	h := buildRouter(s, s.DB, nil)
+
+	tests := []struct {
+		name     string
+		callerID int
+		userID   int
+		role     string
+		status   int
+		want     string // role stored afterwards
+	}{
+		{"owner demotes their manager", ownerID, managerID, RoleAssistant, http.StatusOK, RoleAssistant},
+		{"owner promotes their assistant", ownerID, assistantID, RoleManager, http.StatusOK, RoleManager},
+		{"owner makes their staff an owner", ownerID, assistantID, RoleOwner, http.StatusForbidden, RoleManager},
+		{"staff makes themself an owner", managerID, managerID, RoleOwner, http.StatusForbidden, RoleAssistant},
+		{"staff changes their own role", managerID, managerID, RoleManager, http.StatusForbidden, RoleAssistant},
+		{"staff keeps their own role", managerID, managerID, RoleAssistant, http.StatusOK, RoleAssistant},
+		{"owner demotes themself", ownerID, ownerID, RoleManager, http.StatusForbidden, RoleOwner},
+		{"owner changes another owner's staff", ownerID, otherStaffID, RoleManager, http.StatusNotFound, RoleAssistant},
+		{"owner changes another owner", ownerID, otherOwnerID, RoleAssistant, http.StatusNotFound, RoleOwner},
+		{"staff changes another staff account", managerID, assistantID, RoleAssistant, http.StatusNotFound, RoleManager},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			u, err := s.Users.GetByID(tc.userID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			u.UserRole = tc.role
+			body, _ := json.Marshal(u)
+			w := sendAs(h, tc.callerID, http.MethodPut, "/v1/users/"+strconv.Itoa(tc.userID), "", string(body))
+			if w.Code != tc.status {
+				t.Errorf("status %d (%s), want %d", w.Code, strings.TrimSpace(w.Body.String()), tc.status)
+			}
+			if role, err := s.Users.GetRole(tc.userID); err != nil || role != tc.want {
+				t.Errorf("role = %q, %v; want %q", role, err, tc.want)
+			}
+		})
+	}
+
+	// The repository refuses the promotion too, whoever asks
+	u, err := s.Users.GetByID(managerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.UserRole = RoleOwner
+	if err := s.Users.Update(u); !errors.Is(err, errOwnerRole) {
+		t.Errorf("Update to owner = %v, want errOwnerRole", err)
+	}
+}
@@ -10,7 +10,8 @@ package main
 
 // Package-level summary:
 // This file provides the dashboard lease overview handler, GetLeasesHandler,
-// which queries the leasesView SQL view and returns lease summary data for the UI.
+// which reads the leasesView SQL view through the Store and returns lease summary
+// data for the UI. DB helper: GetLeaseOverview.
 
 import (
 	"database/sql"
@@ -24,37 +25,40 @@ type LeaseSummary struct {
 	LastName       string `json:"lastName"`
 	Address        string `json:"address"`
 	Unit           string `json:"unit"`
-	LeaseStartDate string `json:"leaseStartDate"` // formatted date
+	LeaseStartDate int64  `json:"leaseStartDate"` // unix seconds
+	RentAmount     int    `json:"rentAmount"`
+	LeaseStatus    string `json:"leaseStatus"`
 }
 
-// GetLeasesHandler returns an HTTP handler for retrieving lease overview data from the leasesView SQL view.
-// Responds with a list of lease summaries for the dashboard UI.
-func GetLeasesHandler(db *sql.DB) http.HandlerFunc {
+// GetLeasesHandler returns an HTTP handler for retrieving lease overview data from the leasesView SQL view,
+// scoped to the properties visible to the caller. Responds with a list of lease summaries for the dashboard UI.
+func GetLeasesHandler(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		rows, err := db.Query(`SELECT firstName, lastName, address, unit, leaseStartDate, rentAmount, leaseStatus FROM leasesView`)
+		list, err := s.Dashboard.LeaseOverview(currentUserID(r))
 		if err != nil {
 			http.Error(w, "Failed to query leases", http.StatusInternalServerError)
 			return
 		}
-		defer rows.Close()
+		json.NewEncoder(w).Encode(list)
+	}
+}
 
-		var list []map[string]interface{}
-		for rows.Next() {
-			var firstName, lastName, address, unit, leaseStatus string
-			var leaseStartDate int64
-			var rentAmount int
-			if err := rows.Scan(&firstName, &lastName, &address, &unit, &leaseStartDate, &rentAmount, &leaseStatus); err == nil {
-				list = append(list, map[string]interface{}{
-					"firstName":      firstName,
-					"lastName":       lastName,
-					"address":        address,
-					"unit":           unit,
-					"leaseStartDate": leaseStartDate,
-					"rentAmount":     rentAmount,
-					"leaseStatus":    leaseStatus,
-				})
-			}
+// GetLeaseOverview reads the leasesView view for the properties visible to userID.
+// Rows with missing tenant or unit details are skipped.
+func GetLeaseOverview(db *sql.DB, userID int) ([]LeaseSummary, error) {
+	rows, err := db.Query(`SELECT firstName, lastName, address, unit, leaseStartDate, rentAmount, leaseStatus FROM leasesView
+		WHERE propertyId IN (`+accessiblePropertiesSQL+`)`, scopeArgs(userID, 2)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []LeaseSummary
+	for rows.Next() {
+		var l LeaseSummary
+		if err := rows.Scan(&l.FirstName, &l.LastName, &l.Address, &l.Unit, &l.LeaseStartDate, &l.RentAmount, &l.LeaseStatus); err == nil {
+			list = append(list, l)
 		}
-		json.NewEncoder(w).Encode(list)
 	}
+	return list, rows.Err()
 }
@@ -10,7 +10,8 @@ package main
 
 // Package-level summary:
 // This file provides the dashboard maintenance status handler, GetMaintenanceRequestsHandler,
-// which queries the maintenanceRequestsView SQL view and returns maintenance request status data for the UI.
+// which reads the maintenanceRequestsView SQL view through the Store and returns
+// maintenance request status data for the UI. DB helper: GetMaintenanceStatus.
 
 import (
 	"database/sql"
@@ -18,7 +19,7 @@ import (
 	"net/http"
 )
 
-// MaintenanceRequest matches your maintenanceRequests table
+// MaintenanceRequestStatus is a row of the maintenanceRequestsView view
 type MaintenanceRequestStatus struct {
 	MaintenanceRequestId int    `json:"maintenanceRequestId"`
 	FirstName            string `json:"firstName"`
@@ -26,41 +27,41 @@ type MaintenanceRequestStatus struct {
 	Address              string `json:"address"`
 	Unit                 string `json:"unit"`
 	Description          string `json:"description"`
-	MaintenanceStatus    string `json:"maintenanceStatus"`
-	DateCreated          string `json:"dateCreated"` // formatted date
+	Status               string `json:"status"`
+	Priority             string `json:"priority"`
+	Category             string `json:"category"`
+	DateCreated          int64  `json:"dateCreated"` // unix seconds
 }
 
-// GetMaintenanceRequestsHandler returns an HTTP handler for retrieving maintenance request status data from the maintenanceRequestsView SQL view.
-// Responds with a list of maintenance request statuses for the dashboard UI.
-func GetMaintenanceRequestsHandler(db *sql.DB) http.HandlerFunc {
+// GetMaintenanceRequestsHandler returns an HTTP handler for retrieving maintenance request status data from the maintenanceRequestsView SQL view,
+// scoped to the properties visible to the caller. Responds with a list of maintenance request statuses for the dashboard UI.
+func GetMaintenanceRequestsHandler(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		rows, err := db.Query(`SELECT maintenanceRequestId, firstName, lastName, address, unit, description, maintenanceStatus, dateCreated, priority, category FROM maintenanceRequestsView`)
+		list, err := s.Dashboard.MaintenanceStatus(currentUserID(r))
 		if err != nil {
 			http.Error(w, "Failed to query maintenance requests", http.StatusInternalServerError)
 			return
 		}
-		defer rows.Close()
+		json.NewEncoder(w).Encode(list)
+	}
+}
 
-		var list []map[string]interface{}
-		for rows.Next() {
-			var firstName, lastName, address, unit, description, status, priority, category string
-			var dateCreated int64
-			var maintenanceRequestId int
-			if err := rows.Scan(&maintenanceRequestId, &firstName, &lastName, &address, &unit, &description, &status, &dateCreated, &priority, &category); err == nil {
-				list = append(list, map[string]interface{}{
-					"maintenanceRequestId": maintenanceRequestId,
-					"firstName":            firstName,
-					"lastName":             lastName,
-					"address":              address,
-					"unit":                 unit,
-					"description":          description,
-					"status":               status,
-					"priority":             priority,
-					"category":             category,
-					"dateCreated":          dateCreated,
-				})
-			}
+// GetMaintenanceStatus reads the maintenanceRequestsView view for the properties visible to userID.
+// Rows with missing tenant or unit details are skipped.
+func GetMaintenanceStatus(db *sql.DB, userID int) ([]MaintenanceRequestStatus, error) {
+	rows, err := db.Query(`SELECT maintenanceRequestId, firstName, lastName, address, unit, description, maintenanceStatus, dateCreated, priority, category FROM maintenanceRequestsView
+		WHERE propertyId IN (`+accessiblePropertiesSQL+`)`, scopeArgs(userID, 2)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []MaintenanceRequestStatus
+	for rows.Next() {
+		var m MaintenanceRequestStatus
+		if err := rows.Scan(&m.MaintenanceRequestId, &m.FirstName, &m.LastName, &m.Address, &m.Unit, &m.Description, &m.Status, &m.DateCreated, &m.Priority, &m.Category); err == nil {
+			list = append(list, m)
 		}
-		json.NewEncoder(w).Encode(list)
 	}
+	return list, rows.Err()
 }
@@ -10,7 +10,8 @@ package main
 
 // Package-level summary:
 // This file provides the dashboard overdue payments handler, GetOverduePaymentsHandler,
-// which queries the overduePayments SQL view and returns overdue payment data for the UI.
+// which reads the overduePayments SQL view through the Store and returns overdue
+// payment data for the UI. DB helper: GetOverduePayments.
 
 import (
 	"database/sql"
@@ -29,44 +30,21 @@ type OverduePayment struct {
 	Unit            string   `json:"unit"`            // Unit number
 	RentAmount      float64  `json:"rentAmount"`      // Rent amount for this lease
 	LastPaymentUnix null.Int `json:"lastPaymentUnix"` // Unix timestamp of last payment
-	PaymentStatus   string   `json:"paymentStatus"`   // "Overdue", "Current", or "No payment recorded"
+	PaymentStatus   string   `json:"paymentStatus"`   // Always "Overdue"; only leases with an amount due are listed
+	AmountDue       int      `json:"amountDue"`       // Charges due by now that payments have not covered
+	LateFees        int      `json:"lateFees"`        // Late fees charged on the lease and not waived
 }
 
-// GetOverduePaymentsHandler returns an HTTP handler for retrieving overdue payment data from the overduePayments SQL view.
-// Responds with a list of overdue payments for the dashboard UI.
-func GetOverduePaymentsHandler(db *sql.DB) http.HandlerFunc {
+// GetOverduePaymentsHandler returns an HTTP handler for retrieving overdue payment data from the overduePayments SQL view,
+// scoped to the properties visible to the caller. Responds with a list of overdue payments for the dashboard UI.
+func GetOverduePaymentsHandler(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		rows, err := db.Query(`
-			SELECT 
-				leaseId, firstName, lastName, address, unit, rentAmount, 
-				lastPaymentUnix, paymentStatus
-			FROM overduePayments
-		`)
+		overdueList, err := s.Dashboard.OverduePayments(currentUserID(r))
 		if err != nil {
 			log.Printf("Error querying overduePayments: %v", err)
 			http.Error(w, "Failed to retrieve overdue payments", http.StatusInternalServerError)
 			return
 		}
-		defer rows.Close()
-
-		var overdueList []OverduePayment
-		for rows.Next() {
-			var o OverduePayment
-			if err := rows.Scan(
-				&o.LeaseId,
-				&o.FirstName,
-				&o.LastName,
-				&o.Address,
-				&o.Unit,
-				&o.RentAmount,
-				&o.LastPaymentUnix,
-				&o.PaymentStatus,
-			); err != nil {
-				log.Printf("Error scanning row: %v", err)
-				continue
-			}
-			overdueList = append(overdueList, o)
-		}
 
 		// Return JSON
 		w.Header().Set("Content-Type", "application/json")
@@ -76,3 +54,41 @@ func GetOverduePaymentsHandler(db *sql.DB) http.HandlerFunc {
 		}
 	}
 }
+
+// GetOverduePayments reads the overduePayments view for the properties visible to userID.
+// Rows that cannot be scanned are logged and skipped.
+func GetOverduePayments(db *sql.DB, userID int) ([]OverduePayment, error) {
+	rows, err := db.Query(`
+		SELECT 
+			leaseId, firstName, lastName, address, unit, rentAmount, 
+			lastPaymentUnix, paymentStatus, amountDue, lateFees
+		FROM overduePayments
+		WHERE propertyId IN (`+accessiblePropertiesSQL+`)
+	`, scopeArgs(userID, 2)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var overdueList []OverduePayment
+	for rows.Next() {
+		var o OverduePayment
+		if err := rows.Scan(
+			&o.LeaseId,
+			&o.FirstName,
+			&o.LastName,
+			&o.Address,
+			&o.Unit,
+			&o.RentAmount,
+			&o.LastPaymentUnix,
+			&o.PaymentStatus,
+			&o.AmountDue,
+			&o.LateFees,
+		); err != nil {
+			log.Printf("Error scanning row: %v", err)
+			continue
+		}
+		overdueList = append(overdueList, o)
+	}
+	return overdueList, rows.Err()
+}
@@ -10,7 +10,8 @@ package main
 
 // Package-level summary:
 // This file provides the dashboard upcoming payments handler, GetUpcomingRentHandler,
-// which queries the upcomingPayments SQL view and returns upcoming rent payment data for the UI.
+// which reads the upcomingPayments SQL view through the Store and returns upcoming
+// rent payment data for the UI. DB helper: GetUpcomingPayments.
 
 import (
 	"database/sql"
@@ -27,28 +28,38 @@ type RentPayment struct {
 	RentAmount      float64 `json:"rentAmount"`
 	LastPaymentUnix int64   `json:"lastPaymentUnix"`
 	PaymentStatus   string  `json:"paymentStatus"` // "Overdue", "Due", "Paid"
+	AmountDue       int     `json:"amountDue"`     // balance of the charges posted so far
 }
 
-// GetUpcomingRentHandler returns an HTTP handler for retrieving upcoming rent payment data from the upcomingPayments SQL view.
-// Responds with a list of upcoming rent payments for the dashboard UI.
-func GetUpcomingRentHandler(db *sql.DB) http.HandlerFunc {
+// GetUpcomingRentHandler returns an HTTP handler for retrieving upcoming rent payment data from the upcomingPayments SQL view,
+// scoped to the properties visible to the caller. Responds with a list of upcoming rent payments for the dashboard UI.
+func GetUpcomingRentHandler(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		rows, err := db.Query(`SELECT leaseId, firstName,lastName,address,unit,rentAmount,lastPaymentUnix,paymentStatus FROM upcomingPayments`)
+		payments, err := s.Dashboard.UpcomingPayments(currentUserID(r))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		defer rows.Close()
+		json.NewEncoder(w).Encode(payments)
+	}
+}
 
-		var payments []RentPayment
-		for rows.Next() {
-			var p RentPayment
-			if err := rows.Scan(&p.LeaseId, &p.FirstName, &p.LastName, &p.Address, &p.Unit, &p.RentAmount, &p.LastPaymentUnix, &p.PaymentStatus); err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			payments = append(payments, p)
+// GetUpcomingPayments reads the upcomingPayments view for the properties visible to userID.
+func GetUpcomingPayments(db *sql.DB, userID int) ([]RentPayment, error) {
+	rows, err := db.Query(`SELECT leaseId, firstName,lastName,address,unit,rentAmount,lastPaymentUnix,paymentStatus,amountDue FROM upcomingPayments
+		WHERE propertyId IN (`+accessiblePropertiesSQL+`)`, scopeArgs(userID, 2)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var payments []RentPayment
+	for rows.Next() {
+		var p RentPayment
+		if err := rows.Scan(&p.LeaseId, &p.FirstName, &p.LastName, &p.Address, &p.Unit, &p.RentAmount, &p.LastPaymentUnix, &p.PaymentStatus, &p.AmountDue); err != nil {
+			return nil, err
 		}
-		json.NewEncoder(w).Encode(payments)
+		payments = append(payments, p)
 	}
+	return payments, rows.Err()
 }
@@ -1,9 +1,10 @@
 import React, { createContext, useState, useContext, useEffect } from "react";
 import * as SecureStore from "expo-secure-store";
+import apiRequest from "../apis/client";
 
 type AuthContextType = {
   isAuthenticated: boolean;
-  login: (token: string) => Promise<void>;
+  login: (token: string, refreshToken?: string) => Promise<void>;
   logout: () => Promise<void>;
 };
 
@@ -23,13 +24,19 @@ export const AuthProvider: React.FC<{ children: React.ReactNode }> = ({ children
     })();
   }, []);
 
-  const login = async (token: string) => {
+  const login = async (token: string, refreshToken?: string) => {
     await SecureStore.setItemAsync("authToken", token);
+    if (refreshToken) await SecureStore.setItemAsync("refreshToken", refreshToken);
     setIsAuthenticated(true);
   };
 
   const logout = async () => {
+    // Revoke the session server-side; clear local tokens even if that fails
+    try {
+      await apiRequest("/logout", "POST");
+    } catch {}
     await SecureStore.deleteItemAsync("authToken");
+    await SecureStore.deleteItemAsync("refreshToken");
     setIsAuthenticated(false);
   };
 